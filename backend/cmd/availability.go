@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// AvailableProperty is a property free for a whole stay. The quote is only
+// set when every night of the stay has a rate.
+type AvailableProperty struct {
+	PropertyID         uuid.UUID `json:"property_id"`
+	PropertyName       string    `json:"property_name"`
+	PropertyAddress    *string   `json:"property_address,omitempty"`
+	PropertyType       *string   `json:"property_type,omitempty"`
+	MaxGuests          int       `json:"max_guests"`
+	Description        *string   `json:"description,omitempty"`
+	Nights             int       `json:"nights"`
+	Currency           string    `json:"currency"`
+	QuotedTotal        *float64  `json:"quoted_total,omitempty"`
+	AverageNightlyRate *float64  `json:"average_nightly_rate,omitempty"`
+
+	// Properties with units: free units with room for the party, per type
+	UnitTypes []UnitTypeAvailability `json:"unit_types,omitempty"`
+}
+
+type AvailabilitySearch struct {
+	CheckInDate  time.Time
+	CheckOutDate time.Time
+	Guests       int
+	PropertyType *string
+}
+
+// Properties with room for the party, no active booking or block
+// overlapping the stay and stay rules that allow it. Properties with units
+// qualify when any unit with room for the party is free.
+func (s *BookingService) SearchAvailability(search *AvailabilitySearch) ([]AvailableProperty, error) {
+	query := `
+		SELECT p.property_id, p.property_name, p.property_address, p.property_type,
+			p.max_guests, p.description, COALESCE(pb.currency, $5)
+		FROM properties p
+		LEFT JOIN property_branding pb ON pb.property_id = p.property_id
+		WHERE ($4::VARCHAR IS NULL OR p.property_type = $4)
+		AND (
+			(
+				NOT EXISTS (SELECT 1 FROM units u WHERE u.property_id = p.property_id)
+				AND p.max_guests >= $3
+				AND NOT EXISTS (
+					SELECT 1 FROM bookings b
+					WHERE b.property_id = p.property_id
+					AND b.booking_status IN ('confirmed', 'pending')
+					AND b.check_in_date < $2
+					AND (b.check_out_date > $1 OR b.booking_id IN (SELECT booking_id FROM long_stays WHERE open_ended))
+				)
+			)
+			OR EXISTS (
+				SELECT 1 FROM units u
+				JOIN unit_types ut ON ut.unit_type_id = u.unit_type_id
+				WHERE u.property_id = p.property_id
+				AND u.is_active
+				AND ut.max_guests >= $3
+				AND NOT EXISTS (
+					SELECT 1 FROM bookings b
+					WHERE b.property_id = u.property_id AND (b.unit_id = u.unit_id OR b.unit_id IS NULL)
+					AND b.booking_status IN ('confirmed', 'pending')
+					AND b.check_in_date < $2
+					AND (b.check_out_date > $1 OR b.booking_id IN (SELECT booking_id FROM long_stays WHERE open_ended))
+				)
+				AND NOT EXISTS (
+					SELECT 1 FROM availability_blocks ab
+					WHERE ab.property_id = u.property_id AND ab.unit_id = u.unit_id
+					AND ab.start_date < $2 AND ab.end_date > $1
+				)
+			)
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM availability_blocks ab
+			WHERE ab.property_id = p.property_id AND ab.unit_id IS NULL
+			AND ab.start_date < $2 AND ab.end_date > $1
+		)
+		ORDER BY p.max_guests, p.property_name
+	`
+
+	rows, err := s.db.Query(query, search.CheckInDate, search.CheckOutDate, search.Guests, search.PropertyType, defaultCurrency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nights := int(search.CheckOutDate.Sub(search.CheckInDate).Hours() / 24)
+	properties := []AvailableProperty{}
+
+	for rows.Next() {
+		property := AvailableProperty{Nights: nights}
+		err := rows.Scan(
+			&property.PropertyID, &property.PropertyName, &property.PropertyAddress,
+			&property.PropertyType, &property.MaxGuests, &property.Description,
+			&property.Currency,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		properties = append(properties, property)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Leave out properties whose stay rules do not allow the stay
+	propertyIDs := make([]uuid.UUID, len(properties))
+	for i, property := range properties {
+		propertyIDs[i] = property.PropertyID
+	}
+
+	ruleSets, err := s.loadStayRuleSets(s.db, propertyIDs, search.CheckInDate, search.CheckInDate)
+	if err != nil {
+		return nil, err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	allowed := properties[:0]
+	for _, property := range properties {
+		if len(ruleSets[property.PropertyID].check(search.CheckInDate, search.CheckOutDate, today, true)) == 0 {
+			allowed = append(allowed, property)
+		}
+	}
+	properties = allowed
+
+	if err := s.countFreeUnits(properties, search); err != nil {
+		return nil, err
+	}
+
+	// Quote the stay from the nightly rates
+	for i := range properties {
+		total, ok, err := s.quoteStay(properties[i].PropertyID, search.CheckInDate, search.CheckOutDate)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		average := roundMoney(total / float64(nights))
+
+		properties[i].QuotedTotal = &total
+		properties[i].AverageNightlyRate = &average
+	}
+
+	return properties, nil
+}
+
+// countFreeUnits fills in the free units per type of properties with units
+func (s *BookingService) countFreeUnits(properties []AvailableProperty, search *AvailabilitySearch) error {
+	propertyIDs := make([]uuid.UUID, len(properties))
+	ids := make([]string, len(properties))
+	for i, property := range properties {
+		propertyIDs[i] = property.PropertyID
+		ids[i] = property.PropertyID.String()
+	}
+
+	units, err := s.activeUnits(propertyIDs)
+	if err != nil || len(units) == 0 {
+		return err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT DISTINCT unit_id FROM bookings
+		WHERE property_id = ANY($1::UUID[])
+		AND unit_id IS NOT NULL
+		AND booking_status IN ('confirmed', 'pending')
+		AND check_in_date < $3
+		AND (check_out_date > $2 OR booking_id IN (SELECT booking_id FROM long_stays WHERE open_ended))
+	`, pq.Array(ids), search.CheckInDate, search.CheckOutDate)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	occupied := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var unitID uuid.UUID
+		if err := rows.Scan(&unitID); err != nil {
+			return err
+		}
+		occupied[unitID] = true
+	}
+
+	byProperty := make(map[uuid.UUID][]propertyUnit)
+	for _, unit := range units {
+		if unit.unitType.MaxGuests >= search.Guests {
+			byProperty[unit.propertyID] = append(byProperty[unit.propertyID], unit)
+		}
+	}
+
+	for i := range properties {
+		if propertyUnits, ok := byProperty[properties[i].PropertyID]; ok {
+			properties[i].UnitTypes = countUnitTypes(propertyUnits, occupied)
+		}
+	}
+
+	return nil
+}
+
+// HTTP Handlers
+func (s *BookingService) SearchAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	checkInStr := r.URL.Query().Get("check_in")
+	checkOutStr := r.URL.Query().Get("check_out")
+	guestsStr := r.URL.Query().Get("guests")
+	propertyType := r.URL.Query().Get("type")
+
+	checkIn, err := time.Parse("2006-01-02", checkInStr)
+	if err != nil {
+		http.Error(w, "Invalid check_in format", http.StatusBadRequest)
+		return
+	}
+
+	checkOut, err := time.Parse("2006-01-02", checkOutStr)
+	if err != nil {
+		http.Error(w, "Invalid check_out format", http.StatusBadRequest)
+		return
+	}
+
+	if !checkOut.After(checkIn) {
+		http.Error(w, "check_out must be after check_in", http.StatusBadRequest)
+		return
+	}
+
+	search := &AvailabilitySearch{
+		CheckInDate:  checkIn,
+		CheckOutDate: checkOut,
+		Guests:       1,
+	}
+
+	if guestsStr != "" {
+		search.Guests, err = strconv.Atoi(guestsStr)
+		if err != nil || search.Guests < 1 {
+			http.Error(w, "Invalid guests", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if propertyType != "" {
+		search.PropertyType = &propertyType
+	}
+
+	properties, err := s.SearchAvailability(search)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(properties)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSearchAvailability(t *testing.T) {
+	s := newTestService(t)
+
+	// A property type of its own keeps other tests' properties out of the results
+	propertyType := "Search " + uuid.New().String()[:8]
+	property := func(maxGuests int) uuid.UUID {
+		t.Helper()
+		propertyID := createTestProperty(t, s)
+		_, err := s.db.Exec(`
+			UPDATE properties SET property_type = $1, max_guests = $2 WHERE property_id = $3
+		`, propertyType, maxGuests, propertyID)
+		if err != nil {
+			t.Fatalf("Failed to update property: %v", err)
+		}
+		return propertyID
+	}
+
+	checkIn, checkOut := testDate(50), testDate(53)
+
+	free := property(4)
+	if _, err := s.CreatePropertyRate(free, &PropertyRateRequest{
+		StartDate:   testDate(45).Format("2006-01-02"),
+		EndDate:     testDate(60).Format("2006-01-02"),
+		NightlyRate: 120,
+	}); err != nil {
+		t.Fatalf("CreatePropertyRate: %v", err)
+	}
+
+	unquoted := property(6)
+	tooSmall := property(2)
+
+	booked := property(4)
+	createTestBooking(t, s, booked, testDate(52), testDate(55))
+
+	blocked := property(4)
+	if _, err := s.CreateBlock(blocked, s.systemUserID, &CreateBlockRequest{
+		StartDate: testDate(48).Format("2006-01-02"),
+		EndDate:   testDate(51).Format("2006-01-02"),
+		Reason:    "Repairs",
+	}); err != nil {
+		t.Fatalf("CreateBlock: %v", err)
+	}
+
+	// Departing on the check-in day leaves the property free
+	turnover := property(4)
+	createTestBooking(t, s, turnover, testDate(47), checkIn)
+
+	withUnits := property(10)
+	unitType, err := s.CreateUnitType(withUnits, &UnitTypeRequest{Name: "Family", MaxGuests: 3})
+	if err != nil {
+		t.Fatalf("CreateUnitType: %v", err)
+	}
+	for _, name := range []string{"1", "2"} {
+		if _, err := s.CreateUnit(withUnits, &UnitRequest{UnitTypeID: unitType.UnitTypeID, UnitName: name}); err != nil {
+			t.Fatalf("CreateUnit: %v", err)
+		}
+	}
+	if _, err := s.CreateBooking(s.systemUserID, &CreateBookingRequest{
+		PropertyID:         withUnits,
+		GuestName:          "Unit Guest",
+		GuestIDCard:        "ID-4",
+		GuestContactNumber: "+94771234567",
+		CheckInDate:        checkIn.Format("2006-01-02"),
+		CheckOutDate:       checkOut.Format("2006-01-02"),
+		NumberOfGuests:     2,
+	}); err != nil {
+		t.Fatalf("CreateBooking: %v", err)
+	}
+
+	properties, err := s.SearchAvailability(&AvailabilitySearch{
+		CheckInDate:  checkIn,
+		CheckOutDate: checkOut,
+		Guests:       3,
+		PropertyType: &propertyType,
+	})
+	if err != nil {
+		t.Fatalf("SearchAvailability: %v", err)
+	}
+
+	found := make(map[uuid.UUID]AvailableProperty)
+	for _, property := range properties {
+		found[property.PropertyID] = property
+	}
+
+	for name, id := range map[string]uuid.UUID{"free": free, "unquoted": unquoted, "turnover": turnover, "with units": withUnits} {
+		if _, ok := found[id]; !ok {
+			t.Errorf("%s property was not found", name)
+		}
+	}
+	for name, id := range map[string]uuid.UUID{"too small": tooSmall, "booked": booked, "blocked": blocked} {
+		if _, ok := found[id]; ok {
+			t.Errorf("%s property was found", name)
+		}
+	}
+	if len(properties) != 4 {
+		t.Errorf("found %d properties, want 4", len(properties))
+	}
+
+	if quote := found[free]; quote.Nights != 3 || quote.QuotedTotal == nil || *quote.QuotedTotal != 360 ||
+		quote.AverageNightlyRate == nil || *quote.AverageNightlyRate != 120 {
+		t.Errorf("free property quote = %+v, want 3 nights for 360 at 120", quote)
+	}
+	if quote := found[unquoted]; quote.QuotedTotal != nil {
+		t.Errorf("property without rates quoted %.2f, want no quote", *quote.QuotedTotal)
+	}
+
+	if units := found[withUnits].UnitTypes; len(units) != 1 || units[0].TotalUnits != 2 || units[0].Remaining != 1 {
+		t.Errorf("unit types = %+v, want 1 of 2 units free", units)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Block types
+const (
+	BlockTypeMaintenance = "maintenance"
+	BlockTypeOwnerUse    = "owner_use"
+	BlockTypeExternal    = "external"
+	BlockTypeOther       = "other"
+)
+
+// Block sources
+const (
+	BlockSourceManual = "manual"
+	BlockSourceICal   = "ical"
+)
+
+// AvailabilityBlock closes a property for a date range without a guest
+// booking. Like a booking, EndDate is exclusive: it is the first date the
+// property is available again. On a property with units, a block with a
+// UnitID closes only that unit.
+type AvailabilityBlock struct {
+	BlockID            uuid.UUID  `json:"block_id"`
+	PropertyID         uuid.UUID  `json:"property_id"`
+	UnitID             *uuid.UUID `json:"unit_id,omitempty"`
+	StartDate          time.Time  `json:"start_date"`
+	EndDate            time.Time  `json:"end_date"`
+	BlockType          string     `json:"block_type"`
+	Reason             string     `json:"reason"`
+	Source             string     `json:"source"`
+	ExternalCalendarID *uuid.UUID `json:"external_calendar_id,omitempty"`
+	ExternalUID        *string    `json:"external_uid,omitempty"`
+	CreatedBy          *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+type CreateBlockRequest struct {
+	UnitID    *uuid.UUID `json:"unit_id,omitempty"` // properties with units only
+	StartDate string     `json:"start_date"`        // "2024-01-15" format
+	EndDate   string     `json:"end_date"`          // "2024-01-20" format
+	BlockType string     `json:"block_type"`
+	Reason    string     `json:"reason"`
+}
+
+type UpdateBlockRequest struct {
+	StartDate *string `json:"start_date,omitempty"`
+	EndDate   *string `json:"end_date,omitempty"`
+	BlockType *string `json:"block_type,omitempty"`
+	Reason    *string `json:"reason,omitempty"`
+}
+
+// Block types staff can choose; external blocks only come from the iCal importer
+func validBlockType(blockType string) bool {
+	switch blockType {
+	case BlockTypeMaintenance, BlockTypeOwnerUse, BlockTypeOther:
+		return true
+	}
+	return false
+}
+
+// Close a property, or one of its units, for a date range. The database
+// rejects blocks that overlap active bookings or other blocks, reported as a
+// BookingConflictError.
+func (s *BookingService) CreateBlock(propertyID uuid.UUID, userID uuid.UUID, req *CreateBlockRequest) (*AvailabilityBlock, error) {
+	startDate, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		return nil, serviceError(http.StatusBadRequest, "invalid start date format: %v", err)
+	}
+
+	endDate, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		return nil, serviceError(http.StatusBadRequest, "invalid end date format: %v", err)
+	}
+
+	if !endDate.After(startDate) {
+		return nil, serviceError(http.StatusBadRequest, "end date must be after start date")
+	}
+
+	if req.BlockType == "" {
+		req.BlockType = BlockTypeMaintenance
+	}
+	if !validBlockType(req.BlockType) {
+		return nil, serviceError(http.StatusBadRequest, "block_type must be one of 'maintenance', 'owner_use' or 'other'")
+	}
+
+	if req.Reason == "" {
+		return nil, serviceError(http.StatusBadRequest, "reason is required")
+	}
+
+	if req.UnitID != nil {
+		var exists bool
+		err := s.db.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM units WHERE unit_id = $1 AND property_id = $2)
+		`, *req.UnitID, propertyID).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, serviceError(http.StatusBadRequest, "unit not found for this property")
+		}
+	}
+
+	blockID := uuid.New()
+	query := `
+		INSERT INTO availability_blocks (
+			block_id, property_id, unit_id, start_date, end_date, block_type, reason, created_by
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	_, err = s.db.Exec(query, blockID, propertyID, req.UnitID, startDate, endDate, req.BlockType, req.Reason, userID)
+	if err != nil {
+		return nil, s.asBookingConflict(err, propertyID, req.UnitID, startDate, endDate, nil, nil)
+	}
+
+	return s.GetBlock(blockID)
+}
+
+// Blocks for a property overlapping the given range (inclusive)
+func (s *BookingService) GetBlocks(propertyID uuid.UUID, from, to time.Time) ([]AvailabilityBlock, error) {
+	query := `
+		SELECT block_id, property_id, unit_id, start_date, end_date, block_type, reason,
+			source, external_calendar_id, external_uid, created_by, created_at, updated_at
+		FROM availability_blocks
+		WHERE property_id = $1
+		AND start_date <= $2 AND end_date > $3
+		ORDER BY start_date ASC
+	`
+
+	return s.queryBlocks(query, propertyID, to, from)
+}
+
+func (s *BookingService) GetBlock(blockID uuid.UUID) (*AvailabilityBlock, error) {
+	query := `
+		SELECT block_id, property_id, unit_id, start_date, end_date, block_type, reason,
+			source, external_calendar_id, external_uid, created_by, created_at, updated_at
+		FROM availability_blocks
+		WHERE block_id = $1
+	`
+
+	blocks, err := s.queryBlocks(query, blockID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(blocks) == 0 {
+		return nil, serviceError(http.StatusNotFound, "block not found")
+	}
+
+	return &blocks[0], nil
+}
+
+func (s *BookingService) UpdateBlock(blockID uuid.UUID, req *UpdateBlockRequest) (*AvailabilityBlock, error) {
+	block, err := s.GetBlock(blockID)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Source != BlockSourceManual {
+		return nil, serviceError(http.StatusBadRequest, "imported blocks are managed by their external calendar")
+	}
+
+	// Validate the combined range, not each date on its own
+	if req.StartDate != nil {
+		block.StartDate, err = time.Parse("2006-01-02", *req.StartDate)
+		if err != nil {
+			return nil, serviceError(http.StatusBadRequest, "invalid start date format: %v", err)
+		}
+	}
+
+	if req.EndDate != nil {
+		block.EndDate, err = time.Parse("2006-01-02", *req.EndDate)
+		if err != nil {
+			return nil, serviceError(http.StatusBadRequest, "invalid end date format: %v", err)
+		}
+	}
+
+	if !block.EndDate.After(block.StartDate) {
+		return nil, serviceError(http.StatusBadRequest, "end date must be after start date")
+	}
+
+	if req.BlockType != nil {
+		if !validBlockType(*req.BlockType) {
+			return nil, serviceError(http.StatusBadRequest, "block_type must be one of 'maintenance', 'owner_use' or 'other'")
+		}
+		block.BlockType = *req.BlockType
+	}
+
+	if req.Reason != nil {
+		if *req.Reason == "" {
+			return nil, serviceError(http.StatusBadRequest, "reason is required")
+		}
+		block.Reason = *req.Reason
+	}
+
+	query := `
+		UPDATE availability_blocks
+		SET start_date = $1, end_date = $2, block_type = $3, reason = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE block_id = $5
+	`
+
+	_, err = s.db.Exec(query, block.StartDate, block.EndDate, block.BlockType, block.Reason, blockID)
+	if err != nil {
+		return nil, s.asBookingConflict(err, block.PropertyID, block.UnitID, block.StartDate, block.EndDate, nil, &blockID)
+	}
+
+	return s.GetBlock(blockID)
+}
+
+func (s *BookingService) DeleteBlock(blockID uuid.UUID) error {
+	block, err := s.GetBlock(blockID)
+	if err != nil {
+		return err
+	}
+
+	if block.Source != BlockSourceManual {
+		return serviceError(http.StatusBadRequest, "imported blocks are managed by their external calendar")
+	}
+
+	result, err := s.db.Exec(`DELETE FROM availability_blocks WHERE block_id = $1`, blockID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return serviceError(http.StatusNotFound, "block not found")
+	}
+
+	return nil
+}
+
+func (s *BookingService) queryBlocks(query string, args ...interface{}) ([]AvailabilityBlock, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks []AvailabilityBlock
+
+	for rows.Next() {
+		var block AvailabilityBlock
+		err := rows.Scan(
+			&block.BlockID, &block.PropertyID, &block.UnitID, &block.StartDate, &block.EndDate,
+			&block.BlockType, &block.Reason, &block.Source, &block.ExternalCalendarID, &block.ExternalUID,
+			&block.CreatedBy, &block.CreatedAt, &block.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
+
+// HTTP Handlers
+func (s *BookingService) CreateBlockHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	var req CreateBlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := s.requestUserID(r)
+
+	block, err := s.CreateBlock(propertyID, userID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(block)
+}
+
+func (s *BookingService) GetBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+	fromStr := r.URL.Query().Get("from")
+	toStr := r.URL.Query().Get("to")
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	// Default: from today for the next 12 months
+	from := time.Now().UTC().Truncate(24 * time.Hour)
+	to := from.AddDate(1, 0, 0)
+
+	if fromStr != "" {
+		from, err = time.Parse("2006-01-02", fromStr)
+		if err != nil {
+			http.Error(w, "Invalid from format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if toStr != "" {
+		to, err = time.Parse("2006-01-02", toStr)
+		if err != nil {
+			http.Error(w, "Invalid to format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	blocks, err := s.GetBlocks(propertyID, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blocks)
+}
+
+func (s *BookingService) GetBlockHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	blockIDStr := vars["blockId"]
+
+	blockID, err := uuid.Parse(blockIDStr)
+	if err != nil {
+		http.Error(w, "Invalid block ID", http.StatusBadRequest)
+		return
+	}
+
+	block, err := s.GetBlock(blockID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(block)
+}
+
+func (s *BookingService) UpdateBlockHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	blockIDStr := vars["blockId"]
+
+	blockID, err := uuid.Parse(blockIDStr)
+	if err != nil {
+		http.Error(w, "Invalid block ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateBlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	block, err := s.UpdateBlock(blockID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(block)
+}
+
+func (s *BookingService) DeleteBlockHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	blockIDStr := vars["blockId"]
+
+	blockID, err := uuid.Parse(blockIDStr)
+	if err != nil {
+		http.Error(w, "Invalid block ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.DeleteBlock(blockID); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
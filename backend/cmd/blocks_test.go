@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Blocks and bookings close the same dates to each other
+func TestBlocksAndBookings(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	bookingID := createTestBooking(t, s, propertyID, testDate(10), testDate(13))
+
+	// Over the booking's last night, then from its check-out day
+	_, err := s.CreateBlock(propertyID, s.systemUserID, &CreateBlockRequest{
+		StartDate: testDate(12).Format("2006-01-02"),
+		EndDate:   testDate(15).Format("2006-01-02"),
+		Reason:    "Painting",
+	})
+	var conflict *BookingConflictError
+	if !errors.As(err, &conflict) || conflict.ConflictingBookingID == nil || *conflict.ConflictingBookingID != bookingID {
+		t.Errorf("block over a booking: err = %v, want a conflict with %s", err, bookingID)
+	}
+
+	block, err := s.CreateBlock(propertyID, s.systemUserID, &CreateBlockRequest{
+		StartDate: testDate(13).Format("2006-01-02"),
+		EndDate:   testDate(15).Format("2006-01-02"),
+		Reason:    "Painting",
+	})
+	if err != nil {
+		t.Fatalf("CreateBlock: %v", err)
+	}
+	if block.BlockType != BlockTypeMaintenance || block.Source != BlockSourceManual {
+		t.Errorf("block = %+v, want a manual maintenance block", block)
+	}
+
+	_, err = s.CreateBooking(s.systemUserID, &CreateBookingRequest{
+		PropertyID:         propertyID,
+		GuestName:          "Blocked Guest",
+		GuestIDCard:        "ID-9",
+		GuestContactNumber: "+94771234567",
+		CheckInDate:        testDate(14).Format("2006-01-02"),
+		CheckOutDate:       testDate(16).Format("2006-01-02"),
+		NumberOfGuests:     1,
+	})
+	if !errors.As(err, &conflict) {
+		t.Errorf("booking over a block: err = %v, want a BookingConflictError", err)
+	}
+
+	// Deleting the block reopens its dates
+	if err := s.DeleteBlock(block.BlockID); err != nil {
+		t.Fatalf("DeleteBlock: %v", err)
+	}
+	createTestBooking(t, s, propertyID, testDate(14), testDate(16))
+}
+
+// A block created over HTTP is recorded as made by the acting user
+func TestCreateBlockHandler(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	body := `{"start_date": "` + testDate(20).Format("2006-01-02") + `", "end_date": "` + testDate(22).Format("2006-01-02") + `", "reason": "Deep clean"}`
+	req := httptest.NewRequest("POST", "/api/v1/properties/"+propertyID.String()+"/blocks", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"propertyId": propertyID.String()})
+	rec := httptest.NewRecorder()
+	s.CreateBlockHandler(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d (%s), want %d", rec.Code, rec.Body.String(), http.StatusCreated)
+	}
+
+	var block AvailabilityBlock
+	if err := json.NewDecoder(rec.Body).Decode(&block); err != nil {
+		t.Fatalf("Failed to decode block: %v", err)
+	}
+	if block.CreatedBy == nil || *block.CreatedBy != s.systemUserID {
+		t.Errorf("block created by %v, want %s", block.CreatedBy, s.systemUserID)
+	}
+}
+
+func TestBlockErrors(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	calendar, err := s.CreateExternalCalendar(propertyID, &ExternalCalendarRequest{
+		CalendarName: "Airbnb",
+		FeedURL:      "https://example.com/" + propertyID.String() + ".ics",
+	})
+	if err != nil {
+		t.Fatalf("CreateExternalCalendar: %v", err)
+	}
+
+	var importedID uuid.UUID
+	err = s.db.QueryRow(`
+		INSERT INTO availability_blocks (
+			property_id, start_date, end_date, block_type, reason, source, external_calendar_id, external_uid
+		) VALUES ($1, $2, $3, 'external', 'Airbnb', 'ical', $4, 'stay@airbnb')
+		RETURNING block_id
+	`, propertyID, testDate(20), testDate(22), calendar.CalendarID).Scan(&importedID)
+	if err != nil {
+		t.Fatalf("Failed to import block: %v", err)
+	}
+
+	manual, err := s.CreateBlock(propertyID, s.systemUserID, &CreateBlockRequest{
+		StartDate: testDate(40).Format("2006-01-02"),
+		EndDate:   testDate(41).Format("2006-01-02"),
+		Reason:    "Repairs",
+	})
+	if err != nil {
+		t.Fatalf("CreateBlock: %v", err)
+	}
+
+	reason := "Owner visit"
+	empty := ""
+
+	tests := []struct {
+		name string
+		err  func() error
+		code int
+	}{
+		{"end before start", func() error {
+			_, err := s.CreateBlock(propertyID, s.systemUserID, &CreateBlockRequest{
+				StartDate: testDate(30).Format("2006-01-02"),
+				EndDate:   testDate(30).Format("2006-01-02"),
+				Reason:    reason,
+			})
+			return err
+		}, http.StatusBadRequest},
+		{"bad block type", func() error {
+			_, err := s.CreateBlock(propertyID, s.systemUserID, &CreateBlockRequest{
+				StartDate: testDate(30).Format("2006-01-02"),
+				EndDate:   testDate(31).Format("2006-01-02"),
+				BlockType: BlockTypeExternal,
+				Reason:    reason,
+			})
+			return err
+		}, http.StatusBadRequest},
+		{"empty reason", func() error {
+			_, err := s.UpdateBlock(manual.BlockID, &UpdateBlockRequest{Reason: &empty})
+			return err
+		}, http.StatusBadRequest},
+		{"edit imported block", func() error {
+			_, err := s.UpdateBlock(importedID, &UpdateBlockRequest{Reason: &reason})
+			return err
+		}, http.StatusBadRequest},
+		{"delete imported block", func() error {
+			return s.DeleteBlock(importedID)
+		}, http.StatusBadRequest},
+		{"update unknown block", func() error {
+			_, err := s.UpdateBlock(uuid.New(), &UpdateBlockRequest{Reason: &reason})
+			return err
+		}, http.StatusNotFound},
+		{"delete unknown block", func() error {
+			return s.DeleteBlock(uuid.New())
+		}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		err := tt.err()
+
+		var blockErr *ServiceError
+		if !errors.As(err, &blockErr) || blockErr.Code != tt.code {
+			t.Errorf("%s: err = %v, want a %d ServiceError", tt.name, err, tt.code)
+			continue
+		}
+
+		rec := httptest.NewRecorder()
+		writeServiceError(rec, err)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+}
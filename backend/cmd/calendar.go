@@ -0,0 +1,492 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Calendar day statuses
+const (
+	CalendarStatusAvailable = "available"
+	CalendarStatusBooked    = "booked"
+	CalendarStatusPending   = "pending"
+	CalendarStatusBlocked   = "blocked"
+	CalendarStatusCheckIn   = "check_in"
+	CalendarStatusCheckOut  = "check_out"
+	CalendarStatusTurnover  = "turnover"
+)
+
+// Roles a booking can play on a calendar day
+const (
+	BookingRoleDeparting = "departing"
+	BookingRoleStaying   = "staying"
+	BookingRoleArriving  = "arriving"
+)
+
+// Longest range a single calendar request may cover
+const maxCalendarDays = 366
+
+type RangeCalendar struct {
+	PropertyID uuid.UUID     `json:"property_id"`
+	From       time.Time     `json:"from"`
+	To         time.Time     `json:"to"`
+	Days       []CalendarDay `json:"days"`
+}
+
+type CalendarGrid struct {
+	From time.Time         `json:"from"`
+	To   time.Time         `json:"to"`
+	Rows []CalendarGridRow `json:"rows"`
+}
+
+type CalendarGridRow struct {
+	PropertyID   uuid.UUID     `json:"property_id"`
+	PropertyName string        `json:"property_name"`
+	Days         []CalendarDay `json:"days"`
+}
+
+// CalendarBooking is a booking touching a calendar day. A departing booking
+// only occupies the morning, an arriving one the afternoon, so the calendar
+// can draw half-day bars and housekeeping can spot turnovers. An open-ended
+// long stay has no real departure: it is shown staying on every day from
+// check-in to the end of the calendar.
+type CalendarBooking struct {
+	BookingID     uuid.UUID `json:"booking_id"`
+	GuestName     string    `json:"guest_name"`
+	BookingStatus string    `json:"booking_status"`
+	Role          string    `json:"role"`
+	OpenEnded     bool      `json:"open_ended,omitempty"`
+}
+
+// CalendarBlock is an availability block closing a calendar day, or one
+// unit of the property on it
+type CalendarBlock struct {
+	BlockID   uuid.UUID  `json:"block_id"`
+	UnitID    *uuid.UUID `json:"unit_id,omitempty"`
+	BlockType string     `json:"block_type"`
+	Reason    string     `json:"reason"`
+	Source    string     `json:"source"`
+}
+
+// calendarDayState accumulates everything touching a date before the
+// day's status is derived from it
+type calendarDayState struct {
+	bookings      []CalendarBooking
+	block         *CalendarBlock
+	unitBlocks    []CalendarBlock
+	occupiedUnits map[uuid.UUID]bool
+}
+
+// Get the calendar of a property for an arbitrary date range (inclusive)
+func (s *BookingService) GetRangeCalendar(propertyID uuid.UUID, from, to time.Time) (*RangeCalendar, error) {
+	calendars, err := s.buildCalendars([]uuid.UUID{propertyID}, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RangeCalendar{
+		PropertyID: propertyID,
+		From:       from,
+		To:         to,
+		Days:       calendars[propertyID],
+	}, nil
+}
+
+// Get a properties × days grid for the front-desk overview. With no property
+// IDs every property is included.
+func (s *BookingService) GetCalendarGrid(propertyIDs []uuid.UUID, from, to time.Time) (*CalendarGrid, error) {
+	ids := make([]string, len(propertyIDs))
+	for i, id := range propertyIDs {
+		ids[i] = id.String()
+	}
+
+	query := `
+		SELECT property_id, property_name
+		FROM properties
+		WHERE cardinality($1::UUID[]) = 0 OR property_id = ANY($1::UUID[])
+		ORDER BY property_name
+	`
+
+	rows, err := s.db.Query(query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grid := &CalendarGrid{From: from, To: to, Rows: []CalendarGridRow{}}
+	var gridIDs []uuid.UUID
+
+	for rows.Next() {
+		var row CalendarGridRow
+		if err := rows.Scan(&row.PropertyID, &row.PropertyName); err != nil {
+			return nil, err
+		}
+
+		grid.Rows = append(grid.Rows, row)
+		gridIDs = append(gridIDs, row.PropertyID)
+	}
+	rows.Close()
+
+	calendars, err := s.buildCalendars(gridIDs, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range grid.Rows {
+		grid.Rows[i].Days = calendars[grid.Rows[i].PropertyID]
+	}
+
+	return grid, nil
+}
+
+// buildCalendars works out the per-day status of each property between from
+// and to (inclusive), loading bookings and blocks for all of them at once
+func (s *BookingService) buildCalendars(propertyIDs []uuid.UUID, from, to time.Time) (map[uuid.UUID][]CalendarDay, error) {
+	ids := make([]string, len(propertyIDs))
+	for i, id := range propertyIDs {
+		ids[i] = id.String()
+	}
+
+	// Bookings departing on the first day are included so it shows as a check-out day
+	query := `
+		SELECT b.booking_id, b.property_id, b.unit_id, b.guest_name, b.check_in_date, b.check_out_date,
+			b.booking_status, COALESCE(ls.open_ended, FALSE)
+		FROM bookings b
+		LEFT JOIN long_stays ls ON ls.booking_id = b.booking_id
+		WHERE b.property_id = ANY($1::UUID[])
+		AND b.booking_status IN ('confirmed', 'pending')
+		AND b.check_in_date <= $2 AND (b.check_out_date >= $3 OR ls.open_ended)
+	`
+
+	rows, err := s.db.Query(query, pq.Array(ids), to, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make(map[uuid.UUID]map[string]*calendarDayState)
+	for _, id := range propertyIDs {
+		states[id] = make(map[string]*calendarDayState)
+	}
+
+	stateFor := func(propertyID uuid.UUID, d time.Time) *calendarDayState {
+		key := d.Format("2006-01-02")
+		state, ok := states[propertyID][key]
+		if !ok {
+			state = &calendarDayState{}
+			states[propertyID][key] = state
+		}
+		return state
+	}
+
+	for rows.Next() {
+		var booking CalendarBooking
+		var propertyID uuid.UUID
+		var unitID *uuid.UUID
+		var checkIn, checkOut time.Time
+
+		err := rows.Scan(&booking.BookingID, &propertyID, &unitID, &booking.GuestName,
+			&checkIn, &checkOut, &booking.BookingStatus, &booking.OpenEnded)
+		if err != nil {
+			return nil, err
+		}
+
+		// The check-out date of an open-ended stay only marks how far ahead
+		// it is booked so far
+		if booking.OpenEnded && !checkOut.After(to) {
+			checkOut = to.AddDate(0, 0, 1)
+		}
+
+		for d := checkIn; !d.After(checkOut); d = d.AddDate(0, 0, 1) {
+			if d.Before(from) || d.After(to) {
+				continue
+			}
+
+			booking.Role = BookingRoleStaying
+			if d.Equal(checkIn) {
+				booking.Role = BookingRoleArriving
+			} else if d.Equal(checkOut) {
+				booking.Role = BookingRoleDeparting
+			}
+
+			state := stateFor(propertyID, d)
+			state.bookings = append(state.bookings, booking)
+
+			// The unit is taken for the night unless the guest leaves that morning
+			if unitID != nil && booking.Role != BookingRoleDeparting {
+				if state.occupiedUnits == nil {
+					state.occupiedUnits = make(map[uuid.UUID]bool)
+				}
+				state.occupiedUnits[*unitID] = true
+			}
+		}
+	}
+
+	rows.Close()
+
+	// Blocks close every night from start_date up to, not including, end_date.
+	// A block of one unit takes that unit, like a booking of it.
+	blockQuery := `
+		SELECT block_id, property_id, unit_id, block_type, reason, source, start_date, end_date
+		FROM availability_blocks
+		WHERE property_id = ANY($1::UUID[])
+		AND start_date <= $2 AND end_date > $3
+	`
+
+	blockRows, err := s.db.Query(blockQuery, pq.Array(ids), to, from)
+	if err != nil {
+		return nil, err
+	}
+	defer blockRows.Close()
+
+	for blockRows.Next() {
+		var block CalendarBlock
+		var propertyID uuid.UUID
+		var startDate, endDate time.Time
+
+		err := blockRows.Scan(&block.BlockID, &propertyID, &block.UnitID, &block.BlockType, &block.Reason, &block.Source, &startDate, &endDate)
+		if err != nil {
+			return nil, err
+		}
+
+		for d := startDate; d.Before(endDate); d = d.AddDate(0, 0, 1) {
+			if d.Before(from) || d.After(to) {
+				continue
+			}
+
+			state := stateFor(propertyID, d)
+			if block.UnitID == nil {
+				b := block
+				state.block = &b
+				continue
+			}
+
+			state.unitBlocks = append(state.unitBlocks, block)
+			if state.occupiedUnits == nil {
+				state.occupiedUnits = make(map[uuid.UUID]bool)
+			}
+			state.occupiedUnits[*block.UnitID] = true
+		}
+	}
+
+	blockRows.Close()
+
+	ruleSets, err := s.loadStayRuleSets(s.db, propertyIDs, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	units, err := s.activeUnits(propertyIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	unitsByProperty := make(map[uuid.UUID][]propertyUnit)
+	for _, unit := range units {
+		unitsByProperty[unit.propertyID] = append(unitsByProperty[unit.propertyID], unit)
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	calendars := make(map[uuid.UUID][]CalendarDay)
+	for _, propertyID := range propertyIDs {
+		var days []CalendarDay
+
+		for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+			state := states[propertyID][d.Format("2006-01-02")]
+			if state == nil {
+				state = &calendarDayState{}
+			}
+
+			day := state.day(d)
+			day.Restrictions = ruleSets[propertyID].restrictionsOn(d, today)
+			if propertyUnits, ok := unitsByProperty[propertyID]; ok {
+				state.countUnits(&day, propertyUnits)
+			}
+			days = append(days, day)
+		}
+
+		calendars[propertyID] = days
+	}
+
+	return calendars, nil
+}
+
+func (state *calendarDayState) day(d time.Time) CalendarDay {
+	day := CalendarDay{
+		Date:     d,
+		Status:   CalendarStatusAvailable,
+		Bookings: state.bookings,
+		Block:    state.block,
+	}
+
+	// Departures first, so the list reads in the order the day happens
+	sort.SliceStable(day.Bookings, func(i, j int) bool {
+		return bookingRoleOrder[day.Bookings[i].Role] < bookingRoleOrder[day.Bookings[j].Role]
+	})
+
+	var night *CalendarBooking
+	arriving, departing := false, false
+
+	for i, booking := range day.Bookings {
+		switch booking.Role {
+		case BookingRoleDeparting:
+			departing = true
+		case BookingRoleArriving:
+			arriving = true
+			night = &day.Bookings[i]
+		default:
+			night = &day.Bookings[i]
+		}
+	}
+
+	// The booking occupying the night is the one the day belongs to
+	if night != nil {
+		id := night.BookingID
+		day.IsBooked = true
+		day.BookingID = &id
+	}
+
+	// A blocked night is not bookable whatever happens in the morning
+	switch {
+	case state.block != nil:
+		day.Status = CalendarStatusBlocked
+	case arriving && departing:
+		day.Status = CalendarStatusTurnover
+	case arriving:
+		day.Status = CalendarStatusCheckIn
+	case night != nil && night.BookingStatus == "pending":
+		day.Status = CalendarStatusPending
+	case night != nil:
+		day.Status = CalendarStatusBooked
+	case departing:
+		day.Status = CalendarStatusCheckOut
+	}
+
+	return day
+}
+
+// countUnits adds the remaining units per type to a day of a property with
+// units. The day stays available, with no single booking, while any unit
+// is free for the night.
+func (state *calendarDayState) countUnits(day *CalendarDay, units []propertyUnit) {
+	day.UnitTypes = countUnitTypes(units, state.occupiedUnits)
+	day.UnitBlocks = state.unitBlocks
+
+	remaining := 0
+	for i := range day.UnitTypes {
+		if state.block != nil {
+			day.UnitTypes[i].Remaining = 0
+		}
+		remaining += day.UnitTypes[i].Remaining
+	}
+
+	day.IsBooked = remaining == 0 && state.block == nil
+	day.BookingID = nil
+
+	switch {
+	case state.block != nil:
+		day.Status = CalendarStatusBlocked
+	case remaining > 0:
+		day.Status = CalendarStatusAvailable
+	default:
+		day.Status = CalendarStatusBooked
+	}
+}
+
+var bookingRoleOrder = map[string]int{
+	BookingRoleDeparting: 0,
+	BookingRoleStaying:   1,
+	BookingRoleArriving:  2,
+}
+
+// parseCalendarRange reads the from/to query parameters shared by the
+// calendar endpoints
+func parseCalendarRange(r *http.Request) (time.Time, time.Time, error) {
+	fromStr := r.URL.Query().Get("from")
+	toStr := r.URL.Query().Get("to")
+
+	if fromStr == "" || toStr == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("from and to parameters are required")
+	}
+
+	from, err := time.Parse("2006-01-02", fromStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Invalid from format")
+	}
+
+	to, err := time.Parse("2006-01-02", toStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Invalid to format")
+	}
+
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must not be before from")
+	}
+
+	if int(to.Sub(from).Hours()/24) >= maxCalendarDays {
+		return time.Time{}, time.Time{}, fmt.Errorf("date range cannot exceed %d days", maxCalendarDays)
+	}
+
+	return from, to, nil
+}
+
+// HTTP Handlers
+func (s *BookingService) GetRangeCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parseCalendarRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	calendar, err := s.GetRangeCalendar(propertyID, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(calendar)
+}
+
+func (s *BookingService) GetCalendarGridHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseCalendarRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var propertyIDs []uuid.UUID
+	for _, idStr := range r.URL.Query()["property_id"] {
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			http.Error(w, "Invalid property ID", http.StatusBadRequest)
+			return
+		}
+		propertyIDs = append(propertyIDs, id)
+	}
+
+	grid, err := s.GetCalendarGrid(propertyIDs, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(grid)
+}
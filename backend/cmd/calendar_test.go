@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCalendarDayState(t *testing.T) {
+	departing := CalendarBooking{BookingID: uuid.New(), BookingStatus: "confirmed", Role: BookingRoleDeparting}
+	staying := CalendarBooking{BookingID: uuid.New(), BookingStatus: "confirmed", Role: BookingRoleStaying}
+	pending := CalendarBooking{BookingID: uuid.New(), BookingStatus: "pending", Role: BookingRoleStaying}
+	arriving := CalendarBooking{BookingID: uuid.New(), BookingStatus: "confirmed", Role: BookingRoleArriving}
+	block := &CalendarBlock{BlockID: uuid.New(), BlockType: "maintenance"}
+
+	tests := []struct {
+		name     string
+		bookings []CalendarBooking
+		block    *CalendarBlock
+		status   string
+		night    *CalendarBooking // booking the day belongs to, if any
+		order    []string
+	}{
+		{"empty", nil, nil, CalendarStatusAvailable, nil, nil},
+		{"staying", []CalendarBooking{staying}, nil, CalendarStatusBooked, &staying, nil},
+		{"pending", []CalendarBooking{pending}, nil, CalendarStatusPending, &pending, nil},
+		{"arriving", []CalendarBooking{arriving}, nil, CalendarStatusCheckIn, &arriving, nil},
+		{"departing", []CalendarBooking{departing}, nil, CalendarStatusCheckOut, nil, nil},
+		{"turnover", []CalendarBooking{arriving, departing}, nil, CalendarStatusTurnover, &arriving,
+			[]string{BookingRoleDeparting, BookingRoleArriving}},
+		{"all roles", []CalendarBooking{arriving, staying, departing}, nil, CalendarStatusTurnover, &arriving,
+			[]string{BookingRoleDeparting, BookingRoleStaying, BookingRoleArriving}},
+		{"departing then staying", []CalendarBooking{staying, departing}, nil, CalendarStatusBooked, &staying,
+			[]string{BookingRoleDeparting, BookingRoleStaying}},
+		{"blocked", nil, block, CalendarStatusBlocked, nil, nil},
+		{"blocked after departure", []CalendarBooking{departing}, block, CalendarStatusBlocked, nil, nil},
+		{"blocked turnover", []CalendarBooking{arriving, departing}, block, CalendarStatusBlocked, &arriving, nil},
+	}
+
+	for _, tt := range tests {
+		state := &calendarDayState{bookings: append([]CalendarBooking(nil), tt.bookings...), block: tt.block}
+		day := state.day(testDate(0))
+
+		if day.Status != tt.status {
+			t.Errorf("%s: status = %q, want %q", tt.name, day.Status, tt.status)
+		}
+		if day.Block != tt.block {
+			t.Errorf("%s: block = %v, want %v", tt.name, day.Block, tt.block)
+		}
+
+		if tt.night == nil {
+			if day.IsBooked || day.BookingID != nil {
+				t.Errorf("%s: booked by %v, want a free night", tt.name, day.BookingID)
+			}
+		} else if !day.IsBooked || day.BookingID == nil || *day.BookingID != tt.night.BookingID {
+			t.Errorf("%s: booked = %v by %v, want %s", tt.name, day.IsBooked, day.BookingID, tt.night.BookingID)
+		}
+
+		for i, role := range tt.order {
+			if day.Bookings[i].Role != role {
+				t.Errorf("%s: booking %d is %s, want %s", tt.name, i, day.Bookings[i].Role, role)
+			}
+		}
+	}
+}
+
+func TestParseCalendarRange(t *testing.T) {
+	tests := []struct {
+		query string
+		valid bool
+		days  int
+	}{
+		{"from=2026-05-01&to=2026-05-01", true, 1},
+		{"from=2026-05-25&to=2026-07-10", true, 47},
+		{"from=2026-01-01&to=2026-12-31", true, 365},
+		{"from=2028-01-01&to=2028-12-31", true, 366},
+		{"from=2026-01-01&to=2027-01-01", true, 366},
+		{"from=2026-01-01&to=2027-01-02", false, 0},
+		{"from=2026-05-02&to=2026-05-01", false, 0},
+		{"from=2026-05-01", false, 0},
+		{"to=2026-05-01", false, 0},
+		{"from=01-05-2026&to=2026-05-10", false, 0},
+		{"from=2026-05-01&to=2026-05-32", false, 0},
+	}
+
+	for _, tt := range tests {
+		from, to, err := parseCalendarRange(httptest.NewRequest("GET", "/calendar?"+tt.query, nil))
+		if (err == nil) != tt.valid {
+			t.Errorf("parseCalendarRange(%s): err = %v, want valid %v", tt.query, err, tt.valid)
+			continue
+		}
+		if tt.valid {
+			if days := int(to.Sub(from).Hours()/24) + 1; days != tt.days {
+				t.Errorf("parseCalendarRange(%s) = %d days, want %d", tt.query, days, tt.days)
+			}
+		}
+	}
+}
+
+// The grid covers every day of the range for each property, across month
+// boundaries, with the stays of each property on its own row
+func TestGetCalendarGrid(t *testing.T) {
+	s := newTestService(t)
+	first := createTestProperty(t, s)
+	second := createTestProperty(t, s)
+
+	// The range starts a few days before the end of a month
+	from := time.Date(testDate(60).Year(), testDate(60).Month()+1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -3)
+	to := from.AddDate(0, 0, 39)
+
+	bookingID := createTestBooking(t, s, first, from.AddDate(0, 0, 1), from.AddDate(0, 0, 5))
+
+	grid, err := s.GetCalendarGrid([]uuid.UUID{second, first}, from, to)
+	if err != nil {
+		t.Fatalf("GetCalendarGrid: %v", err)
+	}
+	if len(grid.Rows) != 2 {
+		t.Fatalf("grid has %d rows, want 2", len(grid.Rows))
+	}
+
+	for _, row := range grid.Rows {
+		if len(row.Days) != 40 {
+			t.Errorf("property %s has %d days, want 40", row.PropertyID, len(row.Days))
+			continue
+		}
+		if !row.Days[0].Date.Equal(from) || !row.Days[39].Date.Equal(to) {
+			t.Errorf("property %s runs from %s to %s, want %s to %s", row.PropertyID, row.Days[0].Date, row.Days[39].Date, from, to)
+		}
+
+		for i, day := range row.Days {
+			booked := row.PropertyID == first && i >= 1 && i < 5
+			if day.IsBooked != booked {
+				t.Errorf("property %s day %d booked = %v, want %v", row.PropertyID, i, day.IsBooked, booked)
+			}
+			if booked && (day.BookingID == nil || *day.BookingID != bookingID) {
+				t.Errorf("property %s day %d booked by %v, want %s", row.PropertyID, i, day.BookingID, bookingID)
+			}
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Postgres channel the bookings trigger notifies of calendar changes on
+const calendarChangesChannel = "calendar_changes"
+
+const (
+	calendarStreamBuffer    = 32
+	calendarStreamKeepAlive = 30 * time.Second
+	calendarListenerPing    = 90 * time.Second
+)
+
+// calendarChange is the payload of a calendar_changes notification: the
+// days of a property a booking was on or is now on, check-out day included
+type calendarChange struct {
+	PropertyID uuid.UUID `json:"property_id"`
+	BookingID  uuid.UUID `json:"booking_id"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+}
+
+// CalendarUpdate is pushed to calendar streams when a booking changes: the
+// days it touched within the stream's range, as the calendar shows them now
+type CalendarUpdate struct {
+	PropertyID uuid.UUID     `json:"property_id"`
+	BookingID  uuid.UUID     `json:"booking_id"`
+	Days       []CalendarDay `json:"days"`
+}
+
+// calendarSubscriber is an open calendar stream for a date range
+type calendarSubscriber struct {
+	from, to time.Time
+	updates  chan *CalendarUpdate
+}
+
+// calendarHub keeps the open calendar streams by property. A stream that
+// falls behind, or that may have missed changes while the listener was
+// reconnecting, is closed so the client reconnects and reloads.
+type calendarHub struct {
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[*calendarSubscriber]struct{}
+}
+
+func (h *calendarHub) subscribe(propertyID uuid.UUID, from, to time.Time) *calendarSubscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subscribers == nil {
+		h.subscribers = map[uuid.UUID]map[*calendarSubscriber]struct{}{}
+	}
+	if h.subscribers[propertyID] == nil {
+		h.subscribers[propertyID] = map[*calendarSubscriber]struct{}{}
+	}
+
+	sub := &calendarSubscriber{from: from, to: to, updates: make(chan *CalendarUpdate, calendarStreamBuffer)}
+	h.subscribers[propertyID][sub] = struct{}{}
+	return sub
+}
+
+func (h *calendarHub) unsubscribe(propertyID uuid.UUID, sub *calendarSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(propertyID, sub)
+}
+
+// remove closes a stream's updates; the caller holds the lock
+func (h *calendarHub) remove(propertyID uuid.UUID, sub *calendarSubscriber) {
+	if _, ok := h.subscribers[propertyID][sub]; !ok {
+		return
+	}
+
+	delete(h.subscribers[propertyID], sub)
+	if len(h.subscribers[propertyID]) == 0 {
+		delete(h.subscribers, propertyID)
+	}
+	close(sub.updates)
+}
+
+// window is the range the streams of a property cover between them,
+// clipped to the given days. ok is false when no stream covers them.
+func (h *calendarHub) window(propertyID uuid.UUID, from, to time.Time) (time.Time, time.Time, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var windowFrom, windowTo time.Time
+	found := false
+
+	for sub := range h.subscribers[propertyID] {
+		if sub.to.Before(from) || sub.from.After(to) {
+			continue
+		}
+		if !found || sub.from.Before(windowFrom) {
+			windowFrom = sub.from
+		}
+		if !found || sub.to.After(windowTo) {
+			windowTo = sub.to
+		}
+		found = true
+	}
+
+	if !found {
+		return time.Time{}, time.Time{}, false
+	}
+
+	return maxDate(windowFrom, from), minDate(windowTo, to), true
+}
+
+// publish sends each stream of a property the days of an update within its
+// range
+func (h *calendarHub) publish(update *CalendarUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subscribers[update.PropertyID] {
+		var days []CalendarDay
+		for _, day := range update.Days {
+			if !day.Date.Before(sub.from) && !day.Date.After(sub.to) {
+				days = append(days, day)
+			}
+		}
+		if len(days) == 0 {
+			continue
+		}
+
+		select {
+		case sub.updates <- &CalendarUpdate{PropertyID: update.PropertyID, BookingID: update.BookingID, Days: days}:
+		default:
+			h.remove(update.PropertyID, sub)
+		}
+	}
+}
+
+// closeAll closes every stream
+func (h *calendarHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for propertyID, subs := range h.subscribers {
+		for sub := range subs {
+			h.remove(propertyID, sub)
+		}
+	}
+}
+
+// runCalendarListener listens for calendar changes made by any server
+// against the database and pushes them to the calendar streams open on
+// this one
+func (s *BookingService) runCalendarListener(connStr string) {
+	listener := pq.NewListener(connStr, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Calendar listener: %v", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(calendarChangesChannel); err != nil {
+		log.Printf("Failed to listen for calendar changes: %v", err)
+		return
+	}
+
+	for {
+		select {
+		case notification := <-listener.Notify:
+			if notification == nil {
+				// Reconnected; changes may have been missed
+				s.calendarStreams.closeAll()
+				continue
+			}
+
+			if err := s.publishCalendarChange(notification.Extra); err != nil {
+				log.Printf("Failed to publish calendar change: %v", err)
+			}
+
+		case <-time.After(calendarListenerPing):
+			go listener.Ping()
+		}
+	}
+}
+
+// publishCalendarChange works out the calendar days a change touched for
+// the streams open on the property
+func (s *BookingService) publishCalendarChange(payload string) error {
+	var change calendarChange
+	if err := json.Unmarshal([]byte(payload), &change); err != nil {
+		return err
+	}
+
+	from, err := time.Parse("2006-01-02", change.From)
+	if err != nil {
+		return err
+	}
+	to, err := time.Parse("2006-01-02", change.To)
+	if err != nil {
+		return err
+	}
+
+	from, to, ok := s.calendarStreams.window(change.PropertyID, from, to)
+	if !ok {
+		return nil
+	}
+
+	calendars, err := s.buildCalendars([]uuid.UUID{change.PropertyID}, from, to)
+	if err != nil {
+		return err
+	}
+
+	s.calendarStreams.publish(&CalendarUpdate{
+		PropertyID: change.PropertyID,
+		BookingID:  change.BookingID,
+		Days:       calendars[change.PropertyID],
+	})
+
+	return nil
+}
+
+// HTTP Handlers
+func (s *BookingService) StreamCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parseCalendarRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := s.calendarStreams.subscribe(propertyID, from, to)
+	defer s.calendarStreams.unsubscribe(propertyID, sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(calendarStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case update, ok := <-sub.updates:
+			if !ok {
+				// Closed by the hub; the client reconnects and reloads
+				return
+			}
+
+			data, err := json.Marshal(update)
+			if err != nil {
+				log.Printf("Failed to encode calendar update: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: calendar\ndata: %s\n\n", data)
+			flusher.Flush()
+
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func calendarDays(from time.Time, n int) []CalendarDay {
+	days := make([]CalendarDay, n)
+	for i := range days {
+		days[i] = CalendarDay{Date: from.AddDate(0, 0, i), Status: CalendarStatusAvailable}
+	}
+	return days
+}
+
+func TestCalendarHub(t *testing.T) {
+	var hub calendarHub
+	propertyID, otherID := uuid.New(), uuid.New()
+	start := date(2026, 6, 1)
+
+	early := hub.subscribe(propertyID, start, start.AddDate(0, 0, 9))
+	late := hub.subscribe(propertyID, start.AddDate(0, 0, 20), start.AddDate(0, 0, 29))
+	other := hub.subscribe(otherID, start, start.AddDate(0, 0, 29))
+
+	// The window spans the streams a change touches, clipped to the change
+	tests := []struct {
+		name     string
+		from, to int
+		found    bool
+		wantFrom int
+		wantTo   int
+	}{
+		{"inside one stream", 2, 5, true, 2, 5},
+		{"across both streams", 5, 25, true, 5, 25},
+		{"past both streams", 5, 40, true, 5, 29},
+		{"between the streams", 12, 18, false, 0, 0},
+		{"from the last day of a stream", 9, 11, true, 9, 9},
+	}
+
+	for _, tt := range tests {
+		from, to, ok := hub.window(propertyID, start.AddDate(0, 0, tt.from), start.AddDate(0, 0, tt.to))
+		if ok != tt.found {
+			t.Errorf("%s: found = %v, want %v", tt.name, ok, tt.found)
+			continue
+		}
+		if ok && (!from.Equal(start.AddDate(0, 0, tt.wantFrom)) || !to.Equal(start.AddDate(0, 0, tt.wantTo))) {
+			t.Errorf("%s: window = %s to %s, want days %d to %d", tt.name, from, to, tt.wantFrom, tt.wantTo)
+		}
+	}
+
+	// Each stream gets only its own days, and only if there are any
+	bookingID := uuid.New()
+	hub.publish(&CalendarUpdate{PropertyID: propertyID, BookingID: bookingID, Days: calendarDays(start.AddDate(0, 0, 8), 4)})
+
+	select {
+	case update := <-early.updates:
+		if update.BookingID != bookingID || len(update.Days) != 2 || !update.Days[0].Date.Equal(start.AddDate(0, 0, 8)) {
+			t.Errorf("early stream got %+v, want days 8 and 9 of %s", update, bookingID)
+		}
+	default:
+		t.Errorf("early stream got no update")
+	}
+	if len(late.updates) != 0 || len(other.updates) != 0 {
+		t.Errorf("streams outside the update got %d and %d updates, want none", len(late.updates), len(other.updates))
+	}
+
+	// A stream that falls behind is closed
+	for i := 0; i <= calendarStreamBuffer; i++ {
+		hub.publish(&CalendarUpdate{PropertyID: propertyID, BookingID: bookingID, Days: calendarDays(start.AddDate(0, 0, 20), 1)})
+	}
+	for range late.updates {
+	}
+	if _, _, ok := hub.window(propertyID, start.AddDate(0, 0, 20), start.AddDate(0, 0, 29)); ok {
+		t.Errorf("a stream that fell behind is still subscribed")
+	}
+
+	// Unsubscribing a closed stream is harmless, and closeAll ends the rest
+	hub.unsubscribe(propertyID, late)
+	hub.closeAll()
+	for _, sub := range []*calendarSubscriber{early, other} {
+		if _, ok := <-sub.updates; ok {
+			t.Errorf("stream still open after closeAll")
+		}
+	}
+	if len(hub.subscribers) != 0 {
+		t.Errorf("hub has %d properties after closeAll, want none", len(hub.subscribers))
+	}
+}
+
+func TestStreamCalendarHandler(t *testing.T) {
+	s := &BookingService{}
+	propertyID := uuid.New()
+	start := date(2026, 6, 1)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/properties/{propertyId}/calendar/stream", s.StreamCalendarHandler)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	url := fmt.Sprintf("%s/properties/%s/calendar/stream?from=2026-06-01&to=2026-06-30", server.URL, propertyID)
+
+	resp, err := http.Get(fmt.Sprintf("%s/properties/%s/calendar/stream?from=2026-06-30&to=2026-06-01", server.URL, propertyID))
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("reversed range: status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, content type = %q, want an event stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() string {
+		t.Helper()
+		if !lines.Scan() {
+			t.Fatalf("stream ended early: %v", lines.Err())
+		}
+		return lines.Text()
+	}
+
+	if line := next(); line != "retry: 3000" {
+		t.Errorf("first line = %q, want the retry interval", line)
+	}
+	next()
+
+	// The stream is subscribed before its headers are sent
+	bookingID := uuid.New()
+	s.calendarStreams.publish(&CalendarUpdate{PropertyID: propertyID, BookingID: bookingID, Days: calendarDays(start.AddDate(0, 0, 28), 4)})
+
+	if line := next(); line != "event: calendar" {
+		t.Fatalf("event line = %q, want a calendar event", line)
+	}
+
+	var update CalendarUpdate
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(next(), "data: ")), &update); err != nil {
+		t.Fatalf("Failed to decode update: %v", err)
+	}
+	if update.PropertyID != propertyID || update.BookingID != bookingID || len(update.Days) != 2 {
+		t.Errorf("update = %+v, want the last 2 days of June for %s", update, bookingID)
+	}
+
+	// Closed by the hub, the stream ends so the client reconnects
+	s.calendarStreams.closeAll()
+	for lines.Scan() {
+		if line := lines.Text(); line != "" {
+			t.Errorf("unexpected line after close: %q", line)
+		}
+	}
+}
+
+func TestPublishCalendarChange(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	sub := s.calendarStreams.subscribe(propertyID, testDate(10), testDate(19))
+	defer s.calendarStreams.unsubscribe(propertyID, sub)
+
+	bookingID := createTestBooking(t, s, propertyID, testDate(18), testDate(22))
+
+	payload := fmt.Sprintf(`{"property_id":%q,"booking_id":%q,"from":%q,"to":%q}`,
+		propertyID, bookingID, testDate(18).Format("2006-01-02"), testDate(22).Format("2006-01-02"))
+	if err := s.publishCalendarChange(payload); err != nil {
+		t.Fatalf("publishCalendarChange: %v", err)
+	}
+
+	select {
+	case update := <-sub.updates:
+		if update.BookingID != bookingID || len(update.Days) != 2 {
+			t.Fatalf("update = %+v, want the stream's last 2 days", update)
+		}
+		for _, day := range update.Days {
+			if !day.IsBooked || day.BookingID == nil || *day.BookingID != bookingID {
+				t.Errorf("day %s booked = %v by %v, want %s", day.Date, day.IsBooked, day.BookingID, bookingID)
+			}
+		}
+	default:
+		t.Fatalf("stream got no update")
+	}
+
+	// Changes outside every stream are not looked up at all
+	payload = fmt.Sprintf(`{"property_id":%q,"booking_id":%q,"from":%q,"to":%q}`,
+		propertyID, uuid.New(), testDate(30).Format("2006-01-02"), testDate(32).Format("2006-01-02"))
+	if err := s.publishCalendarChange(payload); err != nil {
+		t.Fatalf("publishCalendarChange: %v", err)
+	}
+	if len(sub.updates) != 0 {
+		t.Errorf("stream got an update for days outside its range")
+	}
+
+	if err := s.publishCalendarChange(`{"property_id":`); err == nil {
+		t.Errorf("publishCalendarChange accepted a broken payload")
+	}
+}
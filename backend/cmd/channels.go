@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Channel is a booking channel (OTA or channel manager) that availability
+// and rates are pushed to and reservations are pulled from. Listing IDs are
+// the channel's own identifiers, mapped to properties in channel_listings.
+type Channel interface {
+	Name() string
+	PushAvailability(listingID string, days []ChannelAvailability) error
+	PushRates(listingID string, rates []ChannelRate) error
+	PullReservations(listingID string) ([]ChannelReservation, error)
+	// AcknowledgeReservation tells the channel a reservation has been
+	// recorded, so it is not returned again unless it changes
+	AcknowledgeReservation(listingID string, reservationID string) error
+}
+
+type ChannelAvailability struct {
+	Date      string `json:"date"` // "2024-01-15" format
+	Available bool   `json:"available"`
+}
+
+type ChannelRate struct {
+	Date        string  `json:"date"` // "2024-01-15" format
+	NightlyRate float64 `json:"nightly_rate"`
+	Currency    string  `json:"currency"`
+}
+
+// Channel reservation statuses
+const (
+	ChannelReservationConfirmed = "confirmed"
+	ChannelReservationCancelled = "cancelled"
+)
+
+type ChannelReservation struct {
+	ReservationID  string   `json:"reservation_id"`
+	Status         string   `json:"status"`
+	GuestName      string   `json:"guest_name"`
+	GuestEmail     string   `json:"guest_email,omitempty"`
+	GuestPhone     string   `json:"guest_phone,omitempty"`
+	CheckInDate    string   `json:"check_in_date"`  // "2024-01-15" format
+	CheckOutDate   string   `json:"check_out_date"` // "2024-01-20" format
+	NumberOfGuests int      `json:"number_of_guests"`
+	TotalAmount    *float64 `json:"total_amount,omitempty"`
+}
+
+// httpChannel talks to a channel through a small JSON API:
+//
+//	PUT  {base}/listings/{id}/availability              {"days": [...]}
+//	PUT  {base}/listings/{id}/rates                     {"rates": [...]}
+//	GET  {base}/listings/{id}/reservations              {"reservations": [...]}
+//	POST {base}/listings/{id}/reservations/{res}/ack
+//
+// Channels with a different API get their own Channel implementation.
+type httpChannel struct {
+	name    string
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func newHTTPChannel(name, baseURL, apiKey string) *httpChannel {
+	return &httpChannel{
+		name:    name,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *httpChannel) Name() string {
+	return c.name
+}
+
+func (c *httpChannel) PushAvailability(listingID string, days []ChannelAvailability) error {
+	return c.do("PUT", c.listingURL(listingID, "availability"), map[string]interface{}{"days": days}, nil)
+}
+
+func (c *httpChannel) PushRates(listingID string, rates []ChannelRate) error {
+	return c.do("PUT", c.listingURL(listingID, "rates"), map[string]interface{}{"rates": rates}, nil)
+}
+
+func (c *httpChannel) PullReservations(listingID string) ([]ChannelReservation, error) {
+	var resp struct {
+		Reservations []ChannelReservation `json:"reservations"`
+	}
+
+	if err := c.do("GET", c.listingURL(listingID, "reservations"), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Reservations, nil
+}
+
+func (c *httpChannel) AcknowledgeReservation(listingID string, reservationID string) error {
+	path := fmt.Sprintf("reservations/%s/ack", url.PathEscape(reservationID))
+	return c.do("POST", c.listingURL(listingID, path), nil, nil)
+}
+
+func (c *httpChannel) listingURL(listingID, path string) string {
+	return fmt.Sprintf("%s/listings/%s/%s", c.baseURL, url.PathEscape(listingID), path)
+}
+
+func (c *httpChannel) do(method, target string, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, target, reqBody)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %v", c.name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s %s returned %s: %s", c.name, method, target, resp.Status, strings.TrimSpace(string(detail)))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("%s: invalid response: %v", c.name, err)
+		}
+	}
+
+	return nil
+}
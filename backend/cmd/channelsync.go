@@ -0,0 +1,835 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// How far ahead availability and rates are pushed
+	channelSyncDays = 365
+
+	// How often the worker looks for listings due a sync
+	channelWorkerTick = time.Minute
+
+	// How long a claimed listing is left to the sync that claimed it
+	channelSyncClaimTimeout = 30 * time.Minute
+)
+
+// Channel reservation mapping statuses
+const (
+	ChannelReservationImported = "imported"
+	ChannelReservationFailed   = "failed"
+)
+
+// Outcomes of re-importing a reservation that already has a booking, stored
+// as imported, and of cancelling one whose stay has already started, stored
+// as cancelled
+const (
+	channelReservationUpdated    = "updated"
+	channelReservationEndedEarly = "ended_early"
+)
+
+// ChannelListing maps a property to its listing on a channel
+type ChannelListing struct {
+	ListingID         uuid.UUID  `json:"listing_id"`
+	PropertyID        uuid.UUID  `json:"property_id"`
+	Channel           string     `json:"channel"`
+	ExternalListingID string     `json:"external_listing_id"`
+	IsActive          bool       `json:"is_active"`
+	LastSyncedAt      *time.Time `json:"last_synced_at,omitempty"`
+	LastSyncError     *string    `json:"last_sync_error,omitempty"`
+	FailureCount      int        `json:"failure_count"`
+	NextSyncAt        *time.Time `json:"next_sync_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+type ChannelListingRequest struct {
+	Channel           string `json:"channel"`
+	ExternalListingID string `json:"external_listing_id"`
+	IsActive          *bool  `json:"is_active,omitempty"`
+}
+
+// ChannelReservationRecord links a reservation pulled from a channel to the
+// booking created for it
+type ChannelReservationRecord struct {
+	ChannelReservationID  uuid.UUID  `json:"channel_reservation_id"`
+	ListingID             uuid.UUID  `json:"listing_id"`
+	ExternalReservationID string     `json:"external_reservation_id"`
+	BookingID             *uuid.UUID `json:"booking_id,omitempty"`
+	Status                string     `json:"status"`
+	LastError             *string    `json:"last_error,omitempty"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+}
+
+type ChannelSyncResult struct {
+	ListingID              uuid.UUID `json:"listing_id"`
+	ReservationsImported   int       `json:"reservations_imported"`
+	ReservationsUpdated    int       `json:"reservations_updated"`
+	ReservationsCancelled  int       `json:"reservations_cancelled"`
+	ReservationsEndedEarly int       `json:"reservations_ended_early"`
+	ReservationsFailed     int       `json:"reservations_failed"`
+	AvailabilityDays       int       `json:"availability_days"`
+	RateDays               int       `json:"rate_days"`
+	SyncedAt               time.Time `json:"synced_at"`
+}
+
+// RegisterChannel makes a channel available for listings to be mapped to
+func (s *BookingService) RegisterChannel(channel Channel) {
+	if s.channels == nil {
+		s.channels = make(map[string]Channel)
+	}
+	s.channels[channel.Name()] = channel
+}
+
+// Names of the registered channels
+func (s *BookingService) GetChannels() []string {
+	names := []string{}
+	for name := range s.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *BookingService) validateChannelListing(req *ChannelListingRequest) error {
+	if _, ok := s.channels[req.Channel]; !ok {
+		return fmt.Errorf("unknown channel %q", req.Channel)
+	}
+
+	if req.ExternalListingID == "" {
+		return fmt.Errorf("external_listing_id is required")
+	}
+
+	return nil
+}
+
+func (s *BookingService) CreateChannelListing(propertyID uuid.UUID, req *ChannelListingRequest) (*ChannelListing, error) {
+	if err := s.validateChannelListing(req); err != nil {
+		return nil, err
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	listingID := uuid.New()
+	query := `
+		INSERT INTO channel_listings (listing_id, property_id, channel, external_listing_id, is_active)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err := s.db.Exec(query, listingID, propertyID, req.Channel, req.ExternalListingID, isActive)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetChannelListing(listingID)
+}
+
+func (s *BookingService) GetChannelListings(propertyID uuid.UUID) ([]ChannelListing, error) {
+	query := `
+		SELECT listing_id, property_id, channel, external_listing_id, is_active, last_synced_at,
+			last_sync_error, failure_count, next_sync_at, created_at, updated_at
+		FROM channel_listings
+		WHERE property_id = $1
+		ORDER BY channel
+	`
+
+	return s.queryChannelListings(query, propertyID)
+}
+
+func (s *BookingService) GetChannelListing(listingID uuid.UUID) (*ChannelListing, error) {
+	query := `
+		SELECT listing_id, property_id, channel, external_listing_id, is_active, last_synced_at,
+			last_sync_error, failure_count, next_sync_at, created_at, updated_at
+		FROM channel_listings
+		WHERE listing_id = $1
+	`
+
+	listings, err := s.queryChannelListings(query, listingID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(listings) == 0 {
+		return nil, fmt.Errorf("channel listing not found")
+	}
+
+	return &listings[0], nil
+}
+
+// Update a listing mapping. This clears any retry backoff, so a fixed
+// listing syncs on the worker's next pass.
+func (s *BookingService) UpdateChannelListing(listingID uuid.UUID, req *ChannelListingRequest) (*ChannelListing, error) {
+	if err := s.validateChannelListing(req); err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE channel_listings
+		SET channel = $1, external_listing_id = $2, is_active = COALESCE($3, is_active),
+			failure_count = 0, next_sync_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE listing_id = $4
+	`
+
+	result, err := s.db.Exec(query, req.Channel, req.ExternalListingID, req.IsActive, listingID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, fmt.Errorf("channel listing not found")
+	}
+
+	return s.GetChannelListing(listingID)
+}
+
+// Remove a listing mapping. Bookings imported through it are kept.
+func (s *BookingService) DeleteChannelListing(listingID uuid.UUID) error {
+	result, err := s.db.Exec(`DELETE FROM channel_listings WHERE listing_id = $1`, listingID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("channel listing not found")
+	}
+
+	return nil
+}
+
+func (s *BookingService) queryChannelListings(query string, args ...interface{}) ([]ChannelListing, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var listings []ChannelListing
+
+	for rows.Next() {
+		var listing ChannelListing
+		err := rows.Scan(
+			&listing.ListingID, &listing.PropertyID, &listing.Channel, &listing.ExternalListingID,
+			&listing.IsActive, &listing.LastSyncedAt, &listing.LastSyncError, &listing.FailureCount,
+			&listing.NextSyncAt, &listing.CreatedAt, &listing.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		listings = append(listings, listing)
+	}
+
+	return listings, nil
+}
+
+// Reservations pulled through a listing, newest first
+func (s *BookingService) GetChannelReservations(listingID uuid.UUID) ([]ChannelReservationRecord, error) {
+	query := `
+		SELECT channel_reservation_id, listing_id, external_reservation_id, booking_id,
+			status, last_error, created_at, updated_at
+		FROM channel_reservations
+		WHERE listing_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := s.db.Query(query, listingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []ChannelReservationRecord
+
+	for rows.Next() {
+		var record ChannelReservationRecord
+		err := rows.Scan(
+			&record.ChannelReservationID, &record.ListingID, &record.ExternalReservationID,
+			&record.BookingID, &record.Status, &record.LastError, &record.CreatedAt, &record.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// errChannelSyncInProgress is returned when another request or server is
+// already syncing the listing
+var errChannelSyncInProgress = errors.New("channel listing is already syncing")
+
+// Sync one listing with its channel: pull reservations first, so the
+// availability pushed afterwards already includes them, then push
+// availability and rates. The outcome drives the listing's retry backoff.
+//
+// The listing is claimed for the sync, so a manual sync and the worker
+// cannot import the same reservation twice. Like webhook deliveries, it is
+// claimed with a lease rather than locked while the channel is called; a
+// sync that dies leaves it to be claimed again after
+// channelSyncClaimTimeout.
+func (s *BookingService) SyncChannelListing(listingID uuid.UUID) (*ChannelSyncResult, error) {
+	result, err := s.db.Exec(`
+		UPDATE channel_listings
+		SET sync_claimed_until = $2
+		WHERE listing_id = $1
+		AND (sync_claimed_until IS NULL OR sync_claimed_until <= CURRENT_TIMESTAMP)
+	`, listingID, time.Now().Add(channelSyncClaimTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read after claiming, so the failure count is the one the last sync left
+	listing, err := s.GetChannelListing(listingID)
+	if err != nil {
+		return nil, err
+	}
+	if claimed == 0 {
+		return nil, errChannelSyncInProgress
+	}
+
+	syncResult, syncErr := s.syncChannelListing(listing)
+
+	if syncErr != nil {
+		retryAt := time.Now().Add(retryDelay(listing.FailureCount + 1))
+		_, err = s.db.Exec(`
+			UPDATE channel_listings
+			SET last_sync_error = $1, failure_count = failure_count + 1, next_sync_at = $2,
+				sync_claimed_until = NULL
+			WHERE listing_id = $3
+		`, syncErr.Error(), retryAt, listingID)
+	} else {
+		_, err = s.db.Exec(`
+			UPDATE channel_listings
+			SET last_synced_at = $1, last_sync_error = NULL, failure_count = 0, next_sync_at = NULL,
+				sync_claimed_until = NULL
+			WHERE listing_id = $2
+		`, syncResult.SyncedAt, listingID)
+	}
+	if err != nil {
+		log.Printf("Failed to record sync outcome for channel listing %s: %v", listingID, err)
+	}
+
+	return syncResult, syncErr
+}
+
+func (s *BookingService) syncChannelListing(listing *ChannelListing) (*ChannelSyncResult, error) {
+	channel, ok := s.channels[listing.Channel]
+	if !ok {
+		return nil, fmt.Errorf("channel %q is not configured", listing.Channel)
+	}
+
+	result := &ChannelSyncResult{ListingID: listing.ListingID}
+
+	reservations, err := channel.PullReservations(listing.ExternalListingID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A reservation that cannot be imported (e.g. it overlaps a local
+	// booking) is recorded and left unacknowledged, so the channel keeps
+	// offering it; it does not fail the whole sync.
+	for _, reservation := range reservations {
+		outcome, err := s.importChannelReservation(listing, &reservation)
+		if err != nil {
+			log.Printf("Failed to import %s reservation %s: %v", listing.Channel, reservation.ReservationID, err)
+			result.ReservationsFailed++
+			continue
+		}
+
+		switch outcome {
+		case ChannelReservationCancelled:
+			result.ReservationsCancelled++
+		case channelReservationEndedEarly:
+			result.ReservationsEndedEarly++
+		case channelReservationUpdated:
+			result.ReservationsUpdated++
+		default:
+			result.ReservationsImported++
+		}
+
+		if err := channel.AcknowledgeReservation(listing.ExternalListingID, reservation.ReservationID); err != nil {
+			return nil, err
+		}
+	}
+
+	from := time.Now().UTC().Truncate(24 * time.Hour)
+	to := from.AddDate(0, 0, channelSyncDays-1)
+
+	calendars, err := s.buildCalendars([]uuid.UUID{listing.PropertyID}, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var days []ChannelAvailability
+	for _, day := range calendars[listing.PropertyID] {
+		days = append(days, ChannelAvailability{
+			Date:      day.Date.Format("2006-01-02"),
+			Available: !day.IsBooked && day.Block == nil,
+		})
+	}
+
+	if err := channel.PushAvailability(listing.ExternalListingID, days); err != nil {
+		return nil, err
+	}
+	result.AvailabilityDays = len(days)
+
+	nightly, err := s.nightlyRates(listing.PropertyID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nightly) > 0 {
+		var currency string
+		err := s.db.QueryRow(`
+			SELECT COALESCE((SELECT currency FROM property_branding WHERE property_id = $1), $2)
+		`, listing.PropertyID, defaultCurrency).Scan(&currency)
+		if err != nil {
+			return nil, err
+		}
+
+		var rates []ChannelRate
+		for date, rate := range nightly {
+			rates = append(rates, ChannelRate{Date: date, NightlyRate: rate, Currency: currency})
+		}
+		sort.Slice(rates, func(i, j int) bool { return rates[i].Date < rates[j].Date })
+
+		if err := channel.PushRates(listing.ExternalListingID, rates); err != nil {
+			return nil, err
+		}
+		result.RateDays = len(rates)
+	}
+
+	result.SyncedAt = time.Now()
+
+	return result, nil
+}
+
+// importChannelReservation creates, updates or cancels the booking behind a
+// channel reservation and records the outcome
+func (s *BookingService) importChannelReservation(listing *ChannelListing, reservation *ChannelReservation) (string, error) {
+	var bookingID *uuid.UUID
+	var status string
+
+	err := s.db.QueryRow(`
+		SELECT booking_id, status FROM channel_reservations
+		WHERE listing_id = $1 AND external_reservation_id = $2
+	`, listing.ListingID, reservation.ReservationID).Scan(&bookingID, &status)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+
+	outcome := ChannelReservationImported
+
+	switch {
+	case reservation.Status == ChannelReservationCancelled:
+		outcome = ChannelReservationCancelled
+		if bookingID != nil && status != ChannelReservationCancelled {
+			outcome, err = s.cancelChannelBooking(*bookingID)
+		}
+
+	case bookingID != nil:
+		outcome = channelReservationUpdated
+		req := &UpdateBookingRequest{
+			CheckInDate:   &reservation.CheckInDate,
+			CheckOutDate:  &reservation.CheckOutDate,
+			BookingAmount: reservation.TotalAmount,
+		}
+		if reservation.NumberOfGuests > 0 {
+			req.NumberOfGuests = &reservation.NumberOfGuests
+		}
+		req.skipStayRules = true
+		_, err = s.UpdateBooking(*bookingID, s.systemUserID, req)
+
+	default:
+		var booking *Booking
+		booking, err = s.CreateBooking(s.systemUserID, channelBookingRequest(listing, reservation))
+		if err == nil {
+			bookingID = &booking.BookingID
+		}
+	}
+
+	// Failed reservations keep the status they had, if any, so a failed
+	// update of an imported reservation is not mistaken for a new one
+	recordStatus := outcome
+	switch outcome {
+	case channelReservationUpdated:
+		recordStatus = ChannelReservationImported
+	case channelReservationEndedEarly:
+		recordStatus = ChannelReservationCancelled
+	}
+
+	var lastError *string
+	if err != nil {
+		message := err.Error()
+		lastError = &message
+		recordStatus = ChannelReservationFailed
+		if status != "" {
+			recordStatus = status
+		}
+	}
+
+	_, dbErr := s.db.Exec(`
+		INSERT INTO channel_reservations (listing_id, external_reservation_id, booking_id, status, last_error)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (listing_id, external_reservation_id) DO UPDATE SET
+			booking_id = EXCLUDED.booking_id,
+			status = EXCLUDED.status,
+			last_error = EXCLUDED.last_error,
+			updated_at = CURRENT_TIMESTAMP
+	`, listing.ListingID, reservation.ReservationID, bookingID, recordStatus, lastError)
+	if dbErr != nil {
+		return "", dbErr
+	}
+
+	return outcome, err
+}
+
+// cancelChannelBooking cancels the booking of a reservation the channel has
+// cancelled. A stay that has already started cannot be cancelled; it ends
+// today instead, releasing the nights left, and is reported as ended early.
+func (s *BookingService) cancelChannelBooking(bookingID uuid.UUID) (string, error) {
+	var checkIn time.Time
+	var status string
+	err := s.db.QueryRow(`
+		SELECT check_in_date, booking_status FROM bookings WHERE booking_id = $1
+	`, bookingID).Scan(&checkIn, &status)
+	if err == sql.ErrNoRows {
+		return "", errBookingNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	switch {
+	case status == "cancelled":
+		return ChannelReservationCancelled, nil
+	case status == "completed":
+		return channelReservationEndedEarly, nil
+	case !checkIn.Before(today):
+		return ChannelReservationCancelled, s.CancelBooking(bookingID, s.systemUserID)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	stay, err := lockBookingStay(tx, bookingID)
+	if err != nil {
+		return "", err
+	}
+
+	// Already checked out; there is nothing left to release
+	if !stay.checkOut.After(today) {
+		return channelReservationEndedEarly, nil
+	}
+
+	amount := moveAmount(nil, stay.amount)
+	if stay.amount.Valid {
+		amount, err = s.repriceStay(stay, stay.checkIn, today)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE bookings
+		SET check_out_date = $1, booking_amount = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE booking_id = $3
+	`, today, amount, bookingID)
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.repriceLongStay(tx, bookingID); err != nil {
+		return "", err
+	}
+
+	if err = s.applyCharges(tx, bookingID); err != nil {
+		return "", err
+	}
+
+	err = s.recordBookingEvent(tx, EventBookingUpdated, bookingID, &BookingEventPayload{Changes: []string{"check_out_date", "booking_amount"}})
+	if err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+
+	if err := s.offerFreedDates(stay.propertyID, today, stay.checkOut); err != nil {
+		log.Printf("Failed to offer the dates of booking %s to the waitlist: %v", bookingID, err)
+	}
+
+	return channelReservationEndedEarly, nil
+}
+
+// channelBookingRequest turns a channel reservation into a booking request.
+// Channels do not share identity documents, so the channel reference stands
+// in for the ID card until the guest checks in.
+func channelBookingRequest(listing *ChannelListing, reservation *ChannelReservation) *CreateBookingRequest {
+	guests := reservation.NumberOfGuests
+	if guests < 1 {
+		guests = 1
+	}
+
+	notes := fmt.Sprintf("Imported from %s reservation %s", listing.Channel, reservation.ReservationID)
+
+	req := &CreateBookingRequest{
+		PropertyID:         listing.PropertyID,
+		GuestName:          reservation.GuestName,
+		GuestIDCard:        truncate(listing.Channel+":"+reservation.ReservationID, 50),
+		GuestContactNumber: truncate(reservation.GuestPhone, 20),
+		CheckInDate:        reservation.CheckInDate,
+		CheckOutDate:       reservation.CheckOutDate,
+		NumberOfGuests:     guests,
+		BookingNotes:       &notes,
+		BookingAmount:      reservation.TotalAmount,
+		skipStayRules:      true,
+	}
+
+	if reservation.GuestEmail != "" {
+		req.GuestEmail = &reservation.GuestEmail
+	}
+
+	return req
+}
+
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max])
+}
+
+// Sync every active listing that is due: never synced, last synced more than
+// interval ago, not waiting out a retry backoff and not being synced
+func (s *BookingService) syncDueChannelListings(interval time.Duration) {
+	rows, err := s.db.Query(`
+		SELECT listing_id FROM channel_listings
+		WHERE is_active = TRUE
+		AND (next_sync_at IS NULL OR next_sync_at <= CURRENT_TIMESTAMP)
+		AND (sync_claimed_until IS NULL OR sync_claimed_until <= CURRENT_TIMESTAMP)
+		AND (failure_count > 0 OR last_synced_at IS NULL OR last_synced_at <= $1)
+	`, time.Now().Add(-interval))
+	if err != nil {
+		log.Printf("Failed to list channel listings: %v", err)
+		return
+	}
+
+	var listingIDs []uuid.UUID
+	for rows.Next() {
+		var listingID uuid.UUID
+		if err := rows.Scan(&listingID); err != nil {
+			rows.Close()
+			log.Printf("Failed to list channel listings: %v", err)
+			return
+		}
+		listingIDs = append(listingIDs, listingID)
+	}
+	rows.Close()
+
+	for _, listingID := range listingIDs {
+		result, err := s.SyncChannelListing(listingID)
+		if errors.Is(err, errChannelSyncInProgress) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to sync channel listing %s: %v", listingID, err)
+			continue
+		}
+
+		log.Printf("Synced channel listing %s: %d imported, %d updated, %d cancelled, %d failed",
+			listingID, result.ReservationsImported, result.ReservationsUpdated,
+			result.ReservationsCancelled, result.ReservationsFailed)
+	}
+}
+
+// runChannelSync syncs due channel listings until the process exits
+func (s *BookingService) runChannelSync(interval time.Duration) {
+	ticker := time.NewTicker(channelWorkerTick)
+	defer ticker.Stop()
+
+	for {
+		s.syncDueChannelListings(interval)
+		<-ticker.C
+	}
+}
+
+// HTTP Handlers
+func (s *BookingService) GetChannelsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.GetChannels())
+}
+
+func (s *BookingService) GetChannelListingsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	listings, err := s.GetChannelListings(propertyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listings)
+}
+
+func (s *BookingService) CreateChannelListingHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ChannelListingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	listing, err := s.CreateChannelListing(propertyID, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(listing)
+}
+
+func (s *BookingService) UpdateChannelListingHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	listingIDStr := vars["listingId"]
+
+	listingID, err := uuid.Parse(listingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid listing ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ChannelListingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	listing, err := s.UpdateChannelListing(listingID, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listing)
+}
+
+func (s *BookingService) DeleteChannelListingHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	listingIDStr := vars["listingId"]
+
+	listingID, err := uuid.Parse(listingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid listing ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.DeleteChannelListing(listingID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *BookingService) SyncChannelListingHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	listingIDStr := vars["listingId"]
+
+	listingID, err := uuid.Parse(listingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid listing ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.SyncChannelListing(listingID)
+	if errors.Is(err, errChannelSyncInProgress) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *BookingService) GetChannelReservationsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	listingIDStr := vars["listingId"]
+
+	listingID, err := uuid.Parse(listingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid listing ID", http.StatusBadRequest)
+		return
+	}
+
+	records, err := s.GetChannelReservations(listingID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
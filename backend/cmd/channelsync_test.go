@@ -0,0 +1,598 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const fakeChannelAPIKey = "test-key"
+
+// fakeChannel implements the JSON API httpChannel talks to. Reservations are
+// offered until they are acknowledged.
+type fakeChannel struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	pending      map[string][]ChannelReservation
+	acknowledged map[string][]string
+	availability map[string][]ChannelAvailability
+	rates        map[string][]ChannelRate
+	failPushes   bool
+
+	// Set by holdPulls
+	pulling chan struct{}
+	release chan struct{}
+}
+
+func newFakeChannel(t *testing.T) *fakeChannel {
+	f := &fakeChannel{
+		pending:      map[string][]ChannelReservation{},
+		acknowledged: map[string][]string{},
+		availability: map[string][]ChannelAvailability{},
+		rates:        map[string][]ChannelRate{},
+	}
+
+	// Reservation IDs may contain escaped slashes
+	r := mux.NewRouter().UseEncodedPath()
+	r.HandleFunc("/listings/{id}/availability", f.pushAvailability).Methods("PUT")
+	r.HandleFunc("/listings/{id}/rates", f.pushRates).Methods("PUT")
+	r.HandleFunc("/listings/{id}/reservations", f.pullReservations).Methods("GET")
+	r.HandleFunc("/listings/{id}/reservations/{res}/ack", f.acknowledge).Methods("POST")
+
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer "+fakeChannelAPIKey {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		r.ServeHTTP(w, req)
+	}))
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+// offer makes a reservation, or a change to one, available to pull
+func (f *fakeChannel) offer(listingID string, reservation ChannelReservation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pending := f.pending[listingID][:0]
+	for _, offered := range f.pending[listingID] {
+		if offered.ReservationID != reservation.ReservationID {
+			pending = append(pending, offered)
+		}
+	}
+	f.pending[listingID] = append(pending, reservation)
+}
+
+func (f *fakeChannel) pendingReservations(listingID string) []ChannelReservation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]ChannelReservation(nil), f.pending[listingID]...)
+}
+
+func (f *fakeChannel) acknowledgedReservations(listingID string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.acknowledged[listingID]...)
+}
+
+func (f *fakeChannel) pushedAvailability(listingID string) []ChannelAvailability {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.availability[listingID]
+}
+
+func (f *fakeChannel) setFailPushes(fail bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failPushes = fail
+}
+
+// holdPulls makes pulls wait for releasePulls, reporting on pulling first
+func (f *fakeChannel) holdPulls() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pulling = make(chan struct{})
+	f.release = make(chan struct{})
+}
+
+func (f *fakeChannel) releasePulls() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	close(f.release)
+	f.pulling, f.release = nil, nil
+}
+
+func (f *fakeChannel) pushAvailability(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Days []ChannelAvailability `json:"days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failPushes {
+		http.Error(w, "channel unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	f.availability[mux.Vars(r)["id"]] = body.Days
+}
+
+func (f *fakeChannel) pushRates(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Rates []ChannelRate `json:"rates"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failPushes {
+		http.Error(w, "channel unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	f.rates[mux.Vars(r)["id"]] = body.Rates
+}
+
+func (f *fakeChannel) pullReservations(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	pulling, release := f.pulling, f.release
+	f.mu.Unlock()
+
+	if pulling != nil {
+		pulling <- struct{}{}
+		<-release
+	}
+
+	reservations := f.pendingReservations(mux.Vars(r)["id"])
+	if reservations == nil {
+		reservations = []ChannelReservation{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"reservations": reservations})
+}
+
+func (f *fakeChannel) acknowledge(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	reservationID, err := url.PathUnescape(vars["res"])
+	if err != nil {
+		http.Error(w, "invalid reservation ID", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pending := f.pending[vars["id"]][:0]
+	found := false
+	for _, offered := range f.pending[vars["id"]] {
+		if offered.ReservationID == reservationID {
+			found = true
+			continue
+		}
+		pending = append(pending, offered)
+	}
+
+	if !found {
+		http.Error(w, "reservation not found", http.StatusNotFound)
+		return
+	}
+
+	f.pending[vars["id"]] = pending
+	f.acknowledged[vars["id"]] = append(f.acknowledged[vars["id"]], reservationID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestHTTPChannel(t *testing.T) {
+	fake := newFakeChannel(t)
+	channel := newHTTPChannel("test", fake.URL+"/", fakeChannelAPIKey)
+
+	fake.offer("L1", ChannelReservation{ReservationID: "R/1", Status: ChannelReservationConfirmed, GuestName: "Ann"})
+
+	reservations, err := channel.PullReservations("L1")
+	if err != nil {
+		t.Fatalf("PullReservations: %v", err)
+	}
+	if len(reservations) != 1 || reservations[0].ReservationID != "R/1" || reservations[0].GuestName != "Ann" {
+		t.Fatalf("reservations = %+v", reservations)
+	}
+
+	// Reservation IDs are escaped in the path
+	if err := channel.AcknowledgeReservation("L1", "R/1"); err != nil {
+		t.Fatalf("AcknowledgeReservation: %v", err)
+	}
+	if pending := fake.pendingReservations("L1"); len(pending) != 0 {
+		t.Errorf("pending after acknowledgement = %+v", pending)
+	}
+	if acked := fake.acknowledgedReservations("L1"); len(acked) != 1 || acked[0] != "R/1" {
+		t.Errorf("acknowledged = %v", acked)
+	}
+
+	days := []ChannelAvailability{{Date: "2024-01-15", Available: false}, {Date: "2024-01-16", Available: true}}
+	if err := channel.PushAvailability("L1", days); err != nil {
+		t.Fatalf("PushAvailability: %v", err)
+	}
+	if pushed := fake.pushedAvailability("L1"); len(pushed) != 2 || pushed[0] != days[0] || pushed[1] != days[1] {
+		t.Errorf("pushed availability = %+v", pushed)
+	}
+
+	if err := channel.PushRates("L1", []ChannelRate{{Date: "2024-01-15", NightlyRate: 120, Currency: "USD"}}); err != nil {
+		t.Fatalf("PushRates: %v", err)
+	}
+
+	if err := channel.AcknowledgeReservation("L1", "unknown"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("acknowledging an unknown reservation: err = %v, want a 404 error", err)
+	}
+
+	unauthorised := newHTTPChannel("test", fake.URL, "wrong-key")
+	if _, err := unauthorised.PullReservations("L1"); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("pulling with a wrong key: err = %v, want a 401 error", err)
+	}
+}
+
+// newChannelTest registers a fake channel and maps a new property to a
+// listing on it
+func newChannelTest(t *testing.T) (*BookingService, *fakeChannel, *ChannelListing) {
+	s := newTestService(t)
+	fake := newFakeChannel(t)
+	s.RegisterChannel(newHTTPChannel("fake", fake.URL, fakeChannelAPIKey))
+
+	propertyID := createTestProperty(t, s)
+	listing, err := s.CreateChannelListing(propertyID, &ChannelListingRequest{
+		Channel:           "fake",
+		ExternalListingID: "listing-" + propertyID.String()[:8],
+	})
+	if err != nil {
+		t.Fatalf("CreateChannelListing: %v", err)
+	}
+
+	return s, fake, listing
+}
+
+func channelReservation(id, status string, checkIn, checkOut time.Time) ChannelReservation {
+	return ChannelReservation{
+		ReservationID:  id,
+		Status:         status,
+		GuestName:      "Channel Guest",
+		GuestPhone:     "+94771234567",
+		CheckInDate:    checkIn.Format("2006-01-02"),
+		CheckOutDate:   checkOut.Format("2006-01-02"),
+		NumberOfGuests: 2,
+	}
+}
+
+func channelBooking(t *testing.T, s *BookingService, listing *ChannelListing, reservationID string) *Booking {
+	t.Helper()
+
+	records, err := s.GetChannelReservations(listing.ListingID)
+	if err != nil {
+		t.Fatalf("GetChannelReservations: %v", err)
+	}
+
+	for _, record := range records {
+		if record.ExternalReservationID != reservationID {
+			continue
+		}
+		if record.BookingID == nil {
+			t.Fatalf("reservation %s has no booking: %+v", reservationID, record)
+		}
+
+		booking, err := s.GetBookingByID(*record.BookingID)
+		if err != nil {
+			t.Fatalf("GetBookingByID: %v", err)
+		}
+		return booking
+	}
+
+	t.Fatalf("reservation %s was not recorded", reservationID)
+	return nil
+}
+
+func TestSyncChannelListing(t *testing.T) {
+	s, fake, listing := newChannelTest(t)
+	id := listing.ExternalListingID
+
+	// Import
+	fake.offer(id, channelReservation("R1", ChannelReservationConfirmed, testDate(20), testDate(23)))
+
+	result, err := s.SyncChannelListing(listing.ListingID)
+	if err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	if result.ReservationsImported != 1 || result.ReservationsFailed != 0 {
+		t.Errorf("first sync = %+v, want 1 imported", result)
+	}
+	if acked := fake.acknowledgedReservations(id); len(acked) != 1 || acked[0] != "R1" {
+		t.Errorf("acknowledged = %v, want R1", acked)
+	}
+
+	booking := channelBooking(t, s, listing, "R1")
+	if !booking.CheckInDate.Equal(testDate(20)) || !booking.CheckOutDate.Equal(testDate(23)) || booking.BookingStatus != "confirmed" {
+		t.Errorf("imported booking = %+v", booking)
+	}
+
+	availability := fake.pushedAvailability(id)
+	if len(availability) != channelSyncDays {
+		t.Fatalf("pushed %d days, want %d", len(availability), channelSyncDays)
+	}
+	for i, day := range availability {
+		booked := i >= 20 && i < 23
+		if day.Date != testDate(i).Format("2006-01-02") || day.Available == booked {
+			t.Errorf("pushed day %d = %+v, want available %v", i, day, !booked)
+		}
+	}
+
+	// Update
+	fake.offer(id, channelReservation("R1", ChannelReservationConfirmed, testDate(21), testDate(25)))
+
+	result, err = s.SyncChannelListing(listing.ListingID)
+	if err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	if result.ReservationsUpdated != 1 || result.ReservationsImported != 0 {
+		t.Errorf("second sync = %+v, want 1 updated", result)
+	}
+
+	updated := channelBooking(t, s, listing, "R1")
+	if updated.BookingID != booking.BookingID || !updated.CheckInDate.Equal(testDate(21)) || !updated.CheckOutDate.Equal(testDate(25)) {
+		t.Errorf("updated booking = %+v", updated)
+	}
+
+	// Cancel
+	fake.offer(id, channelReservation("R1", ChannelReservationCancelled, testDate(21), testDate(25)))
+
+	result, err = s.SyncChannelListing(listing.ListingID)
+	if err != nil {
+		t.Fatalf("third sync: %v", err)
+	}
+	if result.ReservationsCancelled != 1 {
+		t.Errorf("third sync = %+v, want 1 cancelled", result)
+	}
+
+	if cancelled := channelBooking(t, s, listing, "R1"); cancelled.BookingStatus != "cancelled" {
+		t.Errorf("cancelled booking status = %s", cancelled.BookingStatus)
+	}
+	if acked := fake.acknowledgedReservations(id); len(acked) != 3 {
+		t.Errorf("acknowledged = %v, want every change acknowledged", acked)
+	}
+	if pending := fake.pendingReservations(id); len(pending) != 0 {
+		t.Errorf("pending = %+v, want none", pending)
+	}
+}
+
+// A channel cancellation of a stay that has started ends it today instead,
+// and is acknowledged and reported as ended early
+func TestSyncChannelListingCancelsStayInProgress(t *testing.T) {
+	s, fake, listing := newChannelTest(t)
+	id := listing.ExternalListingID
+
+	bookingID := createTestBooking(t, s, listing.PropertyID, testDate(-2), testDate(2))
+	_, err := s.db.Exec(`UPDATE bookings SET booking_amount = 400 WHERE booking_id = $1`, bookingID)
+	if err != nil {
+		t.Fatalf("Failed to price booking: %v", err)
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO channel_reservations (listing_id, external_reservation_id, booking_id, status)
+		VALUES ($1, 'R3', $2, 'imported')
+	`, listing.ListingID, bookingID)
+	if err != nil {
+		t.Fatalf("Failed to record reservation: %v", err)
+	}
+
+	fake.offer(id, channelReservation("R3", ChannelReservationCancelled, testDate(-2), testDate(2)))
+
+	result, err := s.SyncChannelListing(listing.ListingID)
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if result.ReservationsEndedEarly != 1 || result.ReservationsCancelled != 0 || result.ReservationsFailed != 0 {
+		t.Errorf("sync = %+v, want 1 ended early", result)
+	}
+	if acked := fake.acknowledgedReservations(id); len(acked) != 1 || acked[0] != "R3" {
+		t.Errorf("acknowledged = %v, want R3", acked)
+	}
+
+	booking := channelBooking(t, s, listing, "R3")
+	if booking.BookingStatus != "confirmed" || !booking.CheckOutDate.Equal(testDate(0)) {
+		t.Errorf("booking = %s until %s, want it confirmed until today", booking.BookingStatus, booking.CheckOutDate)
+	}
+	if booking.BookingAmount == nil || *booking.BookingAmount != 200 {
+		t.Errorf("booking amount = %v, want 200 for the 2 nights stayed", booking.BookingAmount)
+	}
+
+	records, err := s.GetChannelReservations(listing.ListingID)
+	if err != nil {
+		t.Fatalf("GetChannelReservations: %v", err)
+	}
+	if len(records) != 1 || records[0].Status != ChannelReservationCancelled || records[0].LastError != nil {
+		t.Errorf("records = %+v, want R3 recorded as cancelled", records)
+	}
+
+	// The released nights are pushed as available again
+	if availability := fake.pushedAvailability(id); len(availability) < 2 || !availability[0].Available || !availability[1].Available {
+		t.Errorf("pushed availability = %d days, want today and tomorrow free", len(availability))
+	}
+}
+
+// A property with units is pushed as unavailable only on nights when
+// every unit is taken
+func TestSyncChannelListingWithUnits(t *testing.T) {
+	s, fake, listing := newChannelTest(t)
+
+	unitType, err := s.CreateUnitType(listing.PropertyID, &UnitTypeRequest{Name: "Double", MaxGuests: 2})
+	if err != nil {
+		t.Fatalf("CreateUnitType: %v", err)
+	}
+	for _, name := range []string{"101", "102"} {
+		if _, err := s.CreateUnit(listing.PropertyID, &UnitRequest{UnitTypeID: unitType.UnitTypeID, UnitName: name}); err != nil {
+			t.Fatalf("CreateUnit: %v", err)
+		}
+	}
+
+	book := func(checkIn, checkOut time.Time) {
+		t.Helper()
+		_, err := s.CreateBooking(s.systemUserID, &CreateBookingRequest{
+			PropertyID:         listing.PropertyID,
+			GuestName:          "Unit Guest",
+			GuestIDCard:        "ID-5",
+			GuestContactNumber: "+94771234567",
+			CheckInDate:        checkIn.Format("2006-01-02"),
+			CheckOutDate:       checkOut.Format("2006-01-02"),
+			NumberOfGuests:     1,
+		})
+		if err != nil {
+			t.Fatalf("CreateBooking: %v", err)
+		}
+	}
+
+	// Both units on nights 10 and 11, one of them on night 15
+	book(testDate(10), testDate(12))
+	book(testDate(10), testDate(12))
+	book(testDate(15), testDate(16))
+
+	if _, err := s.SyncChannelListing(listing.ListingID); err != nil {
+		t.Fatalf("SyncChannelListing: %v", err)
+	}
+
+	availability := fake.pushedAvailability(listing.ExternalListingID)
+	if len(availability) != channelSyncDays {
+		t.Fatalf("pushed %d days, want %d", len(availability), channelSyncDays)
+	}
+	for i, day := range availability[:20] {
+		full := i == 10 || i == 11
+		if day.Available == full {
+			t.Errorf("pushed day %d = %+v, want available %v", i, day, !full)
+		}
+	}
+}
+
+func TestSyncChannelListingRecordsFailures(t *testing.T) {
+	s, fake, listing := newChannelTest(t)
+	id := listing.ExternalListingID
+
+	// A reservation clashing with a local booking is recorded as failed and
+	// left unacknowledged
+	createTestBooking(t, s, listing.PropertyID, testDate(30), testDate(33))
+	fake.offer(id, channelReservation("R2", ChannelReservationConfirmed, testDate(31), testDate(34)))
+
+	result, err := s.SyncChannelListing(listing.ListingID)
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if result.ReservationsFailed != 1 || result.ReservationsImported != 0 {
+		t.Errorf("sync = %+v, want 1 failed", result)
+	}
+	if pending := fake.pendingReservations(id); len(pending) != 1 {
+		t.Errorf("pending = %+v, want the failed reservation still offered", pending)
+	}
+
+	records, err := s.GetChannelReservations(listing.ListingID)
+	if err != nil {
+		t.Fatalf("GetChannelReservations: %v", err)
+	}
+	if len(records) != 1 || records[0].Status != ChannelReservationFailed || records[0].LastError == nil || records[0].BookingID != nil {
+		t.Errorf("records = %+v, want R2 recorded as failed", records)
+	}
+
+	// A channel error fails the sync and backs the listing off
+	fake.setFailPushes(true)
+
+	for failures := 1; failures <= 2; failures++ {
+		before := time.Now()
+		if _, err := s.SyncChannelListing(listing.ListingID); err == nil {
+			t.Fatalf("sync %d: expected the push to fail", failures)
+		}
+
+		failed, err := s.GetChannelListing(listing.ListingID)
+		if err != nil {
+			t.Fatalf("GetChannelListing: %v", err)
+		}
+		if failed.FailureCount != failures || failed.LastSyncError == nil || failed.NextSyncAt == nil {
+			t.Fatalf("listing after %d failures = %+v", failures, failed)
+		}
+
+		wait := failed.NextSyncAt.Sub(before)
+		if want := retryDelay(failures); wait < want || wait > want+time.Minute {
+			t.Errorf("retry after %d failures in %v, want %v", failures, wait, want)
+		}
+	}
+
+	// A successful sync clears the backoff
+	fake.setFailPushes(false)
+
+	if _, err := s.SyncChannelListing(listing.ListingID); err != nil {
+		t.Fatalf("sync after recovery: %v", err)
+	}
+
+	recovered, err := s.GetChannelListing(listing.ListingID)
+	if err != nil {
+		t.Fatalf("GetChannelListing: %v", err)
+	}
+	if recovered.FailureCount != 0 || recovered.LastSyncError != nil || recovered.NextSyncAt != nil || recovered.LastSyncedAt == nil {
+		t.Errorf("listing after recovery = %+v", recovered)
+	}
+}
+
+func TestSyncChannelListingWhileSyncing(t *testing.T) {
+	s, fake, listing := newChannelTest(t)
+	fake.holdPulls()
+
+	done := make(chan error)
+	go func() {
+		_, err := s.SyncChannelListing(listing.ListingID)
+		done <- err
+	}()
+
+	// The first sync has claimed the listing while it pulls reservations
+	<-fake.pulling
+
+	if _, err := s.SyncChannelListing(listing.ListingID); err != errChannelSyncInProgress {
+		t.Errorf("second sync: err = %v, want %v", err, errChannelSyncInProgress)
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/channel-listings/"+listing.ListingID.String()+"/sync", nil)
+	req = mux.SetURLVars(req, map[string]string{"listingId": listing.ListingID.String()})
+	rec := httptest.NewRecorder()
+	s.SyncChannelListingHandler(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("sync handler status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	// The listing is claimed, not locked, while the channel is called
+	_, err := s.db.Exec(`SELECT 1 FROM channel_listings WHERE listing_id = $1 FOR UPDATE NOWAIT`, listing.ListingID)
+	if err != nil {
+		t.Errorf("listing row is locked during the sync: %v", err)
+	}
+
+	fake.releasePulls()
+	if err := <-done; err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+
+	// Free again once the first sync is done
+	if _, err := s.SyncChannelListing(listing.ListingID); err != nil {
+		t.Errorf("sync after the first finished: %v", err)
+	}
+
+	if _, err := s.SyncChannelListing(uuid.New()); err == nil || err == errChannelSyncInProgress {
+		t.Errorf("syncing an unknown listing: err = %v, want not found", err)
+	}
+}
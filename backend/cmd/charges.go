@@ -0,0 +1,538 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Charge rule types, calculations and bases
+const (
+	ChargeTypeTax = "tax"
+	ChargeTypeFee = "fee"
+
+	CalculationPercentage = "percentage"
+	CalculationFixed      = "fixed"
+
+	ChargeBasisPerNight      = "per_night"
+	ChargeBasisPerGuest      = "per_guest"
+	ChargeBasisPerGuestNight = "per_guest_per_night"
+	ChargeBasisPerStay       = "per_stay"
+)
+
+// ChargeRule is a tax or fee configured for a property. Percentage rules are
+// applied to the accommodation amount; fixed rules are multiplied by the
+// number of nights and/or chargeable guests depending on the basis.
+type ChargeRule struct {
+	RuleID         uuid.UUID `json:"rule_id"`
+	PropertyID     uuid.UUID `json:"property_id"`
+	RuleName       string    `json:"rule_name"`
+	ChargeType     string    `json:"charge_type"`
+	Calculation    string    `json:"calculation"`
+	ChargeBasis    string    `json:"charge_basis"`
+	Rate           float64   `json:"rate"`
+	ExemptUnderAge *int      `json:"exempt_under_age,omitempty"`
+	IsActive       bool      `json:"is_active"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// BookingCharge is one line of the tax/fee breakdown stored with a booking
+type BookingCharge struct {
+	ChargeID    uuid.UUID  `json:"charge_id"`
+	BookingID   uuid.UUID  `json:"booking_id"`
+	RuleID      *uuid.UUID `json:"rule_id,omitempty"`
+	RuleName    string     `json:"rule_name"`
+	ChargeType  string     `json:"charge_type"`
+	Calculation string     `json:"calculation"`
+	ChargeBasis string     `json:"charge_basis"`
+	Rate        float64    `json:"rate"`
+	Quantity    int        `json:"quantity"`
+	Amount      float64    `json:"amount"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type ChargeRuleRequest struct {
+	RuleName       string  `json:"rule_name"`
+	ChargeType     string  `json:"charge_type"`
+	Calculation    string  `json:"calculation"`
+	ChargeBasis    string  `json:"charge_basis"`
+	Rate           float64 `json:"rate"`
+	ExemptUnderAge *int    `json:"exempt_under_age,omitempty"`
+	IsActive       *bool   `json:"is_active,omitempty"`
+}
+
+func (req *ChargeRuleRequest) validate() error {
+	if req.RuleName == "" {
+		return serviceError(http.StatusBadRequest, "rule_name is required")
+	}
+
+	if req.ChargeType != ChargeTypeTax && req.ChargeType != ChargeTypeFee {
+		return serviceError(http.StatusBadRequest, "charge_type must be 'tax' or 'fee'")
+	}
+
+	switch req.Calculation {
+	case CalculationPercentage:
+		if req.ChargeBasis == "" {
+			req.ChargeBasis = ChargeBasisPerStay
+		}
+		if req.ChargeBasis != ChargeBasisPerStay {
+			return serviceError(http.StatusBadRequest, "percentage rules apply to the whole stay and must use the 'per_stay' basis")
+		}
+	case CalculationFixed:
+		switch req.ChargeBasis {
+		case ChargeBasisPerNight, ChargeBasisPerGuest, ChargeBasisPerGuestNight, ChargeBasisPerStay:
+		default:
+			return serviceError(http.StatusBadRequest, "charge_basis must be one of 'per_night', 'per_guest', 'per_guest_per_night' or 'per_stay'")
+		}
+	default:
+		return serviceError(http.StatusBadRequest, "calculation must be 'percentage' or 'fixed'")
+	}
+
+	if req.Rate < 0 {
+		return serviceError(http.StatusBadRequest, "rate cannot be negative")
+	}
+
+	if req.ExemptUnderAge != nil && *req.ExemptUnderAge < 0 {
+		return serviceError(http.StatusBadRequest, "exempt_under_age cannot be negative")
+	}
+
+	return nil
+}
+
+// Get the tax and fee rules configured for a property
+func (s *BookingService) GetChargeRules(propertyID uuid.UUID) ([]ChargeRule, error) {
+	query := `
+		SELECT rule_id, property_id, rule_name, charge_type, calculation, charge_basis,
+			rate, exempt_under_age, is_active, created_at, updated_at
+		FROM property_charge_rules
+		WHERE property_id = $1
+		ORDER BY charge_type DESC, rule_name
+	`
+
+	return s.queryChargeRules(s.db, query, propertyID)
+}
+
+func (s *BookingService) CreateChargeRule(propertyID uuid.UUID, req *ChargeRuleRequest) (*ChargeRule, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	ruleID := uuid.New()
+	query := `
+		INSERT INTO property_charge_rules (
+			rule_id, property_id, rule_name, charge_type, calculation,
+			charge_basis, rate, exempt_under_age, is_active
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	_, err := s.db.Exec(query, ruleID, propertyID, req.RuleName, req.ChargeType,
+		req.Calculation, req.ChargeBasis, req.Rate, req.ExemptUnderAge, isActive)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetChargeRule(ruleID)
+}
+
+// Rule changes only affect bookings created or repriced afterwards
+func (s *BookingService) UpdateChargeRule(ruleID uuid.UUID, req *ChargeRuleRequest) (*ChargeRule, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	query := `
+		UPDATE property_charge_rules
+		SET rule_name = $1, charge_type = $2, calculation = $3, charge_basis = $4,
+			rate = $5, exempt_under_age = $6, is_active = $7, updated_at = CURRENT_TIMESTAMP
+		WHERE rule_id = $8
+	`
+
+	result, err := s.db.Exec(query, req.RuleName, req.ChargeType, req.Calculation,
+		req.ChargeBasis, req.Rate, req.ExemptUnderAge, isActive, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, serviceError(http.StatusNotFound, "charge rule not found")
+	}
+
+	return s.GetChargeRule(ruleID)
+}
+
+func (s *BookingService) DeleteChargeRule(ruleID uuid.UUID) error {
+	result, err := s.db.Exec(`DELETE FROM property_charge_rules WHERE rule_id = $1`, ruleID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return serviceError(http.StatusNotFound, "charge rule not found")
+	}
+
+	return nil
+}
+
+func (s *BookingService) GetChargeRule(ruleID uuid.UUID) (*ChargeRule, error) {
+	query := `
+		SELECT rule_id, property_id, rule_name, charge_type, calculation, charge_basis,
+			rate, exempt_under_age, is_active, created_at, updated_at
+		FROM property_charge_rules
+		WHERE rule_id = $1
+	`
+
+	rules, err := s.queryChargeRules(s.db, query, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rules) == 0 {
+		return nil, serviceError(http.StatusNotFound, "charge rule not found")
+	}
+
+	return &rules[0], nil
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func (s *BookingService) queryChargeRules(q queryer, query string, args ...interface{}) ([]ChargeRule, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []ChargeRule
+
+	for rows.Next() {
+		var rule ChargeRule
+		err := rows.Scan(
+			&rule.RuleID, &rule.PropertyID, &rule.RuleName, &rule.ChargeType,
+			&rule.Calculation, &rule.ChargeBasis, &rule.Rate, &rule.ExemptUnderAge,
+			&rule.IsActive, &rule.CreatedAt, &rule.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// applyCharges recalculates the tax/fee breakdown of a booking from the
+// property's active rules, replacing whatever breakdown was stored before.
+func (s *BookingService) applyCharges(tx *sql.Tx, bookingID uuid.UUID) error {
+	var propertyID uuid.UUID
+	var nights, numberOfGuests int
+	var amount sql.NullFloat64
+
+	err := tx.QueryRow(`
+		SELECT property_id, check_out_date - check_in_date, number_of_guests, booking_amount
+		FROM bookings
+		WHERE booking_id = $1
+	`, bookingID).Scan(&propertyID, &nights, &numberOfGuests, &amount)
+	if err != nil {
+		return err
+	}
+
+	// Percentage rules apply to the accommodation amount after discounts
+	var base float64
+	if amount.Valid {
+		discount, err := recalculateDiscount(tx, bookingID, amount.Float64)
+		if err != nil {
+			return err
+		}
+		base = amount.Float64 - discount
+	}
+
+	rules, err := s.queryChargeRules(tx, `
+		SELECT rule_id, property_id, rule_name, charge_type, calculation, charge_basis,
+			rate, exempt_under_age, is_active, created_at, updated_at
+		FROM property_charge_rules
+		WHERE property_id = $1 AND is_active = TRUE
+		ORDER BY charge_type DESC, rule_name
+	`, propertyID)
+	if err != nil {
+		return err
+	}
+
+	ages, err := additionalGuestAges(tx, bookingID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM booking_charges WHERE booking_id = $1`, bookingID); err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		quantity, chargeAmount := computeCharge(&rule, base, nights, numberOfGuests, ages)
+		if chargeAmount == 0 {
+			continue
+		}
+
+		_, err := tx.Exec(`
+			INSERT INTO booking_charges (
+				charge_id, booking_id, rule_id, rule_name, charge_type,
+				calculation, charge_basis, rate, quantity, amount
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`, uuid.New(), bookingID, rule.RuleID, rule.RuleName, rule.ChargeType,
+			rule.Calculation, rule.ChargeBasis, rule.Rate, quantity, chargeAmount)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// computeCharge works out the quantity and rounded amount one rule charges
+// on a stay. base is the accommodation amount after discounts.
+func computeCharge(rule *ChargeRule, base float64, nights, numberOfGuests int, ages []*int) (int, float64) {
+	if rule.Calculation == CalculationPercentage {
+		return 1, roundMoney(base * rule.Rate / 100)
+	}
+
+	guests := chargeableGuests(numberOfGuests, ages, rule.ExemptUnderAge)
+
+	quantity := 1
+	switch rule.ChargeBasis {
+	case ChargeBasisPerNight:
+		quantity = nights
+	case ChargeBasisPerGuest:
+		quantity = guests
+	case ChargeBasisPerGuestNight:
+		quantity = guests * nights
+	}
+
+	return quantity, roundMoney(rule.Rate * float64(quantity))
+}
+
+// chargeableGuests counts the guests a per-guest rule applies to. Additional
+// guests younger than the exemption age are not charged; the main guest and
+// guests without a recorded age always are.
+func chargeableGuests(numberOfGuests int, ages []*int, exemptUnderAge *int) int {
+	if exemptUnderAge == nil {
+		return numberOfGuests
+	}
+
+	guests := numberOfGuests
+	for _, age := range ages {
+		if age != nil && *age < *exemptUnderAge {
+			guests--
+		}
+	}
+
+	if guests < 1 {
+		guests = 1
+	}
+
+	return guests
+}
+
+func additionalGuestAges(q queryer, bookingID uuid.UUID) ([]*int, error) {
+	rows, err := q.Query(`SELECT guest_age FROM booking_guests WHERE booking_id = $1`, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ages []*int
+
+	for rows.Next() {
+		var age *int
+		if err := rows.Scan(&age); err != nil {
+			return nil, err
+		}
+
+		ages = append(ages, age)
+	}
+
+	return ages, nil
+}
+
+func (s *BookingService) getBookingCharges(q queryer, bookingID uuid.UUID) ([]BookingCharge, error) {
+	query := `
+		SELECT charge_id, booking_id, rule_id, rule_name, charge_type, calculation,
+			charge_basis, rate, quantity, amount, created_at
+		FROM booking_charges
+		WHERE booking_id = $1
+		ORDER BY charge_type DESC, rule_name
+	`
+
+	rows, err := q.Query(query, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var charges []BookingCharge
+
+	for rows.Next() {
+		var charge BookingCharge
+		err := rows.Scan(
+			&charge.ChargeID, &charge.BookingID, &charge.RuleID, &charge.RuleName,
+			&charge.ChargeType, &charge.Calculation, &charge.ChargeBasis, &charge.Rate,
+			&charge.Quantity, &charge.Amount, &charge.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		charges = append(charges, charge)
+	}
+
+	return charges, nil
+}
+
+// bookingTotal is the accommodation amount less discounts, plus all taxes and fees
+func bookingTotal(booking *Booking) *float64 {
+	if booking.BookingAmount == nil && len(booking.Charges) == 0 {
+		return nil
+	}
+
+	var total float64
+	if booking.BookingAmount != nil {
+		total = *booking.BookingAmount
+	}
+
+	if booking.Discount != nil {
+		total -= booking.Discount.DiscountAmount
+	}
+
+	for _, charge := range booking.Charges {
+		total += charge.Amount
+	}
+
+	total = roundMoney(total)
+	return &total
+}
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// HTTP Handlers
+func (s *BookingService) GetChargeRulesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	rules, err := s.GetChargeRules(propertyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+func (s *BookingService) CreateChargeRuleHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ChargeRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := s.CreateChargeRule(propertyID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+func (s *BookingService) UpdateChargeRuleHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ruleIDStr := vars["ruleId"]
+
+	ruleID, err := uuid.Parse(ruleIDStr)
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ChargeRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := s.UpdateChargeRule(ruleID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+func (s *BookingService) DeleteChargeRuleHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ruleIDStr := vars["ruleId"]
+
+	ruleID, err := uuid.Parse(ruleIDStr)
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.DeleteChargeRule(ruleID); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestComputeCharge(t *testing.T) {
+	age := func(years int) *int { return &years }
+	twelve := 12
+
+	tests := []struct {
+		name         string
+		rule         ChargeRule
+		base         float64
+		nights       int
+		guests       int
+		ages         []*int
+		wantQuantity int
+		wantAmount   float64
+	}{
+		{"percentage of the discounted base", ChargeRule{Calculation: CalculationPercentage, ChargeBasis: ChargeBasisPerStay, Rate: 10}, 450, 3, 2, nil, 1, 45},
+		{"percentage rounded to cents", ChargeRule{Calculation: CalculationPercentage, ChargeBasis: ChargeBasisPerStay, Rate: 12.5}, 99.99, 3, 2, nil, 1, 12.5},
+		{"percentage without an amount", ChargeRule{Calculation: CalculationPercentage, ChargeBasis: ChargeBasisPerStay, Rate: 10}, 0, 3, 2, nil, 1, 0},
+		{"per stay", ChargeRule{Calculation: CalculationFixed, ChargeBasis: ChargeBasisPerStay, Rate: 50}, 450, 3, 2, nil, 1, 50},
+		{"per night", ChargeRule{Calculation: CalculationFixed, ChargeBasis: ChargeBasisPerNight, Rate: 4.5}, 450, 3, 2, nil, 3, 13.5},
+		{"per guest", ChargeRule{Calculation: CalculationFixed, ChargeBasis: ChargeBasisPerGuest, Rate: 10}, 450, 3, 3, nil, 3, 30},
+		{"per guest per night", ChargeRule{Calculation: CalculationFixed, ChargeBasis: ChargeBasisPerGuestNight, Rate: 2}, 450, 3, 3, nil, 9, 18},
+		{"child exempted by age", ChargeRule{Calculation: CalculationFixed, ChargeBasis: ChargeBasisPerGuestNight, Rate: 2, ExemptUnderAge: &twelve}, 450, 3, 3, []*int{age(8), age(30)}, 6, 12},
+		{"guest of the exemption age charged", ChargeRule{Calculation: CalculationFixed, ChargeBasis: ChargeBasisPerGuest, Rate: 10, ExemptUnderAge: &twelve}, 450, 3, 2, []*int{age(12)}, 2, 20},
+		{"guest without an age charged", ChargeRule{Calculation: CalculationFixed, ChargeBasis: ChargeBasisPerGuest, Rate: 10, ExemptUnderAge: &twelve}, 450, 3, 2, []*int{nil}, 2, 20},
+		{"ages ignored without an exemption", ChargeRule{Calculation: CalculationFixed, ChargeBasis: ChargeBasisPerGuest, Rate: 10}, 450, 3, 2, []*int{age(3)}, 2, 20},
+		{"at least one guest charged", ChargeRule{Calculation: CalculationFixed, ChargeBasis: ChargeBasisPerGuest, Rate: 10, ExemptUnderAge: &twelve}, 450, 3, 1, []*int{age(2), age(4)}, 1, 10},
+	}
+
+	for _, tt := range tests {
+		quantity, amount := computeCharge(&tt.rule, tt.base, tt.nights, tt.guests, tt.ages)
+		if quantity != tt.wantQuantity || amount != tt.wantAmount {
+			t.Errorf("%s: computeCharge = %d, %.2f, want %d, %.2f", tt.name, quantity, amount, tt.wantQuantity, tt.wantAmount)
+		}
+	}
+}
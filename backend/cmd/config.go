@@ -1,9 +1,11 @@
 package main
 
 import (
-	"os"
-	// "strconv"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -16,6 +18,63 @@ type Config struct {
 	DBName     string
 	DBSSLMode  string
 	ServerPort string
+
+	// How often external iCal feeds are imported; 0 disables the importer
+	ICalImportInterval time.Duration
+
+	// Booking channels as name=base URL pairs, the API key sent to them, and
+	// how often each listing is synced; 0 disables the sync worker
+	ChannelEndpoints    map[string]string
+	ChannelAPIKey       string
+	ChannelSyncInterval time.Duration
+
+	// How often open-ended long stays are extended and their months billed;
+	// 0 disables the worker
+	LongStayInterval time.Duration
+
+	// How often expired waitlist holds are released; 0 disables the worker
+	WaitlistInterval time.Duration
+
+	// SMTP server guest emails are sent through; without a host they are
+	// only logged
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	// Provider guest text messages are sent through: "twilio" for a
+	// Twilio-style API, "stub" to only log them, or empty for none. With
+	// SMSWhatsApp set the Twilio provider sends WhatsApp messages instead.
+	SMSProvider   string
+	SMSAPIURL     string
+	SMSAccountSID string
+	SMSAuthToken  string
+	SMSFrom       string
+	SMSWhatsApp   bool
+
+	// Country code assumed for phone numbers written without one, e.g. "94";
+	// empty requires numbers in international format
+	DefaultCountryCode string
+
+	// How often the notification outbox is delivered; 0 disables the worker.
+	// Reminders go out this many days before check-in; 0 disables them.
+	NotificationInterval time.Duration
+	ReminderDaysBefore   int
+
+	// How often the domain events are published to their sinks; 0 disables
+	// the worker
+	EventDispatchInterval time.Duration
+
+	// How often due webhook deliveries are sent; 0 disables the worker.
+	// Webhooks may only point at public addresses unless
+	// WebhookAllowPrivateHosts is set, e.g. in development.
+	WebhookInterval          time.Duration
+	WebhookAllowPrivateHosts bool
+
+	// User recorded as the creator of bookings made by background jobs and,
+	// until requests are authenticated, as acting on API requests
+	SystemUserID string
 }
 
 func LoadConfig() *Config {
@@ -32,6 +91,41 @@ func LoadConfig() *Config {
 		DBName:     getEnv("DB_NAME", ""),
 		DBSSLMode:  getEnv("DB_SSLMODE", ""),
 		ServerPort: getEnv("SERVER_PORT", "8080"),
+
+		ICalImportInterval: getEnvDuration("ICAL_IMPORT_INTERVAL", 30*time.Minute),
+
+		ChannelEndpoints:    getEnvMap("CHANNEL_ENDPOINTS"),
+		ChannelAPIKey:       getEnv("CHANNEL_API_KEY", ""),
+		ChannelSyncInterval: getEnvDuration("CHANNEL_SYNC_INTERVAL", 15*time.Minute),
+
+		LongStayInterval: getEnvDuration("LONG_STAY_INTERVAL", 6*time.Hour),
+
+		WaitlistInterval: getEnvDuration("WAITLIST_INTERVAL", 15*time.Minute),
+
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:     getEnv("SMTP_FROM", ""),
+
+		SMSProvider:   getEnv("SMS_PROVIDER", ""),
+		SMSAPIURL:     getEnv("SMS_API_URL", "https://api.twilio.com"),
+		SMSAccountSID: getEnv("SMS_ACCOUNT_SID", ""),
+		SMSAuthToken:  getEnv("SMS_AUTH_TOKEN", ""),
+		SMSFrom:       getEnv("SMS_FROM", ""),
+		SMSWhatsApp:   getEnv("SMS_WHATSAPP", "") == "true",
+
+		DefaultCountryCode: getEnv("DEFAULT_COUNTRY_CODE", ""),
+
+		NotificationInterval: getEnvDuration("NOTIFICATION_INTERVAL", time.Minute),
+		ReminderDaysBefore:   getEnvInt("REMINDER_DAYS_BEFORE", 2),
+
+		EventDispatchInterval: getEnvDuration("EVENT_DISPATCH_INTERVAL", 5*time.Second),
+
+		WebhookInterval:          getEnvDuration("WEBHOOK_INTERVAL", 30*time.Second),
+		WebhookAllowPrivateHosts: getEnv("WEBHOOK_ALLOW_PRIVATE_HOSTS", "") == "true",
+
+		SystemUserID: getEnv("SYSTEM_USER_ID", ""),
 	}
 }
 
@@ -41,3 +135,46 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return duration
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return number
+}
+
+// getEnvMap reads "a=1,b=2" into a map
+func getEnvMap(key string) map[string]string {
+	values := make(map[string]string)
+
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" || value == "" {
+			continue
+		}
+		values[name] = value
+	}
+
+	return values
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// SQLSTATE raised by the overlap exclusion constraints and triggers, and by
+// unique and foreign key constraints
+const (
+	pqExclusionViolation  = "23P01"
+	pqUniqueViolation     = "23505"
+	pqForeignKeyViolation = "23503"
+)
+
+// How far either side of the requested dates to look for free windows, and
+// how many windows and alternative properties to suggest
+const (
+	conflictSearchDays       = 60
+	maxSuggestedDates        = 3
+	maxAlternativeProperties = 5
+)
+
+// BookingConflictError reports dates the database refused because they are
+// already taken by another booking or an availability block, with what they
+// clash with and, for bookings, where the guest could stay instead
+type BookingConflictError struct {
+	Message               string                `json:"error"`
+	Code                  int                   `json:"code"`
+	ConflictingBookingID  *uuid.UUID            `json:"conflicting_booking_id,omitempty"`
+	ConflictingBlockID    *uuid.UUID            `json:"conflicting_block_id,omitempty"`
+	Conflicts             []ConflictingStay     `json:"conflicts"`
+	SuggestedDates        []DateWindow          `json:"suggested_dates,omitempty"`
+	AlternativeProperties []AlternativeProperty `json:"alternative_properties,omitempty"`
+}
+
+func (e *BookingConflictError) Error() string {
+	return e.Message
+}
+
+// ConflictingStay is a booking or block occupying some of the requested nights
+type ConflictingStay struct {
+	Type          string    `json:"type"` // "booking" or "block"
+	ID            uuid.UUID `json:"id"`
+	StartDate     time.Time `json:"start_date"`
+	EndDate       time.Time `json:"end_date"`
+	GuestName     *string   `json:"guest_name,omitempty"`
+	BookingStatus *string   `json:"booking_status,omitempty"`
+	BlockType     *string   `json:"block_type,omitempty"`
+	Reason        *string   `json:"reason,omitempty"`
+}
+
+// DateWindow is a free stay of the requested length
+type DateWindow struct {
+	CheckInDate  string `json:"check_in_date"`  // "2024-01-15" format
+	CheckOutDate string `json:"check_out_date"` // "2024-01-20" format
+}
+
+// AlternativeProperty is another property free on the requested dates
+type AlternativeProperty struct {
+	PropertyID   uuid.UUID `json:"property_id"`
+	PropertyName string    `json:"property_name"`
+	PropertyType *string   `json:"property_type,omitempty"`
+	MaxGuests    int       `json:"max_guests"`
+}
+
+// Conflicting stay types
+const (
+	ConflictTypeBooking = "booking"
+	ConflictTypeBlock   = "block"
+)
+
+func isExclusionViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pqExclusionViolation
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pqForeignKeyViolation
+}
+
+// asBookingConflict turns an overlap rejected by the database into a
+// BookingConflictError listing what it clashed with. Other errors are
+// returned unchanged. The booking or block being written is excluded; given
+// a unit, only what holds that unit is listed.
+func (s *BookingService) asBookingConflict(err error, propertyID uuid.UUID, unitID *uuid.UUID, from, to time.Time, excludeBookingID, excludeBlockID *uuid.UUID) error {
+	if !isExclusionViolation(err) {
+		return err
+	}
+
+	conflict, lookupErr := s.diagnoseConflict(s.db, propertyID, unitID, from, to, excludeBookingID, excludeBlockID)
+	if lookupErr != nil {
+		return err
+	}
+
+	return conflict
+}
+
+// asStayConflict is asBookingConflict for a guest stay. It also suggests the
+// nearest free windows of the same length on the property (or the booked
+// unit, on properties with units) and other
+// properties with room for the guests that are free on the requested dates.
+func (s *BookingService) asStayConflict(err error, propertyID uuid.UUID, unitID *uuid.UUID, from, to time.Time, guests int, excludeBookingID *uuid.UUID) error {
+	if !isExclusionViolation(err) {
+		return err
+	}
+
+	conflict, lookupErr := s.diagnoseConflict(s.db, propertyID, unitID, from, to, excludeBookingID, nil)
+	if lookupErr != nil {
+		return err
+	}
+
+	// Suggestions are best effort; the conflict is reported either way
+	conflict.SuggestedDates, _ = s.suggestDates(propertyID, unitID, from, to, excludeBookingID)
+	conflict.AlternativeProperties, _ = s.alternativeProperties(propertyID, from, to, guests)
+
+	return conflict
+}
+
+// asBookingUpdateConflict is asStayConflict for an update of an existing
+// booking, where the new dates and guest count may only be partly given
+func (s *BookingService) asBookingUpdateConflict(err error, bookingID uuid.UUID, checkIn, checkOut *time.Time, guests *int) error {
+	if !isExclusionViolation(err) {
+		return err
+	}
+
+	var propertyID uuid.UUID
+	var unitID *uuid.UUID
+	var from, to time.Time
+	var numberOfGuests int
+	lookupErr := s.db.QueryRow(`
+		SELECT property_id, unit_id, check_in_date, check_out_date, number_of_guests FROM bookings WHERE booking_id = $1
+	`, bookingID).Scan(&propertyID, &unitID, &from, &to, &numberOfGuests)
+	if lookupErr != nil {
+		return err
+	}
+
+	if checkIn != nil {
+		from = *checkIn
+	}
+	if checkOut != nil {
+		to = *checkOut
+	}
+	if guests != nil {
+		numberOfGuests = *guests
+	}
+
+	return s.asStayConflict(err, propertyID, unitID, from, to, numberOfGuests, &bookingID)
+}
+
+// lockPropertyAvailability takes the lock the overlap triggers take on a
+// property, for checks of its availability the triggers do not make. It is
+// held until the transaction ends.
+func lockPropertyAvailability(q queryer, propertyID uuid.UUID) error {
+	_, err := q.Exec(`SELECT pg_advisory_xact_lock(hashtext('property_availability'), hashtext($1::TEXT))`, propertyID)
+	return err
+}
+
+func (s *BookingService) diagnoseConflict(q queryer, propertyID uuid.UUID, unitID *uuid.UUID, from, to time.Time, excludeBookingID, excludeBlockID *uuid.UUID) (*BookingConflictError, error) {
+	stays, err := s.occupiedStays(q, propertyID, unitID, from, to, excludeBookingID, excludeBlockID)
+	if err != nil {
+		return nil, err
+	}
+
+	conflict := &BookingConflictError{
+		Message:   "dates overlap with an existing booking for this property",
+		Code:      http.StatusConflict,
+		Conflicts: stays,
+	}
+
+	// Name the first clash on its own as well, bookings before blocks. The
+	// clashing booking may already be gone again; it is still a conflict.
+	for i := range stays {
+		if stays[i].Type == ConflictTypeBooking {
+			conflict.ConflictingBookingID = &stays[i].ID
+			return conflict, nil
+		}
+	}
+	if len(stays) > 0 {
+		conflict.Message = "dates overlap with an availability block for this property"
+		conflict.ConflictingBlockID = &stays[0].ID
+	}
+
+	return conflict, nil
+}
+
+// occupiedStays returns the active bookings and blocks of a property that
+// overlap the given range (end exclusive), ordered by start date. Given a
+// unit, only bookings and blocks of that unit or of the whole property are
+// returned.
+func (s *BookingService) occupiedStays(q queryer, propertyID uuid.UUID, unitID *uuid.UUID, from, to time.Time, excludeBookingID, excludeBlockID *uuid.UUID) ([]ConflictingStay, error) {
+	query := `
+		SELECT 'booking', booking_id, check_in_date, check_out_date,
+			guest_name, booking_status, NULL, NULL
+		FROM bookings
+		WHERE property_id = $1
+		AND booking_status IN ('confirmed', 'pending')
+		AND check_in_date < $3
+		AND (check_out_date > $2 OR booking_id IN (SELECT booking_id FROM long_stays WHERE open_ended))
+		AND ($4::UUID IS NULL OR booking_id != $4)
+		AND ($6::UUID IS NULL OR unit_id = $6 OR unit_id IS NULL)
+		UNION ALL
+		SELECT 'block', block_id, start_date, end_date,
+			NULL, NULL, block_type, reason
+		FROM availability_blocks
+		WHERE property_id = $1
+		AND start_date < $3 AND end_date > $2
+		AND ($5::UUID IS NULL OR block_id != $5)
+		AND ($6::UUID IS NULL OR unit_id = $6 OR unit_id IS NULL)
+		ORDER BY 3
+	`
+
+	rows, err := q.Query(query, propertyID, from, to, excludeBookingID, excludeBlockID, unitID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stays := []ConflictingStay{}
+
+	for rows.Next() {
+		var stay ConflictingStay
+		err := rows.Scan(
+			&stay.Type, &stay.ID, &stay.StartDate, &stay.EndDate,
+			&stay.GuestName, &stay.BookingStatus, &stay.BlockType, &stay.Reason,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		stays = append(stays, stay)
+	}
+
+	return stays, nil
+}
+
+// suggestDates finds the free windows of the same length as from..to
+// closest to it, trying one day later, one day earlier, two days later and
+// so on. Windows starting in the past are not suggested.
+func (s *BookingService) suggestDates(propertyID uuid.UUID, unitID *uuid.UUID, from, to time.Time, excludeBookingID *uuid.UUID) ([]DateWindow, error) {
+	nights := int(to.Sub(from).Hours() / 24)
+	searchFrom := from.AddDate(0, 0, -conflictSearchDays)
+	searchTo := to.AddDate(0, 0, conflictSearchDays)
+
+	stays, err := s.occupiedStays(s.db, propertyID, unitID, searchFrom, searchTo, excludeBookingID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	free := func(checkIn time.Time) bool {
+		if checkIn.Before(today) {
+			return false
+		}
+		checkOut := checkIn.AddDate(0, 0, nights)
+		for _, stay := range stays {
+			if checkIn.Before(stay.EndDate) && checkOut.After(stay.StartDate) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var windows []DateWindow
+	for offset := 1; offset <= conflictSearchDays && len(windows) < maxSuggestedDates; offset++ {
+		for _, checkIn := range []time.Time{from.AddDate(0, 0, offset), from.AddDate(0, 0, -offset)} {
+			if len(windows) < maxSuggestedDates && free(checkIn) {
+				windows = append(windows, DateWindow{
+					CheckInDate:  checkIn.Format("2006-01-02"),
+					CheckOutDate: checkIn.AddDate(0, 0, nights).Format("2006-01-02"),
+				})
+			}
+		}
+	}
+
+	return windows, nil
+}
+
+// alternativeProperties lists other properties the stay could move to, as
+// found by the availability search, closest in capacity to the requested one
+func (s *BookingService) alternativeProperties(propertyID uuid.UUID, from, to time.Time, guests int) ([]AlternativeProperty, error) {
+	var capacity int
+	err := s.db.QueryRow(`SELECT max_guests FROM properties WHERE property_id = $1`, propertyID).Scan(&capacity)
+	if err != nil {
+		return nil, err
+	}
+
+	available, err := s.SearchAvailability(&AvailabilitySearch{CheckInDate: from, CheckOutDate: to, Guests: guests})
+	if err != nil {
+		return nil, err
+	}
+
+	distance := func(maxGuests int) int {
+		if maxGuests > capacity {
+			return maxGuests - capacity
+		}
+		return capacity - maxGuests
+	}
+	sort.SliceStable(available, func(i, j int) bool {
+		return distance(available[i].MaxGuests) < distance(available[j].MaxGuests)
+	})
+
+	var properties []AlternativeProperty
+	for _, property := range available {
+		if property.PropertyID == propertyID {
+			continue
+		}
+		if len(properties) == maxAlternativeProperties {
+			break
+		}
+
+		properties = append(properties, AlternativeProperty{
+			PropertyID:   property.PropertyID,
+			PropertyName: property.PropertyName,
+			PropertyType: property.PropertyType,
+			MaxGuests:    property.MaxGuests,
+		})
+	}
+
+	return properties, nil
+}
+
+// ServiceError is a request refused because of what it asks for or the
+// records it is made against, rather than a failure to carry it out. Code
+// is the HTTP status it is answered with.
+type ServiceError struct {
+	Message string `json:"error"`
+	Code    int    `json:"code"`
+}
+
+func (e *ServiceError) Error() string {
+	return e.Message
+}
+
+func serviceError(code int, format string, args ...interface{}) *ServiceError {
+	return &ServiceError{Message: fmt.Sprintf(format, args...), Code: code}
+}
+
+// writeServiceError answers with 409 Conflict and the clashing booking or
+// block for overlaps, 400 with the broken rules for stay rule violations,
+// the error's own status for other refused requests, and 500 otherwise
+func writeServiceError(w http.ResponseWriter, err error) {
+	var conflict *BookingConflictError
+	if errors.As(err, &conflict) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(conflict)
+		return
+	}
+
+	var ruleErr *StayRuleError
+	if errors.As(err, &ruleErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ruleErr)
+		return
+	}
+
+	var serviceErr *ServiceError
+	if errors.As(err, &serviceErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(serviceErr.Code)
+		json.NewEncoder(w).Encode(serviceErr)
+		return
+	}
+
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Concurrent bookings of the same dates: the database lets exactly one
+// through and the others are answered with 409 Conflict
+func TestCreateBookingConcurrentConflicts(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	const attempts = 10
+
+	checkIn := testDate(90).Format("2006-01-02")
+	checkOut := testDate(93).Format("2006-01-02")
+
+	start := make(chan struct{})
+	errs := make([]error, attempts)
+
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+
+			_, errs[i] = s.CreateBooking(s.systemUserID, &CreateBookingRequest{
+				PropertyID:         propertyID,
+				GuestName:          "Concurrent Guest",
+				GuestIDCard:        "ID-1",
+				GuestContactNumber: "+94771234567",
+				CheckInDate:        checkIn,
+				CheckOutDate:       checkOut,
+				NumberOfGuests:     1,
+			})
+		}(i)
+	}
+
+	close(start)
+	wg.Wait()
+
+	var created int
+	var winner *BookingConflictError
+
+	for i, err := range errs {
+		if err == nil {
+			created++
+			continue
+		}
+
+		var conflict *BookingConflictError
+		if !errors.As(err, &conflict) {
+			t.Errorf("attempt %d: err = %v, want a BookingConflictError", i, err)
+			continue
+		}
+		if conflict.ConflictingBookingID == nil {
+			t.Errorf("attempt %d: conflict does not name the booking it clashes with: %+v", i, conflict)
+		}
+		winner = conflict
+
+		rec := httptest.NewRecorder()
+		writeServiceError(rec, err)
+		if rec.Code != http.StatusConflict || conflict.Code != http.StatusConflict {
+			t.Errorf("attempt %d: status = %d, code = %d, want %d", i, rec.Code, conflict.Code, http.StatusConflict)
+		}
+	}
+
+	if created != 1 {
+		t.Fatalf("%d of %d bookings were created, want exactly 1", created, attempts)
+	}
+
+	var bookings int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM bookings WHERE property_id = $1 AND booking_status IN ('confirmed', 'pending')
+	`, propertyID).Scan(&bookings)
+	if err != nil {
+		t.Fatalf("Failed to count bookings: %v", err)
+	}
+	if bookings != 1 {
+		t.Errorf("property has %d active bookings, want 1", bookings)
+	}
+
+	if winner != nil && len(winner.Conflicts) != 1 {
+		t.Errorf("conflicts = %+v, want the one booking that was created", winner.Conflicts)
+	}
+}
+
+// An overlap names the clashing booking and suggests the nearest free
+// windows of the same length and other properties free on those dates
+func TestCreateBookingConflictSuggestions(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	alternativeID := createTestProperty(t, s)
+
+	// A capacity no other test property has, so the alternative is the closest
+	for _, id := range []uuid.UUID{propertyID, alternativeID} {
+		if _, err := s.db.Exec(`UPDATE properties SET max_guests = 37 WHERE property_id = $1`, id); err != nil {
+			t.Fatalf("Failed to set capacity: %v", err)
+		}
+	}
+
+	bookingID := createTestBooking(t, s, propertyID, testDate(10), testDate(13))
+
+	_, err := s.CreateBooking(s.systemUserID, &CreateBookingRequest{
+		PropertyID:         propertyID,
+		GuestName:          "Late Guest",
+		GuestIDCard:        "ID-2",
+		GuestContactNumber: "+94771234567",
+		CheckInDate:        testDate(11).Format("2006-01-02"),
+		CheckOutDate:       testDate(13).Format("2006-01-02"),
+		NumberOfGuests:     30,
+	})
+
+	var conflict *BookingConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("CreateBooking: err = %v, want a BookingConflictError", err)
+	}
+
+	if len(conflict.Conflicts) != 1 || conflict.Conflicts[0].ID != bookingID || conflict.Conflicts[0].Type != ConflictTypeBooking {
+		t.Errorf("conflicts = %+v, want booking %s", conflict.Conflicts, bookingID)
+	}
+
+	// One day later and earlier still clash; then two days later, three
+	// days later and three days earlier are free
+	want := []DateWindow{
+		{testDate(13).Format("2006-01-02"), testDate(15).Format("2006-01-02")},
+		{testDate(14).Format("2006-01-02"), testDate(16).Format("2006-01-02")},
+		{testDate(8).Format("2006-01-02"), testDate(10).Format("2006-01-02")},
+	}
+	if len(conflict.SuggestedDates) != len(want) {
+		t.Fatalf("suggested dates = %+v, want %+v", conflict.SuggestedDates, want)
+	}
+	for i, window := range want {
+		if conflict.SuggestedDates[i] != window {
+			t.Errorf("suggested dates %d = %+v, want %+v", i, conflict.SuggestedDates[i], window)
+		}
+	}
+
+	if len(conflict.AlternativeProperties) == 0 || conflict.AlternativeProperties[0].PropertyID != alternativeID {
+		t.Errorf("alternative properties = %+v, want %s first", conflict.AlternativeProperties, alternativeID)
+	}
+	for _, property := range conflict.AlternativeProperties {
+		if property.PropertyID == propertyID {
+			t.Errorf("the requested property is suggested as its own alternative")
+		}
+	}
+}
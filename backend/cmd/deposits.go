@@ -0,0 +1,477 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Deposit statuses
+const (
+	DepositStatusHeld             = "held"
+	DepositStatusReleased         = "released"
+	DepositStatusPartiallyClaimed = "partially_claimed"
+)
+
+type Deposit struct {
+	DepositID      uuid.UUID     `json:"deposit_id"`
+	BookingID      uuid.UUID     `json:"booking_id"`
+	AmountHeld     float64       `json:"amount_held"`
+	AmountClaimed  float64       `json:"amount_claimed"`
+	AmountRefunded *float64      `json:"amount_refunded,omitempty"`
+	DepositStatus  string        `json:"deposit_status"`
+	ReleasedAt     *time.Time    `json:"released_at,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+	Claims         []DamageClaim `json:"claims,omitempty"`
+}
+
+type DamageClaim struct {
+	ClaimID     uuid.UUID `json:"claim_id"`
+	DepositID   uuid.UUID `json:"deposit_id"`
+	Description string    `json:"description"`
+	ClaimAmount float64   `json:"claim_amount"`
+	CreatedBy   uuid.UUID `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type OutstandingDeposit struct {
+	Deposit
+	PropertyID   uuid.UUID `json:"property_id"`
+	GuestName    string    `json:"guest_name"`
+	CheckOutDate time.Time `json:"check_out_date"`
+	DaysOverdue  int       `json:"days_overdue"`
+}
+
+type SetDepositRequest struct {
+	AmountHeld float64 `json:"amount_held"`
+}
+
+type CreateDamageClaimRequest struct {
+	Description string  `json:"description"`
+	ClaimAmount float64 `json:"claim_amount"`
+}
+
+// Hold (or adjust) the security deposit for a booking
+func (s *BookingService) SetDeposit(bookingID uuid.UUID, req *SetDepositRequest) (*Deposit, error) {
+	if req.AmountHeld < 0 {
+		return nil, serviceError(http.StatusBadRequest, "deposit amount cannot be negative")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM bookings WHERE booking_id = $1)`, bookingID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, serviceError(http.StatusNotFound, "booking not found")
+	}
+
+	// Lock the deposit before summing its claims so a claim cannot be added
+	// between the check and the update
+	var depositID uuid.UUID
+	var status string
+	err = tx.QueryRow(`
+		SELECT deposit_id, deposit_status
+		FROM booking_deposits
+		WHERE booking_id = $1
+		FOR UPDATE
+	`, bookingID).Scan(&depositID, &status)
+
+	switch {
+	case err == sql.ErrNoRows:
+		if err := insertDeposit(tx, bookingID, req.AmountHeld); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		if status != DepositStatusHeld {
+			return nil, serviceError(http.StatusConflict, "deposit is already settled (%s)", status)
+		}
+
+		var claimed float64
+		if err := tx.QueryRow(`SELECT COALESCE(SUM(claim_amount), 0) FROM damage_claims WHERE deposit_id = $1`, depositID).Scan(&claimed); err != nil {
+			return nil, err
+		}
+		if req.AmountHeld < claimed {
+			return nil, serviceError(http.StatusBadRequest, "deposit amount cannot be less than claimed damages (%.2f)", claimed)
+		}
+
+		_, err = tx.Exec(`
+			UPDATE booking_deposits SET amount_held = $1, updated_at = CURRENT_TIMESTAMP
+			WHERE deposit_id = $2
+		`, req.AmountHeld, depositID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetDeposit(bookingID)
+}
+
+// Record a damage claim against a held deposit
+func (s *BookingService) AddDamageClaim(bookingID uuid.UUID, userID uuid.UUID, req *CreateDamageClaimRequest) (*Deposit, error) {
+	if req.Description == "" {
+		return nil, serviceError(http.StatusBadRequest, "claim description is required")
+	}
+	if req.ClaimAmount <= 0 {
+		return nil, serviceError(http.StatusBadRequest, "claim amount must be greater than zero")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var depositID uuid.UUID
+	var amountHeld float64
+	var status string
+	err = tx.QueryRow(`
+		SELECT deposit_id, amount_held, deposit_status
+		FROM booking_deposits
+		WHERE booking_id = $1
+		FOR UPDATE
+	`, bookingID).Scan(&depositID, &amountHeld, &status)
+	if err == sql.ErrNoRows {
+		return nil, serviceError(http.StatusNotFound, "no deposit held for this booking")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if status != DepositStatusHeld {
+		return nil, serviceError(http.StatusConflict, "deposit is already settled (%s)", status)
+	}
+
+	var claimed float64
+	if err := tx.QueryRow(`SELECT COALESCE(SUM(claim_amount), 0) FROM damage_claims WHERE deposit_id = $1`, depositID).Scan(&claimed); err != nil {
+		return nil, err
+	}
+
+	if claimed+req.ClaimAmount > amountHeld {
+		return nil, serviceError(http.StatusBadRequest, "total claims (%.2f) would exceed the deposit held (%.2f)", claimed+req.ClaimAmount, amountHeld)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO damage_claims (claim_id, deposit_id, description, claim_amount, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+	`, uuid.New(), depositID, req.Description, req.ClaimAmount, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetDeposit(bookingID)
+}
+
+// Settle a deposit: refund whatever has not been claimed for damages
+func (s *BookingService) ReleaseDeposit(bookingID uuid.UUID) (*Deposit, error) {
+	query := `
+		UPDATE booking_deposits d
+		SET amount_refunded = d.amount_held - c.claimed,
+			deposit_status = CASE WHEN c.claimed > 0 THEN 'partially_claimed' ELSE 'released' END,
+			released_at = CURRENT_TIMESTAMP,
+			updated_at = CURRENT_TIMESTAMP
+		FROM (
+			SELECT COALESCE(SUM(claim_amount), 0) AS claimed
+			FROM damage_claims
+			WHERE deposit_id = (SELECT deposit_id FROM booking_deposits WHERE booking_id = $1)
+		) c
+		WHERE d.booking_id = $1
+		AND d.deposit_status = 'held'
+	`
+
+	result, err := s.db.Exec(query, bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		var status string
+		err := s.db.QueryRow(`SELECT deposit_status FROM booking_deposits WHERE booking_id = $1`, bookingID).Scan(&status)
+		if err == sql.ErrNoRows {
+			return nil, serviceError(http.StatusNotFound, "deposit not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, serviceError(http.StatusConflict, "deposit is already settled (%s)", status)
+	}
+
+	return s.GetDeposit(bookingID)
+}
+
+// Deposits still held for guests who have already checked out
+func (s *BookingService) GetOutstandingDeposits(propertyID *uuid.UUID) ([]OutstandingDeposit, error) {
+	query := `
+		SELECT d.deposit_id, d.booking_id, d.amount_held,
+			COALESCE((SELECT SUM(claim_amount) FROM damage_claims WHERE deposit_id = d.deposit_id), 0),
+			d.amount_refunded, d.deposit_status, d.released_at, d.created_at, d.updated_at,
+			b.property_id, b.guest_name, b.check_out_date, CURRENT_DATE - b.check_out_date
+		FROM booking_deposits d
+		JOIN bookings b ON b.booking_id = d.booking_id
+		WHERE d.deposit_status = 'held'
+		AND b.check_out_date <= CURRENT_DATE
+		AND b.booking_status IN ('confirmed', 'completed')
+		AND ($1::UUID IS NULL OR b.property_id = $1)
+		ORDER BY b.check_out_date ASC
+	`
+
+	rows, err := s.db.Query(query, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deposits []OutstandingDeposit
+
+	for rows.Next() {
+		var d OutstandingDeposit
+		err := rows.Scan(
+			&d.DepositID, &d.BookingID, &d.AmountHeld, &d.AmountClaimed,
+			&d.AmountRefunded, &d.DepositStatus, &d.ReleasedAt, &d.CreatedAt, &d.UpdatedAt,
+			&d.PropertyID, &d.GuestName, &d.CheckOutDate, &d.DaysOverdue,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		deposits = append(deposits, d)
+	}
+
+	return deposits, nil
+}
+
+func (s *BookingService) GetDeposit(bookingID uuid.UUID) (*Deposit, error) {
+	deposit, err := s.getDeposit(s.db, bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	if deposit == nil {
+		return nil, fmt.Errorf("deposit not found")
+	}
+
+	return deposit, nil
+}
+
+// getDeposit returns nil when the booking has no deposit
+func (s *BookingService) getDeposit(q queryer, bookingID uuid.UUID) (*Deposit, error) {
+	query := `
+		SELECT deposit_id, booking_id, amount_held, amount_refunded, deposit_status,
+			released_at, created_at, updated_at
+		FROM booking_deposits
+		WHERE booking_id = $1
+	`
+
+	var d Deposit
+	err := q.QueryRow(query, bookingID).Scan(
+		&d.DepositID, &d.BookingID, &d.AmountHeld, &d.AmountRefunded, &d.DepositStatus,
+		&d.ReleasedAt, &d.CreatedAt, &d.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := s.getDamageClaims(q, d.DepositID)
+	if err != nil {
+		return nil, err
+	}
+	d.Claims = claims
+
+	for _, claim := range claims {
+		d.AmountClaimed += claim.ClaimAmount
+	}
+
+	return &d, nil
+}
+
+func (s *BookingService) getDamageClaims(q queryer, depositID uuid.UUID) ([]DamageClaim, error) {
+	query := `
+		SELECT claim_id, deposit_id, description, claim_amount, created_by, created_at
+		FROM damage_claims
+		WHERE deposit_id = $1
+		ORDER BY created_at ASC
+	`
+
+	rows, err := q.Query(query, depositID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claims []DamageClaim
+
+	for rows.Next() {
+		var claim DamageClaim
+		err := rows.Scan(
+			&claim.ClaimID, &claim.DepositID, &claim.Description,
+			&claim.ClaimAmount, &claim.CreatedBy, &claim.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		claims = append(claims, claim)
+	}
+
+	return claims, nil
+}
+
+func insertDeposit(tx *sql.Tx, bookingID uuid.UUID, amount float64) error {
+	if amount < 0 {
+		return serviceError(http.StatusBadRequest, "deposit amount cannot be negative")
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO booking_deposits (deposit_id, booking_id, amount_held)
+		VALUES ($1, $2, $3)
+	`, uuid.New(), bookingID, amount)
+	return err
+}
+
+// HTTP Handlers
+func (s *BookingService) GetDepositHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingIDStr := vars["bookingId"]
+
+	bookingID, err := uuid.Parse(bookingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid booking ID", http.StatusBadRequest)
+		return
+	}
+
+	deposit, err := s.GetDeposit(bookingID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deposit)
+}
+
+func (s *BookingService) SetDepositHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingIDStr := vars["bookingId"]
+
+	bookingID, err := uuid.Parse(bookingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid booking ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SetDepositRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	deposit, err := s.SetDeposit(bookingID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deposit)
+}
+
+func (s *BookingService) AddDamageClaimHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingIDStr := vars["bookingId"]
+
+	bookingID, err := uuid.Parse(bookingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid booking ID", http.StatusBadRequest)
+		return
+	}
+
+	var req CreateDamageClaimRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := s.requestUserID(r)
+
+	deposit, err := s.AddDamageClaim(bookingID, userID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(deposit)
+}
+
+func (s *BookingService) ReleaseDepositHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingIDStr := vars["bookingId"]
+
+	bookingID, err := uuid.Parse(bookingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid booking ID", http.StatusBadRequest)
+		return
+	}
+
+	deposit, err := s.ReleaseDeposit(bookingID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deposit)
+}
+
+func (s *BookingService) GetOutstandingDepositsHandler(w http.ResponseWriter, r *http.Request) {
+	propertyIDStr := r.URL.Query().Get("property_id")
+
+	var propertyID *uuid.UUID
+	if propertyIDStr != "" {
+		id, err := uuid.Parse(propertyIDStr)
+		if err != nil {
+			http.Error(w, "Invalid property ID", http.StatusBadRequest)
+			return
+		}
+		propertyID = &id
+	}
+
+	deposits, err := s.GetOutstandingDeposits(propertyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deposits)
+}
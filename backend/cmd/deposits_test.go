@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestReleaseDepositErrors(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	bookingID := createTestBooking(t, s, propertyID, testDate(-10), testDate(-7))
+
+	release := func() int {
+		req := httptest.NewRequest("POST", "/api/v1/bookings/"+bookingID.String()+"/deposit/release", nil)
+		req = mux.SetURLVars(req, map[string]string{"bookingId": bookingID.String()})
+		rec := httptest.NewRecorder()
+		s.ReleaseDepositHandler(rec, req)
+		return rec.Code
+	}
+
+	if code := release(); code != http.StatusNotFound {
+		t.Errorf("releasing a missing deposit: status %d, want %d", code, http.StatusNotFound)
+	}
+
+	if _, err := s.SetDeposit(bookingID, &SetDepositRequest{AmountHeld: 200}); err != nil {
+		t.Fatalf("SetDeposit: %v", err)
+	}
+	if code := release(); code != http.StatusOK {
+		t.Errorf("releasing a held deposit: status %d, want %d", code, http.StatusOK)
+	}
+	if code := release(); code != http.StatusConflict {
+		t.Errorf("releasing a settled deposit: status %d, want %d", code, http.StatusConflict)
+	}
+
+	// A settled deposit can no longer be changed or claimed against either
+	_, setErr := s.SetDeposit(bookingID, &SetDepositRequest{AmountHeld: 300})
+	_, claimErr := s.AddDamageClaim(bookingID, s.systemUserID, &CreateDamageClaimRequest{Description: "Broken lamp", ClaimAmount: 40})
+
+	for name, err := range map[string]error{"changing": setErr, "claiming against": claimErr} {
+		var depositErr *ServiceError
+		if !errors.As(err, &depositErr) || depositErr.Code != http.StatusConflict {
+			t.Errorf("%s a settled deposit: err = %v, want a 409 ServiceError", name, err)
+		}
+	}
+}
+
+func TestCreateBookingRefusesNegativeDeposit(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	deposit := -50.0
+	_, err := s.CreateBooking(s.systemUserID, &CreateBookingRequest{
+		PropertyID:         propertyID,
+		GuestName:          "Deposit Guest",
+		GuestIDCard:        "ID-7",
+		GuestContactNumber: "+94771234567",
+		CheckInDate:        testDate(50).Format("2006-01-02"),
+		CheckOutDate:       testDate(52).Format("2006-01-02"),
+		NumberOfGuests:     1,
+		DepositAmount:      &deposit,
+	})
+
+	var depositErr *ServiceError
+	if !errors.As(err, &depositErr) || depositErr.Code != http.StatusBadRequest {
+		t.Fatalf("CreateBooking: err = %v, want a 400 ServiceError", err)
+	}
+
+	rec := httptest.NewRecorder()
+	writeServiceError(rec, err)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Domain events, recorded in the same transaction as the change they are
+// about
+const (
+	EventBookingCreated   = "booking.created"
+	EventBookingUpdated   = "booking.updated"
+	EventBookingCancelled = "booking.cancelled"
+	EventPaymentReceived  = "payment.received"
+)
+
+// Causes of booking events that were not a direct edit of the booking
+const (
+	EventCauseSplit       = "split"
+	EventCauseMove        = "move"
+	EventCauseLongStay    = "long_stay"
+	EventCauseWaitlist    = "waitlist"
+	EventCauseHoldExpired = "hold_expired"
+)
+
+// Attempts a sink gets at an event before it is given up on
+const (
+	maxEventAttempts = 8
+	eventBatchSize   = 100
+)
+
+// Event consumption statuses
+const (
+	EventConsumptionProcessed = "processed"
+	EventConsumptionRetrying  = "retrying"
+	EventConsumptionFailed    = "failed"
+)
+
+// DomainEvent is a change to a booking. Sequence orders the events as they
+// were committed, with no event ever committed behind a later one.
+type DomainEvent struct {
+	EventID    uuid.UUID          `json:"event_id"`
+	Sequence   int64              `json:"sequence"`
+	EventType  string             `json:"event_type"`
+	BookingID  uuid.UUID          `json:"booking_id"`
+	PropertyID uuid.UUID          `json:"property_id"`
+	Payload    json.RawMessage    `json:"payload"`
+	CreatedAt  time.Time          `json:"created_at"`
+	Consumers  []EventConsumption `json:"consumers,omitempty"`
+}
+
+// BookingEventPayload is the booking as it was once the change was made,
+// with what changed and why
+type BookingEventPayload struct {
+	Booking        *Booking `json:"booking"`
+	PreviousStatus string   `json:"previous_status,omitempty"`
+	Changes        []string `json:"changes,omitempty"`
+	Cause          string   `json:"cause,omitempty"`
+	Payment        *Payment `json:"payment,omitempty"`
+}
+
+// EventConsumption is how far a sink got with an event
+type EventConsumption struct {
+	Consumer      string     `json:"consumer"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     *string    `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	ProcessedAt   *time.Time `json:"processed_at,omitempty"`
+}
+
+// EventSink consumes the domain events. The dispatcher hands each event to
+// each sink once: HandleEvent runs in the transaction that records the
+// event as processed by the sink, so whatever the sink writes through q
+// happens exactly once. Side effects outside the database happen at least
+// once. A sink that returns an error gets the event again later.
+type EventSink interface {
+	// Name identifies the sink in the record of the events it has
+	// processed, so it must not change
+	Name() string
+	HandleEvent(q queryer, event *DomainEvent, payload *BookingEventPayload) error
+}
+
+// logEventSink writes the events to the log
+type logEventSink struct{}
+
+func (logEventSink) Name() string {
+	return "log"
+}
+
+func (logEventSink) HandleEvent(q queryer, event *DomainEvent, payload *BookingEventPayload) error {
+	log.Printf("Event %d %s for booking %s", event.Sequence, event.EventType, event.BookingID)
+	return nil
+}
+
+// RegisterEventSink adds a sink the dispatcher publishes the events to
+func (s *BookingService) RegisterEventSink(sink EventSink) {
+	s.eventSinks = append(s.eventSinks, sink)
+}
+
+// recordBookingEvent records an event about a booking in the caller's
+// transaction, with the booking as it is in that transaction. The event
+// gets its sequence number when the transaction commits.
+func (s *BookingService) recordBookingEvent(q queryer, eventType string, bookingID uuid.UUID, payload *BookingEventPayload) error {
+	if payload == nil {
+		payload = &BookingEventPayload{}
+	}
+
+	booking, err := s.getBooking(q, bookingID)
+	if err != nil {
+		return err
+	}
+	payload.Booking = booking
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(`
+		INSERT INTO domain_events (event_type, booking_id, property_id, payload)
+		VALUES ($1, $2, $3, $4)
+	`, eventType, bookingID, booking.PropertyID, data)
+	return err
+}
+
+// DispatchEvents hands the events each sink has not processed yet to it,
+// oldest first. An event a sink fails on is retried later without holding
+// up the ones after it.
+func (s *BookingService) DispatchEvents() {
+	for _, sink := range s.eventSinks {
+		// New sinks start from the first event
+		_, err := s.db.Exec(`
+			INSERT INTO event_consumers (consumer) VALUES ($1)
+			ON CONFLICT (consumer) DO NOTHING
+		`, sink.Name())
+		if err != nil {
+			log.Printf("Failed to dispatch events to %s: %v", sink.Name(), err)
+			continue
+		}
+
+		for i := 0; i < eventBatchSize; i++ {
+			dispatched, err := s.dispatchNextEvent(sink)
+			if err != nil {
+				log.Printf("Failed to dispatch events to %s: %v", sink.Name(), err)
+				break
+			}
+			if !dispatched {
+				break
+			}
+		}
+	}
+}
+
+// dispatchNextEvent hands a sink the event it is due to retry, or else the
+// next event after the last one it read, and records the outcome. Events
+// are numbered as they commit, so none turns up later behind the sink's
+// position, and both lookups are indexed however long the history gets.
+// The sink's position is locked meanwhile, so each sink is dispatched to
+// by one server at a time.
+func (s *BookingService) dispatchNextEvent(sink EventSink) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var lastSequence int64
+	err = tx.QueryRow(`
+		SELECT last_sequence FROM event_consumers WHERE consumer = $1 FOR UPDATE SKIP LOCKED
+	`, sink.Name()).Scan(&lastSequence)
+	if err == sql.ErrNoRows {
+		// Another server is dispatching to the sink
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var event DomainEvent
+	var payload []byte
+	var attempts int
+	err = tx.QueryRow(`
+		SELECT e.event_id, e.sequence, e.event_type, e.booking_id, e.property_id, e.payload,
+			e.created_at, c.attempts
+		FROM event_consumptions c
+		JOIN domain_events e ON e.event_id = c.event_id
+		WHERE c.consumer = $1
+		AND c.status = 'retrying'
+		AND c.next_attempt_at <= CURRENT_TIMESTAMP
+		ORDER BY c.next_attempt_at
+		LIMIT 1
+	`, sink.Name()).Scan(
+		&event.EventID, &event.Sequence, &event.EventType, &event.BookingID, &event.PropertyID,
+		&payload, &event.CreatedAt, &attempts,
+	)
+	retry := err == nil
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(`
+			SELECT event_id, sequence, event_type, booking_id, property_id, payload, created_at
+			FROM domain_events
+			WHERE sequence > $1
+			ORDER BY sequence
+			LIMIT 1
+		`, lastSequence).Scan(
+			&event.EventID, &event.Sequence, &event.EventType, &event.BookingID, &event.PropertyID,
+			&payload, &event.CreatedAt,
+		)
+	}
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	event.Payload = payload
+	attempts++
+
+	if !retry {
+		_, err = tx.Exec(`
+			UPDATE event_consumers SET last_sequence = $2, updated_at = CURRENT_TIMESTAMP
+			WHERE consumer = $1
+		`, sink.Name(), event.Sequence)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// What the sink wrote is undone if it fails, keeping the lock
+	if _, err = tx.Exec(`SAVEPOINT handle_event`); err != nil {
+		return false, err
+	}
+
+	var bookingPayload BookingEventPayload
+	handleErr := json.Unmarshal(payload, &bookingPayload)
+	if handleErr == nil {
+		handleErr = sink.HandleEvent(tx, &event, &bookingPayload)
+	}
+
+	if handleErr == nil {
+		_, err = tx.Exec(`
+			INSERT INTO event_consumptions (consumer, event_id, status, attempts, processed_at)
+			VALUES ($1, $2, 'processed', $3, CURRENT_TIMESTAMP)
+			ON CONFLICT (consumer, event_id) DO UPDATE
+			SET status = 'processed', attempts = EXCLUDED.attempts, last_error = NULL,
+				next_attempt_at = NULL, processed_at = EXCLUDED.processed_at
+		`, sink.Name(), event.EventID, attempts)
+		if err != nil {
+			return false, err
+		}
+
+		return true, tx.Commit()
+	}
+
+	log.Printf("Event sink %s failed on event %s: %v", sink.Name(), event.EventID, handleErr)
+
+	if _, err = tx.Exec(`ROLLBACK TO SAVEPOINT handle_event`); err != nil {
+		return false, err
+	}
+
+	status := EventConsumptionRetrying
+	var nextAttemptAt *time.Time
+	if attempts >= maxEventAttempts {
+		status = EventConsumptionFailed
+	} else {
+		retryAt := time.Now().Add(retryDelay(attempts))
+		nextAttemptAt = &retryAt
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO event_consumptions (consumer, event_id, status, attempts, last_error, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (consumer, event_id) DO UPDATE
+		SET status = EXCLUDED.status, attempts = EXCLUDED.attempts,
+			last_error = EXCLUDED.last_error, next_attempt_at = EXCLUDED.next_attempt_at
+	`, sink.Name(), event.EventID, status, attempts, handleErr.Error(), nextAttemptAt)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// runEventDispatcher dispatches the events every interval
+func (s *BookingService) runEventDispatcher(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.DispatchEvents()
+		<-ticker.C
+	}
+}
+
+// Get the events recorded after the given sequence number, oldest first.
+// Integrations read the stream by passing the last sequence they have seen;
+// events are numbered as they commit, so none can turn up later below it.
+func (s *BookingService) GetEvents(after int64, limit int) ([]DomainEvent, error) {
+	return s.queryEvents(`
+		SELECT event_id, sequence, event_type, booking_id, property_id, payload, created_at
+		FROM domain_events
+		WHERE sequence > $1
+		ORDER BY sequence
+		LIMIT $2
+	`, after, limit)
+}
+
+func (s *BookingService) GetBookingEvents(bookingID uuid.UUID) ([]DomainEvent, error) {
+	return s.queryEvents(`
+		SELECT event_id, sequence, event_type, booking_id, property_id, payload, created_at
+		FROM domain_events
+		WHERE booking_id = $1
+		ORDER BY sequence
+	`, bookingID)
+}
+
+// Get an event with how far each sink got with it
+func (s *BookingService) GetEvent(eventID uuid.UUID) (*DomainEvent, error) {
+	events, err := s.queryEvents(`
+		SELECT event_id, sequence, event_type, booking_id, property_id, payload, created_at
+		FROM domain_events
+		WHERE event_id = $1
+	`, eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		return nil, fmt.Errorf("event not found")
+	}
+	event := &events[0]
+
+	rows, err := s.db.Query(`
+		SELECT consumer, status, attempts, last_error, next_attempt_at, processed_at
+		FROM event_consumptions
+		WHERE event_id = $1
+		ORDER BY consumer
+	`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	event.Consumers = []EventConsumption{}
+
+	for rows.Next() {
+		var consumption EventConsumption
+		err := rows.Scan(
+			&consumption.Consumer, &consumption.Status, &consumption.Attempts, &consumption.LastError,
+			&consumption.NextAttemptAt, &consumption.ProcessedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Consumers = append(event.Consumers, consumption)
+	}
+
+	return event, nil
+}
+
+func (s *BookingService) queryEvents(query string, args ...interface{}) ([]DomainEvent, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []DomainEvent{}
+
+	for rows.Next() {
+		var event DomainEvent
+		var payload []byte
+		err := rows.Scan(
+			&event.EventID, &event.Sequence, &event.EventType, &event.BookingID, &event.PropertyID,
+			&payload, &event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		event.Payload = payload
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// HTTP Handlers
+func (s *BookingService) GetEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var after int64
+	if afterStr := r.URL.Query().Get("after"); afterStr != "" {
+		var err error
+		after, err = strconv.ParseInt(afterStr, 10, 64)
+		if err != nil || after < 0 {
+			http.Error(w, "Invalid after", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > 500 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := s.GetEvents(after, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+func (s *BookingService) GetEventHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	eventIDStr := vars["eventId"]
+
+	eventID, err := uuid.Parse(eventIDStr)
+	if err != nil {
+		http.Error(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	event, err := s.GetEvent(eventID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(event)
+}
+
+func (s *BookingService) GetBookingEventsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingIDStr := vars["bookingId"]
+
+	bookingID, err := uuid.Parse(bookingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid booking ID", http.StatusBadRequest)
+		return
+	}
+
+	events, err := s.GetBookingEvents(bookingID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// An event recorded first but committed last still turns up after the last
+// sequence a reader saw
+func TestGetEventsAfterLateCommit(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	bookingID := createTestBooking(t, s, propertyID, testDate(30), testDate(32))
+
+	record := func() (*sql.Tx, uuid.UUID) {
+		tx, err := s.db.Begin()
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		if err := s.recordBookingEvent(tx, EventBookingUpdated, bookingID, nil); err != nil {
+			tx.Rollback()
+			t.Fatalf("recordBookingEvent: %v", err)
+		}
+
+		var eventID uuid.UUID
+		err = tx.QueryRow(`
+			SELECT event_id FROM domain_events WHERE booking_id = $1 AND sequence IS NULL
+		`, bookingID).Scan(&eventID)
+		if err != nil {
+			tx.Rollback()
+			t.Fatalf("Failed to find the event: %v", err)
+		}
+
+		return tx, eventID
+	}
+
+	slow, slowEventID := record()
+	fast, fastEventID := record()
+	if err := fast.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	events, err := s.GetBookingEvents(bookingID)
+	if err != nil {
+		t.Fatalf("GetBookingEvents: %v", err)
+	}
+	if len(events) != 1 || events[0].EventID != fastEventID {
+		t.Fatalf("events before the slow commit = %+v, want only %s", events, fastEventID)
+	}
+	lastSeen := events[0].Sequence
+
+	if err := slow.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	events, err = s.GetEvents(lastSeen, 500)
+	if err != nil {
+		t.Fatalf("GetEvents: %v", err)
+	}
+
+	found := false
+	for _, event := range events {
+		if event.EventID == slowEventID {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("event committed last is not after sequence %d", lastSeen)
+	}
+}
+
+// testEventSink fails on the events in failOn, and records what it was
+// handed
+type testEventSink struct {
+	name    string
+	failOn  map[uuid.UUID]bool
+	handled []uuid.UUID
+}
+
+func (sink *testEventSink) Name() string {
+	return sink.name
+}
+
+func (sink *testEventSink) HandleEvent(q queryer, event *DomainEvent, payload *BookingEventPayload) error {
+	sink.handled = append(sink.handled, event.EventID)
+	if sink.failOn[event.EventID] {
+		delete(sink.failOn, event.EventID)
+		return fmt.Errorf("sink is down")
+	}
+	return nil
+}
+
+func recordTestEvent(t *testing.T, s *BookingService, bookingID uuid.UUID) uuid.UUID {
+	t.Helper()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.recordBookingEvent(tx, EventBookingUpdated, bookingID, nil); err != nil {
+		t.Fatalf("recordBookingEvent: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	var eventID uuid.UUID
+	err = s.db.QueryRow(`
+		SELECT event_id FROM domain_events WHERE booking_id = $1 ORDER BY sequence DESC LIMIT 1
+	`, bookingID).Scan(&eventID)
+	if err != nil {
+		t.Fatalf("Failed to find the event: %v", err)
+	}
+
+	return eventID
+}
+
+func TestDispatchEvents(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	bookingID := createTestBooking(t, s, propertyID, testDate(40), testDate(42))
+
+	sink := &testEventSink{name: "test-" + uuid.New().String()[:8], failOn: map[uuid.UUID]bool{}}
+	s.eventSinks = []EventSink{sink}
+
+	// Start the sink after the events already recorded
+	_, err := s.db.Exec(`
+		INSERT INTO event_consumers (consumer, last_sequence)
+		SELECT $1, COALESCE(MAX(sequence), 0) FROM domain_events
+	`, sink.name)
+	if err != nil {
+		t.Fatalf("Failed to position the sink: %v", err)
+	}
+
+	first := recordTestEvent(t, s, bookingID)
+	second := recordTestEvent(t, s, bookingID)
+	sink.failOn[first] = true
+
+	s.DispatchEvents()
+
+	if len(sink.handled) != 2 || sink.handled[0] != first || sink.handled[1] != second {
+		t.Fatalf("sink was handed %v, want %s then %s", sink.handled, first, second)
+	}
+
+	consumption := func(eventID uuid.UUID) EventConsumption {
+		t.Helper()
+		event, err := s.GetEvent(eventID)
+		if err != nil {
+			t.Fatalf("GetEvent: %v", err)
+		}
+		for _, c := range event.Consumers {
+			if c.Consumer == sink.name {
+				return c
+			}
+		}
+		t.Fatalf("event %s has no consumption by %s", eventID, sink.name)
+		return EventConsumption{}
+	}
+
+	if c := consumption(first); c.Status != EventConsumptionRetrying || c.Attempts != 1 || c.NextAttemptAt == nil {
+		t.Errorf("failed event: %+v, want it retrying", c)
+	}
+	if c := consumption(second); c.Status != EventConsumptionProcessed {
+		t.Errorf("event after the failed one: %+v, want it processed", c)
+	}
+
+	// Nothing is due, so nothing is handed over again
+	s.DispatchEvents()
+	if len(sink.handled) != 2 {
+		t.Fatalf("sink was handed %v, want nothing new", sink.handled)
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE event_consumptions SET next_attempt_at = CURRENT_TIMESTAMP
+		WHERE consumer = $1 AND event_id = $2
+	`, sink.name, first)
+	if err != nil {
+		t.Fatalf("Failed to reschedule the event: %v", err)
+	}
+
+	s.DispatchEvents()
+
+	if len(sink.handled) != 3 || sink.handled[2] != first {
+		t.Fatalf("sink was handed %v, want %s retried", sink.handled, first)
+	}
+	if c := consumption(first); c.Status != EventConsumptionProcessed || c.Attempts != 2 {
+		t.Errorf("retried event: %+v, want it processed on the second attempt", c)
+	}
+
+	// While another server holds the sink's position it is left alone
+	third := recordTestEvent(t, s, bookingID)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT 1 FROM event_consumers WHERE consumer = $1 FOR UPDATE`, sink.name); err != nil {
+		t.Fatalf("Failed to lock the sink: %v", err)
+	}
+
+	dispatched, err := s.dispatchNextEvent(sink)
+	if err != nil || dispatched {
+		t.Errorf("dispatchNextEvent while locked = %v, %v, want false, nil", dispatched, err)
+	}
+
+	tx.Rollback()
+
+	if dispatched, err := s.dispatchNextEvent(sink); err != nil || !dispatched || sink.handled[len(sink.handled)-1] != third {
+		t.Errorf("dispatchNextEvent = %v, %v, handed %v, want %s", dispatched, err, sink.handled, third)
+	}
+}
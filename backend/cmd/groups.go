@@ -0,0 +1,486 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// GroupReservation links the bookings of a wedding party, corporate group and
+// the like, usually across several properties, under one lead contact
+type GroupReservation struct {
+	GroupID           uuid.UUID `json:"group_id"`
+	GroupName         string    `json:"group_name"`
+	LeadName          string    `json:"lead_name"`
+	LeadContactNumber string    `json:"lead_contact_number"`
+	LeadEmail         *string   `json:"lead_email,omitempty"`
+	Notes             *string   `json:"notes,omitempty"`
+	CreatedBy         uuid.UUID `json:"created_by"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	Bookings          []Booking `json:"bookings"`
+	TotalAmount       *float64  `json:"total_amount,omitempty"`
+}
+
+// CreateGroupRequest creates a group and all its bookings, or nothing. Guest
+// name, contact number and email left empty on a booking default to the lead
+// contact.
+type CreateGroupRequest struct {
+	GroupName         string                 `json:"group_name"`
+	LeadName          string                 `json:"lead_name"`
+	LeadContactNumber string                 `json:"lead_contact_number"`
+	LeadEmail         *string                `json:"lead_email,omitempty"`
+	Notes             *string                `json:"notes,omitempty"`
+	Bookings          []CreateBookingRequest `json:"bookings"`
+}
+
+// Create a group reservation with its bookings in one transaction. If any
+// booking is refused, for stay rules or an overlap, none are made.
+func (s *BookingService) CreateGroup(userID uuid.UUID, req *CreateGroupRequest) (*GroupReservation, error) {
+	if req.GroupName == "" {
+		return nil, serviceError(http.StatusBadRequest, "group_name is required")
+	}
+	if req.LeadName == "" || req.LeadContactNumber == "" {
+		return nil, serviceError(http.StatusBadRequest, "lead_name and lead_contact_number are required")
+	}
+	if len(req.Bookings) == 0 {
+		return nil, serviceError(http.StatusBadRequest, "a group needs at least one booking")
+	}
+
+	req.LeadContactNumber = s.normalizeContactNumber(req.LeadContactNumber)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	groupID := uuid.New()
+	_, err = tx.Exec(`
+		INSERT INTO group_reservations (
+			group_id, group_name, lead_name, lead_contact_number, lead_email, notes, created_by
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, groupID, req.GroupName, req.LeadName, req.LeadContactNumber, req.LeadEmail, req.Notes, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range req.Bookings {
+		booking := &req.Bookings[i]
+		booking.groupID = &groupID
+
+		if booking.GuestName == "" {
+			booking.GuestName = req.LeadName
+		}
+		if booking.GuestContactNumber == "" {
+			booking.GuestContactNumber = req.LeadContactNumber
+		}
+		if booking.GuestEmail == nil {
+			booking.GuestEmail = req.LeadEmail
+		}
+
+		checkInDate, err := time.Parse("2006-01-02", booking.CheckInDate)
+		if err != nil {
+			return nil, serviceError(http.StatusBadRequest, "booking %d: invalid check-in date format: %v", i+1, err)
+		}
+
+		checkOutDate, err := time.Parse("2006-01-02", booking.CheckOutDate)
+		if err != nil {
+			return nil, serviceError(http.StatusBadRequest, "booking %d: invalid check-out date format: %v", i+1, err)
+		}
+
+		if err := s.checkStayRules(tx, booking.PropertyID, checkInDate, checkOutDate, true); err != nil {
+			return nil, err
+		}
+
+		bookingID, err := s.createBookingTx(tx, userID, booking, checkInDate, checkOutDate)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.recordBookingEvent(tx, EventBookingCreated, bookingID, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetGroup(groupID)
+}
+
+// Get a group reservation with all its bookings, cancelled ones included
+func (s *BookingService) GetGroup(groupID uuid.UUID) (*GroupReservation, error) {
+	var group GroupReservation
+	err := s.db.QueryRow(`
+		SELECT group_id, group_name, lead_name, lead_contact_number, lead_email, notes,
+			created_by, created_at, updated_at
+		FROM group_reservations
+		WHERE group_id = $1
+	`, groupID).Scan(
+		&group.GroupID, &group.GroupName, &group.LeadName, &group.LeadContactNumber,
+		&group.LeadEmail, &group.Notes, &group.CreatedBy, &group.CreatedAt, &group.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, serviceError(http.StatusNotFound, "group not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	group.Bookings, err = s.groupBookings(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only bookings that still stand count towards the total
+	for _, booking := range group.Bookings {
+		if booking.BookingStatus == "cancelled" || booking.TotalAmount == nil {
+			continue
+		}
+		if group.TotalAmount == nil {
+			group.TotalAmount = new(float64)
+		}
+		*group.TotalAmount = roundMoney(*group.TotalAmount + *booking.TotalAmount)
+	}
+
+	return &group, nil
+}
+
+func (s *BookingService) groupBookings(groupID uuid.UUID) ([]Booking, error) {
+	query := `
+		SELECT booking_id, property_id, created_by, guest_name, guest_id_card,
+			guest_contact_number, guest_email, check_in_date, check_out_date,
+			number_of_guests, total_nights, booking_notes, special_requests,
+			booking_status, booking_amount, payment_status, created_at, updated_at, unit_id,
+			split_from_booking_id, group_id
+		FROM bookings
+		WHERE group_id = $1
+		ORDER BY check_in_date ASC, created_at ASC
+	`
+
+	bookings, err := s.queryBookings(s.db, query, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if bookings == nil {
+		bookings = []Booking{}
+	}
+
+	return bookings, nil
+}
+
+// Cancel every upcoming booking of a group at once. Bookings that have
+// already started or ended are left alone. Guests are emailed and the freed
+// dates offered to the waitlists of their properties.
+func (s *BookingService) CancelGroup(groupID uuid.UUID, userID uuid.UUID) (*GroupReservation, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		UPDATE bookings
+		SET booking_status = 'cancelled', updated_at = CURRENT_TIMESTAMP
+		WHERE group_id = $1
+		AND check_in_date >= CURRENT_DATE
+		AND booking_status IN ('confirmed', 'pending')
+		RETURNING booking_id, property_id, check_in_date, check_out_date
+	`, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	type freedStay struct {
+		bookingID, propertyID uuid.UUID
+		checkIn, checkOut     time.Time
+	}
+
+	var freed []freedStay
+	for rows.Next() {
+		var stay freedStay
+		if err := rows.Scan(&stay.bookingID, &stay.propertyID, &stay.checkIn, &stay.checkOut); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		freed = append(freed, stay)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(freed) == 0 {
+		if _, err := s.GetGroup(groupID); err != nil {
+			return nil, err
+		}
+		return nil, serviceError(http.StatusConflict, "group has no upcoming bookings to cancel")
+	}
+
+	// The change itself is logged as made by the booking's creator; record
+	// who cancelled the group alongside it
+	for _, stay := range freed {
+		_, err := tx.Exec(`
+			INSERT INTO booking_history (booking_id, modified_by, modification_type, modification_notes)
+			VALUES ($1, $2, 'cancelled', 'Group reservation cancelled')
+		`, stay.bookingID, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.recordBookingEvent(tx, EventBookingCancelled, stay.bookingID, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, stay := range freed {
+		if err := s.offerFreedDates(stay.propertyID, stay.checkIn, stay.checkOut); err != nil {
+			log.Printf("Failed to offer the dates of booking %s to the waitlist: %v", stay.bookingID, err)
+		}
+	}
+
+	return s.GetGroup(groupID)
+}
+
+// Get the invoice for a whole group, billed to the lead contact and branded
+// for the property of the group's first booking. It lists the bookings that
+// have not been cancelled with the payments taken on any of them, or every
+// booking once the whole group has been.
+func (s *BookingService) GetGroupInvoice(groupID uuid.UUID) (*Invoice, error) {
+	group, err := s.GetGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	var bookings []Booking
+	confirmed := false
+	for _, booking := range group.Bookings {
+		if booking.BookingStatus != "cancelled" {
+			bookings = append(bookings, booking)
+		}
+		if booking.BookingStatus != "cancelled" && booking.BookingStatus != "pending" {
+			confirmed = true
+		}
+	}
+
+	// As for a single booking, no number is used up on a group that is not
+	// going ahead; one issued before the group was cancelled is still shown
+	if !confirmed {
+		_, _, _, found, err := findInvoice(s.db, "group_id", groupID)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, serviceError(http.StatusConflict, "no invoice can be issued for a group without confirmed bookings")
+		}
+		if len(bookings) == 0 {
+			bookings = group.Bookings
+		}
+	}
+	if len(bookings) == 0 {
+		return nil, serviceError(http.StatusConflict, "group has no bookings to invoice")
+	}
+
+	invoiceID, number, issuedAt, err := s.issueInvoiceNumber("group_id", groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	branding, err := s.GetBranding(bookings[0].PropertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	invoice := &Invoice{
+		InvoiceID:     invoiceID,
+		InvoiceNumber: number,
+		IssuedAt:      issuedAt,
+		Branding:      *branding,
+		Booking:       groupStay(group, bookings),
+		Group:         group,
+		Payments:      []Payment{},
+	}
+
+	propertyNames := make(map[uuid.UUID]string)
+	for i := range bookings {
+		booking := &bookings[i]
+
+		name, ok := propertyNames[booking.PropertyID]
+		if !ok {
+			propertyBranding, err := s.GetBranding(booking.PropertyID)
+			if err != nil {
+				return nil, err
+			}
+			name = propertyBranding.DisplayName
+			propertyNames[booking.PropertyID] = name
+		}
+
+		for _, line := range invoiceLines(booking) {
+			line.Description = fmt.Sprintf("%s, %s: %s", name, booking.GuestName, line.Description)
+			invoice.Lines = append(invoice.Lines, line)
+			invoice.Total += line.Amount
+		}
+		for _, charge := range booking.Charges {
+			if charge.ChargeType == ChargeTypeTax {
+				invoice.TaxTotal += charge.Amount
+			}
+		}
+
+		payments, err := s.GetPayments(booking.BookingID)
+		if err != nil {
+			return nil, err
+		}
+		invoice.Payments = append(invoice.Payments, payments...)
+	}
+
+	sort.SliceStable(invoice.Payments, func(i, j int) bool {
+		return invoice.Payments[i].ReceivedAt.Before(invoice.Payments[j].ReceivedAt)
+	})
+	for _, payment := range invoice.Payments {
+		invoice.AmountPaid += payment.Amount
+	}
+
+	invoice.Total = roundMoney(invoice.Total)
+	invoice.TaxTotal = roundMoney(invoice.TaxTotal)
+	invoice.Subtotal = roundMoney(invoice.Total - invoice.TaxTotal)
+	invoice.AmountPaid = roundMoney(invoice.AmountPaid)
+	invoice.BalanceDue = roundMoney(invoice.Total - invoice.AmountPaid)
+
+	return invoice, nil
+}
+
+// groupStay summarises a group's bookings as the one stay an invoice header
+// shows: the lead contact, from the first arrival to the last departure, with
+// every guest of the group
+func groupStay(group *GroupReservation, bookings []Booking) Booking {
+	stay := Booking{
+		PropertyID:         bookings[0].PropertyID,
+		GroupID:            &group.GroupID,
+		CreatedBy:          group.CreatedBy,
+		GuestName:          group.LeadName,
+		GuestContactNumber: group.LeadContactNumber,
+		GuestEmail:         group.LeadEmail,
+		CheckInDate:        bookings[0].CheckInDate,
+		CheckOutDate:       bookings[0].CheckOutDate,
+		CreatedAt:          group.CreatedAt,
+		UpdatedAt:          group.UpdatedAt,
+	}
+
+	for _, booking := range bookings {
+		if booking.CheckInDate.Before(stay.CheckInDate) {
+			stay.CheckInDate = booking.CheckInDate
+		}
+		if booking.CheckOutDate.After(stay.CheckOutDate) {
+			stay.CheckOutDate = booking.CheckOutDate
+		}
+		stay.NumberOfGuests += booking.NumberOfGuests
+	}
+	stay.TotalNights = stayNights(stay.CheckInDate, stay.CheckOutDate)
+
+	return stay
+}
+
+// HTTP Handlers
+func (s *BookingService) CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := s.requestUserID(r)
+
+	group, err := s.CreateGroup(userID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(group)
+}
+
+func (s *BookingService) GetGroupHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	groupIDStr := vars["groupId"]
+
+	groupID, err := uuid.Parse(groupIDStr)
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	group, err := s.GetGroup(groupID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+func (s *BookingService) CancelGroupHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	groupIDStr := vars["groupId"]
+
+	groupID, err := uuid.Parse(groupIDStr)
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := s.requestUserID(r)
+
+	group, err := s.CancelGroup(groupID, userID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+func (s *BookingService) GetGroupInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	groupIDStr := vars["groupId"]
+	format := r.URL.Query().Get("format")
+
+	groupID, err := uuid.Parse(groupIDStr)
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	if format == "" {
+		format = "pdf"
+	}
+	if format != "pdf" && format != "html" && format != "json" {
+		http.Error(w, "format must be pdf, html or json", http.StatusBadRequest)
+		return
+	}
+
+	invoice, err := s.GetGroupInvoice(groupID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	writeInvoice(w, invoice, format)
+}
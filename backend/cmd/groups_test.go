@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func TestGroupStay(t *testing.T) {
+	group := &GroupReservation{GroupID: uuid.New(), LeadName: "Lead Guest", LeadContactNumber: "+94771234567"}
+	first, second := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name     string
+		bookings []Booking
+		checkIn  time.Time
+		checkOut time.Time
+		guests   int
+	}{
+		{"one booking", []Booking{
+			{PropertyID: first, CheckInDate: date(2026, 6, 1), CheckOutDate: date(2026, 6, 4), NumberOfGuests: 2},
+		}, date(2026, 6, 1), date(2026, 6, 4), 2},
+		{"same dates", []Booking{
+			{PropertyID: first, CheckInDate: date(2026, 6, 1), CheckOutDate: date(2026, 6, 4), NumberOfGuests: 2},
+			{PropertyID: second, CheckInDate: date(2026, 6, 1), CheckOutDate: date(2026, 6, 4), NumberOfGuests: 3},
+		}, date(2026, 6, 1), date(2026, 6, 4), 5},
+		{"staggered", []Booking{
+			{PropertyID: first, CheckInDate: date(2026, 6, 2), CheckOutDate: date(2026, 6, 4), NumberOfGuests: 2},
+			{PropertyID: second, CheckInDate: date(2026, 6, 1), CheckOutDate: date(2026, 6, 3), NumberOfGuests: 1},
+			{PropertyID: second, CheckInDate: date(2026, 6, 3), CheckOutDate: date(2026, 6, 7), NumberOfGuests: 4},
+		}, date(2026, 6, 1), date(2026, 6, 7), 7},
+	}
+
+	for _, tt := range tests {
+		stay := groupStay(group, tt.bookings)
+
+		if !stay.CheckInDate.Equal(tt.checkIn) || !stay.CheckOutDate.Equal(tt.checkOut) {
+			t.Errorf("%s: stay from %s to %s, want %s to %s", tt.name, stay.CheckInDate, stay.CheckOutDate, tt.checkIn, tt.checkOut)
+		}
+		if want := stayNights(tt.checkIn, tt.checkOut); stay.TotalNights != want {
+			t.Errorf("%s: %d nights, want %d", tt.name, stay.TotalNights, want)
+		}
+		if stay.NumberOfGuests != tt.guests {
+			t.Errorf("%s: %d guests, want %d", tt.name, stay.NumberOfGuests, tt.guests)
+		}
+		if stay.GuestName != group.LeadName || stay.PropertyID != tt.bookings[0].PropertyID {
+			t.Errorf("%s: billed to %s at %s, want the lead at the first booking's property", tt.name, stay.GuestName, stay.PropertyID)
+		}
+	}
+}
+
+// A group's bookings are made together or not at all, and cancelled together
+func TestCreateAndCancelGroup(t *testing.T) {
+	s := newTestService(t)
+	first := createTestProperty(t, s)
+	second := createTestProperty(t, s)
+
+	takenID := createTestBooking(t, s, second, testDate(31), testDate(33))
+
+	firstAmount, secondAmount := 300.0, 200.0
+	bookings := func(secondCheckIn int) []CreateBookingRequest {
+		return []CreateBookingRequest{
+			{
+				PropertyID:     first,
+				GuestIDCard:    "ID-10",
+				CheckInDate:    testDate(30).Format("2006-01-02"),
+				CheckOutDate:   testDate(33).Format("2006-01-02"),
+				NumberOfGuests: 2,
+				BookingAmount:  &firstAmount,
+			},
+			{
+				PropertyID:     second,
+				GuestName:      "Second Guest",
+				GuestIDCard:    "ID-11",
+				CheckInDate:    testDate(secondCheckIn).Format("2006-01-02"),
+				CheckOutDate:   testDate(secondCheckIn + 2).Format("2006-01-02"),
+				NumberOfGuests: 1,
+				BookingAmount:  &secondAmount,
+			},
+		}
+	}
+
+	groupName := "Wedding " + uuid.New().String()[:8]
+
+	// The second booking clashes, so the first is not made either
+	_, err := s.CreateGroup(s.systemUserID, &CreateGroupRequest{
+		GroupName:         groupName,
+		LeadName:          "Lead Guest",
+		LeadContactNumber: "+94771234567",
+		Bookings:          bookings(30),
+	})
+	var conflict *BookingConflictError
+	if !errors.As(err, &conflict) || conflict.ConflictingBookingID == nil || *conflict.ConflictingBookingID != takenID {
+		t.Fatalf("CreateGroup over a booking: err = %v, want a conflict with %s", err, takenID)
+	}
+
+	var left int
+	err = s.db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM group_reservations WHERE group_name = $1)
+			+ (SELECT COUNT(*) FROM bookings WHERE property_id = $2)
+	`, groupName, first).Scan(&left)
+	if err != nil {
+		t.Fatalf("Failed to count leftovers: %v", err)
+	}
+	if left != 0 {
+		t.Errorf("a refused group left %d rows behind", left)
+	}
+
+	group, err := s.CreateGroup(s.systemUserID, &CreateGroupRequest{
+		GroupName:         groupName,
+		LeadName:          "Lead Guest",
+		LeadContactNumber: "+94771234567",
+		Bookings:          bookings(33),
+	})
+	if err != nil {
+		t.Fatalf("CreateGroup: %v", err)
+	}
+
+	if len(group.Bookings) != 2 {
+		t.Fatalf("group has %d bookings, want 2", len(group.Bookings))
+	}
+	if lead := group.Bookings[0]; lead.PropertyID != first || lead.GuestName != "Lead Guest" || lead.GroupID == nil || *lead.GroupID != group.GroupID {
+		t.Errorf("first booking = %s for %s in %v, want the lead at %s in the group", lead.PropertyID, lead.GuestName, lead.GroupID, first)
+	}
+	if guest := group.Bookings[1]; guest.PropertyID != second || guest.GuestName != "Second Guest" || guest.GuestContactNumber != group.LeadContactNumber {
+		t.Errorf("second booking = %s for %s on %s, want Second Guest at %s on the lead's number", guest.PropertyID, guest.GuestName, guest.GuestContactNumber, second)
+	}
+	if group.TotalAmount == nil || *group.TotalAmount != 500 {
+		t.Errorf("group total = %v, want 500", group.TotalAmount)
+	}
+
+	cancelledBy := createTestUser(t, s)
+	group, err = s.CancelGroup(group.GroupID, cancelledBy)
+	if err != nil {
+		t.Fatalf("CancelGroup: %v", err)
+	}
+	for _, booking := range group.Bookings {
+		if booking.BookingStatus != "cancelled" {
+			t.Errorf("booking %s is %s after cancelling the group", booking.BookingID, booking.BookingStatus)
+		}
+	}
+	if group.TotalAmount != nil {
+		t.Errorf("cancelled group total = %.2f, want none", *group.TotalAmount)
+	}
+
+	for _, booking := range group.Bookings {
+		var modifiedBy uuid.UUID
+		err := s.db.QueryRow(`
+			SELECT modified_by FROM booking_history
+			WHERE booking_id = $1 AND modification_type = 'cancelled'
+		`, booking.BookingID).Scan(&modifiedBy)
+		if err != nil {
+			t.Fatalf("Failed to read the cancellation of %s from the booking history: %v", booking.BookingID, err)
+		}
+		if modifiedBy != cancelledBy {
+			t.Errorf("booking %s cancelled by %s, want %s", booking.BookingID, modifiedBy, cancelledBy)
+		}
+	}
+}
+
+func TestCreateGroupErrors(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	booking := CreateBookingRequest{
+		PropertyID:     propertyID,
+		GuestIDCard:    "ID-12",
+		CheckInDate:    testDate(30).Format("2006-01-02"),
+		CheckOutDate:   testDate(32).Format("2006-01-02"),
+		NumberOfGuests: 1,
+	}
+	badDates := booking
+	badDates.CheckOutDate = "next week"
+
+	tests := []struct {
+		name string
+		req  CreateGroupRequest
+	}{
+		{"missing group name", CreateGroupRequest{LeadName: "Lead", LeadContactNumber: "+94771234567", Bookings: []CreateBookingRequest{booking}}},
+		{"missing lead", CreateGroupRequest{GroupName: "Retreat", Bookings: []CreateBookingRequest{booking}}},
+		{"no bookings", CreateGroupRequest{GroupName: "Retreat", LeadName: "Lead", LeadContactNumber: "+94771234567"}},
+		{"bad booking date", CreateGroupRequest{GroupName: "Retreat", LeadName: "Lead", LeadContactNumber: "+94771234567", Bookings: []CreateBookingRequest{badDates}}},
+	}
+
+	for _, tt := range tests {
+		_, err := s.CreateGroup(s.systemUserID, &tt.req)
+
+		var groupErr *ServiceError
+		if !errors.As(err, &groupErr) || groupErr.Code != http.StatusBadRequest {
+			t.Errorf("%s: err = %v, want a 400 ServiceError", tt.name, err)
+		}
+	}
+}
+
+func TestUnknownGroupHandlers(t *testing.T) {
+	s := newTestService(t)
+	groupID := uuid.New().String()
+
+	handlers := map[string]http.HandlerFunc{
+		"GET /groups/{groupId}":         s.GetGroupHandler,
+		"PUT /groups/{groupId}/cancel":  s.CancelGroupHandler,
+		"GET /groups/{groupId}/invoice": s.GetGroupInvoiceHandler,
+	}
+
+	for name, handler := range handlers {
+		req := httptest.NewRequest("GET", "/api/v1/groups/"+groupID, nil)
+		req = mux.SetURLVars(req, map[string]string{"groupId": groupID})
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d (%s), want %d", name, rec.Code, rec.Body.String(), http.StatusNotFound)
+		}
+	}
+}
+
+// A group cancelled before it was invoiced gets no invoice number; one
+// invoiced first keeps its invoice
+func TestGroupInvoiceOfCancelledGroup(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	createGroup := func(checkIn int) *GroupReservation {
+		group, err := s.CreateGroup(s.systemUserID, &CreateGroupRequest{
+			GroupName:         "Offsite " + uuid.New().String()[:8],
+			LeadName:          "Lead Guest",
+			LeadContactNumber: "+94771234567",
+			Bookings: []CreateBookingRequest{{
+				PropertyID:     propertyID,
+				GuestIDCard:    "ID-13",
+				CheckInDate:    testDate(checkIn).Format("2006-01-02"),
+				CheckOutDate:   testDate(checkIn + 2).Format("2006-01-02"),
+				NumberOfGuests: 1,
+			}},
+		})
+		if err != nil {
+			t.Fatalf("CreateGroup: %v", err)
+		}
+		return group
+	}
+
+	cancelled := createGroup(40)
+	if _, err := s.CancelGroup(cancelled.GroupID, s.systemUserID); err != nil {
+		t.Fatalf("CancelGroup: %v", err)
+	}
+
+	_, err := s.GetGroupInvoice(cancelled.GroupID)
+	var invoiceErr *ServiceError
+	if !errors.As(err, &invoiceErr) || invoiceErr.Code != http.StatusConflict {
+		t.Errorf("invoicing a cancelled group: err = %v, want a 409 ServiceError", err)
+	}
+
+	invoiced := createGroup(50)
+	invoice, err := s.GetGroupInvoice(invoiced.GroupID)
+	if err != nil {
+		t.Fatalf("GetGroupInvoice: %v", err)
+	}
+	if _, err := s.CancelGroup(invoiced.GroupID, s.systemUserID); err != nil {
+		t.Fatalf("CancelGroup: %v", err)
+	}
+
+	again, err := s.GetGroupInvoice(invoiced.GroupID)
+	if err != nil {
+		t.Fatalf("GetGroupInvoice after cancelling: %v", err)
+	}
+	if again.InvoiceNumber != invoice.InvoiceNumber {
+		t.Errorf("invoice number = %s after cancelling, want %s", again.InvoiceNumber, invoice.InvoiceNumber)
+	}
+}
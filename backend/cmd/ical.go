@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Past stays older than this are left out of the feed
+const icalFeedPastDays = 30
+
+// Suffix making event UIDs globally unique, as RFC 5545 asks
+const icalUIDDomain = "booking-service"
+
+// CalendarFeed holds the secret token that grants access to a property's
+// public iCalendar feed
+type CalendarFeed struct {
+	PropertyID     uuid.UUID `json:"property_id"`
+	Token          string    `json:"token"`
+	HideGuestNames bool      `json:"hide_guest_names"`
+	FeedPath       string    `json:"feed_path"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type UpdateCalendarFeedRequest struct {
+	HideGuestNames *bool `json:"hide_guest_names,omitempty"`
+	RotateToken    bool  `json:"rotate_token"`
+}
+
+type icalEvent struct {
+	UID          string
+	Start        time.Time
+	End          time.Time
+	Summary      string
+	Description  string
+	Status       string
+	LastModified time.Time
+}
+
+// Get the feed settings of a property, creating them on first use
+func (s *BookingService) GetCalendarFeed(propertyID uuid.UUID) (*CalendarFeed, error) {
+	feed, err := s.getCalendarFeed(propertyID)
+	if err != nil {
+		return nil, err
+	}
+	if feed != nil {
+		return feed, nil
+	}
+
+	return s.UpdateCalendarFeed(propertyID, &UpdateCalendarFeedRequest{})
+}
+
+// Change the feed settings of a property. Rotating the token invalidates
+// every subscription using the old URL.
+func (s *BookingService) UpdateCalendarFeed(propertyID uuid.UUID, req *UpdateCalendarFeedRequest) (*CalendarFeed, error) {
+	token, err := newFeedToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// Guest names are hidden unless explicitly shown, as the feed is public
+	query := `
+		INSERT INTO property_calendar_feeds (property_id, token, hide_guest_names)
+		VALUES ($1, $2, COALESCE($3, TRUE))
+		ON CONFLICT (property_id) DO UPDATE SET
+			token = CASE WHEN $4 THEN EXCLUDED.token ELSE property_calendar_feeds.token END,
+			hide_guest_names = COALESCE($3, property_calendar_feeds.hide_guest_names),
+			updated_at = CURRENT_TIMESTAMP
+	`
+
+	_, err = s.db.Exec(query, propertyID, token, req.HideGuestNames, req.RotateToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getCalendarFeed(propertyID)
+}
+
+func (s *BookingService) getCalendarFeed(propertyID uuid.UUID) (*CalendarFeed, error) {
+	query := `
+		SELECT property_id, token, hide_guest_names, created_at, updated_at
+		FROM property_calendar_feeds
+		WHERE property_id = $1
+	`
+
+	var feed CalendarFeed
+	err := s.db.QueryRow(query, propertyID).Scan(
+		&feed.PropertyID, &feed.Token, &feed.HideGuestNames, &feed.CreatedAt, &feed.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	feed.FeedPath = fmt.Sprintf("/api/v1/properties/%s/calendar.ics?token=%s", propertyID, feed.Token)
+
+	return &feed, nil
+}
+
+func newFeedToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// errCalendarFeedNotFound is returned for unknown properties and wrong tokens
+// alike
+var errCalendarFeedNotFound = errors.New("calendar feed not found")
+
+// Render the iCalendar feed of a property, returning the document and the
+// time anything in it last changed. Unknown properties and wrong tokens both
+// report "calendar feed not found" so tokens cannot be probed.
+func (s *BookingService) GetCalendarICS(propertyID uuid.UUID, token string) ([]byte, time.Time, error) {
+	feed, err := s.getCalendarFeed(propertyID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if feed == nil || subtle.ConstantTimeCompare([]byte(feed.Token), []byte(token)) != 1 {
+		return nil, time.Time{}, errCalendarFeedNotFound
+	}
+
+	var propertyName string
+	err = s.db.QueryRow(`SELECT property_name FROM properties WHERE property_id = $1`, propertyID).Scan(&propertyName)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	units, err := s.activeUnits([]uuid.UUID{propertyID})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, -icalFeedPastDays)
+	lastModified := feed.UpdatedAt
+
+	// Properties with units are exported as busy only on the nights every
+	// unit is taken, so a booked unit does not close the others elsewhere
+	bookingUpdates := make(map[uuid.UUID]time.Time)
+	var bookedUntil time.Time
+
+	// Cancelled bookings are read too: they are not exported, but cancelling
+	// one changes the feed
+	rows, err := s.db.Query(`
+		SELECT booking_id, guest_name, check_in_date, check_out_date, number_of_guests,
+			booking_status, updated_at
+		FROM bookings
+		WHERE property_id = $1 AND check_out_date >= $2
+		ORDER BY check_in_date
+	`, propertyID, since)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+
+	var events []icalEvent
+
+	for rows.Next() {
+		var bookingID uuid.UUID
+		var guestName, status string
+		var checkIn, checkOut, updatedAt time.Time
+		var guests int
+
+		if err := rows.Scan(&bookingID, &guestName, &checkIn, &checkOut, &guests, &status, &updatedAt); err != nil {
+			return nil, time.Time{}, err
+		}
+
+		if updatedAt.After(lastModified) {
+			lastModified = updatedAt
+		}
+
+		if status != "confirmed" && status != "pending" {
+			continue
+		}
+
+		if len(units) > 0 {
+			bookingUpdates[bookingID] = updatedAt
+			if checkOut.After(bookedUntil) {
+				bookedUntil = checkOut
+			}
+			continue
+		}
+
+		event := icalEvent{
+			UID:          fmt.Sprintf("%s@%s", bookingID, icalUIDDomain),
+			Start:        checkIn,
+			End:          checkOut,
+			Summary:      "Reserved",
+			Status:       "CONFIRMED",
+			LastModified: updatedAt,
+		}
+
+		if !feed.HideGuestNames {
+			event.Summary = guestName
+			event.Description = fmt.Sprintf("Guests: %d", guests)
+		}
+
+		if status == "pending" {
+			event.Status = "TENTATIVE"
+		}
+
+		events = append(events, event)
+	}
+	rows.Close()
+
+	// Blocks imported from other calendars are left out: sending them back
+	// to the channel they came from would block its own reservations there
+	blockRows, err := s.db.Query(`
+		SELECT block_id, unit_id, start_date, end_date, reason, updated_at
+		FROM availability_blocks
+		WHERE property_id = $1 AND end_date >= $2 AND source = $3
+		ORDER BY start_date
+	`, propertyID, since, BlockSourceManual)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer blockRows.Close()
+
+	for blockRows.Next() {
+		var blockID uuid.UUID
+		var unitID *uuid.UUID
+		var reason string
+		var startDate, endDate, updatedAt time.Time
+
+		if err := blockRows.Scan(&blockID, &unitID, &startDate, &endDate, &reason, &updatedAt); err != nil {
+			return nil, time.Time{}, err
+		}
+
+		if updatedAt.After(lastModified) {
+			lastModified = updatedAt
+		}
+
+		// A block of one unit only counts towards the fully booked nights
+		if unitID != nil {
+			if endDate.After(bookedUntil) {
+				bookedUntil = endDate
+			}
+			continue
+		}
+
+		event := icalEvent{
+			UID:          fmt.Sprintf("block-%s@%s", blockID, icalUIDDomain),
+			Start:        startDate,
+			End:          endDate,
+			Summary:      "Not available",
+			Status:       "CONFIRMED",
+			LastModified: updatedAt,
+		}
+
+		if !feed.HideGuestNames {
+			event.Summary = "Blocked: " + reason
+		}
+
+		events = append(events, event)
+	}
+	blockRows.Close()
+
+	if len(units) > 0 && bookedUntil.After(since) {
+		fullyBooked, err := s.fullyBookedEvents(propertyID, since, bookedUntil.AddDate(0, 0, -1), bookingUpdates)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		events = append(events, fullyBooked...)
+	}
+
+	return renderICS(propertyName, events), lastModified, nil
+}
+
+// fullyBookedEvents covers each run of nights from..to on which every unit
+// of the property is taken with one event. Its UID follows the first night,
+// and it was last modified when the latest of its bookings was.
+func (s *BookingService) fullyBookedEvents(propertyID uuid.UUID, from, to time.Time, bookingUpdates map[uuid.UUID]time.Time) ([]icalEvent, error) {
+	calendars, err := s.buildCalendars([]uuid.UUID{propertyID}, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []icalEvent
+	var event *icalEvent
+
+	for _, day := range calendars[propertyID] {
+		if !day.IsBooked {
+			event = nil
+			continue
+		}
+
+		if event == nil {
+			events = append(events, icalEvent{
+				UID:     fmt.Sprintf("full-%s-%s@%s", propertyID, day.Date.Format("20060102"), icalUIDDomain),
+				Start:   day.Date,
+				Summary: "Fully booked",
+				Status:  "CONFIRMED",
+			})
+			event = &events[len(events)-1]
+		}
+
+		event.End = day.Date.AddDate(0, 0, 1)
+		for _, booking := range day.Bookings {
+			if updatedAt := bookingUpdates[booking.BookingID]; updatedAt.After(event.LastModified) {
+				event.LastModified = updatedAt
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// renderICS writes an RFC 5545 calendar of all-day events
+func renderICS(calendarName string, events []icalEvent) []byte {
+	var buf bytes.Buffer
+
+	line := func(content string) {
+		writeICSLine(&buf, content)
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//Bookings Management//Booking Service//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:" + icsEscape(calendarName))
+
+	for _, event := range events {
+		stamp := event.LastModified.UTC().Format("20060102T150405Z")
+
+		line("BEGIN:VEVENT")
+		line("UID:" + event.UID)
+		line("DTSTAMP:" + stamp)
+		line("LAST-MODIFIED:" + stamp)
+		line("DTSTART;VALUE=DATE:" + event.Start.Format("20060102"))
+		line("DTEND;VALUE=DATE:" + event.End.Format("20060102"))
+		line("SUMMARY:" + icsEscape(event.Summary))
+		if event.Description != "" {
+			line("DESCRIPTION:" + icsEscape(event.Description))
+		}
+		line("STATUS:" + event.Status)
+		line("TRANSP:OPAQUE")
+		line("END:VEVENT")
+	}
+
+	line("END:VCALENDAR")
+
+	return buf.Bytes()
+}
+
+// writeICSLine ends a content line with CRLF, folding it so no physical line
+// exceeds 75 octets and multi-byte characters are never split
+func writeICSLine(buf *bytes.Buffer, content string) {
+	width := 0
+	for _, r := range content {
+		size := len(string(r))
+		if width+size > 75 {
+			buf.WriteString("\r\n ")
+			width = 1
+		}
+		buf.WriteRune(r)
+		width += size
+	}
+	buf.WriteString("\r\n")
+}
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func icsEscape(text string) string {
+	return icsEscaper.Replace(text)
+}
+
+// HTTP Handlers
+func (s *BookingService) GetCalendarICSHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	body, lastModified, err := s.GetCalendarICS(propertyID, r.URL.Query().Get("token"))
+	if errors.Is(err, errCalendarFeedNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The ETag is derived from the content, so it also changes when a block
+	// is deleted, which Last-Modified cannot see. ServeContent answers
+	// conditional requests with 304.
+	sum := sha256.Sum256(body)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+
+	http.ServeContent(w, r, "calendar.ics", lastModified, bytes.NewReader(body))
+}
+
+func (s *BookingService) GetCalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	feed, err := s.GetCalendarFeed(propertyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(feed)
+}
+
+func (s *BookingService) UpdateCalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateCalendarFeedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	feed, err := s.UpdateCalendarFeed(propertyID, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(feed)
+}
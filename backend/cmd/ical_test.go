@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestICSEscape(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Booked", "Booked"},
+		{"Smith, John", `Smith\, John`},
+		{"Room 1; upstairs", `Room 1\; upstairs`},
+		{`C:\path`, `C:\\path`},
+		{"two\nlines", `two\nlines`},
+		{"two\r\nlines", `two\nlines`},
+		{`a\,b`, `a\\\,b`},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := icsEscape(tt.text); got != tt.want {
+			t.Errorf("icsEscape(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+		if got := icsUnescape(icsEscape(tt.text)); got != strings.ReplaceAll(tt.text, "\r\n", "\n") {
+			t.Errorf("icsUnescape(icsEscape(%q)) = %q", tt.text, got)
+		}
+	}
+}
+
+func TestWriteICSLine(t *testing.T) {
+	tests := []struct {
+		content string
+		lines   int
+	}{
+		{"", 1},
+		{"SUMMARY:Booked", 1},
+		{strings.Repeat("a", 75), 1},
+		{strings.Repeat("a", 76), 2},
+		// Continuation lines start with a space, leaving 74 octets of content
+		{strings.Repeat("a", 75+74), 2},
+		{strings.Repeat("a", 75+75), 3},
+		// Two-byte characters straddling the 75th octet move to the next line
+		{strings.Repeat("é", 38), 2},
+		{"SUMMARY:" + strings.Repeat("日本", 20), 2},
+		{strings.Repeat("€", 100), 5},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		writeICSLine(&buf, tt.content)
+		out := buf.String()
+
+		if !strings.HasSuffix(out, "\r\n") {
+			t.Errorf("writeICSLine(%q) = %q, want it ended with CRLF", tt.content, out)
+			continue
+		}
+
+		physical := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+		if len(physical) != tt.lines {
+			t.Errorf("writeICSLine(%q) wrote %d lines, want %d", tt.content, len(physical), tt.lines)
+		}
+		for i, line := range physical {
+			if len(line) > 75 {
+				t.Errorf("writeICSLine(%q) line %d is %d octets", tt.content, i, len(line))
+			}
+			if !utf8.ValidString(line) {
+				t.Errorf("writeICSLine(%q) line %d splits a character: %q", tt.content, i, line)
+			}
+			if i > 0 && !strings.HasPrefix(line, " ") {
+				t.Errorf("writeICSLine(%q) continuation line %d does not start with a space", tt.content, i)
+			}
+		}
+
+		if unfolded := strings.ReplaceAll(strings.TrimSuffix(out, "\r\n"), "\r\n ", ""); unfolded != tt.content {
+			t.Errorf("writeICSLine(%q) unfolds to %q", tt.content, unfolded)
+		}
+	}
+}
+
+// An exported calendar reads back through the importer with the same text
+func TestRenderICSRoundTrip(t *testing.T) {
+	summaries := []string{
+		"Smith, John; party of 2",
+		`Back\slash`,
+		"Line one\nline two",
+		"Ünïcödé " + strings.Repeat("ß", 60),
+	}
+
+	var events []icalEvent
+	for i, summary := range summaries {
+		events = append(events, icalEvent{
+			UID:          string(rune('a'+i)) + "@test",
+			Start:        date(2026, 5, 1+i*3),
+			End:          date(2026, 5, 3+i*3),
+			Summary:      summary,
+			Status:       "CONFIRMED",
+			LastModified: time.Date(2026, 4, 1, 12, 0, 0, 0, time.UTC),
+		})
+	}
+
+	out := renderICS("Villa, by the sea", events)
+	for _, line := range strings.Split(string(out), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+	}
+
+	imported, err := parseICS(bytes.NewReader(out), time.UTC)
+	if err != nil {
+		t.Fatalf("parseICS: %v", err)
+	}
+	if len(imported) != len(events) {
+		t.Fatalf("parseICS = %d events, want %d", len(imported), len(events))
+	}
+
+	for i, event := range imported {
+		if event.UID != events[i].UID || event.Summary != events[i].Summary ||
+			!event.Start.Equal(events[i].Start) || !event.End.Equal(events[i].End) {
+			t.Errorf("event %d = %+v, want %+v", i, event, events[i])
+		}
+	}
+}
+
+// A property with units is exported as busy only on the nights every unit
+// is taken
+func TestGetCalendarICSWithUnits(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	unitType, err := s.CreateUnitType(propertyID, &UnitTypeRequest{Name: "Double", MaxGuests: 2})
+	if err != nil {
+		t.Fatalf("CreateUnitType: %v", err)
+	}
+	for _, name := range []string{"101", "102"} {
+		if _, err := s.CreateUnit(propertyID, &UnitRequest{UnitTypeID: unitType.UnitTypeID, UnitName: name}); err != nil {
+			t.Fatalf("CreateUnit: %v", err)
+		}
+	}
+
+	book := func(checkIn, checkOut time.Time) {
+		t.Helper()
+		_, err := s.CreateBooking(s.systemUserID, &CreateBookingRequest{
+			PropertyID:         propertyID,
+			GuestName:          "Unit Guest",
+			GuestIDCard:        "ID-5",
+			GuestContactNumber: "+94771234567",
+			CheckInDate:        checkIn.Format("2006-01-02"),
+			CheckOutDate:       checkOut.Format("2006-01-02"),
+			NumberOfGuests:     1,
+		})
+		if err != nil {
+			t.Fatalf("CreateBooking: %v", err)
+		}
+	}
+
+	// Both units on nights 10 and 11, one of them from night 11 to 14
+	book(testDate(10), testDate(12))
+	book(testDate(10), testDate(11))
+	book(testDate(11), testDate(15))
+
+	feed, err := s.GetCalendarFeed(propertyID)
+	if err != nil {
+		t.Fatalf("GetCalendarFeed: %v", err)
+	}
+
+	out, _, err := s.GetCalendarICS(propertyID, feed.Token)
+	if err != nil {
+		t.Fatalf("GetCalendarICS: %v", err)
+	}
+
+	events, err := parseICS(bytes.NewReader(out), time.UTC)
+	if err != nil {
+		t.Fatalf("parseICS: %v", err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("exported %d events, want 1: %+v", len(events), events)
+	}
+	if !events[0].Start.Equal(testDate(10)) || !events[0].End.Equal(testDate(12)) {
+		t.Errorf("exported %s to %s, want %s to %s", events[0].Start, events[0].End, testDate(10), testDate(12))
+	}
+}
@@ -0,0 +1,1038 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Largest feed the importer will read
+const maxICalFeedBytes = 5 << 20
+
+var icalHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// ExternalCalendar is an iCalendar feed published by another channel whose
+// reservations are imported as availability blocks
+type ExternalCalendar struct {
+	CalendarID    uuid.UUID  `json:"calendar_id"`
+	PropertyID    uuid.UUID  `json:"property_id"`
+	CalendarName  string     `json:"calendar_name"`
+	FeedURL       string     `json:"feed_url"`
+	IsActive      bool       `json:"is_active"`
+	LastSyncedAt  *time.Time `json:"last_synced_at,omitempty"`
+	LastSyncError *string    `json:"last_sync_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+type ExternalCalendarRequest struct {
+	CalendarName string `json:"calendar_name"`
+	FeedURL      string `json:"feed_url"`
+	IsActive     *bool  `json:"is_active,omitempty"`
+}
+
+type ICalSyncResult struct {
+	CalendarID uuid.UUID `json:"calendar_id"`
+	Events     int       `json:"events"`
+	Created    int       `json:"created"`
+	Updated    int       `json:"updated"`
+	Cancelled  int       `json:"cancelled"`
+	Conflicts  int       `json:"conflicts"`
+	SyncedAt   time.Time `json:"synced_at"`
+}
+
+// ImportConflict is an imported event that overlaps a local booking or block
+// and was therefore not imported
+type ImportConflict struct {
+	ConflictID   uuid.UUID  `json:"conflict_id"`
+	CalendarID   uuid.UUID  `json:"calendar_id"`
+	CalendarName string     `json:"calendar_name"`
+	ExternalUID  string     `json:"external_uid"`
+	Summary      *string    `json:"summary,omitempty"`
+	StartDate    time.Time  `json:"start_date"`
+	EndDate      time.Time  `json:"end_date"`
+	BookingID    *uuid.UUID `json:"booking_id,omitempty"`
+	BlockID      *uuid.UUID `json:"block_id,omitempty"`
+	DetectedAt   time.Time  `json:"detected_at"`
+	ResolvedAt   *time.Time `json:"resolved_at,omitempty"`
+}
+
+// icalImportedEvent is a VEVENT read from an external feed. End is exclusive.
+type icalImportedEvent struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time
+}
+
+func (req *ExternalCalendarRequest) validate() error {
+	if strings.TrimSpace(req.CalendarName) == "" {
+		return fmt.Errorf("calendar_name is required")
+	}
+
+	feedURL, err := url.Parse(req.FeedURL)
+	if err != nil || feedURL.Host == "" {
+		return fmt.Errorf("feed_url must be an absolute URL")
+	}
+
+	switch feedURL.Scheme {
+	case "http", "https", "webcal":
+	default:
+		return fmt.Errorf("feed_url must use http, https or webcal")
+	}
+
+	return nil
+}
+
+func (s *BookingService) CreateExternalCalendar(propertyID uuid.UUID, req *ExternalCalendarRequest) (*ExternalCalendar, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	calendarID := uuid.New()
+	query := `
+		INSERT INTO external_calendars (calendar_id, property_id, calendar_name, feed_url, is_active)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err := s.db.Exec(query, calendarID, propertyID, strings.TrimSpace(req.CalendarName), req.FeedURL, isActive)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetExternalCalendar(calendarID)
+}
+
+func (s *BookingService) GetExternalCalendars(propertyID uuid.UUID) ([]ExternalCalendar, error) {
+	query := `
+		SELECT calendar_id, property_id, calendar_name, feed_url, is_active,
+			last_synced_at, last_sync_error, created_at, updated_at
+		FROM external_calendars
+		WHERE property_id = $1
+		ORDER BY calendar_name
+	`
+
+	return s.queryExternalCalendars(query, propertyID)
+}
+
+func (s *BookingService) GetExternalCalendar(calendarID uuid.UUID) (*ExternalCalendar, error) {
+	query := `
+		SELECT calendar_id, property_id, calendar_name, feed_url, is_active,
+			last_synced_at, last_sync_error, created_at, updated_at
+		FROM external_calendars
+		WHERE calendar_id = $1
+	`
+
+	calendars, err := s.queryExternalCalendars(query, calendarID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(calendars) == 0 {
+		return nil, fmt.Errorf("external calendar not found")
+	}
+
+	return &calendars[0], nil
+}
+
+func (s *BookingService) UpdateExternalCalendar(calendarID uuid.UUID, req *ExternalCalendarRequest) (*ExternalCalendar, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE external_calendars
+		SET calendar_name = $1, feed_url = $2, is_active = COALESCE($3, is_active), updated_at = CURRENT_TIMESTAMP
+		WHERE calendar_id = $4
+	`
+
+	result, err := s.db.Exec(query, strings.TrimSpace(req.CalendarName), req.FeedURL, req.IsActive, calendarID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, fmt.Errorf("external calendar not found")
+	}
+
+	return s.GetExternalCalendar(calendarID)
+}
+
+// Remove an external calendar together with the blocks imported from it
+func (s *BookingService) DeleteExternalCalendar(calendarID uuid.UUID) error {
+	result, err := s.db.Exec(`DELETE FROM external_calendars WHERE calendar_id = $1`, calendarID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("external calendar not found")
+	}
+
+	return nil
+}
+
+func (s *BookingService) queryExternalCalendars(query string, args ...interface{}) ([]ExternalCalendar, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var calendars []ExternalCalendar
+
+	for rows.Next() {
+		var calendar ExternalCalendar
+		err := rows.Scan(
+			&calendar.CalendarID, &calendar.PropertyID, &calendar.CalendarName, &calendar.FeedURL,
+			&calendar.IsActive, &calendar.LastSyncedAt, &calendar.LastSyncError,
+			&calendar.CreatedAt, &calendar.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		calendars = append(calendars, calendar)
+	}
+
+	return calendars, nil
+}
+
+// Get the import conflicts of a property, newest first
+func (s *BookingService) GetImportConflicts(propertyID uuid.UUID, includeResolved bool) ([]ImportConflict, error) {
+	query := `
+		SELECT c.conflict_id, c.calendar_id, ec.calendar_name, c.external_uid, c.summary,
+			c.start_date, c.end_date, c.booking_id, c.block_id, c.detected_at, c.resolved_at
+		FROM ical_import_conflicts c
+		JOIN external_calendars ec ON ec.calendar_id = c.calendar_id
+		WHERE ec.property_id = $1
+		AND ($2 OR c.resolved_at IS NULL)
+		ORDER BY c.detected_at DESC
+	`
+
+	rows, err := s.db.Query(query, propertyID, includeResolved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conflicts []ImportConflict
+
+	for rows.Next() {
+		var conflict ImportConflict
+		err := rows.Scan(
+			&conflict.ConflictID, &conflict.CalendarID, &conflict.CalendarName, &conflict.ExternalUID,
+			&conflict.Summary, &conflict.StartDate, &conflict.EndDate, &conflict.BookingID,
+			&conflict.BlockID, &conflict.DetectedAt, &conflict.ResolvedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		conflicts = append(conflicts, conflict)
+	}
+
+	return conflicts, nil
+}
+
+// Import one external calendar: new events become blocks, changed events
+// move their block, and future blocks whose event disappeared or was
+// cancelled are removed. Events overlapping a local booking or block are
+// recorded as conflicts instead of being imported.
+func (s *BookingService) SyncExternalCalendar(calendarID uuid.UUID) (*ICalSyncResult, error) {
+	calendar, err := s.GetExternalCalendar(calendarID)
+	if err != nil {
+		return nil, err
+	}
+
+	loc, err := s.propertyLocation(calendar.PropertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := fetchICalFeed(calendar.FeedURL, loc)
+	if err != nil {
+		s.recordSyncError(calendarID, err)
+		return nil, err
+	}
+
+	result, err := s.importICalEvents(calendar, events)
+	if err != nil {
+		s.recordSyncError(calendarID, err)
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// propertyLocation is the time zone a property's calendar days are in
+func (s *BookingService) propertyLocation(propertyID uuid.UUID) (*time.Location, error) {
+	var timeZone string
+	err := s.db.QueryRow(`SELECT time_zone FROM properties WHERE property_id = $1`, propertyID).Scan(&timeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("property time zone %q is not known: %v", timeZone, err)
+	}
+
+	return loc, nil
+}
+
+func (s *BookingService) recordSyncError(calendarID uuid.UUID, syncErr error) {
+	_, err := s.db.Exec(`
+		UPDATE external_calendars SET last_sync_error = $1 WHERE calendar_id = $2
+	`, syncErr.Error(), calendarID)
+	if err != nil {
+		log.Printf("Failed to record sync error for calendar %s: %v", calendarID, err)
+	}
+}
+
+// fullyBookedNight returns the earliest booking, or block of a unit, on the
+// first night from..to (exclusive) on which every one of the property's
+// units is taken, or on which the whole property is booked
+func fullyBookedNight(tx *sql.Tx, propertyID uuid.UUID, from, to time.Time, units int) (*uuid.UUID, *uuid.UUID, error) {
+	var bookingID, blockID *uuid.UUID
+	err := tx.QueryRow(`
+		WITH nights AS (
+			SELECT d::DATE AS night FROM generate_series($2::DATE, $3::DATE - 1, INTERVAL '1 day') d
+		),
+		taken AS (
+			SELECT n.night, b.booking_id, NULL::UUID AS block_id, b.unit_id, b.check_in_date AS start_date
+			FROM nights n
+			JOIN bookings b ON b.property_id = $1
+				AND b.booking_status IN ('confirmed', 'pending')
+				AND b.check_in_date <= n.night
+				AND (b.check_out_date > n.night
+					OR b.booking_id IN (SELECT booking_id FROM long_stays WHERE open_ended))
+			UNION ALL
+			SELECT n.night, NULL, ab.block_id, ab.unit_id, ab.start_date
+			FROM nights n
+			JOIN availability_blocks ab ON ab.property_id = $1
+				AND ab.unit_id IS NOT NULL
+				AND ab.start_date <= n.night AND ab.end_date > n.night
+		)
+		SELECT booking_id, block_id FROM taken
+		WHERE night = (
+			SELECT night FROM taken
+			GROUP BY night
+			HAVING bool_or(unit_id IS NULL)
+				OR COUNT(DISTINCT unit_id) FILTER (
+					WHERE unit_id IN (SELECT unit_id FROM units WHERE property_id = $1 AND is_active)
+				) >= $4
+			ORDER BY night
+			LIMIT 1
+		)
+		ORDER BY booking_id IS NULL, start_date
+		LIMIT 1
+	`, propertyID, from, to, units).Scan(&bookingID, &blockID)
+
+	return bookingID, blockID, err
+}
+
+type importedBlock struct {
+	blockID   uuid.UUID
+	startDate time.Time
+	endDate   time.Time
+	reason    string
+}
+
+func (s *BookingService) importICalEvents(calendar *ExternalCalendar, events []icalImportedEvent) (*ICalSyncResult, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Serialise concurrent syncs of the same calendar
+	_, err = tx.Exec(`SELECT 1 FROM external_calendars WHERE calendar_id = $1 FOR UPDATE`, calendar.CalendarID)
+	if err != nil {
+		return nil, err
+	}
+
+	// On a property with units an event only clashes with the bookings and
+	// unit blocks on nights every unit is taken. The block trigger leaves that check to
+	// this transaction, so take the lock it would take.
+	var units int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM units WHERE property_id = $1 AND is_active
+	`, calendar.PropertyID).Scan(&units)
+	if err != nil {
+		return nil, err
+	}
+
+	if units > 0 {
+		if err := lockPropertyAvailability(tx, calendar.PropertyID); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := tx.Query(`
+		SELECT block_id, external_uid, start_date, end_date, reason
+		FROM availability_blocks
+		WHERE external_calendar_id = $1
+	`, calendar.CalendarID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]importedBlock)
+	for rows.Next() {
+		var block importedBlock
+		var uid string
+		if err := rows.Scan(&block.blockID, &uid, &block.startDate, &block.endDate, &block.reason); err != nil {
+			return nil, err
+		}
+		existing[uid] = block
+	}
+	rows.Close()
+
+	result := &ICalSyncResult{CalendarID: calendar.CalendarID, Events: len(events)}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	inFeed := make(map[string]bool)
+	for _, event := range events {
+		inFeed[event.UID] = true
+	}
+
+	// Cancel first, so an event moving into dates another event just vacated
+	// does not conflict with the stale block. Past blocks are kept, as feeds
+	// usually drop old events.
+	for uid, block := range existing {
+		if inFeed[uid] || !block.endDate.After(today) {
+			continue
+		}
+
+		if _, err := tx.Exec(`DELETE FROM availability_blocks WHERE block_id = $1`, block.blockID); err != nil {
+			return nil, err
+		}
+		delete(existing, uid)
+		result.Cancelled++
+	}
+
+	conflictUIDs := []string{}
+
+	for _, event := range events {
+		if !event.End.After(today) {
+			continue
+		}
+
+		block, imported := existing[event.UID]
+
+		var bookingID, blockID *uuid.UUID
+		if units > 0 {
+			bookingID, blockID, err = fullyBookedNight(tx, calendar.PropertyID, event.Start, event.End, units)
+		} else {
+			err = tx.QueryRow(`
+				SELECT booking_id FROM bookings
+				WHERE property_id = $1
+				AND booking_status IN ('confirmed', 'pending')
+				AND check_in_date < $3
+				AND (check_out_date > $2 OR booking_id IN (SELECT booking_id FROM long_stays WHERE open_ended))
+				ORDER BY check_in_date
+				LIMIT 1
+			`, calendar.PropertyID, event.Start, event.End).Scan(&bookingID)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		// Blocks of single units only clash on fully booked nights, found above
+		if bookingID == nil && blockID == nil {
+			err = tx.QueryRow(`
+				SELECT block_id FROM availability_blocks
+				WHERE property_id = $1 AND unit_id IS NULL
+				AND start_date < $3 AND end_date > $2
+				AND block_id != COALESCE($4, '00000000-0000-0000-0000-000000000000'::UUID)
+				ORDER BY start_date
+				LIMIT 1
+			`, calendar.PropertyID, event.Start, event.End, importedBlockID(block, imported)).Scan(&blockID)
+			if err != nil && err != sql.ErrNoRows {
+				return nil, err
+			}
+		}
+
+		if bookingID != nil || blockID != nil {
+			_, err = tx.Exec(`
+				INSERT INTO ical_import_conflicts (
+					calendar_id, external_uid, summary, start_date, end_date, booking_id, block_id
+				) VALUES ($1, $2, $3, $4, $5, $6, $7)
+				ON CONFLICT (calendar_id, external_uid) WHERE resolved_at IS NULL DO UPDATE SET
+					summary = EXCLUDED.summary,
+					start_date = EXCLUDED.start_date,
+					end_date = EXCLUDED.end_date,
+					booking_id = EXCLUDED.booking_id,
+					block_id = EXCLUDED.block_id
+			`, calendar.CalendarID, event.UID, event.Summary, event.Start, event.End, bookingID, blockID)
+			if err != nil {
+				return nil, err
+			}
+
+			// The event moved onto taken dates; its old dates are free again
+			if imported {
+				if _, err := tx.Exec(`DELETE FROM availability_blocks WHERE block_id = $1`, block.blockID); err != nil {
+					return nil, err
+				}
+			}
+
+			conflictUIDs = append(conflictUIDs, event.UID)
+			result.Conflicts++
+			continue
+		}
+
+		reason := calendar.CalendarName
+		if event.Summary != "" {
+			reason = fmt.Sprintf("%s: %s", calendar.CalendarName, event.Summary)
+		}
+
+		if imported {
+			if block.startDate.Equal(event.Start) && block.endDate.Equal(event.End) && block.reason == reason {
+				continue
+			}
+
+			_, err = tx.Exec(`
+				UPDATE availability_blocks
+				SET start_date = $1, end_date = $2, reason = $3, updated_at = CURRENT_TIMESTAMP
+				WHERE block_id = $4
+			`, event.Start, event.End, reason, block.blockID)
+			if err != nil {
+				return nil, err
+			}
+			result.Updated++
+			continue
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO availability_blocks (
+				block_id, property_id, start_date, end_date, block_type, reason,
+				source, external_calendar_id, external_uid
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, uuid.New(), calendar.PropertyID, event.Start, event.End, BlockTypeExternal, reason,
+			BlockSourceICal, calendar.CalendarID, event.UID)
+		if err != nil {
+			return nil, err
+		}
+		result.Created++
+	}
+
+	// Conflicts that were not reported again have been resolved
+	_, err = tx.Exec(`
+		UPDATE ical_import_conflicts
+		SET resolved_at = CURRENT_TIMESTAMP
+		WHERE calendar_id = $1 AND resolved_at IS NULL
+		AND NOT (external_uid = ANY($2::TEXT[]))
+	`, calendar.CalendarID, pq.Array(conflictUIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(`
+		UPDATE external_calendars
+		SET last_synced_at = CURRENT_TIMESTAMP, last_sync_error = NULL
+		WHERE calendar_id = $1
+		RETURNING last_synced_at
+	`, calendar.CalendarID).Scan(&result.SyncedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func importedBlockID(block importedBlock, imported bool) *uuid.UUID {
+	if !imported {
+		return nil
+	}
+	return &block.blockID
+}
+
+// Sync every active external calendar. Failures are logged and recorded on
+// the calendar so one broken feed does not hold up the others.
+func (s *BookingService) SyncExternalCalendars() {
+	rows, err := s.db.Query(`SELECT calendar_id FROM external_calendars WHERE is_active = TRUE`)
+	if err != nil {
+		log.Printf("Failed to list external calendars: %v", err)
+		return
+	}
+
+	var calendarIDs []uuid.UUID
+	for rows.Next() {
+		var calendarID uuid.UUID
+		if err := rows.Scan(&calendarID); err != nil {
+			rows.Close()
+			log.Printf("Failed to list external calendars: %v", err)
+			return
+		}
+		calendarIDs = append(calendarIDs, calendarID)
+	}
+	rows.Close()
+
+	for _, calendarID := range calendarIDs {
+		result, err := s.SyncExternalCalendar(calendarID)
+		if err != nil {
+			log.Printf("Failed to sync external calendar %s: %v", calendarID, err)
+			continue
+		}
+
+		log.Printf("Synced external calendar %s: %d created, %d updated, %d cancelled, %d conflicts",
+			calendarID, result.Created, result.Updated, result.Cancelled, result.Conflicts)
+	}
+}
+
+// runICalImporter syncs all external calendars now and then every interval
+func (s *BookingService) runICalImporter(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.SyncExternalCalendars()
+		<-ticker.C
+	}
+}
+
+func fetchICalFeed(feedURL string, loc *time.Location) ([]icalImportedEvent, error) {
+	if strings.HasPrefix(feedURL, "webcal://") {
+		feedURL = "https://" + strings.TrimPrefix(feedURL, "webcal://")
+	}
+
+	req, err := http.NewRequest("GET", feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/calendar")
+
+	resp, err := icalHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch feed: %s", resp.Status)
+	}
+
+	return parseICS(io.LimitReader(resp.Body, maxICalFeedBytes), loc)
+}
+
+// parseICS reads the VEVENTs of an iCalendar document. Bookings are by
+// night, so DATE-TIME values are moved into the property's time zone, loc,
+// and only their date is kept. Cancelled events and events without a UID
+// are skipped. Events are keyed by UID, with the RECURRENCE-ID of an
+// override of a single occurrence appended; when a key repeats, the last
+// event with it wins.
+func parseICS(r io.Reader, loc *time.Location) ([]icalImportedEvent, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxICalFeedBytes)
+
+	// Unfold continuation lines (RFC 5545 3.1) before parsing
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read feed: %v", err)
+	}
+
+	var keys []string
+	byKey := map[string]*icalImportedEvent{}
+	var components []string
+	var event icalImportedEvent
+	var start time.Time
+	var duration, status, recurrenceID string
+	foundCalendar := false
+
+	for _, line := range lines {
+		name, params, value := splitICSLine(line)
+
+		switch name {
+		case "BEGIN":
+			components = append(components, strings.ToUpper(value))
+			if strings.EqualFold(value, "VCALENDAR") {
+				foundCalendar = true
+			}
+			if strings.EqualFold(value, "VEVENT") {
+				event = icalImportedEvent{}
+				start = time.Time{}
+				duration, status, recurrenceID = "", "", ""
+			}
+			continue
+		case "END":
+			if len(components) > 0 {
+				components = components[:len(components)-1]
+			}
+			if !strings.EqualFold(value, "VEVENT") {
+				continue
+			}
+
+			if event.UID == "" || event.Start.IsZero() {
+				continue
+			}
+
+			if recurrenceID != "" {
+				event.UID += "#" + recurrenceID
+			}
+			if _, seen := byKey[event.UID]; !seen {
+				keys = append(keys, event.UID)
+			}
+			if strings.EqualFold(status, "CANCELLED") {
+				byKey[event.UID] = nil
+				continue
+			}
+
+			if event.End.IsZero() && duration != "" {
+				days, clock, err := parseICSDuration(duration)
+				if err != nil {
+					return nil, fmt.Errorf("invalid DURATION %q in event %s", duration, event.UID)
+				}
+				event.End = icsDate(start.AddDate(0, 0, days).Add(clock))
+			}
+			if !event.End.After(event.Start) {
+				event.End = event.Start.AddDate(0, 0, 1)
+			}
+
+			imported := event
+			byKey[event.UID] = &imported
+			continue
+		}
+
+		// Ignore properties of the calendar itself and of nested components such as VALARM
+		if len(components) == 0 || components[len(components)-1] != "VEVENT" {
+			continue
+		}
+
+		switch name {
+		case "UID":
+			event.UID = value
+		case "SUMMARY":
+			event.Summary = icsUnescape(value)
+		case "STATUS":
+			status = value
+		case "DURATION":
+			duration = value
+		case "RECURRENCE-ID":
+			recurrenceID = value
+		case "DTSTART", "DTEND":
+			instant, err := parseICSDate(value, params["TZID"], loc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q in event %s", name, value, event.UID)
+			}
+			if name == "DTSTART" {
+				start = instant
+				event.Start = icsDate(instant)
+			} else {
+				event.End = icsDate(instant)
+			}
+		}
+	}
+
+	if !foundCalendar {
+		return nil, fmt.Errorf("feed is not an iCalendar document")
+	}
+
+	var events []icalImportedEvent
+	for _, key := range keys {
+		if event := byKey[key]; event != nil {
+			events = append(events, *event)
+		}
+	}
+
+	return events, nil
+}
+
+// splitICSLine splits "NAME;PARAM=x:value" into the name, the parameters and
+// the value. Colons and semicolons inside quoted parameter values are kept.
+func splitICSLine(line string) (string, map[string]string, string) {
+	var fields []string
+	inQuotes := false
+	fieldStart := 0
+
+	for i, r := range line {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case r == ';' && !inQuotes:
+			fields = append(fields, line[fieldStart:i])
+			fieldStart = i + 1
+		case r == ':' && !inQuotes:
+			fields = append(fields, line[fieldStart:i])
+
+			params := make(map[string]string)
+			for _, param := range fields[1:] {
+				if j := strings.IndexByte(param, '='); j >= 0 {
+					params[strings.ToUpper(param[:j])] = strings.Trim(param[j+1:], `"`)
+				}
+			}
+			return strings.ToUpper(fields[0]), params, line[i+1:]
+		}
+	}
+	return strings.ToUpper(line), nil, ""
+}
+
+// parseICSDate reads a DATE (20240115) or DATE-TIME value as an instant in
+// loc. DATE-TIMEs in UTC (20240115T140000Z) or with a TZID are converted to
+// loc; floating DATE-TIMEs, and those whose TZID is not an IANA zone name,
+// are taken as local time at the property.
+func parseICSDate(value, tzid string, loc *time.Location) (time.Time, error) {
+	if len(value) == 8 {
+		return time.ParseInLocation("20060102", value, loc)
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		instant, err := time.Parse("20060102T150405Z", value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return instant.In(loc), nil
+	}
+
+	zone := loc
+	if tzid != "" {
+		if named, err := time.LoadLocation(tzid); err == nil {
+			zone = named
+		}
+	}
+
+	instant, err := time.ParseInLocation("20060102T150405", value, zone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return instant.In(loc), nil
+}
+
+// icsDate is the calendar date of an instant, in the form stay dates are kept
+func icsDate(instant time.Time) time.Time {
+	return time.Date(instant.Year(), instant.Month(), instant.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// parseICSDuration reads a positive DURATION such as P3D, P1W or P1DT12H
+// into its days and its hours, minutes and seconds. Days are kept apart so
+// adding them follows the calendar across daylight saving changes.
+func parseICSDuration(duration string) (int, time.Duration, error) {
+	value := strings.TrimPrefix(strings.ToUpper(duration), "+")
+	if !strings.HasPrefix(value, "P") || len(value) < 3 {
+		return 0, 0, fmt.Errorf("invalid duration %q", duration)
+	}
+
+	var days int
+	var clock time.Duration
+	inTime := false
+	digits := ""
+
+	for _, r := range value[1:] {
+		if r >= '0' && r <= '9' {
+			digits += string(r)
+			continue
+		}
+		if r == 'T' && !inTime && digits == "" {
+			inTime = true
+			continue
+		}
+
+		n, err := strconv.Atoi(digits)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid duration %q", duration)
+		}
+		digits = ""
+
+		switch {
+		case r == 'W' && !inTime:
+			days += n * 7
+		case r == 'D' && !inTime:
+			days += n
+		case r == 'H' && inTime:
+			clock += time.Duration(n) * time.Hour
+		case r == 'M' && inTime:
+			clock += time.Duration(n) * time.Minute
+		case r == 'S' && inTime:
+			clock += time.Duration(n) * time.Second
+		default:
+			return 0, 0, fmt.Errorf("invalid duration %q", duration)
+		}
+	}
+
+	// Digits without a unit, or a T without a time after it
+	if digits != "" || strings.HasSuffix(value, "T") {
+		return 0, 0, fmt.Errorf("invalid duration %q", duration)
+	}
+
+	return days, clock, nil
+}
+
+var icsUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+func icsUnescape(text string) string {
+	return icsUnescaper.Replace(text)
+}
+
+// HTTP Handlers
+func (s *BookingService) GetExternalCalendarsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	calendars, err := s.GetExternalCalendars(propertyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(calendars)
+}
+
+func (s *BookingService) CreateExternalCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ExternalCalendarRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	calendar, err := s.CreateExternalCalendar(propertyID, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(calendar)
+}
+
+func (s *BookingService) UpdateExternalCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	calendarIDStr := vars["calendarId"]
+
+	calendarID, err := uuid.Parse(calendarIDStr)
+	if err != nil {
+		http.Error(w, "Invalid calendar ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ExternalCalendarRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	calendar, err := s.UpdateExternalCalendar(calendarID, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(calendar)
+}
+
+func (s *BookingService) DeleteExternalCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	calendarIDStr := vars["calendarId"]
+
+	calendarID, err := uuid.Parse(calendarIDStr)
+	if err != nil {
+		http.Error(w, "Invalid calendar ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.DeleteExternalCalendar(calendarID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *BookingService) SyncExternalCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	calendarIDStr := vars["calendarId"]
+
+	calendarID, err := uuid.Parse(calendarIDStr)
+	if err != nil {
+		http.Error(w, "Invalid calendar ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.SyncExternalCalendar(calendarID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *BookingService) GetImportConflictsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	includeResolved := r.URL.Query().Get("include_resolved") == "true"
+
+	conflicts, err := s.GetImportConflicts(propertyID, includeResolved)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conflicts)
+}
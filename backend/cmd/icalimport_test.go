@@ -0,0 +1,522 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// icsDocument wraps VEVENT lines in a calendar, with CRLF line endings
+func icsDocument(lines ...string) string {
+	all := append([]string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//Test//Test//EN"}, lines...)
+	all = append(all, "END:VCALENDAR")
+	return strings.Join(all, "\r\n") + "\r\n"
+}
+
+// icsFeedServer serves whatever feed was set last
+type icsFeedServer struct {
+	*httptest.Server
+	mu   sync.Mutex
+	feed string
+}
+
+func newICSFeedServer(t *testing.T) *icsFeedServer {
+	server := &icsFeedServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/calendar")
+		fmt.Fprint(w, server.feed)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (f *icsFeedServer) setFeed(feed string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.feed = feed
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParseICS(t *testing.T) {
+	colombo, err := time.LoadLocation("Asia/Colombo")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+
+	doc := icsDocument(
+		"BEGIN:VEVENT",
+		"UID:all-day@example.com",
+		"DTSTART;VALUE=DATE:20240115",
+		"DTEND;VALUE=DATE:20240118",
+		"SUMMARY:Reserved by a guest whose name is long enough that the line is f",
+		" olded\\, twice",
+		"\tover",
+		"BEGIN:VALARM",
+		"DTSTART:20230101T000000Z",
+		"END:VALARM",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:utc@example.com",
+		"DTSTART:20240201T200000Z",
+		"DTEND:20240203T200000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:tzid@example.com",
+		`DTSTART;TZID="America/New_York":20240301T200000`,
+		"DTEND;TZID=America/New_York:20240303T100000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:floating@example.com",
+		"DTSTART:20240401T230000",
+		"DTEND:20240402T110000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:days@example.com",
+		"DTSTART;VALUE=DATE:20240501",
+		"DURATION:P3D",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:compound@example.com",
+		"DTSTART:20240601T150000Z",
+		"DURATION:P1DT12H",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:weeks@example.com",
+		"DTSTART;VALUE=DATE:20240701",
+		"DURATION:P1W",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:no-end@example.com",
+		"DTSTART;VALUE=DATE:20240801",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:cancelled@example.com",
+		"DTSTART;VALUE=DATE:20240901",
+		"DTEND;VALUE=DATE:20240903",
+		"STATUS:CANCELLED",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20241001",
+		"DTEND;VALUE=DATE:20241003",
+		"END:VEVENT",
+	)
+
+	events, err := parseICS(strings.NewReader(doc), colombo)
+	if err != nil {
+		t.Fatalf("parseICS: %v", err)
+	}
+
+	want := []icalImportedEvent{
+		{UID: "all-day@example.com", Summary: "Reserved by a guest whose name is long enough that the line is folded, twiceover", Start: date(2024, 1, 15), End: date(2024, 1, 18)},
+		// 20:00 UTC is 01:30 the next day in Colombo
+		{UID: "utc@example.com", Start: date(2024, 2, 2), End: date(2024, 2, 4)},
+		// 20:00 in New York is 06:30 the next morning in Colombo
+		{UID: "tzid@example.com", Start: date(2024, 3, 2), End: date(2024, 3, 3)},
+		{UID: "floating@example.com", Start: date(2024, 4, 1), End: date(2024, 4, 2)},
+		{UID: "days@example.com", Start: date(2024, 5, 1), End: date(2024, 5, 4)},
+		// 20:30 in Colombo plus a day and a half ends at 08:30 on the 3rd
+		{UID: "compound@example.com", Start: date(2024, 6, 1), End: date(2024, 6, 3)},
+		{UID: "weeks@example.com", Start: date(2024, 7, 1), End: date(2024, 7, 8)},
+		{UID: "no-end@example.com", Start: date(2024, 8, 1), End: date(2024, 8, 2)},
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+
+	for i, event := range events {
+		if event.UID != want[i].UID || event.Summary != want[i].Summary ||
+			!event.Start.Equal(want[i].Start) || !event.End.Equal(want[i].End) {
+			t.Errorf("event %d = %+v, want %+v", i, event, want[i])
+		}
+	}
+}
+
+// Repeated UIDs collapse to the last event with them; overrides of single
+// occurrences of a recurring event are kept apart by their RECURRENCE-ID
+func TestParseICSDuplicateUIDs(t *testing.T) {
+	var lines []string
+	lines = append(lines, icsEvent("repeat@example.com", date(2024, 1, 10), date(2024, 1, 12))...)
+	lines = append(lines, icsEvent("series@example.com", date(2024, 2, 1), date(2024, 2, 2), "RRULE:FREQ=WEEKLY;COUNT=4")...)
+	lines = append(lines, icsEvent("gone@example.com", date(2024, 3, 1), date(2024, 3, 3))...)
+	lines = append(lines, icsEvent("repeat@example.com", date(2024, 1, 11), date(2024, 1, 14))...)
+	lines = append(lines, icsEvent("series@example.com", date(2024, 2, 9), date(2024, 2, 10), "RECURRENCE-ID;VALUE=DATE:20240208")...)
+	lines = append(lines, icsEvent("gone@example.com", date(2024, 3, 1), date(2024, 3, 3), "STATUS:CANCELLED")...)
+
+	events, err := parseICS(strings.NewReader(icsDocument(lines...)), time.UTC)
+	if err != nil {
+		t.Fatalf("parseICS: %v", err)
+	}
+
+	want := []icalImportedEvent{
+		{UID: "repeat@example.com", Start: date(2024, 1, 11), End: date(2024, 1, 14)},
+		{UID: "series@example.com", Start: date(2024, 2, 1), End: date(2024, 2, 2)},
+		{UID: "series@example.com#20240208", Start: date(2024, 2, 9), End: date(2024, 2, 10)},
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+
+	for i, event := range events {
+		if event.UID != want[i].UID || !event.Start.Equal(want[i].Start) || !event.End.Equal(want[i].End) {
+			t.Errorf("event %d = %+v, want %+v", i, event, want[i])
+		}
+	}
+}
+
+func TestParseICSRejectsInvalidDocuments(t *testing.T) {
+	tests := map[string]string{
+		"not a calendar":   "<html></html>",
+		"invalid date":     icsDocument("BEGIN:VEVENT", "UID:a", "DTSTART:2024-01-15", "END:VEVENT"),
+		"invalid duration": icsDocument("BEGIN:VEVENT", "UID:a", "DTSTART;VALUE=DATE:20240115", "DURATION:P1X", "END:VEVENT"),
+	}
+
+	for name, doc := range tests {
+		if _, err := parseICS(strings.NewReader(doc), time.UTC); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestParseICSDuration(t *testing.T) {
+	tests := []struct {
+		duration string
+		days     int
+		clock    time.Duration
+		valid    bool
+	}{
+		{"P3D", 3, 0, true},
+		{"P2W", 14, 0, true},
+		{"+P1D", 1, 0, true},
+		{"P1DT12H", 1, 12 * time.Hour, true},
+		{"PT36H", 0, 36 * time.Hour, true},
+		{"P1W2D", 9, 0, true},
+		{"PT1H30M15S", 0, time.Hour + 30*time.Minute + 15*time.Second, true},
+		{"p1d", 1, 0, true},
+		{"", 0, 0, false},
+		{"P", 0, 0, false},
+		{"3D", 0, 0, false},
+		{"P1H", 0, 0, false},
+		{"PT1D", 0, 0, false},
+		{"P1DT", 0, 0, false},
+		{"P1D2", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		days, clock, err := parseICSDuration(tt.duration)
+		if (err == nil) != tt.valid {
+			t.Errorf("parseICSDuration(%q) error = %v, want valid %v", tt.duration, err, tt.valid)
+			continue
+		}
+		if tt.valid && (days != tt.days || clock != tt.clock) {
+			t.Errorf("parseICSDuration(%q) = %d days %v, want %d days %v", tt.duration, days, clock, tt.days, tt.clock)
+		}
+	}
+}
+
+func TestFetchICalFeedFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer server.Close()
+
+	if _, err := fetchICalFeed(server.URL+"/missing", time.UTC); err == nil {
+		t.Error("expected an error for a missing feed")
+	}
+	if _, err := fetchICalFeed(server.URL+"/page", time.UTC); err == nil {
+		t.Error("expected an error for a feed that is not a calendar")
+	}
+}
+
+// icsEvent is a VEVENT of whole days
+func icsEvent(uid string, start, end time.Time, extra ...string) []string {
+	lines := []string{
+		"BEGIN:VEVENT",
+		"UID:" + uid,
+		"DTSTART;VALUE=DATE:" + start.Format("20060102"),
+		"DTEND;VALUE=DATE:" + end.Format("20060102"),
+	}
+	lines = append(lines, extra...)
+	return append(lines, "END:VEVENT")
+}
+
+func TestSyncExternalCalendar(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	localBookingID := createTestBooking(t, s, propertyID, testDate(61), testDate(63))
+
+	feed := newICSFeedServer(t)
+
+	calendar, err := s.CreateExternalCalendar(propertyID, &ExternalCalendarRequest{
+		CalendarName: "Airbnb",
+		FeedURL:      feed.URL,
+	})
+	if err != nil {
+		t.Fatalf("CreateExternalCalendar: %v", err)
+	}
+
+	var lines []string
+	lines = append(lines, icsEvent("stay@airbnb", testDate(30), testDate(33),
+		"SUMMARY:Reserved by a guest with a name long enough for the line to be fol",
+		" ded")...)
+	lines = append(lines,
+		"BEGIN:VEVENT",
+		"UID:duration@airbnb",
+		"DTSTART:"+testDate(40).Add(14*time.Hour).Format("20060102T150405Z"),
+		"DURATION:P2DT12H",
+		"END:VEVENT",
+	)
+	lines = append(lines, icsEvent("cancelled@airbnb", testDate(50), testDate(52), "STATUS:CANCELLED")...)
+	lines = append(lines, icsEvent("clash@airbnb", testDate(60), testDate(62))...)
+	feed.setFeed(icsDocument(lines...))
+
+	result, err := s.SyncExternalCalendar(calendar.CalendarID)
+	if err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	if result.Events != 3 || result.Created != 2 || result.Updated != 0 || result.Cancelled != 0 || result.Conflicts != 1 {
+		t.Errorf("first sync = %+v, want 3 events, 2 created and 1 conflict", result)
+	}
+
+	blocks := importedBlocks(t, s, calendar)
+	if len(blocks) != 2 {
+		t.Fatalf("got %d imported blocks, want 2", len(blocks))
+	}
+	if block := blocks["stay@airbnb"]; !block.startDate.Equal(testDate(30)) || !block.endDate.Equal(testDate(33)) ||
+		block.reason != "Airbnb: Reserved by a guest with a name long enough for the line to be folded" {
+		t.Errorf("stay block = %+v", block)
+	}
+	// 14:00 UTC plus two and a half days ends at 02:00 on the third day
+	if block := blocks["duration@airbnb"]; !block.startDate.Equal(testDate(40)) || !block.endDate.Equal(testDate(43)) {
+		t.Errorf("duration block = %+v", block)
+	}
+
+	conflicts, err := s.GetImportConflicts(propertyID, false)
+	if err != nil {
+		t.Fatalf("GetImportConflicts: %v", err)
+	}
+	if len(conflicts) != 1 || conflicts[0].ExternalUID != "clash@airbnb" ||
+		conflicts[0].BookingID == nil || *conflicts[0].BookingID != localBookingID {
+		t.Errorf("conflicts = %+v, want clash@airbnb against the local booking", conflicts)
+	}
+
+	// The stay moves a night later, the other events are dropped
+	feed.setFeed(icsDocument(icsEvent("stay@airbnb", testDate(31), testDate(34), "SUMMARY:Reserved")...))
+
+	result, err = s.SyncExternalCalendar(calendar.CalendarID)
+	if err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	if result.Events != 1 || result.Created != 0 || result.Updated != 1 || result.Cancelled != 1 || result.Conflicts != 0 {
+		t.Errorf("second sync = %+v, want 1 event, 1 updated and 1 cancelled", result)
+	}
+
+	blocks = importedBlocks(t, s, calendar)
+	if len(blocks) != 1 {
+		t.Fatalf("got %d imported blocks, want 1", len(blocks))
+	}
+	if block := blocks["stay@airbnb"]; !block.startDate.Equal(testDate(31)) || !block.endDate.Equal(testDate(34)) ||
+		block.reason != "Airbnb: Reserved" {
+		t.Errorf("moved stay block = %+v", block)
+	}
+
+	conflicts, err = s.GetImportConflicts(propertyID, false)
+	if err != nil {
+		t.Fatalf("GetImportConflicts: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("conflicts = %+v, want the clash resolved", conflicts)
+	}
+
+	// Nothing changed
+	result, err = s.SyncExternalCalendar(calendar.CalendarID)
+	if err != nil {
+		t.Fatalf("third sync: %v", err)
+	}
+	if result.Created != 0 || result.Updated != 0 || result.Cancelled != 0 || result.Conflicts != 0 {
+		t.Errorf("third sync = %+v, want no changes", result)
+	}
+}
+
+// On a property with units only nights with every unit taken clash with an
+// imported event
+func TestSyncExternalCalendarWithUnits(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	unitType, err := s.CreateUnitType(propertyID, &UnitTypeRequest{Name: "Double", MaxGuests: 2})
+	if err != nil {
+		t.Fatalf("CreateUnitType: %v", err)
+	}
+	for _, name := range []string{"101", "102"} {
+		if _, err := s.CreateUnit(propertyID, &UnitRequest{UnitTypeID: unitType.UnitTypeID, UnitName: name}); err != nil {
+			t.Fatalf("CreateUnit: %v", err)
+		}
+	}
+
+	book := func(checkIn, checkOut time.Time) uuid.UUID {
+		t.Helper()
+		booking, err := s.CreateBooking(s.systemUserID, &CreateBookingRequest{
+			PropertyID:         propertyID,
+			GuestName:          "Unit Guest",
+			GuestIDCard:        "ID-5",
+			GuestContactNumber: "+94771234567",
+			CheckInDate:        checkIn.Format("2006-01-02"),
+			CheckOutDate:       checkOut.Format("2006-01-02"),
+			NumberOfGuests:     1,
+		})
+		if err != nil {
+			t.Fatalf("CreateBooking: %v", err)
+		}
+		return booking.BookingID
+	}
+
+	// One unit on nights 30 and 31, both on night 41
+	book(testDate(30), testDate(32))
+	fullBookingID := book(testDate(40), testDate(42))
+	book(testDate(41), testDate(42))
+
+	feed := newICSFeedServer(t)
+	calendar, err := s.CreateExternalCalendar(propertyID, &ExternalCalendarRequest{
+		CalendarName: "Airbnb",
+		FeedURL:      feed.URL,
+	})
+	if err != nil {
+		t.Fatalf("CreateExternalCalendar: %v", err)
+	}
+
+	var lines []string
+	lines = append(lines, icsEvent("partial@airbnb", testDate(31), testDate(33))...)
+	lines = append(lines, icsEvent("full@airbnb", testDate(41), testDate(43))...)
+	feed.setFeed(icsDocument(lines...))
+
+	result, err := s.SyncExternalCalendar(calendar.CalendarID)
+	if err != nil {
+		t.Fatalf("SyncExternalCalendar: %v", err)
+	}
+	if result.Created != 1 || result.Conflicts != 1 {
+		t.Errorf("sync = %+v, want 1 created and 1 conflict", result)
+	}
+
+	if block, ok := importedBlocks(t, s, calendar)["partial@airbnb"]; !ok || !block.startDate.Equal(testDate(31)) {
+		t.Errorf("partially booked nights were not blocked: %+v", block)
+	}
+
+	conflicts, err := s.GetImportConflicts(propertyID, false)
+	if err != nil {
+		t.Fatalf("GetImportConflicts: %v", err)
+	}
+	if len(conflicts) != 1 || conflicts[0].ExternalUID != "full@airbnb" ||
+		conflicts[0].BookingID == nil || *conflicts[0].BookingID != fullBookingID {
+		t.Errorf("conflicts = %+v, want full@airbnb against the first booking of night 41", conflicts)
+	}
+}
+
+// A feed repeating a UID still imports, rather than failing the sync on
+// the unique UID of the imported blocks
+func TestSyncExternalCalendarDuplicateUIDs(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	feed := newICSFeedServer(t)
+
+	calendar, err := s.CreateExternalCalendar(propertyID, &ExternalCalendarRequest{
+		CalendarName: "Booking.com",
+		FeedURL:      feed.URL,
+	})
+	if err != nil {
+		t.Fatalf("CreateExternalCalendar: %v", err)
+	}
+
+	var lines []string
+	lines = append(lines, icsEvent("twice@booking", testDate(30), testDate(32))...)
+	lines = append(lines, icsEvent("other@booking", testDate(40), testDate(42))...)
+	lines = append(lines, icsEvent("twice@booking", testDate(31), testDate(34))...)
+	feed.setFeed(icsDocument(lines...))
+
+	result, err := s.SyncExternalCalendar(calendar.CalendarID)
+	if err != nil {
+		t.Fatalf("SyncExternalCalendar: %v", err)
+	}
+	if result.Events != 2 || result.Created != 2 {
+		t.Errorf("sync = %+v, want 2 events created", result)
+	}
+
+	blocks := importedBlocks(t, s, calendar)
+	if len(blocks) != 2 {
+		t.Fatalf("got %d imported blocks, want 2", len(blocks))
+	}
+	if block := blocks["twice@booking"]; !block.startDate.Equal(testDate(31)) || !block.endDate.Equal(testDate(34)) {
+		t.Errorf("repeated event block = %+v, want the last one's dates", block)
+	}
+}
+
+func TestSyncExternalCalendarRecordsFailures(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	feed := newICSFeedServer(t)
+	feed.setFeed("not a calendar")
+
+	calendar, err := s.CreateExternalCalendar(propertyID, &ExternalCalendarRequest{
+		CalendarName: "Booking.com",
+		FeedURL:      feed.URL,
+	})
+	if err != nil {
+		t.Fatalf("CreateExternalCalendar: %v", err)
+	}
+
+	if _, err := s.SyncExternalCalendar(calendar.CalendarID); err == nil {
+		t.Fatal("expected the sync to fail")
+	}
+
+	calendar, err = s.GetExternalCalendar(calendar.CalendarID)
+	if err != nil {
+		t.Fatalf("GetExternalCalendar: %v", err)
+	}
+	if calendar.LastSyncError == nil {
+		t.Error("expected the failure to be recorded on the calendar")
+	}
+}
+
+func importedBlocks(t *testing.T, s *BookingService, calendar *ExternalCalendar) map[string]importedBlock {
+	t.Helper()
+
+	rows, err := s.db.Query(`
+		SELECT block_id, external_uid, start_date, end_date, reason
+		FROM availability_blocks
+		WHERE external_calendar_id = $1
+	`, calendar.CalendarID)
+	if err != nil {
+		t.Fatalf("Failed to read imported blocks: %v", err)
+	}
+	defer rows.Close()
+
+	blocks := make(map[string]importedBlock)
+	for rows.Next() {
+		var block importedBlock
+		var uid string
+		if err := rows.Scan(&block.blockID, &uid, &block.startDate, &block.endDate, &block.reason); err != nil {
+			t.Fatalf("Failed to read imported blocks: %v", err)
+		}
+		blocks[uid] = block
+	}
+
+	return blocks
+}
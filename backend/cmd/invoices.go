@@ -0,0 +1,665 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// PropertyBranding controls how invoices for a property look. HTMLTemplate,
+// when set, replaces the default html/template used for HTML invoices.
+type PropertyBranding struct {
+	PropertyID   uuid.UUID `json:"property_id"`
+	DisplayName  string    `json:"display_name"`
+	Address      *string   `json:"address,omitempty"`
+	Email        *string   `json:"email,omitempty"`
+	Phone        *string   `json:"phone,omitempty"`
+	TaxNumber    *string   `json:"tax_number,omitempty"`
+	Currency     string    `json:"currency"`
+	AccentColor  string    `json:"accent_color"`
+	FooterText   *string   `json:"footer_text,omitempty"`
+	HTMLTemplate *string   `json:"html_template,omitempty"`
+}
+
+type UpdateBrandingRequest struct {
+	DisplayName  string  `json:"display_name"`
+	Address      *string `json:"address,omitempty"`
+	Email        *string `json:"email,omitempty"`
+	Phone        *string `json:"phone,omitempty"`
+	TaxNumber    *string `json:"tax_number,omitempty"`
+	Currency     string  `json:"currency"`
+	AccentColor  string  `json:"accent_color"`
+	FooterText   *string `json:"footer_text,omitempty"`
+	HTMLTemplate *string `json:"html_template,omitempty"`
+}
+
+type Invoice struct {
+	InvoiceID     uuid.UUID         `json:"invoice_id"`
+	InvoiceNumber string            `json:"invoice_number"`
+	IssuedAt      time.Time         `json:"issued_at"`
+	Branding      PropertyBranding  `json:"branding"`
+	Booking       Booking           `json:"booking"`
+	Group         *GroupReservation `json:"group,omitempty"`          // group invoices, where Booking summarises the group
+	BillingPeriod *BillingPeriod    `json:"billing_period,omitempty"` // monthly invoices of long stays
+	Lines         []InvoiceLine     `json:"lines"`
+	Subtotal      float64           `json:"subtotal"`
+	TaxTotal      float64           `json:"tax_total"`
+	Total         float64           `json:"total"`
+	Payments      []Payment         `json:"payments"`
+	AmountPaid    float64           `json:"amount_paid"`
+	BalanceDue    float64           `json:"balance_due"`
+}
+
+type InvoiceLine struct {
+	Description string  `json:"description"`
+	Quantity    int     `json:"quantity"`
+	UnitPrice   float64 `json:"unit_price"`
+	Amount      float64 `json:"amount"`
+}
+
+const (
+	defaultCurrency    = "USD"
+	defaultAccentColor = "#1f4e79"
+)
+
+// Get the invoice for a booking, issuing the next invoice number the first
+// time it is requested. The content always reflects the current booking,
+// charges and payments.
+func (s *BookingService) GetBookingInvoice(bookingID uuid.UUID) (*Invoice, error) {
+	booking, err := s.GetBookingByID(bookingID)
+	if errors.Is(err, errBookingNotFound) {
+		return nil, serviceError(http.StatusNotFound, "booking not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Numbers are gap-free, so none is used up on a booking that is not
+	// going ahead; one issued before a cancellation is still shown
+	if booking.BookingStatus == "cancelled" || booking.BookingStatus == "pending" {
+		_, _, _, found, err := findInvoice(s.db, "booking_id", bookingID)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, serviceError(http.StatusConflict, "no invoice can be issued for a %s booking", booking.BookingStatus)
+		}
+	}
+
+	invoiceID, number, issuedAt, err := s.issueInvoiceNumber("booking_id", bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	branding, err := s.GetBranding(booking.PropertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	payments, err := s.GetPayments(bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	invoice := &Invoice{
+		InvoiceID:     invoiceID,
+		InvoiceNumber: number,
+		IssuedAt:      issuedAt,
+		Branding:      *branding,
+		Booking:       *booking,
+		Payments:      payments,
+	}
+
+	invoice.Lines = invoiceLines(booking)
+	for _, line := range invoice.Lines {
+		invoice.Total += line.Amount
+	}
+	for _, charge := range booking.Charges {
+		if charge.ChargeType == ChargeTypeTax {
+			invoice.TaxTotal += charge.Amount
+		}
+	}
+	for _, payment := range payments {
+		invoice.AmountPaid += payment.Amount
+	}
+
+	invoice.Total = roundMoney(invoice.Total)
+	invoice.TaxTotal = roundMoney(invoice.TaxTotal)
+	invoice.Subtotal = roundMoney(invoice.Total - invoice.TaxTotal)
+	invoice.AmountPaid = roundMoney(invoice.AmountPaid)
+	invoice.BalanceDue = roundMoney(invoice.Total - invoice.AmountPaid)
+
+	return invoice, nil
+}
+
+// invoiceLines itemises the accommodation, discount and charges of a booking
+func invoiceLines(booking *Booking) []InvoiceLine {
+	var lines []InvoiceLine
+
+	if booking.BookingAmount != nil {
+		nights := booking.TotalNights
+		if nights < 1 {
+			nights = 1
+		}
+
+		lines = append(lines, InvoiceLine{
+			Description: fmt.Sprintf("Accommodation %s to %s",
+				booking.CheckInDate.Format("2 Jan 2006"), booking.CheckOutDate.Format("2 Jan 2006")),
+			Quantity:  nights,
+			UnitPrice: roundMoney(*booking.BookingAmount / float64(nights)),
+			Amount:    *booking.BookingAmount,
+		})
+	}
+
+	return append(lines, adjustmentLines(booking)...)
+}
+
+// adjustmentLines itemises the discount and charges of a booking
+func adjustmentLines(booking *Booking) []InvoiceLine {
+	var lines []InvoiceLine
+
+	if booking.Discount != nil && booking.Discount.DiscountAmount > 0 {
+		lines = append(lines, InvoiceLine{
+			Description: fmt.Sprintf("Discount (%s)", booking.Discount.PromoCode),
+			Quantity:    1,
+			UnitPrice:   -booking.Discount.DiscountAmount,
+			Amount:      -booking.Discount.DiscountAmount,
+		})
+	}
+
+	for _, charge := range booking.Charges {
+		unitPrice := charge.Rate
+		if charge.Calculation == CalculationPercentage {
+			unitPrice = charge.Amount
+		}
+
+		description := charge.RuleName
+		if charge.Calculation == CalculationPercentage {
+			description = fmt.Sprintf("%s (%g%%)", charge.RuleName, charge.Rate)
+		}
+
+		lines = append(lines, InvoiceLine{
+			Description: description,
+			Quantity:    charge.Quantity,
+			UnitPrice:   unitPrice,
+			Amount:      charge.Amount,
+		})
+	}
+
+	return lines
+}
+
+// issueInvoiceNumber returns the invoice of a booking or group, named by
+// ownerColumn ("booking_id" or "group_id"), numbering it from a gap-free
+// counter if it has not been issued yet
+func (s *BookingService) issueInvoiceNumber(ownerColumn string, ownerID uuid.UUID) (uuid.UUID, string, time.Time, error) {
+	// Most requests are for invoices already issued; only lock the counter
+	// when a number may have to be issued
+	invoiceID, number, issuedAt, found, err := findInvoice(s.db, ownerColumn, ownerID)
+	if err != nil || found {
+		return invoiceID, number, issuedAt, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return invoiceID, number, issuedAt, err
+	}
+	defer tx.Rollback()
+
+	// Serialise on the counter, then look again, so two first requests for
+	// the same booking or group cannot both issue a number
+	var next int
+	err = tx.QueryRow(`SELECT last_number + 1 FROM invoice_counters WHERE counter_name = 'invoice' FOR UPDATE`).Scan(&next)
+	if err != nil {
+		return invoiceID, number, issuedAt, err
+	}
+
+	invoiceID, number, issuedAt, found, err = findInvoice(tx, ownerColumn, ownerID)
+	if err != nil || found {
+		return invoiceID, number, issuedAt, err
+	}
+
+	invoiceID = uuid.New()
+	number = fmt.Sprintf("INV-%06d", next)
+
+	err = tx.QueryRow(fmt.Sprintf(`
+		INSERT INTO invoices (invoice_id, invoice_number, %s)
+		VALUES ($1, $2, $3)
+		RETURNING issued_at
+	`, ownerColumn), invoiceID, number, ownerID).Scan(&issuedAt)
+	if err != nil {
+		return invoiceID, number, issuedAt, err
+	}
+
+	if _, err := tx.Exec(`UPDATE invoice_counters SET last_number = $1 WHERE counter_name = 'invoice'`, next); err != nil {
+		return invoiceID, number, issuedAt, err
+	}
+
+	return invoiceID, number, issuedAt, tx.Commit()
+}
+
+// findInvoice looks up the invoice already issued to a booking or group
+func findInvoice(q queryer, ownerColumn string, ownerID uuid.UUID) (uuid.UUID, string, time.Time, bool, error) {
+	var invoiceID uuid.UUID
+	var number string
+	var issuedAt time.Time
+
+	err := q.QueryRow(fmt.Sprintf(`
+		SELECT invoice_id, invoice_number, issued_at FROM invoices WHERE %s = $1
+	`, ownerColumn), ownerID).Scan(&invoiceID, &number, &issuedAt)
+	if err == sql.ErrNoRows {
+		return invoiceID, number, issuedAt, false, nil
+	}
+	if err != nil {
+		return invoiceID, number, issuedAt, false, err
+	}
+
+	return invoiceID, number, issuedAt, true, nil
+}
+
+// Get the branding for a property, falling back to the property's own details
+func (s *BookingService) GetBranding(propertyID uuid.UUID) (*PropertyBranding, error) {
+	query := `
+		SELECT p.property_id, COALESCE(pb.display_name, p.property_name),
+			COALESCE(pb.address, p.property_address), pb.email, pb.phone, pb.tax_number,
+			COALESCE(pb.currency, $2), COALESCE(pb.accent_color, $3), pb.footer_text, pb.html_template
+		FROM properties p
+		LEFT JOIN property_branding pb ON pb.property_id = p.property_id
+		WHERE p.property_id = $1
+	`
+
+	var b PropertyBranding
+	err := s.db.QueryRow(query, propertyID, defaultCurrency, defaultAccentColor).Scan(
+		&b.PropertyID, &b.DisplayName, &b.Address, &b.Email, &b.Phone, &b.TaxNumber,
+		&b.Currency, &b.AccentColor, &b.FooterText, &b.HTMLTemplate,
+	)
+	if err == sql.ErrNoRows {
+		return nil, serviceError(http.StatusNotFound, "property not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func (s *BookingService) UpdateBranding(propertyID uuid.UUID, req *UpdateBrandingRequest) (*PropertyBranding, error) {
+	if req.DisplayName == "" {
+		return nil, serviceError(http.StatusBadRequest, "display_name is required")
+	}
+
+	if req.Currency == "" {
+		req.Currency = defaultCurrency
+	}
+	if len(req.Currency) != 3 {
+		return nil, serviceError(http.StatusBadRequest, "currency must be a 3-letter ISO 4217 code")
+	}
+
+	if req.AccentColor == "" {
+		req.AccentColor = defaultAccentColor
+	}
+	var r, g, b uint8
+	if _, err := fmt.Sscanf(req.AccentColor, "#%02x%02x%02x", &r, &g, &b); err != nil || len(req.AccentColor) != 7 {
+		return nil, serviceError(http.StatusBadRequest, "accent_color must be a hex colour such as #1f4e79")
+	}
+
+	// Reject templates that would fail at render time
+	if req.HTMLTemplate != nil && *req.HTMLTemplate != "" {
+		if _, err := template.New("invoice").Funcs(invoiceTemplateFuncs).Parse(*req.HTMLTemplate); err != nil {
+			return nil, serviceError(http.StatusBadRequest, "invalid html_template: %v", err)
+		}
+	}
+
+	if _, err := s.GetBranding(propertyID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO property_branding (
+			property_id, display_name, address, email, phone, tax_number,
+			currency, accent_color, footer_text, html_template
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (property_id) DO UPDATE SET
+			display_name = EXCLUDED.display_name, address = EXCLUDED.address,
+			email = EXCLUDED.email, phone = EXCLUDED.phone, tax_number = EXCLUDED.tax_number,
+			currency = EXCLUDED.currency, accent_color = EXCLUDED.accent_color,
+			footer_text = EXCLUDED.footer_text, html_template = EXCLUDED.html_template,
+			updated_at = CURRENT_TIMESTAMP
+	`
+
+	_, err := s.db.Exec(query, propertyID, req.DisplayName, req.Address, req.Email, req.Phone,
+		req.TaxNumber, req.Currency, req.AccentColor, req.FooterText, req.HTMLTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetBranding(propertyID)
+}
+
+var invoiceTemplateFuncs = template.FuncMap{
+	"money": func(currency string, amount float64) string {
+		return fmt.Sprintf("%s %.2f", currency, amount)
+	},
+	"date": func(t time.Time) string {
+		return t.Format("2 Jan 2006")
+	},
+}
+
+const defaultInvoiceTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Invoice {{.InvoiceNumber}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; color: #333; margin: 40px; }
+header { border-bottom: 4px solid {{.Branding.AccentColor}}; padding-bottom: 12px; margin-bottom: 24px; }
+h1 { color: {{.Branding.AccentColor}}; margin: 0; }
+table { width: 100%; border-collapse: collapse; margin-top: 16px; }
+th { text-align: left; border-bottom: 2px solid {{.Branding.AccentColor}}; padding: 6px; }
+td { border-bottom: 1px solid #ddd; padding: 6px; }
+.num { text-align: right; }
+.totals td { border: none; }
+footer { margin-top: 40px; font-size: 0.85em; color: #777; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Branding.DisplayName}}</h1>
+{{with .Branding.Address}}<div>{{.}}</div>{{end}}
+{{with .Branding.Email}}<div>{{.}}</div>{{end}}
+{{with .Branding.Phone}}<div>{{.}}</div>{{end}}
+{{with .Branding.TaxNumber}}<div>Tax number: {{.}}</div>{{end}}
+</header>
+
+<h2>Invoice {{.InvoiceNumber}}</h2>
+<p>Issued {{date .IssuedAt}}</p>
+
+<p>
+<strong>{{.Booking.GuestName}}</strong><br>
+{{.Booking.GuestContactNumber}}<br>
+{{with .Booking.GuestEmail}}{{.}}<br>{{end}}
+Stay: {{date .Booking.CheckInDate}} to {{date .Booking.CheckOutDate}} ({{.Booking.TotalNights}} nights, {{.Booking.NumberOfGuests}} guests)
+</p>
+
+<table>
+<tr><th>Description</th><th class="num">Qty</th><th class="num">Unit price</th><th class="num">Amount</th></tr>
+{{range .Lines}}<tr><td>{{.Description}}</td><td class="num">{{.Quantity}}</td><td class="num">{{money $.Branding.Currency .UnitPrice}}</td><td class="num">{{money $.Branding.Currency .Amount}}</td></tr>
+{{end}}
+</table>
+
+<table class="totals">
+<tr><td class="num">Subtotal</td><td class="num">{{money .Branding.Currency .Subtotal}}</td></tr>
+<tr><td class="num">Taxes</td><td class="num">{{money .Branding.Currency .TaxTotal}}</td></tr>
+<tr><td class="num"><strong>Total</strong></td><td class="num"><strong>{{money .Branding.Currency .Total}}</strong></td></tr>
+</table>
+
+{{if .Payments}}
+<h3>Payments received</h3>
+<table>
+<tr><th>Date</th><th>Method</th><th>Reference</th><th class="num">Amount</th></tr>
+{{range .Payments}}<tr><td>{{date .ReceivedAt}}</td><td>{{.PaymentMethod}}</td><td>{{with .Reference}}{{.}}{{end}}</td><td class="num">{{money $.Branding.Currency .Amount}}</td></tr>
+{{end}}
+</table>
+{{end}}
+
+<table class="totals">
+<tr><td class="num">Amount paid</td><td class="num">{{money .Branding.Currency .AmountPaid}}</td></tr>
+<tr><td class="num"><strong>Balance due</strong></td><td class="num"><strong>{{money .Branding.Currency .BalanceDue}}</strong></td></tr>
+</table>
+
+{{with .Branding.FooterText}}<footer>{{.}}</footer>{{end}}
+</body>
+</html>
+`
+
+var defaultInvoiceHTML = template.Must(template.New("invoice").Funcs(invoiceTemplateFuncs).Parse(defaultInvoiceTemplate))
+
+func renderInvoiceHTML(invoice *Invoice) ([]byte, error) {
+	tmpl := defaultInvoiceHTML
+	if invoice.Branding.HTMLTemplate != nil && *invoice.Branding.HTMLTemplate != "" {
+		var err error
+		tmpl, err = template.New("invoice").Funcs(invoiceTemplateFuncs).Parse(*invoice.Branding.HTMLTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, invoice); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func renderInvoicePDF(invoice *Invoice) []byte {
+	const (
+		left   = 50.0
+		right  = pdfPageWidth - 50
+		bottom = 60.0
+	)
+
+	doc := newPDFDocument()
+	r, g, b := parseHexColor(invoice.Branding.AccentColor)
+	currency := invoice.Branding.Currency
+	money := func(amount float64) string {
+		return fmt.Sprintf("%s %.2f", currency, amount)
+	}
+
+	// Branded header band
+	doc.FillRect(0, pdfPageHeight-90, pdfPageWidth, 90, r, g, b)
+	doc.SetTextColor(1, 1, 1)
+	doc.Text(left, pdfPageHeight-50, 20, true, invoice.Branding.DisplayName)
+	doc.TextRight(right, pdfPageHeight-50, 16, true, "INVOICE")
+	doc.SetTextColor(0, 0, 0)
+
+	y := pdfPageHeight - 115
+	for _, line := range []*string{invoice.Branding.Address, invoice.Branding.Email, invoice.Branding.Phone} {
+		if line != nil && *line != "" {
+			doc.Text(left, y, 9, false, *line)
+			y -= 12
+		}
+	}
+	if invoice.Branding.TaxNumber != nil && *invoice.Branding.TaxNumber != "" {
+		doc.Text(left, y, 9, false, "Tax number: "+*invoice.Branding.TaxNumber)
+	}
+
+	y = pdfPageHeight - 115
+	doc.TextRight(right, y, 10, true, "Invoice "+invoice.InvoiceNumber)
+	doc.TextRight(right, y-14, 9, false, "Issued "+invoice.IssuedAt.Format("2 Jan 2006"))
+
+	// Guest and stay details
+	y = pdfPageHeight - 190
+	booking := invoice.Booking
+	doc.Text(left, y, 10, true, "Bill to")
+	doc.Text(left, y-14, 10, false, booking.GuestName)
+	doc.Text(left, y-28, 9, false, booking.GuestContactNumber)
+	if booking.GuestEmail != nil {
+		doc.Text(left, y-40, 9, false, *booking.GuestEmail)
+	}
+
+	doc.Text(320, y, 10, true, "Stay")
+	doc.Text(320, y-14, 9, false, fmt.Sprintf("%s to %s",
+		booking.CheckInDate.Format("2 Jan 2006"), booking.CheckOutDate.Format("2 Jan 2006")))
+	doc.Text(320, y-28, 9, false, fmt.Sprintf("%d nights, %d guests", booking.TotalNights, booking.NumberOfGuests))
+
+	// Line items
+	y -= 80
+	newRowSpace := func(height float64) {
+		if y-height < bottom {
+			doc.AddPage()
+			y = pdfPageHeight - 60
+		}
+	}
+
+	table := func(headers [4]string) {
+		newRowSpace(30)
+		doc.Text(left, y, 9, true, headers[0])
+		doc.TextRight(360, y, 9, true, headers[1])
+		doc.TextRight(450, y, 9, true, headers[2])
+		doc.TextRight(right, y, 9, true, headers[3])
+		doc.Line(left, y-5, right, y-5)
+		y -= 20
+	}
+
+	table([4]string{"Description", "Qty", "Unit price", "Amount"})
+	for _, line := range invoice.Lines {
+		newRowSpace(16)
+		doc.Text(left, y, 9, false, pdfTruncate(line.Description, 260, 9, false))
+		doc.TextRight(360, y, 9, false, fmt.Sprintf("%d", line.Quantity))
+		doc.TextRight(450, y, 9, false, money(line.UnitPrice))
+		doc.TextRight(right, y, 9, false, money(line.Amount))
+		y -= 16
+	}
+
+	totals := func(rows [][2]string, boldLast bool) {
+		for i, row := range rows {
+			newRowSpace(16)
+			bold := boldLast && i == len(rows)-1
+			doc.TextRight(450, y, 9, bold, row[0])
+			doc.TextRight(right, y, 9, bold, row[1])
+			y -= 16
+		}
+	}
+
+	y -= 6
+	doc.Line(320, y+12, right, y+12)
+	totals([][2]string{
+		{"Subtotal", money(invoice.Subtotal)},
+		{"Taxes", money(invoice.TaxTotal)},
+		{"Total", money(invoice.Total)},
+	}, true)
+
+	// Payments received
+	if len(invoice.Payments) > 0 {
+		y -= 20
+		newRowSpace(40)
+		doc.Text(left, y, 10, true, "Payments received")
+		y -= 20
+
+		table([4]string{"Date", "", "Method", "Amount"})
+		for _, payment := range invoice.Payments {
+			newRowSpace(16)
+			doc.Text(left, y, 9, false, payment.ReceivedAt.Format("2 Jan 2006"))
+			doc.TextRight(450, y, 9, false, payment.PaymentMethod)
+			doc.TextRight(right, y, 9, false, money(payment.Amount))
+			y -= 16
+		}
+	}
+
+	y -= 10
+	totals([][2]string{
+		{"Amount paid", money(invoice.AmountPaid)},
+		{"Balance due", money(invoice.BalanceDue)},
+	}, true)
+
+	if invoice.Branding.FooterText != nil && *invoice.Branding.FooterText != "" {
+		newRowSpace(40)
+		doc.Text(left, bottom-20, 8, false, pdfTruncate(*invoice.Branding.FooterText, right-left, 8, false))
+	}
+
+	return doc.Bytes()
+}
+
+// HTTP Handlers
+func (s *BookingService) GetBookingInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingIDStr := vars["bookingId"]
+	format := r.URL.Query().Get("format")
+
+	bookingID, err := uuid.Parse(bookingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid booking ID", http.StatusBadRequest)
+		return
+	}
+
+	if format == "" {
+		format = "pdf"
+	}
+	if format != "pdf" && format != "html" && format != "json" {
+		http.Error(w, "format must be pdf, html or json", http.StatusBadRequest)
+		return
+	}
+
+	invoice, err := s.GetBookingInvoice(bookingID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	writeInvoice(w, invoice, format)
+}
+
+func writeInvoice(w http.ResponseWriter, invoice *Invoice, format string) {
+	switch format {
+	case "html":
+		body, err := renderInvoiceHTML(invoice)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(body)
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(invoice)
+	default:
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", invoice.InvoiceNumber+".pdf"))
+		w.Write(renderInvoicePDF(invoice))
+	}
+}
+
+func (s *BookingService) GetBrandingHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	branding, err := s.GetBranding(propertyID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(branding)
+}
+
+func (s *BookingService) UpdateBrandingHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateBrandingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	branding, err := s.UpdateBranding(propertyID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(branding)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func TestGetBookingInvoiceErrors(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	invoice := func(bookingID uuid.UUID) int {
+		req := httptest.NewRequest("GET", "/api/v1/bookings/"+bookingID.String()+"/invoice?format=json", nil)
+		req = mux.SetURLVars(req, map[string]string{"bookingId": bookingID.String()})
+		rec := httptest.NewRecorder()
+		s.GetBookingInvoiceHandler(rec, req)
+		return rec.Code
+	}
+
+	if code := invoice(uuid.New()); code != http.StatusNotFound {
+		t.Errorf("invoice of an unknown booking: status %d, want %d", code, http.StatusNotFound)
+	}
+
+	for _, status := range []string{"cancelled", "pending"} {
+		bookingID := createTestBooking(t, s, propertyID, testDate(30), testDate(32))
+		if _, err := s.db.Exec(`UPDATE bookings SET booking_status = $1 WHERE booking_id = $2`, status, bookingID); err != nil {
+			t.Fatalf("Failed to mark booking %s: %v", status, err)
+		}
+
+		if code := invoice(bookingID); code != http.StatusConflict {
+			t.Errorf("invoice of a %s booking: status %d, want %d", status, code, http.StatusConflict)
+		}
+
+		var issued bool
+		if err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM invoices WHERE booking_id = $1)`, bookingID).Scan(&issued); err != nil {
+			t.Fatalf("Failed to look up invoice: %v", err)
+		}
+		if issued {
+			t.Errorf("an invoice number was issued for a %s booking", status)
+		}
+	}
+
+	// An invoice issued before the booking was cancelled is still shown
+	bookingID := createTestBooking(t, s, propertyID, testDate(40), testDate(42))
+	if code := invoice(bookingID); code != http.StatusOK {
+		t.Fatalf("invoice of a confirmed booking: status %d, want %d", code, http.StatusOK)
+	}
+	if err := s.CancelBooking(bookingID, s.systemUserID); err != nil {
+		t.Fatalf("CancelBooking: %v", err)
+	}
+	if code := invoice(bookingID); code != http.StatusOK {
+		t.Errorf("invoice issued before cancelling: status %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestUpdateBrandingErrors(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	tests := []struct {
+		name       string
+		propertyID uuid.UUID
+		body       string
+		code       int
+	}{
+		{"no display name", propertyID, `{"currency": "EUR"}`, http.StatusBadRequest},
+		{"long currency", propertyID, `{"display_name": "Villa", "currency": "EURO"}`, http.StatusBadRequest},
+		{"named accent colour", propertyID, `{"display_name": "Villa", "accent_color": "blue"}`, http.StatusBadRequest},
+		{"broken template", propertyID, `{"display_name": "Villa", "html_template": "{{ .Missing"}`, http.StatusBadRequest},
+		{"unknown property", uuid.New(), `{"display_name": "Villa"}`, http.StatusNotFound},
+		{"defaults", propertyID, `{"display_name": "Villa"}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("PUT", "/api/v1/properties/"+tt.propertyID.String()+"/branding", strings.NewReader(tt.body))
+		req = mux.SetURLVars(req, map[string]string{"propertyId": tt.propertyID.String()})
+		rec := httptest.NewRecorder()
+		s.UpdateBrandingHandler(rec, req)
+
+		if rec.Code != tt.code {
+			t.Errorf("%s: status = %d (%s), want %d", tt.name, rec.Code, strings.TrimSpace(rec.Body.String()), tt.code)
+		}
+	}
+}
@@ -0,0 +1,738 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// How many months ahead open-ended long stays are kept booked. Their
+// check-out date is moved forward a billing period at a time, so other
+// bookings, blocks and the calendar see them as occupied until then.
+const longStayHorizonMonths = 2
+
+// LongStay holds the terms of a booking billed by the month. Billing periods
+// run monthly from the check-in date; the last one ends at check-out.
+type LongStay struct {
+	MonthlyRate *float64 `json:"monthly_rate,omitempty"` // unset: periods are priced from the nightly rates
+	OpenEnded   bool     `json:"open_ended"`
+}
+
+// LongStayRequest sets the terms of a long stay. EndDate gives the final
+// check-out date, which also ends an open-ended stay.
+type LongStayRequest struct {
+	MonthlyRate *float64 `json:"monthly_rate,omitempty"`
+	OpenEnded   bool     `json:"open_ended"`
+	EndDate     *string  `json:"end_date,omitempty"` // "2024-06-01" format
+}
+
+// BillingPeriod is a month of a long stay that has been billed. Its amount
+// is fixed when it is billed.
+type BillingPeriod struct {
+	PeriodID    uuid.UUID `json:"period_id"`
+	BookingID   uuid.UUID `json:"booking_id"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Amount      float64   `json:"amount"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// billingPeriod is a month of a stay; end is before fullEnd for the last,
+// partial month
+type billingPeriod struct {
+	start   time.Time
+	end     time.Time
+	fullEnd time.Time
+}
+
+func billingPeriods(checkIn, checkOut time.Time) []billingPeriod {
+	var periods []billingPeriod
+
+	for months := 0; ; months++ {
+		start := addMonths(checkIn, months)
+		if !start.Before(checkOut) {
+			break
+		}
+
+		fullEnd := addMonths(checkIn, months+1)
+		periods = append(periods, billingPeriod{start: start, end: minDate(fullEnd, checkOut), fullEnd: fullEnd})
+	}
+
+	return periods
+}
+
+// addMonths moves a date by whole months, keeping the day of the month where
+// the target month has it, so stays from the 31st bill on the last day of
+// shorter months
+func addMonths(d time.Time, months int) time.Time {
+	first := time.Date(d.Year(), d.Month()+time.Month(months), 1, 0, 0, 0, 0, d.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+
+	day := d.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+
+	return time.Date(first.Year(), first.Month(), day, 0, 0, 0, 0, d.Location())
+}
+
+// longStayCheckOut is the check-out date an open-ended stay is booked to:
+// the first period boundary at least longStayHorizonMonths after today
+func longStayCheckOut(checkIn, today time.Time) time.Time {
+	horizon := addMonths(today, longStayHorizonMonths)
+
+	months := 1
+	for addMonths(checkIn, months).Before(horizon) {
+		months++
+	}
+
+	return addMonths(checkIn, months)
+}
+
+// pricePeriod prices a month of a long stay: the monthly rate, prorated by
+// nights for a partial month, or the nightly rates if there is none
+func (s *BookingService) pricePeriod(propertyID uuid.UUID, terms *LongStay, period billingPeriod) (float64, error) {
+	if terms.MonthlyRate == nil {
+		amount, err := s.priceStay(propertyID, period.start, period.end)
+		if err != nil {
+			return 0, err
+		}
+		return *amount, nil
+	}
+
+	if period.end.Equal(period.fullEnd) {
+		return *terms.MonthlyRate, nil
+	}
+
+	return roundMoney(*terms.MonthlyRate * float64(stayNights(period.start, period.end)) / float64(stayNights(period.start, period.fullEnd))), nil
+}
+
+func validateLongStay(terms *LongStay) error {
+	if terms.MonthlyRate != nil && *terms.MonthlyRate <= 0 {
+		return serviceError(http.StatusBadRequest, "monthly_rate must be greater than zero")
+	}
+	return nil
+}
+
+// insertLongStay sets the terms of a long stay, making the booking one if it
+// is not yet
+func insertLongStay(tx *sql.Tx, bookingID uuid.UUID, terms *LongStay) error {
+	var monthlyRate *float64
+	if terms.MonthlyRate != nil {
+		rate := roundMoney(*terms.MonthlyRate)
+		monthlyRate = &rate
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO long_stays (booking_id, monthly_rate, open_ended)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (booking_id) DO UPDATE SET
+			monthly_rate = EXCLUDED.monthly_rate, open_ended = EXCLUDED.open_ended,
+			updated_at = CURRENT_TIMESTAMP
+	`, bookingID, monthlyRate, terms.OpenEnded)
+	return err
+}
+
+// repriceLongStay sets the amount of a long stay to the sum of its months.
+// Other bookings are left alone.
+func (s *BookingService) repriceLongStay(tx *sql.Tx, bookingID uuid.UUID) error {
+	var terms LongStay
+	var propertyID uuid.UUID
+	var checkIn, checkOut time.Time
+
+	err := tx.QueryRow(`
+		SELECT ls.monthly_rate, ls.open_ended, b.property_id, b.check_in_date, b.check_out_date
+		FROM long_stays ls
+		JOIN bookings b ON b.booking_id = ls.booking_id
+		WHERE ls.booking_id = $1
+	`, bookingID).Scan(&terms.MonthlyRate, &terms.OpenEnded, &propertyID, &checkIn, &checkOut)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var total float64
+	for _, period := range billingPeriods(checkIn, checkOut) {
+		amount, err := s.pricePeriod(propertyID, &terms, period)
+		if err != nil {
+			return err
+		}
+		total += amount
+	}
+
+	_, err = tx.Exec(`
+		UPDATE bookings SET booking_amount = $1, updated_at = CURRENT_TIMESTAMP WHERE booking_id = $2
+	`, roundMoney(total), bookingID)
+	return err
+}
+
+// extendLongStay moves the check-out date of an open-ended stay forward to
+// keep it booked longStayHorizonMonths ahead. It fails with a
+// BookingConflictError when the property is taken after the current
+// check-out date. It reports whether the stay was extended.
+func (s *BookingService) extendLongStay(tx *sql.Tx, bookingID uuid.UUID, today time.Time) (bool, error) {
+	stay, err := lockBookingStay(tx, bookingID)
+	if err != nil {
+		return false, err
+	}
+
+	checkOut := longStayCheckOut(stay.checkIn, today)
+	if !checkOut.After(stay.checkOut) {
+		return false, nil
+	}
+
+	_, err = tx.Exec(`
+		UPDATE bookings SET check_out_date = $1, updated_at = CURRENT_TIMESTAMP WHERE booking_id = $2
+	`, checkOut, bookingID)
+	if err != nil {
+		return false, s.asBookingUpdateConflict(err, bookingID, nil, &checkOut, nil)
+	}
+
+	return true, nil
+}
+
+// checkOpenEnded refuses to leave a stay open-ended when anything is booked
+// or blocked after it. Nothing writes the booking itself, so no trigger locks
+// the property; the lock is taken here so a booking for later dates can't
+// commit alongside.
+func (s *BookingService) checkOpenEnded(tx *sql.Tx, bookingID uuid.UUID) error {
+	stay, err := lockBookingStay(tx, bookingID)
+	if err != nil {
+		return err
+	}
+
+	if err := lockPropertyAvailability(tx, stay.propertyID); err != nil {
+		return err
+	}
+
+	forever := time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+	conflict, err := s.diagnoseConflict(tx, stay.propertyID, stay.unitID, stay.checkOut, forever, &bookingID, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(conflict.Conflicts) == 0 {
+		return nil
+	}
+
+	conflict.Message = "stay cannot be open-ended, the property is booked or blocked after it"
+	return conflict
+}
+
+// Set the terms of a long stay, turning a booking into one. Open-ended stays
+// are extended straight away; EndDate ends the stay on that date.
+func (s *BookingService) UpdateLongStay(bookingID uuid.UUID, req *LongStayRequest) (*Booking, error) {
+	terms := &LongStay{MonthlyRate: req.MonthlyRate, OpenEnded: req.OpenEnded}
+	if err := validateLongStay(terms); err != nil {
+		return nil, err
+	}
+
+	var endDate *time.Time
+	if req.EndDate != nil {
+		end, err := time.Parse("2006-01-02", *req.EndDate)
+		if err != nil {
+			return nil, serviceError(http.StatusBadRequest, "invalid end date format: %v", err)
+		}
+		endDate = &end
+		terms.OpenEnded = false
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockBookingStay(tx, bookingID); err != nil {
+		return nil, err
+	}
+
+	if endDate != nil {
+		// The stay was accepted under the stay rules when it was booked
+		if err := s.checkNewBookingDates(tx, bookingID, nil, endDate, false); err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(`
+			UPDATE bookings SET check_out_date = $1, updated_at = CURRENT_TIMESTAMP WHERE booking_id = $2
+		`, endDate, bookingID)
+		if err != nil {
+			return nil, s.asBookingUpdateConflict(err, bookingID, nil, endDate, nil)
+		}
+	}
+
+	if err = insertLongStay(tx, bookingID, terms); err != nil {
+		return nil, err
+	}
+
+	if terms.OpenEnded {
+		if _, err = s.extendLongStay(tx, bookingID, time.Now().UTC().Truncate(24*time.Hour)); err != nil {
+			return nil, err
+		}
+		if err = s.checkOpenEnded(tx, bookingID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = s.repriceLongStay(tx, bookingID); err != nil {
+		return nil, err
+	}
+
+	if err = s.applyCharges(tx, bookingID); err != nil {
+		return nil, err
+	}
+
+	changes := []string{"long_stay", "booking_amount"}
+	if endDate != nil {
+		changes = append(changes, "check_out_date")
+	}
+
+	err = s.recordBookingEvent(tx, EventBookingUpdated, bookingID, &BookingEventPayload{
+		Changes: changes,
+		Cause:   EventCauseLongStay,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetBookingByID(bookingID)
+}
+
+func (s *BookingService) getLongStay(q queryer, bookingID uuid.UUID) (*LongStay, error) {
+	var terms LongStay
+	err := q.QueryRow(`
+		SELECT monthly_rate, open_ended FROM long_stays WHERE booking_id = $1
+	`, bookingID).Scan(&terms.MonthlyRate, &terms.OpenEnded)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &terms, nil
+}
+
+// Bill every month of a long stay that has started and is not billed yet,
+// and return all its billing periods
+func (s *BookingService) BillLongStay(bookingID uuid.UUID) ([]BillingPeriod, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var terms LongStay
+	var propertyID uuid.UUID
+	var checkIn, checkOut time.Time
+
+	err = tx.QueryRow(`
+		SELECT ls.monthly_rate, ls.open_ended, b.property_id, b.check_in_date, b.check_out_date
+		FROM long_stays ls
+		JOIN bookings b ON b.booking_id = ls.booking_id
+		WHERE ls.booking_id = $1
+		AND b.booking_status IN ('confirmed', 'pending', 'completed')
+		FOR UPDATE OF ls
+	`, bookingID).Scan(&terms.MonthlyRate, &terms.OpenEnded, &propertyID, &checkIn, &checkOut)
+	if err == sql.ErrNoRows {
+		var exists bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM bookings WHERE booking_id = $1)`, bookingID).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, serviceError(http.StatusNotFound, "booking not found")
+		}
+		return nil, serviceError(http.StatusBadRequest, "booking is not a long stay or has been cancelled")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for _, period := range billingPeriods(checkIn, checkOut) {
+		if period.start.After(today) {
+			break
+		}
+
+		amount, err := s.pricePeriod(propertyID, &terms, period)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO billing_periods (period_id, booking_id, period_start, period_end, amount)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (booking_id, period_start) DO NOTHING
+		`, uuid.New(), bookingID, period.start, period.end, amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetBillingPeriods(bookingID)
+}
+
+func (s *BookingService) GetBillingPeriods(bookingID uuid.UUID) ([]BillingPeriod, error) {
+	query := `
+		SELECT period_id, booking_id, period_start, period_end, amount, created_at
+		FROM billing_periods
+		WHERE booking_id = $1
+		ORDER BY period_start ASC
+	`
+
+	return s.queryBillingPeriods(query, bookingID)
+}
+
+func (s *BookingService) queryBillingPeriods(query string, args ...interface{}) ([]BillingPeriod, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	periods := []BillingPeriod{}
+
+	for rows.Next() {
+		var period BillingPeriod
+		err := rows.Scan(&period.PeriodID, &period.BookingID, &period.PeriodStart,
+			&period.PeriodEnd, &period.Amount, &period.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		periods = append(periods, period)
+	}
+
+	return periods, rows.Err()
+}
+
+// Get the invoice for a billed month of a long stay. Discounts, taxes and
+// fees of the booking are shared between months in proportion to their
+// amount, and payments on the booking settle the oldest months first.
+func (s *BookingService) GetBillingPeriodInvoice(periodID uuid.UUID) (*Invoice, error) {
+	var bookingID uuid.UUID
+	err := s.db.QueryRow(`SELECT booking_id FROM billing_periods WHERE period_id = $1`, periodID).Scan(&bookingID)
+	if err == sql.ErrNoRows {
+		return nil, serviceError(http.StatusNotFound, "billing period not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	booking, err := s.GetBookingByID(bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	// As for a whole booking, no number is used up on a stay that is not
+	// going ahead; one issued before a cancellation is still shown
+	if booking.BookingStatus == "cancelled" || booking.BookingStatus == "pending" {
+		_, _, _, found, err := findInvoice(s.db, "period_id", periodID)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, serviceError(http.StatusConflict, "no invoice can be issued for a %s booking", booking.BookingStatus)
+		}
+	}
+
+	periods, err := s.GetBillingPeriods(bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	invoiceID, number, issuedAt, err := s.issueInvoiceNumber("period_id", periodID)
+	if err != nil {
+		return nil, err
+	}
+
+	branding, err := s.GetBranding(booking.PropertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	payments, err := s.GetPayments(bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Share of the booking's discounts and charges carried by a month
+	share := func(amount float64) float64 {
+		if booking.BookingAmount == nil || *booking.BookingAmount == 0 {
+			return 0
+		}
+		return amount / *booking.BookingAmount
+	}
+	periodTotal := func(amount float64) float64 {
+		if booking.TotalAmount == nil {
+			return amount
+		}
+		return roundMoney(*booking.TotalAmount * share(amount))
+	}
+
+	invoice := &Invoice{
+		InvoiceID:     invoiceID,
+		InvoiceNumber: number,
+		IssuedAt:      issuedAt,
+		Branding:      *branding,
+		Booking:       *booking,
+		Payments:      []Payment{},
+	}
+
+	var paid, billedBefore float64
+	for _, payment := range payments {
+		paid += payment.Amount
+	}
+
+	for i := range periods {
+		period := &periods[i]
+		if period.PeriodID != periodID {
+			billedBefore += periodTotal(period.Amount)
+			continue
+		}
+
+		invoice.BillingPeriod = period
+		nights := stayNights(period.PeriodStart, period.PeriodEnd)
+		invoice.Lines = append(invoice.Lines, InvoiceLine{
+			Description: fmt.Sprintf("Rent %s to %s",
+				period.PeriodStart.Format("2 Jan 2006"), period.PeriodEnd.Format("2 Jan 2006")),
+			Quantity:  nights,
+			UnitPrice: roundMoney(period.Amount / float64(nights)),
+			Amount:    period.Amount,
+		})
+
+		for _, line := range adjustmentLines(booking) {
+			amount := roundMoney(line.Amount * share(period.Amount))
+			if amount == 0 {
+				continue
+			}
+			invoice.Lines = append(invoice.Lines, InvoiceLine{
+				Description: line.Description,
+				Quantity:    1,
+				UnitPrice:   amount,
+				Amount:      amount,
+			})
+		}
+
+		for _, charge := range booking.Charges {
+			if charge.ChargeType == ChargeTypeTax {
+				invoice.TaxTotal += charge.Amount * share(period.Amount)
+			}
+		}
+		break
+	}
+
+	for _, line := range invoice.Lines {
+		invoice.Total += line.Amount
+	}
+
+	invoice.Total = roundMoney(invoice.Total)
+	invoice.TaxTotal = roundMoney(invoice.TaxTotal)
+	invoice.Subtotal = roundMoney(invoice.Total - invoice.TaxTotal)
+	invoice.AmountPaid = roundMoney(min(max(paid-billedBefore, 0), invoice.Total))
+	invoice.BalanceDue = roundMoney(invoice.Total - invoice.AmountPaid)
+
+	return invoice, nil
+}
+
+// RunLongStays extends the open-ended long stays and bills the months that
+// have started. Failures are logged and retried on the next run.
+func (s *BookingService) RunLongStays() {
+	rows, err := s.db.Query(`
+		SELECT ls.booking_id, ls.open_ended
+		FROM long_stays ls
+		JOIN bookings b ON b.booking_id = ls.booking_id
+		WHERE b.booking_status IN ('confirmed', 'pending')
+	`)
+	if err != nil {
+		log.Printf("Failed to list long stays: %v", err)
+		return
+	}
+
+	type longStayRow struct {
+		bookingID uuid.UUID
+		openEnded bool
+	}
+
+	var stays []longStayRow
+	for rows.Next() {
+		var stay longStayRow
+		if err := rows.Scan(&stay.bookingID, &stay.openEnded); err != nil {
+			rows.Close()
+			log.Printf("Failed to list long stays: %v", err)
+			return
+		}
+		stays = append(stays, stay)
+	}
+	rows.Close()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for _, stay := range stays {
+		if stay.openEnded {
+			if err := s.renewLongStay(stay.bookingID, today); err != nil {
+				log.Printf("Failed to extend long stay %s: %v", stay.bookingID, err)
+			}
+		}
+
+		if _, err := s.BillLongStay(stay.bookingID); err != nil {
+			log.Printf("Failed to bill long stay %s: %v", stay.bookingID, err)
+		}
+	}
+}
+
+// renewLongStay extends an open-ended stay and reprices it
+func (s *BookingService) renewLongStay(bookingID uuid.UUID, today time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	extended, err := s.extendLongStay(tx, bookingID, today)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repriceLongStay(tx, bookingID); err != nil {
+		return err
+	}
+
+	if err := s.applyCharges(tx, bookingID); err != nil {
+		return err
+	}
+
+	if extended {
+		err = s.recordBookingEvent(tx, EventBookingUpdated, bookingID, &BookingEventPayload{
+			Changes: []string{"check_out_date", "booking_amount"},
+			Cause:   EventCauseLongStay,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// runLongStayWorker runs the long stays now and then every interval
+func (s *BookingService) runLongStayWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.RunLongStays()
+		<-ticker.C
+	}
+}
+
+// HTTP Handlers
+func (s *BookingService) UpdateLongStayHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingIDStr := vars["bookingId"]
+
+	bookingID, err := uuid.Parse(bookingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid booking ID", http.StatusBadRequest)
+		return
+	}
+
+	var req LongStayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	booking, err := s.UpdateLongStay(bookingID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(booking)
+}
+
+func (s *BookingService) GetBillingPeriodsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingIDStr := vars["bookingId"]
+
+	bookingID, err := uuid.Parse(bookingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid booking ID", http.StatusBadRequest)
+		return
+	}
+
+	periods, err := s.GetBillingPeriods(bookingID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(periods)
+}
+
+func (s *BookingService) BillLongStayHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingIDStr := vars["bookingId"]
+
+	bookingID, err := uuid.Parse(bookingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid booking ID", http.StatusBadRequest)
+		return
+	}
+
+	periods, err := s.BillLongStay(bookingID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(periods)
+}
+
+func (s *BookingService) GetBillingPeriodInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	periodIDStr := vars["periodId"]
+	format := r.URL.Query().Get("format")
+
+	periodID, err := uuid.Parse(periodIDStr)
+	if err != nil {
+		http.Error(w, "Invalid billing period ID", http.StatusBadRequest)
+		return
+	}
+
+	if format == "" {
+		format = "pdf"
+	}
+	if format != "pdf" && format != "html" && format != "json" {
+		http.Error(w, "format must be pdf, html or json", http.StatusBadRequest)
+		return
+	}
+
+	invoice, err := s.GetBillingPeriodInvoice(periodID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	writeInvoice(w, invoice, format)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// A stay can't be left open-ended with anything booked after it, whether it
+// is booked that way or turned into one later
+func TestOpenEndedStayRefusedBeforeLaterBooking(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	laterBookingID := createTestBooking(t, s, propertyID, testDate(300), testDate(303))
+
+	rate := 900.0
+
+	_, err := s.CreateBooking(s.systemUserID, &CreateBookingRequest{
+		PropertyID:         propertyID,
+		GuestName:          "Long Stay Guest",
+		GuestIDCard:        "ID-6",
+		GuestContactNumber: "+94771234567",
+		CheckInDate:        testDate(10).Format("2006-01-02"),
+		NumberOfGuests:     1,
+		LongStay:           &LongStay{MonthlyRate: &rate, OpenEnded: true},
+	})
+	var conflict *BookingConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("open-ended CreateBooking: err = %v, want a BookingConflictError", err)
+	}
+	if conflict.ConflictingBookingID == nil || *conflict.ConflictingBookingID != laterBookingID {
+		t.Errorf("conflict = %+v, want it to name %s", conflict, laterBookingID)
+	}
+
+	bookingID := createTestBooking(t, s, propertyID, testDate(10), testDate(40))
+
+	_, err = s.UpdateLongStay(bookingID, &LongStayRequest{MonthlyRate: &rate, OpenEnded: true})
+	if !errors.As(err, &conflict) {
+		t.Fatalf("UpdateLongStay: err = %v, want a BookingConflictError", err)
+	}
+
+	if terms, err := s.getLongStay(s.db, bookingID); err != nil || terms != nil {
+		t.Errorf("long stay terms = %+v (%v), want none", terms, err)
+	}
+}
+
+// Changing the dates of a long stay through a booking update reprices it by
+// the month, as a stay change does
+func TestUpdateBookingRepricesLongStay(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	checkIn := testDate(10)
+	bookingID := createTestBooking(t, s, propertyID, checkIn, addMonths(checkIn, 2))
+
+	rate := 900.0
+	booking, err := s.UpdateLongStay(bookingID, &LongStayRequest{MonthlyRate: &rate})
+	if err != nil {
+		t.Fatalf("UpdateLongStay: %v", err)
+	}
+	if booking.BookingAmount == nil || *booking.BookingAmount != 1800 {
+		t.Fatalf("two months = %v, want 1800", booking.BookingAmount)
+	}
+
+	checkOut := addMonths(checkIn, 1).Format("2006-01-02")
+	booking, err = s.UpdateBooking(bookingID, s.systemUserID, &UpdateBookingRequest{CheckOutDate: &checkOut})
+	if err != nil {
+		t.Fatalf("UpdateBooking: %v", err)
+	}
+	if booking.BookingAmount == nil || *booking.BookingAmount != 900 {
+		t.Errorf("one month = %v, want 900", booking.BookingAmount)
+	}
+}
+
+func TestBillingPeriodInvoiceErrors(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	checkIn := testDate(0)
+	bookingID := createTestBooking(t, s, propertyID, checkIn, addMonths(checkIn, 2))
+	shortStayID := createTestBooking(t, s, propertyID, testDate(90), testDate(92))
+
+	rate := 900.0
+	if _, err := s.UpdateLongStay(bookingID, &LongStayRequest{MonthlyRate: &rate}); err != nil {
+		t.Fatalf("UpdateLongStay: %v", err)
+	}
+
+	periods, err := s.BillLongStay(bookingID)
+	if err != nil {
+		t.Fatalf("BillLongStay: %v", err)
+	}
+	if len(periods) != 1 {
+		t.Fatalf("billed %d months, want the first", len(periods))
+	}
+
+	if err := s.CancelBooking(bookingID, s.systemUserID); err != nil {
+		t.Fatalf("CancelBooking: %v", err)
+	}
+
+	call := func(_ interface{}, err error) error { return err }
+
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"billing an unknown booking", call(s.BillLongStay(uuid.New())), http.StatusNotFound},
+		{"billing a booking that is not a long stay", call(s.BillLongStay(shortStayID)), http.StatusBadRequest},
+		{"invoicing an unknown month", call(s.GetBillingPeriodInvoice(uuid.New())), http.StatusNotFound},
+		{"invoicing a month of a cancelled stay", call(s.GetBillingPeriodInvoice(periods[0].PeriodID)), http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		var stayErr *ServiceError
+		if !errors.As(tt.err, &stayErr) || stayErr.Code != tt.code {
+			t.Errorf("%s: err = %v, want a %d ServiceError", tt.name, tt.err, tt.code)
+		}
+	}
+}
+
+func TestAddMonths(t *testing.T) {
+	tests := []struct {
+		d      string
+		months int
+		want   string
+	}{
+		{"2026-06-10", 0, "2026-06-10"},
+		{"2026-06-10", 1, "2026-07-10"},
+		{"2026-12-15", 1, "2027-01-15"},
+		{"2026-01-29", 1, "2026-02-28"},
+		{"2026-01-31", 1, "2026-02-28"},
+		{"2028-01-31", 1, "2028-02-29"},
+		{"2026-01-31", 2, "2026-03-31"},
+		{"2026-03-31", 1, "2026-04-30"},
+		{"2026-08-31", 1, "2026-09-30"},
+		{"2026-11-30", 3, "2027-02-28"},
+		{"2026-05-31", -3, "2026-02-28"},
+		{"2026-01-15", -1, "2025-12-15"},
+	}
+
+	for _, tt := range tests {
+		if got := addMonths(parseTestDate(t, tt.d), tt.months); got.Format("2006-01-02") != tt.want {
+			t.Errorf("addMonths(%s, %d) = %s, want %s", tt.d, tt.months, got.Format("2006-01-02"), tt.want)
+		}
+	}
+}
+
+func TestBillingPeriods(t *testing.T) {
+	tests := []struct {
+		checkIn, checkOut string
+		periods           [][3]string // start, end, full end
+	}{
+		{"2026-06-10", "2026-06-10", nil},
+		{"2026-06-10", "2026-06-20", [][3]string{{"2026-06-10", "2026-06-20", "2026-07-10"}}},
+		{"2026-06-10", "2026-07-10", [][3]string{{"2026-06-10", "2026-07-10", "2026-07-10"}}},
+		{"2026-06-10", "2026-08-10", [][3]string{
+			{"2026-06-10", "2026-07-10", "2026-07-10"},
+			{"2026-07-10", "2026-08-10", "2026-08-10"},
+		}},
+		// Periods of a stay from the 31st end on the last day of shorter
+		// months and go back to the 31st after them
+		{"2026-01-31", "2026-04-15", [][3]string{
+			{"2026-01-31", "2026-02-28", "2026-02-28"},
+			{"2026-02-28", "2026-03-31", "2026-03-31"},
+			{"2026-03-31", "2026-04-15", "2026-04-30"},
+		}},
+	}
+
+	for _, tt := range tests {
+		got := billingPeriods(parseTestDate(t, tt.checkIn), parseTestDate(t, tt.checkOut))
+		if len(got) != len(tt.periods) {
+			t.Errorf("billingPeriods(%s, %s) = %d periods, want %d", tt.checkIn, tt.checkOut, len(got), len(tt.periods))
+			continue
+		}
+
+		for i, period := range got {
+			dates := [3]string{period.start.Format("2006-01-02"), period.end.Format("2006-01-02"), period.fullEnd.Format("2006-01-02")}
+			if dates != tt.periods[i] {
+				t.Errorf("billingPeriods(%s, %s) period %d = %v, want %v", tt.checkIn, tt.checkOut, i, dates, tt.periods[i])
+			}
+		}
+	}
+}
+
+func TestLongStayCheckOut(t *testing.T) {
+	tests := []struct {
+		checkIn, today string
+		want           string
+	}{
+		{"2026-01-10", "2026-01-10", "2026-03-10"},
+		{"2026-01-10", "2026-01-11", "2026-04-10"},
+		{"2026-01-31", "2026-02-15", "2026-04-30"},
+		{"2025-06-01", "2026-06-01", "2026-08-01"},
+		// A stay arriving beyond the horizon is still booked for a month
+		{"2026-09-01", "2026-06-01", "2026-10-01"},
+	}
+
+	for _, tt := range tests {
+		if got := longStayCheckOut(parseTestDate(t, tt.checkIn), parseTestDate(t, tt.today)); got.Format("2006-01-02") != tt.want {
+			t.Errorf("longStayCheckOut(%s, %s) = %s, want %s", tt.checkIn, tt.today, got.Format("2006-01-02"), tt.want)
+		}
+	}
+}
+
+func TestPricePeriodMonthlyRate(t *testing.T) {
+	s := &BookingService{}
+
+	tests := []struct {
+		rate                float64
+		start, end, fullEnd string
+		want                float64
+	}{
+		{900, "2026-06-10", "2026-07-10", "2026-07-10", 900},
+		{900, "2026-06-10", "2026-06-20", "2026-07-10", 300},
+		{900, "2026-02-10", "2026-02-17", "2026-03-10", 225},
+		{900, "2026-02-28", "2026-03-10", "2026-03-31", 290.32},
+		{1000, "2026-01-31", "2026-02-14", "2026-02-28", 500},
+		{1000, "2026-01-31", "2026-02-28", "2026-02-28", 1000},
+	}
+
+	for _, tt := range tests {
+		rate := tt.rate
+		period := billingPeriod{start: parseTestDate(t, tt.start), end: parseTestDate(t, tt.end), fullEnd: parseTestDate(t, tt.fullEnd)}
+
+		got, err := s.pricePeriod(uuid.New(), &LongStay{MonthlyRate: &rate}, period)
+		if err != nil {
+			t.Errorf("pricePeriod(%.2f, %s-%s of %s): %v", tt.rate, tt.start, tt.end, tt.fullEnd, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("pricePeriod(%.2f, %s-%s of %s) = %.2f, want %.2f", tt.rate, tt.start, tt.end, tt.fullEnd, got, tt.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -37,30 +38,39 @@ type Property struct {
 	PropertyType    string    `json:"property_type"`
 	MaxGuests       int       `json:"max_guests"`
 	Description     string    `json:"description"`
+	TimeZone        string    `json:"time_zone"`
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
 }
 
 type Booking struct {
-	BookingID          uuid.UUID `json:"booking_id"`
-	PropertyID         uuid.UUID `json:"property_id"`
-	CreatedBy          uuid.UUID `json:"created_by"`
-	GuestName          string    `json:"guest_name"`
-	GuestIDCard        string    `json:"guest_id_card"`
-	GuestContactNumber string    `json:"guest_contact_number"`
-	GuestEmail         *string   `json:"guest_email,omitempty"`
-	CheckInDate        time.Time `json:"check_in_date"`
-	CheckOutDate       time.Time `json:"check_out_date"`
-	NumberOfGuests     int       `json:"number_of_guests"`
-	TotalNights        int       `json:"total_nights"`
-	BookingNotes       *string   `json:"booking_notes,omitempty"`
-	SpecialRequests    *string   `json:"special_requests,omitempty"`
-	BookingStatus      string    `json:"booking_status"`
-	BookingAmount      *float64  `json:"booking_amount,omitempty"`
-	PaymentStatus      string    `json:"payment_status"`
-	CreatedAt          time.Time `json:"created_at"`
-	UpdatedAt          time.Time `json:"updated_at"`
-	AdditionalGuests   []Guest   `json:"additional_guests,omitempty"`
+	BookingID          uuid.UUID        `json:"booking_id"`
+	PropertyID         uuid.UUID        `json:"property_id"`
+	UnitID             *uuid.UUID       `json:"unit_id,omitempty"`
+	SplitFromBookingID *uuid.UUID       `json:"split_from_booking_id,omitempty"`
+	GroupID            *uuid.UUID       `json:"group_id,omitempty"`
+	CreatedBy          uuid.UUID        `json:"created_by"`
+	GuestName          string           `json:"guest_name"`
+	GuestIDCard        string           `json:"guest_id_card"`
+	GuestContactNumber string           `json:"guest_contact_number"`
+	GuestEmail         *string          `json:"guest_email,omitempty"`
+	CheckInDate        time.Time        `json:"check_in_date"`
+	CheckOutDate       time.Time        `json:"check_out_date"`
+	NumberOfGuests     int              `json:"number_of_guests"`
+	TotalNights        int              `json:"total_nights"`
+	BookingNotes       *string          `json:"booking_notes,omitempty"`
+	SpecialRequests    *string          `json:"special_requests,omitempty"`
+	BookingStatus      string           `json:"booking_status"`
+	BookingAmount      *float64         `json:"booking_amount,omitempty"`
+	PaymentStatus      string           `json:"payment_status"`
+	CreatedAt          time.Time        `json:"created_at"`
+	UpdatedAt          time.Time        `json:"updated_at"`
+	AdditionalGuests   []Guest          `json:"additional_guests,omitempty"`
+	Deposit            *Deposit         `json:"deposit,omitempty"`
+	Discount           *BookingDiscount `json:"discount,omitempty"`
+	Charges            []BookingCharge  `json:"charges,omitempty"`
+	TotalAmount        *float64         `json:"total_amount,omitempty"`
+	LongStay           *LongStay        `json:"long_stay,omitempty"`
 }
 
 type Guest struct {
@@ -75,9 +85,15 @@ type Guest struct {
 }
 
 type CalendarDay struct {
-	Date      time.Time  `json:"date"`
-	IsBooked  bool       `json:"is_booked"`
-	BookingID *uuid.UUID `json:"booking_id,omitempty"`
+	Date         time.Time              `json:"date"`
+	IsBooked     bool                   `json:"is_booked"`
+	BookingID    *uuid.UUID             `json:"booking_id,omitempty"`
+	Status       string                 `json:"status"`
+	Bookings     []CalendarBooking      `json:"bookings,omitempty"`
+	Block        *CalendarBlock         `json:"block,omitempty"`
+	UnitBlocks   []CalendarBlock        `json:"unit_blocks,omitempty"` // blocks of single units
+	Restrictions *CalendarRestrictions  `json:"restrictions,omitempty"`
+	UnitTypes    []UnitTypeAvailability `json:"unit_types,omitempty"` // properties with units only
 }
 
 type MonthCalendar struct {
@@ -89,6 +105,8 @@ type MonthCalendar struct {
 // Request/Response DTOs
 type CreateBookingRequest struct {
 	PropertyID         uuid.UUID            `json:"property_id"`
+	UnitID             *uuid.UUID           `json:"unit_id,omitempty"`      // properties with units: book this unit
+	UnitTypeID         *uuid.UUID           `json:"unit_type_id,omitempty"` // or any free unit of this type
 	GuestName          string               `json:"guest_name"`
 	GuestIDCard        string               `json:"guest_id_card"`
 	GuestContactNumber string               `json:"guest_contact_number"`
@@ -99,7 +117,19 @@ type CreateBookingRequest struct {
 	BookingNotes       *string              `json:"booking_notes,omitempty"`
 	SpecialRequests    *string              `json:"special_requests,omitempty"`
 	BookingAmount      *float64             `json:"booking_amount,omitempty"`
+	DepositAmount      *float64             `json:"deposit_amount,omitempty"`
+	PromoCode          *string              `json:"promo_code,omitempty"`
 	AdditionalGuests   []CreateGuestRequest `json:"additional_guests,omitempty"`
+	LongStay           *LongStay            `json:"long_stay,omitempty"` // bill by the month; open-ended stays may omit check_out_date
+
+	// Set for reservations a channel has already accepted
+	skipStayRules bool
+
+	// Set for bookings made as part of a group reservation
+	groupID *uuid.UUID
+
+	// Set for holds that wait for the guest to confirm
+	pending bool
 }
 
 type CreateGuestRequest struct {
@@ -123,79 +153,62 @@ type UpdateBookingRequest struct {
 	BookingAmount      *float64 `json:"booking_amount,omitempty"`
 	BookingStatus      *string  `json:"booking_status,omitempty"`
 	PaymentStatus      *string  `json:"payment_status,omitempty"`
+
+	// Set for reservations a channel has already accepted
+	skipStayRules bool
 }
 
 // Service layer
 type BookingService struct {
 	db *sql.DB
+
+	// Booking channels by name, and the user recorded as creating the
+	// bookings imported from them and acting on API requests
+	channels     map[string]Channel
+	systemUserID uuid.UUID
+
+	// Where guests and waitlisted contacts are emailed (the log if unset)
+	// and texted (not at all if unset), and the country code assumed for
+	// phone numbers written without one
+	notifier           Notifier
+	smsProvider        SMSProvider
+	defaultCountryCode string
+
+	// Where the domain events are published, and whether webhooks may point
+	// at loopback, private and link-local addresses
+	eventSinks           []EventSink
+	allowPrivateWebhooks bool
+
+	// Open live calendar streams
+	calendarStreams calendarHub
 }
 
 func NewBookingService(database *sql.DB) *BookingService {
 	return &BookingService{db: database}
 }
 
+// requestUserID is the user a request acts as. Requests are not
+// authenticated yet, so that is the system user; a zero ID means none is
+// configured and whatever the request records is refused by the database.
+func (s *BookingService) requestUserID(r *http.Request) uuid.UUID {
+	return s.systemUserID
+}
+
 // 1. Loading a calendar by month and see which dates have been booked
 func (s *BookingService) GetMonthCalendar(propertyID uuid.UUID, year, month int) (*MonthCalendar, error) {
 	// Get first and last day of the month
 	firstDay := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
 	lastDay := firstDay.AddDate(0, 1, -1)
 
-	// Get all bookings for this property in this month
-	query := `
-		SELECT booking_id, check_in_date, check_out_date 
-		FROM bookings 
-		WHERE property_id = $1 
-		AND booking_status IN ('confirmed', 'pending')
-		AND (check_in_date <= $2 AND check_out_date > $3)
-	`
-
-	rows, err := s.db.Query(query, propertyID, lastDay, firstDay)
+	calendars, err := s.buildCalendars([]uuid.UUID{propertyID}, firstDay, lastDay)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	// Create a map to track booked dates
-	bookedDates := make(map[string]uuid.UUID)
-
-	for rows.Next() {
-		var bookingID uuid.UUID
-		var checkIn, checkOut time.Time
-
-		if err := rows.Scan(&bookingID, &checkIn, &checkOut); err != nil {
-			return nil, err
-		}
-
-		// Mark all dates in the booking range as booked
-		for d := checkIn; d.Before(checkOut); d = d.AddDate(0, 0, 1) {
-			if d.Year() == year && int(d.Month()) == month {
-				bookedDates[d.Format("2006-01-02")] = bookingID
-			}
-		}
-	}
-
-	// Build calendar days
-	var days []CalendarDay
-	for d := firstDay; !d.After(lastDay); d = d.AddDate(0, 0, 1) {
-		dateStr := d.Format("2006-01-02")
-		bookingID, isBooked := bookedDates[dateStr]
-
-		day := CalendarDay{
-			Date:     d,
-			IsBooked: isBooked,
-		}
-
-		if isBooked {
-			day.BookingID = &bookingID
-		}
-
-		days = append(days, day)
-	}
 
 	return &MonthCalendar{
 		Year:  year,
 		Month: month,
-		Days:  days,
+		Days:  calendars[propertyID],
 	}, nil
 }
 
@@ -207,9 +220,21 @@ func (s *BookingService) CreateBooking(userID uuid.UUID, req *CreateBookingReque
 		return nil, fmt.Errorf("invalid check-in date format: %v", err)
 	}
 
-	checkOutDate, err := time.Parse("2006-01-02", req.CheckOutDate)
-	if err != nil {
-		return nil, fmt.Errorf("invalid check-out date format: %v", err)
+	var checkOutDate time.Time
+	if req.CheckOutDate == "" && req.LongStay != nil && req.LongStay.OpenEnded {
+		// Booked ahead, then extended as the stay goes on
+		checkOutDate = longStayCheckOut(checkInDate, maxDate(checkInDate, time.Now().UTC().Truncate(24*time.Hour)))
+	} else {
+		checkOutDate, err = time.Parse("2006-01-02", req.CheckOutDate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid check-out date format: %v", err)
+		}
+	}
+
+	if !req.skipStayRules {
+		if err := s.checkStayRules(s.db, req.PropertyID, checkInDate, checkOutDate, true); err != nil {
+			return nil, err
+		}
 	}
 
 	// Start transaction
@@ -219,22 +244,76 @@ func (s *BookingService) CreateBooking(userID uuid.UUID, req *CreateBookingReque
 	}
 	defer tx.Rollback()
 
+	bookingID, err := s.createBookingTx(tx, userID, req, checkInDate, checkOutDate)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.recordBookingEvent(tx, EventBookingCreated, bookingID, nil); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// Return the created booking
+	return s.GetBookingByID(bookingID)
+}
+
+// createBookingTx inserts a booking with its guests, promo discount, charges
+// and deposit in the caller's transaction
+func (s *BookingService) createBookingTx(tx *sql.Tx, userID uuid.UUID, req *CreateBookingRequest, checkInDate, checkOutDate time.Time) (uuid.UUID, error) {
+	// Phone numbers are stored in E.164 format where possible so guests can
+	// be texted
+	req.GuestContactNumber = s.normalizeContactNumber(req.GuestContactNumber)
+
+	// Allocate a unit on properties that have them
+	unitID, err := s.assignUnit(tx, req, checkInDate, checkOutDate)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	bookingStatus := "confirmed"
+	if req.pending {
+		bookingStatus = "pending"
+	}
+
 	// Insert booking
 	bookingID := uuid.New()
 	query := `
 		INSERT INTO bookings (
 			booking_id, property_id, created_by, guest_name, guest_id_card, 
 			guest_contact_number, guest_email, check_in_date, check_out_date, 
-			number_of_guests, booking_notes, special_requests, booking_amount
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			number_of_guests, booking_notes, special_requests, booking_amount, unit_id,
+			group_id, booking_status
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
 	`
 
 	_, err = tx.Exec(query, bookingID, req.PropertyID, userID, req.GuestName,
 		req.GuestIDCard, req.GuestContactNumber, req.GuestEmail, checkInDate,
 		checkOutDate, req.NumberOfGuests, req.BookingNotes, req.SpecialRequests,
-		req.BookingAmount)
+		req.BookingAmount, unitID, req.groupID, bookingStatus)
 	if err != nil {
-		return nil, err
+		return uuid.Nil, s.asStayConflict(err, req.PropertyID, unitID, checkInDate, checkOutDate, req.NumberOfGuests, nil)
+	}
+
+	// Long stays are priced by the month
+	if req.LongStay != nil {
+		if err = validateLongStay(req.LongStay); err != nil {
+			return uuid.Nil, err
+		}
+		if err = insertLongStay(tx, bookingID, req.LongStay); err != nil {
+			return uuid.Nil, err
+		}
+		if req.LongStay.OpenEnded {
+			if err = s.checkOpenEnded(tx, bookingID); err != nil {
+				return uuid.Nil, err
+			}
+		}
+		if err = s.repriceLongStay(tx, bookingID); err != nil {
+			return uuid.Nil, err
+		}
 	}
 
 	// Insert additional guests
@@ -250,16 +329,31 @@ func (s *BookingService) CreateBooking(userID uuid.UUID, req *CreateBookingReque
 			guest.GuestIDCard, guest.GuestContactNumber, guest.GuestAge,
 			guest.RelationshipToMainGuest)
 		if err != nil {
-			return nil, err
+			return uuid.Nil, err
 		}
 	}
 
-	if err = tx.Commit(); err != nil {
-		return nil, err
+	// Redeem the promo code, if one was given
+	if req.PromoCode != nil && *req.PromoCode != "" {
+		err = s.redeemPromoCode(tx, bookingID, *req.PromoCode, req, checkInDate, checkOutDate)
+		if err != nil {
+			return uuid.Nil, err
+		}
 	}
 
-	// Return the created booking
-	return s.GetBookingByID(bookingID)
+	// Work out taxes and fees
+	if err = s.applyCharges(tx, bookingID); err != nil {
+		return uuid.Nil, err
+	}
+
+	// Hold the security deposit, if one was taken
+	if req.DepositAmount != nil {
+		if err = insertDeposit(tx, bookingID, *req.DepositAmount); err != nil {
+			return uuid.Nil, err
+		}
+	}
+
+	return bookingID, nil
 }
 
 // 3. Get upcoming bookings up to a selected date
@@ -268,7 +362,8 @@ func (s *BookingService) GetUpcomingBookings(propertyID uuid.UUID, upToDate time
 		SELECT booking_id, property_id, created_by, guest_name, guest_id_card,
 			guest_contact_number, guest_email, check_in_date, check_out_date,
 			number_of_guests, total_nights, booking_notes, special_requests,
-			booking_status, booking_amount, payment_status, created_at, updated_at
+			booking_status, booking_amount, payment_status, created_at, updated_at, unit_id,
+			split_from_booking_id, group_id
 		FROM bookings
 		WHERE property_id = $1
 		AND check_in_date >= CURRENT_DATE
@@ -277,7 +372,7 @@ func (s *BookingService) GetUpcomingBookings(propertyID uuid.UUID, upToDate time
 		ORDER BY check_in_date ASC
 	`
 
-	return s.queryBookings(query, propertyID, upToDate)
+	return s.queryBookings(s.db, query, propertyID, upToDate)
 }
 
 // 4. Get previous bookings up to a selected date
@@ -286,7 +381,8 @@ func (s *BookingService) GetPreviousBookings(propertyID uuid.UUID, backToDate ti
 		SELECT booking_id, property_id, created_by, guest_name, guest_id_card,
 			guest_contact_number, guest_email, check_in_date, check_out_date,
 			number_of_guests, total_nights, booking_notes, special_requests,
-			booking_status, booking_amount, payment_status, created_at, updated_at
+			booking_status, booking_amount, payment_status, created_at, updated_at, unit_id,
+			split_from_booking_id, group_id
 		FROM bookings
 		WHERE property_id = $1
 		AND check_out_date < CURRENT_DATE
@@ -294,10 +390,12 @@ func (s *BookingService) GetPreviousBookings(propertyID uuid.UUID, backToDate ti
 		ORDER BY check_out_date DESC
 	`
 
-	return s.queryBookings(query, propertyID, backToDate)
+	return s.queryBookings(s.db, query, propertyID, backToDate)
 }
 
-// 5. Cancel an upcoming booking
+// 5. Cancel an upcoming booking and let the guest know. The freed dates are
+// then offered to the property's waitlist; the cancellation stands even if
+// that fails.
 func (s *BookingService) CancelBooking(bookingID uuid.UUID, userID uuid.UUID) error {
 	query := `
 		UPDATE bookings 
@@ -305,20 +403,35 @@ func (s *BookingService) CancelBooking(bookingID uuid.UUID, userID uuid.UUID) er
 		WHERE booking_id = $1 
 		AND check_in_date >= CURRENT_DATE
 		AND booking_status IN ('confirmed', 'pending')
+		RETURNING property_id, check_in_date, check_out_date
 	`
 
-	result, err := s.db.Exec(query, bookingID)
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	var propertyID uuid.UUID
+	var checkIn, checkOut time.Time
+	err = tx.QueryRow(query, bookingID).Scan(&propertyID, &checkIn, &checkOut)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("booking not found or cannot be cancelled")
+	}
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("booking not found or cannot be cancelled")
+	if err = s.recordBookingEvent(tx, EventBookingCancelled, bookingID, nil); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := s.offerFreedDates(propertyID, checkIn, checkOut); err != nil {
+		log.Printf("Failed to offer the dates of booking %s to the waitlist: %v", bookingID, err)
 	}
 
 	return nil
@@ -331,6 +444,9 @@ func (s *BookingService) UpdateBooking(bookingID uuid.UUID, userID uuid.UUID, re
 	args := []interface{}{}
 	argIndex := 1
 
+	// New dates, to name the clashing booking if they are taken
+	var newCheckIn, newCheckOut *time.Time
+
 	if req.GuestName != nil {
 		setParts = append(setParts, fmt.Sprintf("guest_name = $%d", argIndex))
 		args = append(args, *req.GuestName)
@@ -345,7 +461,7 @@ func (s *BookingService) UpdateBooking(bookingID uuid.UUID, userID uuid.UUID, re
 
 	if req.GuestContactNumber != nil {
 		setParts = append(setParts, fmt.Sprintf("guest_contact_number = $%d", argIndex))
-		args = append(args, *req.GuestContactNumber)
+		args = append(args, s.normalizeContactNumber(*req.GuestContactNumber))
 		argIndex++
 	}
 
@@ -363,6 +479,7 @@ func (s *BookingService) UpdateBooking(bookingID uuid.UUID, userID uuid.UUID, re
 		setParts = append(setParts, fmt.Sprintf("check_in_date = $%d", argIndex))
 		args = append(args, checkInDate)
 		argIndex++
+		newCheckIn = &checkInDate
 	}
 
 	if req.CheckOutDate != nil {
@@ -373,6 +490,7 @@ func (s *BookingService) UpdateBooking(bookingID uuid.UUID, userID uuid.UUID, re
 		setParts = append(setParts, fmt.Sprintf("check_out_date = $%d", argIndex))
 		args = append(args, checkOutDate)
 		argIndex++
+		newCheckOut = &checkOutDate
 	}
 
 	if req.NumberOfGuests != nil {
@@ -415,6 +533,12 @@ func (s *BookingService) UpdateBooking(bookingID uuid.UUID, userID uuid.UUID, re
 		return nil, fmt.Errorf("no fields to update")
 	}
 
+	// The columns set, for the event
+	changes := make([]string, len(setParts))
+	for i, part := range setParts {
+		changes[i] = strings.Fields(part)[0]
+	}
+
 	// Add updated_at
 	setParts = append(setParts, fmt.Sprintf("updated_at = CURRENT_TIMESTAMP"))
 
@@ -424,10 +548,34 @@ func (s *BookingService) UpdateBooking(bookingID uuid.UUID, userID uuid.UUID, re
 
 	query := fmt.Sprintf("UPDATE bookings SET %s %s", strings.Join(setParts, ", "), whereClause)
 
-	result, err := s.db.Exec(query, args...)
+	tx, err := s.db.Begin()
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
+
+	// The status before the update tells an update from a cancellation
+	var previousStatus string
+	err = tx.QueryRow(`
+		SELECT booking_status FROM bookings WHERE booking_id = $1 FOR UPDATE
+	`, bookingID).Scan(&previousStatus)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("booking not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if newCheckIn != nil || newCheckOut != nil {
+		if err := s.checkNewBookingDates(tx, bookingID, newCheckIn, newCheckOut, !req.skipStayRules); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return nil, s.asBookingUpdateConflict(err, bookingID, newCheckIn, newCheckOut, req.NumberOfGuests)
+	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
@@ -438,7 +586,50 @@ func (s *BookingService) UpdateBooking(bookingID uuid.UUID, userID uuid.UUID, re
 		return nil, fmt.Errorf("booking not found")
 	}
 
-	return s.GetBookingByID(bookingID)
+	// Long stays are always priced by the month
+	if newCheckIn != nil || newCheckOut != nil {
+		if err = s.repriceLongStay(tx, bookingID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Taxes and fees depend on the stay length, guests and amount
+	if req.CheckInDate != nil || req.CheckOutDate != nil || req.NumberOfGuests != nil || req.BookingAmount != nil {
+		if err = s.applyCharges(tx, bookingID); err != nil {
+			return nil, err
+		}
+	}
+
+	eventType := EventBookingUpdated
+	if req.BookingStatus != nil && *req.BookingStatus == "cancelled" && previousStatus != "cancelled" {
+		eventType = EventBookingCancelled
+	}
+
+	err = s.recordBookingEvent(tx, eventType, bookingID, &BookingEventPayload{
+		PreviousStatus: previousStatus,
+		Changes:        changes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	booking, err := s.GetBookingByID(bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like CancelBooking, a cancellation frees the dates for the waitlist
+	if eventType == EventBookingCancelled {
+		if err := s.offerFreedDates(booking.PropertyID, booking.CheckInDate, booking.CheckOutDate); err != nil {
+			log.Printf("Failed to offer the dates of booking %s to the waitlist: %v", bookingID, err)
+		}
+	}
+
+	return booking, nil
 }
 
 // 7. Search for bookings by guest name
@@ -447,7 +638,8 @@ func (s *BookingService) SearchBookingsByGuestName(propertyID uuid.UUID, guestNa
 		SELECT booking_id, property_id, created_by, guest_name, guest_id_card,
 			guest_contact_number, guest_email, check_in_date, check_out_date,
 			number_of_guests, total_nights, booking_notes, special_requests,
-			booking_status, booking_amount, payment_status, created_at, updated_at
+			booking_status, booking_amount, payment_status, created_at, updated_at, unit_id,
+			split_from_booking_id, group_id
 		FROM bookings
 		WHERE property_id = $1
 		AND LOWER(guest_name) LIKE LOWER($2)
@@ -455,38 +647,46 @@ func (s *BookingService) SearchBookingsByGuestName(propertyID uuid.UUID, guestNa
 	`
 
 	searchPattern := "%" + guestName + "%"
-	return s.queryBookings(query, propertyID, searchPattern)
+	return s.queryBookings(s.db, query, propertyID, searchPattern)
 }
 
 // Helper methods
 func (s *BookingService) GetBookingByID(bookingID uuid.UUID) (*Booking, error) {
+	return s.getBooking(s.db, bookingID)
+}
+
+// errBookingNotFound is returned for bookings that do not exist
+var errBookingNotFound = errors.New("booking not found")
+
+// getBooking reads a booking in the caller's transaction, or outside one
+func (s *BookingService) getBooking(q queryer, bookingID uuid.UUID) (*Booking, error) {
 	query := `
 		SELECT booking_id, property_id, created_by, guest_name, guest_id_card,
 			guest_contact_number, guest_email, check_in_date, check_out_date,
 			number_of_guests, total_nights, booking_notes, special_requests,
-			booking_status, booking_amount, payment_status, created_at, updated_at
+			booking_status, booking_amount, payment_status, created_at, updated_at, unit_id,
+			split_from_booking_id, group_id
 		FROM bookings
 		WHERE booking_id = $1
 	`
 
-	bookings, err := s.queryBookings(query, bookingID)
+	bookings, err := s.queryBookings(q, query, bookingID)
 	if err != nil {
 		return nil, err
 	}
 
 	if len(bookings) == 0 {
-		return nil, fmt.Errorf("booking not found")
+		return nil, errBookingNotFound
 	}
 
 	return &bookings[0], nil
 }
 
-func (s *BookingService) queryBookings(query string, args ...interface{}) ([]Booking, error) {
-	rows, err := s.db.Query(query, args...)
+func (s *BookingService) queryBookings(q queryer, query string, args ...interface{}) ([]Booking, error) {
+	rows, err := q.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
 	var bookings []Booking
 
@@ -499,26 +699,68 @@ func (s *BookingService) queryBookings(query string, args ...interface{}) ([]Boo
 			&booking.GuestEmail, &booking.CheckInDate, &booking.CheckOutDate,
 			&booking.NumberOfGuests, &booking.TotalNights, &booking.BookingNotes,
 			&booking.SpecialRequests, &booking.BookingStatus, &booking.BookingAmount,
-			&booking.PaymentStatus, &booking.CreatedAt, &booking.UpdatedAt,
+			&booking.PaymentStatus, &booking.CreatedAt, &booking.UpdatedAt, &booking.UnitID,
+			&booking.SplitFromBookingID, &booking.GroupID,
 		)
 		if err != nil {
+			rows.Close()
 			return nil, err
 		}
 
+		bookings = append(bookings, booking)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// A transaction runs one query at a time, so the details are loaded
+	// once the bookings have been read
+	for i := range bookings {
+		booking := &bookings[i]
+
 		// Load additional guests
-		guests, err := s.getAdditionalGuests(booking.BookingID)
+		guests, err := s.getAdditionalGuests(q, booking.BookingID)
 		if err != nil {
 			return nil, err
 		}
 		booking.AdditionalGuests = guests
 
-		bookings = append(bookings, booking)
+		// Load security deposit
+		deposit, err := s.getDeposit(q, booking.BookingID)
+		if err != nil {
+			return nil, err
+		}
+		booking.Deposit = deposit
+
+		// Load promo discount
+		discount, err := s.getBookingDiscount(q, booking.BookingID)
+		if err != nil {
+			return nil, err
+		}
+		booking.Discount = discount
+
+		// Load tax and fee breakdown
+		charges, err := s.getBookingCharges(q, booking.BookingID)
+		if err != nil {
+			return nil, err
+		}
+		booking.Charges = charges
+		booking.TotalAmount = bookingTotal(booking)
+
+		// Load long stay terms
+		longStay, err := s.getLongStay(q, booking.BookingID)
+		if err != nil {
+			return nil, err
+		}
+		booking.LongStay = longStay
 	}
 
 	return bookings, nil
 }
 
-func (s *BookingService) getAdditionalGuests(bookingID uuid.UUID) ([]Guest, error) {
+func (s *BookingService) getAdditionalGuests(q queryer, bookingID uuid.UUID) ([]Guest, error) {
 	query := `
 		SELECT guest_id, booking_id, guest_name, guest_id_card, guest_contact_number,
 			guest_age, relationship_to_main_guest, created_at
@@ -526,7 +768,7 @@ func (s *BookingService) getAdditionalGuests(bookingID uuid.UUID) ([]Guest, erro
 		WHERE booking_id = $1
 	`
 
-	rows, err := s.db.Query(query, bookingID)
+	rows, err := q.Query(query, bookingID)
 	if err != nil {
 		return nil, err
 	}
@@ -593,12 +835,11 @@ func (s *BookingService) CreateBookingHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// In a real application, you would extract userID from JWT token or session
-	userID := uuid.New() // Mock user ID
+	userID := s.requestUserID(r)
 
 	booking, err := s.CreateBooking(userID, &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
@@ -677,8 +918,7 @@ func (s *BookingService) CancelBookingHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// In a real application, you would extract userID from JWT token or session
-	userID := uuid.New() // Mock user ID
+	userID := s.requestUserID(r)
 
 	err = s.CancelBooking(bookingID, userID)
 	if err != nil {
@@ -705,12 +945,11 @@ func (s *BookingService) UpdateBookingHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// In a real application, you would extract userID from JWT token or session
-	userID := uuid.New() // Mock user ID
+	userID := s.requestUserID(r)
 
 	booking, err := s.UpdateBooking(bookingID, userID, &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
@@ -745,16 +984,10 @@ func (s *BookingService) SearchBookingsHandler(w http.ResponseWriter, r *http.Re
 }
 
 // Database initialization
-func initDB() error {
+func initDB(config *Config) error {
 	var err error
 
-	config := LoadConfig()
-
-	// Database connection string - adjust as needed
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		config.DBHost, config.DBPort, config.DBUser, config.DBPassword, config.DBName, config.DBSSLMode)
-
-	db, err = sql.Open("postgres", connStr)
+	db, err = sql.Open("postgres", connectionString(config))
 	if err != nil {
 		return fmt.Errorf("failed to open database: %v", err)
 	}
@@ -768,6 +1001,12 @@ func initDB() error {
 	return nil
 }
 
+// Database connection string - adjust as needed
+func connectionString(config *Config) string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		config.DBHost, config.DBPort, config.DBUser, config.DBPassword, config.DBName, config.DBSSLMode)
+}
+
 // Setup routes
 func setupRoutes(service *BookingService) *mux.Router {
 	r := mux.NewRouter()
@@ -778,6 +1017,13 @@ func setupRoutes(service *BookingService) *mux.Router {
 	// 1. Get calendar for a specific month
 	api.HandleFunc("/properties/{propertyId}/calendar/{year}/{month}", service.GetMonthCalendarHandler).Methods("GET")
 
+	// Calendar for an arbitrary date range, and the multi-property grid
+	api.HandleFunc("/properties/{propertyId}/calendar", service.GetRangeCalendarHandler).Methods("GET")
+	api.HandleFunc("/calendar/grid", service.GetCalendarGridHandler).Methods("GET")
+
+	// Live updates to a property's calendar (Server-Sent Events)
+	api.HandleFunc("/properties/{propertyId}/calendar/stream", service.StreamCalendarHandler).Methods("GET")
+
 	// 2. Create a new booking
 	api.HandleFunc("/bookings", service.CreateBookingHandler).Methods("POST")
 
@@ -793,6 +1039,19 @@ func setupRoutes(service *BookingService) *mux.Router {
 	// 6. Update a booking
 	api.HandleFunc("/bookings/{bookingId}", service.UpdateBookingHandler).Methods("PUT")
 
+	// Move a booking to another property or unit
+	api.HandleFunc("/bookings/{bookingId}/move", service.MoveBookingHandler).Methods("POST")
+
+	// Extend or shorten a stay, or split it in two
+	api.HandleFunc("/bookings/{bookingId}/stay", service.ChangeStayHandler).Methods("PUT")
+	api.HandleFunc("/bookings/{bookingId}/split", service.SplitBookingHandler).Methods("POST")
+
+	// Long stays billed by the month
+	api.HandleFunc("/bookings/{bookingId}/long-stay", service.UpdateLongStayHandler).Methods("PUT")
+	api.HandleFunc("/bookings/{bookingId}/billing-periods", service.GetBillingPeriodsHandler).Methods("GET")
+	api.HandleFunc("/bookings/{bookingId}/billing-periods", service.BillLongStayHandler).Methods("POST")
+	api.HandleFunc("/billing-periods/{periodId}/invoice", service.GetBillingPeriodInvoiceHandler).Methods("GET")
+
 	// 7. Search bookings by guest name
 	api.HandleFunc("/properties/{propertyId}/bookings/search", service.SearchBookingsHandler).Methods("GET")
 
@@ -800,6 +1059,123 @@ func setupRoutes(service *BookingService) *mux.Router {
 	api.HandleFunc("/bookings/{bookingId}", service.GetBookingByIDHandler).Methods("GET")
 	api.HandleFunc("/properties", service.GetPropertiesHandler).Methods("GET")
 
+	// Security deposits and damage claims
+	api.HandleFunc("/bookings/{bookingId}/deposit", service.GetDepositHandler).Methods("GET")
+	api.HandleFunc("/bookings/{bookingId}/deposit", service.SetDepositHandler).Methods("PUT")
+	api.HandleFunc("/bookings/{bookingId}/deposit/claims", service.AddDamageClaimHandler).Methods("POST")
+	api.HandleFunc("/bookings/{bookingId}/deposit/release", service.ReleaseDepositHandler).Methods("POST")
+	api.HandleFunc("/reports/deposits/outstanding", service.GetOutstandingDepositsHandler).Methods("GET")
+
+	// Taxes and fees
+	api.HandleFunc("/properties/{propertyId}/charge-rules", service.GetChargeRulesHandler).Methods("GET")
+	api.HandleFunc("/properties/{propertyId}/charge-rules", service.CreateChargeRuleHandler).Methods("POST")
+	api.HandleFunc("/charge-rules/{ruleId}", service.UpdateChargeRuleHandler).Methods("PUT")
+	api.HandleFunc("/charge-rules/{ruleId}", service.DeleteChargeRuleHandler).Methods("DELETE")
+	api.HandleFunc("/reports/revenue", service.GetRevenueReportHandler).Methods("GET")
+
+	// Promo codes
+	api.HandleFunc("/promo-codes", service.GetPromoCodesHandler).Methods("GET")
+	api.HandleFunc("/promo-codes", service.CreatePromoCodeHandler).Methods("POST")
+	api.HandleFunc("/promo-codes/{promoId}", service.GetPromoCodeHandler).Methods("GET")
+	api.HandleFunc("/promo-codes/{promoId}", service.UpdatePromoCodeHandler).Methods("PUT")
+	api.HandleFunc("/promo-codes/{promoId}", service.DeletePromoCodeHandler).Methods("DELETE")
+
+	// Payments and invoices
+	api.HandleFunc("/bookings/{bookingId}/payments", service.GetPaymentsHandler).Methods("GET")
+	api.HandleFunc("/bookings/{bookingId}/payments", service.RecordPaymentHandler).Methods("POST")
+	api.HandleFunc("/bookings/{bookingId}/invoice", service.GetBookingInvoiceHandler).Methods("GET")
+	api.HandleFunc("/properties/{propertyId}/branding", service.GetBrandingHandler).Methods("GET")
+	api.HandleFunc("/properties/{propertyId}/branding", service.UpdateBrandingHandler).Methods("PUT")
+
+	// Availability blocks
+	api.HandleFunc("/properties/{propertyId}/blocks", service.GetBlocksHandler).Methods("GET")
+	api.HandleFunc("/properties/{propertyId}/blocks", service.CreateBlockHandler).Methods("POST")
+	api.HandleFunc("/blocks/{blockId}", service.GetBlockHandler).Methods("GET")
+	api.HandleFunc("/blocks/{blockId}", service.UpdateBlockHandler).Methods("PUT")
+	api.HandleFunc("/blocks/{blockId}", service.DeleteBlockHandler).Methods("DELETE")
+
+	// iCalendar export; the .ics feed is public and authorised by its token
+	api.HandleFunc("/properties/{propertyId}/calendar.ics", service.GetCalendarICSHandler).Methods("GET")
+	api.HandleFunc("/properties/{propertyId}/calendar-feed", service.GetCalendarFeedHandler).Methods("GET")
+	api.HandleFunc("/properties/{propertyId}/calendar-feed", service.UpdateCalendarFeedHandler).Methods("PUT")
+
+	// iCalendar import from external channels
+	api.HandleFunc("/properties/{propertyId}/external-calendars", service.GetExternalCalendarsHandler).Methods("GET")
+	api.HandleFunc("/properties/{propertyId}/external-calendars", service.CreateExternalCalendarHandler).Methods("POST")
+	api.HandleFunc("/external-calendars/{calendarId}", service.UpdateExternalCalendarHandler).Methods("PUT")
+	api.HandleFunc("/external-calendars/{calendarId}", service.DeleteExternalCalendarHandler).Methods("DELETE")
+	api.HandleFunc("/external-calendars/{calendarId}/sync", service.SyncExternalCalendarHandler).Methods("POST")
+	api.HandleFunc("/properties/{propertyId}/import-conflicts", service.GetImportConflictsHandler).Methods("GET")
+
+	// Nightly rates
+	api.HandleFunc("/properties/{propertyId}/rates", service.GetPropertyRatesHandler).Methods("GET")
+	api.HandleFunc("/properties/{propertyId}/rates", service.CreatePropertyRateHandler).Methods("POST")
+	api.HandleFunc("/rates/{rateId}", service.UpdatePropertyRateHandler).Methods("PUT")
+	api.HandleFunc("/rates/{rateId}", service.DeletePropertyRateHandler).Methods("DELETE")
+
+	// Stay rules
+	api.HandleFunc("/properties/{propertyId}/stay-rules", service.GetStayRulesHandler).Methods("GET")
+	api.HandleFunc("/properties/{propertyId}/stay-rules", service.UpdateStayRulesHandler).Methods("PUT")
+	api.HandleFunc("/properties/{propertyId}/stay-restrictions", service.GetStayRestrictionsHandler).Methods("GET")
+	api.HandleFunc("/properties/{propertyId}/stay-restrictions", service.CreateStayRestrictionHandler).Methods("POST")
+	api.HandleFunc("/stay-restrictions/{restrictionId}", service.UpdateStayRestrictionHandler).Methods("PUT")
+	api.HandleFunc("/stay-restrictions/{restrictionId}", service.DeleteStayRestrictionHandler).Methods("DELETE")
+
+	// Channel manager
+	api.HandleFunc("/channels", service.GetChannelsHandler).Methods("GET")
+	api.HandleFunc("/properties/{propertyId}/channel-listings", service.GetChannelListingsHandler).Methods("GET")
+	api.HandleFunc("/properties/{propertyId}/channel-listings", service.CreateChannelListingHandler).Methods("POST")
+	api.HandleFunc("/channel-listings/{listingId}", service.UpdateChannelListingHandler).Methods("PUT")
+	api.HandleFunc("/channel-listings/{listingId}", service.DeleteChannelListingHandler).Methods("DELETE")
+	api.HandleFunc("/channel-listings/{listingId}/sync", service.SyncChannelListingHandler).Methods("POST")
+	api.HandleFunc("/channel-listings/{listingId}/reservations", service.GetChannelReservationsHandler).Methods("GET")
+
+	// Units within properties
+	api.HandleFunc("/properties/{propertyId}/unit-types", service.GetUnitTypesHandler).Methods("GET")
+	api.HandleFunc("/properties/{propertyId}/unit-types", service.CreateUnitTypeHandler).Methods("POST")
+	api.HandleFunc("/unit-types/{unitTypeId}", service.UpdateUnitTypeHandler).Methods("PUT")
+	api.HandleFunc("/unit-types/{unitTypeId}", service.DeleteUnitTypeHandler).Methods("DELETE")
+	api.HandleFunc("/properties/{propertyId}/units", service.GetUnitsHandler).Methods("GET")
+	api.HandleFunc("/properties/{propertyId}/units", service.CreateUnitHandler).Methods("POST")
+	api.HandleFunc("/units/{unitId}", service.UpdateUnitHandler).Methods("PUT")
+	api.HandleFunc("/units/{unitId}", service.DeleteUnitHandler).Methods("DELETE")
+
+	// Availability search
+	api.HandleFunc("/availability", service.SearchAvailabilityHandler).Methods("GET")
+
+	// Group reservations
+	api.HandleFunc("/groups", service.CreateGroupHandler).Methods("POST")
+	api.HandleFunc("/groups/{groupId}", service.GetGroupHandler).Methods("GET")
+	api.HandleFunc("/groups/{groupId}/cancel", service.CancelGroupHandler).Methods("PUT")
+	api.HandleFunc("/groups/{groupId}/invoice", service.GetGroupInvoiceHandler).Methods("GET")
+
+	// Guest notifications
+	api.HandleFunc("/bookings/{bookingId}/notifications", service.GetBookingNotificationsHandler).Methods("GET")
+	api.HandleFunc("/notification-opt-outs", service.GetNotificationOptOutsHandler).Methods("GET")
+	api.HandleFunc("/notification-opt-outs", service.CreateNotificationOptOutHandler).Methods("POST")
+	api.HandleFunc("/notification-opt-outs/{phoneNumber}", service.DeleteNotificationOptOutHandler).Methods("DELETE")
+
+	// Waitlists for fully booked dates
+	api.HandleFunc("/properties/{propertyId}/waitlist", service.GetWaitlistHandler).Methods("GET")
+	api.HandleFunc("/properties/{propertyId}/waitlist", service.CreateWaitlistEntryHandler).Methods("POST")
+	api.HandleFunc("/waitlist/{entryId}", service.GetWaitlistEntryHandler).Methods("GET")
+	api.HandleFunc("/waitlist/{entryId}", service.CancelWaitlistEntryHandler).Methods("DELETE")
+
+	// Domain event stream
+	api.HandleFunc("/events", service.GetEventsHandler).Methods("GET")
+	api.HandleFunc("/events/{eventId}", service.GetEventHandler).Methods("GET")
+	api.HandleFunc("/bookings/{bookingId}/events", service.GetBookingEventsHandler).Methods("GET")
+
+	// Webhook subscriptions
+	api.HandleFunc("/webhooks", service.GetWebhooksHandler).Methods("GET")
+	api.HandleFunc("/webhooks", service.CreateWebhookHandler).Methods("POST")
+	api.HandleFunc("/webhooks/{webhookId}", service.GetWebhookHandler).Methods("GET")
+	api.HandleFunc("/webhooks/{webhookId}", service.UpdateWebhookHandler).Methods("PUT")
+	api.HandleFunc("/webhooks/{webhookId}", service.DeleteWebhookHandler).Methods("DELETE")
+	api.HandleFunc("/webhooks/{webhookId}/deliveries", service.GetWebhookDeliveriesHandler).Methods("GET")
+	api.HandleFunc("/webhook-deliveries/{deliveryId}", service.GetWebhookDeliveryHandler).Methods("GET")
+	api.HandleFunc("/webhook-deliveries/{deliveryId}/redeliver", service.RedeliverWebhookHandler).Methods("POST")
+
 	return r
 }
 
@@ -827,7 +1203,7 @@ func (s *BookingService) GetBookingByIDHandler(w http.ResponseWriter, r *http.Re
 func (s *BookingService) GetPropertiesHandler(w http.ResponseWriter, r *http.Request) {
 	query := `
 		SELECT property_id, property_name, property_address, property_type, 
-			max_guests, description, created_at, updated_at
+			max_guests, description, time_zone, created_at, updated_at
 		FROM properties
 		ORDER BY property_name
 	`
@@ -846,7 +1222,7 @@ func (s *BookingService) GetPropertiesHandler(w http.ResponseWriter, r *http.Req
 		err := rows.Scan(
 			&property.PropertyID, &property.PropertyName, &property.PropertyAddress,
 			&property.PropertyType, &property.MaxGuests, &property.Description,
-			&property.CreatedAt, &property.UpdatedAt,
+			&property.TimeZone, &property.CreatedAt, &property.UpdatedAt,
 		)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -887,14 +1263,39 @@ func loggingMiddleware(next http.Handler) http.Handler {
 
 // Main function
 func main() {
+	config := LoadConfig()
+
 	// Initialize database
-	if err := initDB(); err != nil {
+	if err := initDB(config); err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 	defer db.Close()
 
 	// Create service
 	service := NewBookingService(db)
+	service.defaultCountryCode = config.DefaultCountryCode
+	service.allowPrivateWebhooks = config.WebhookAllowPrivateHosts
+
+	// Email guests about their bookings
+	if config.SMTPHost != "" {
+		service.SetNotifier(newSMTPNotifier(config.SMTPHost, config.SMTPPort,
+			config.SMTPUsername, config.SMTPPassword, config.SMTPFrom))
+	}
+
+	// Text them too if an SMS provider is set up
+	switch config.SMSProvider {
+	case "twilio":
+		service.SetSMSProvider(newTwilioSMSProvider(config.SMSAPIURL, config.SMSAccountSID,
+			config.SMSAuthToken, config.SMSFrom, config.SMSWhatsApp))
+	case "stub":
+		service.SetSMSProvider(stubSMSProvider{})
+	case "":
+	default:
+		log.Printf("Unknown SMS_PROVIDER %q, text messages are disabled", config.SMSProvider)
+	}
+	if service.smsProvider != nil && config.DefaultCountryCode == "" {
+		log.Printf("DEFAULT_COUNTRY_CODE is not set, guests with national phone numbers will not be texted")
+	}
 
 	// Setup routes
 	router := setupRoutes(service)
@@ -903,6 +1304,64 @@ func main() {
 	router.Use(corsMiddleware)
 	router.Use(loggingMiddleware)
 
+	// Import reservations from external iCal feeds in the background
+	if config.ICalImportInterval > 0 {
+		go service.runICalImporter(config.ICalImportInterval)
+	}
+
+	// Extend open-ended long stays and bill their months
+	if config.LongStayInterval > 0 {
+		go service.runLongStayWorker(config.LongStayInterval)
+	}
+
+	// Release waitlist holds nobody confirmed in time
+	if config.WaitlistInterval > 0 {
+		go service.runWaitlistWorker(config.WaitlistInterval)
+	}
+
+	// Deliver the notification outbox
+	if config.NotificationInterval > 0 {
+		go service.runNotificationWorker(config.NotificationInterval, config.ReminderDaysBefore)
+	}
+
+	// Push booking changes made on any server to the live calendars
+	go service.runCalendarListener(connectionString(config))
+
+	// Publish the domain events to webhooks, guest notifications and the log
+	service.RegisterEventSink(webhookEventSink{service})
+	service.RegisterEventSink(notificationEventSink{service})
+	service.RegisterEventSink(logEventSink{})
+
+	if config.EventDispatchInterval > 0 {
+		go service.runEventDispatcher(config.EventDispatchInterval)
+	}
+
+	// Send booking events to webhook subscribers
+	if config.WebhookInterval > 0 {
+		go service.runWebhookWorker(config.WebhookInterval)
+	}
+
+	// API requests and imported bookings need a user to act as
+	systemUserID, err := uuid.Parse(config.SystemUserID)
+	if err != nil {
+		log.Printf("SYSTEM_USER_ID is not set to a valid user ID, changes made through the API will be refused")
+	} else {
+		service.systemUserID = systemUserID
+	}
+
+	// Two-way sync with booking channels
+	for name, endpoint := range config.ChannelEndpoints {
+		service.RegisterChannel(newHTTPChannel(name, endpoint, config.ChannelAPIKey))
+	}
+
+	if len(config.ChannelEndpoints) > 0 && config.ChannelSyncInterval > 0 {
+		if service.systemUserID == uuid.Nil {
+			log.Printf("SYSTEM_USER_ID is not set to a valid user ID, channel sync is disabled")
+		} else {
+			go service.runChannelSync(config.ChannelSyncInterval)
+		}
+	}
+
 	// Start server
 	port := ":8080"
 	log.Printf("Server starting on port %s", port)
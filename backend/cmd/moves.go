@@ -0,0 +1,348 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// MoveBookingRequest moves a booking to another property or unit. With a
+// MoveDate inside the stay only the nights from that date move: the booking
+// is shortened and a continuation booking is created at the destination.
+type MoveBookingRequest struct {
+	PropertyID       uuid.UUID  `json:"property_id"`
+	UnitID           *uuid.UUID `json:"unit_id,omitempty"`
+	UnitTypeID       *uuid.UUID `json:"unit_type_id,omitempty"`
+	MoveDate         *string    `json:"move_date,omitempty"` // "2024-01-17" format
+	Reason           string     `json:"reason"`
+	RecalculatePrice bool       `json:"recalculate_price"`
+}
+
+type BookingMove struct {
+	Booking      *Booking `json:"booking"`
+	Continuation *Booking `json:"continuation,omitempty"`
+}
+
+// movingBooking is the part of a booking a move needs
+type movingBooking struct {
+	propertyID     uuid.UUID
+	unitID         *uuid.UUID
+	checkIn        time.Time
+	checkOut       time.Time
+	numberOfGuests int
+	amount         sql.NullFloat64
+}
+
+// Move a booking, or the rest of its stay, to another property or unit in one
+// transaction. Without RecalculatePrice the booking amount is kept, split
+// between the two bookings by nights on a partial move; with it, each part
+// is priced from the nightly rates of its property.
+func (s *BookingService) MoveBooking(bookingID uuid.UUID, userID uuid.UUID, req *MoveBookingRequest) (*BookingMove, error) {
+	if req.PropertyID == uuid.Nil {
+		return nil, serviceError(http.StatusBadRequest, "property_id is required")
+	}
+	if req.Reason == "" {
+		return nil, serviceError(http.StatusBadRequest, "reason is required")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var booking movingBooking
+	err = tx.QueryRow(`
+		SELECT property_id, unit_id, check_in_date, check_out_date, number_of_guests, booking_amount
+		FROM bookings
+		WHERE booking_id = $1
+		AND booking_status IN ('confirmed', 'pending')
+		FOR UPDATE
+	`, bookingID).Scan(&booking.propertyID, &booking.unitID, &booking.checkIn, &booking.checkOut,
+		&booking.numberOfGuests, &booking.amount)
+	if err == sql.ErrNoRows {
+		var status string
+		err := tx.QueryRow(`SELECT booking_status FROM bookings WHERE booking_id = $1`, bookingID).Scan(&status)
+		if err == sql.ErrNoRows {
+			return nil, serviceError(http.StatusNotFound, "booking not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, serviceError(http.StatusBadRequest, "a %s booking cannot be moved", status)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	moveDate := booking.checkIn
+	if req.MoveDate != nil {
+		moveDate, err = time.Parse("2006-01-02", *req.MoveDate)
+		if err != nil {
+			return nil, serviceError(http.StatusBadRequest, "invalid move date format: %v", err)
+		}
+		if moveDate.Before(booking.checkIn) || !moveDate.Before(booking.checkOut) {
+			return nil, serviceError(http.StatusBadRequest, "move date must be within the stay")
+		}
+	}
+
+	if req.PropertyID == booking.propertyID && req.UnitID == nil && req.UnitTypeID == nil {
+		var hasUnits bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM units WHERE property_id = $1)`, req.PropertyID).Scan(&hasUnits)
+		if err != nil {
+			return nil, err
+		}
+		if !hasUnits {
+			return nil, serviceError(http.StatusBadRequest, "booking is already at this property")
+		}
+	}
+
+	// Properties without units must have room for the guests; unit
+	// assignment checks the unit type instead
+	var maxGuests int
+	err = tx.QueryRow(`SELECT max_guests FROM properties WHERE property_id = $1`, req.PropertyID).Scan(&maxGuests)
+	if err == sql.ErrNoRows {
+		return nil, serviceError(http.StatusNotFound, "property not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	unitID, err := s.assignUnit(tx, &CreateBookingRequest{
+		PropertyID:     req.PropertyID,
+		UnitID:         req.UnitID,
+		UnitTypeID:     req.UnitTypeID,
+		NumberOfGuests: booking.numberOfGuests,
+	}, moveDate, booking.checkOut)
+	if err != nil {
+		return nil, err
+	}
+	if unitID == nil && maxGuests < booking.numberOfGuests {
+		return nil, serviceError(http.StatusBadRequest, "property has room for %d guests, the booking has %d", maxGuests, booking.numberOfGuests)
+	}
+	if unitID != nil && booking.unitID != nil && *unitID == *booking.unitID {
+		return nil, serviceError(http.StatusBadRequest, "booking is already in this unit")
+	}
+
+	// Work out the new amounts before anything changes
+	nights := stayNights(booking.checkIn, booking.checkOut)
+	movedNights := stayNights(moveDate, booking.checkOut)
+	var keptAmount, movedAmount *float64
+
+	if req.RecalculatePrice {
+		movedAmount, err = s.priceStay(req.PropertyID, moveDate, booking.checkOut)
+		if err != nil {
+			return nil, err
+		}
+		if movedNights < nights {
+			keptAmount, err = s.priceStay(booking.propertyID, booking.checkIn, moveDate)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else if booking.amount.Valid {
+		moved := roundMoney(booking.amount.Float64 * float64(movedNights) / float64(nights))
+		kept := roundMoney(booking.amount.Float64 - moved)
+		movedAmount, keptAmount = &moved, &kept
+	}
+
+	result := &BookingMove{}
+	var continuationID *uuid.UUID
+
+	if movedNights == nights {
+		// The whole stay moves
+		_, err = tx.Exec(`
+			UPDATE bookings
+			SET property_id = $1, unit_id = $2, booking_amount = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE booking_id = $4
+		`, req.PropertyID, unitID, moveAmount(movedAmount, booking.amount), bookingID)
+		if err != nil {
+			return nil, s.asStayConflict(err, req.PropertyID, unitID, moveDate, booking.checkOut, booking.numberOfGuests, &bookingID)
+		}
+	} else {
+		// Shorten the booking, then continue the stay at the destination
+		_, err = tx.Exec(`
+			UPDATE bookings
+			SET check_out_date = $1, booking_amount = $2, updated_at = CURRENT_TIMESTAMP
+			WHERE booking_id = $3
+		`, moveDate, moveAmount(keptAmount, booking.amount), bookingID)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := copyBooking(tx, bookingID, req.PropertyID, unitID, moveDate, booking.checkOut, movedAmount)
+		if err != nil {
+			return nil, s.asStayConflict(err, req.PropertyID, unitID, moveDate, booking.checkOut, booking.numberOfGuests, nil)
+		}
+		continuationID = &id
+
+		if err = s.applyCharges(tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = s.applyCharges(tx, bookingID); err != nil {
+		return nil, err
+	}
+
+	oldValues, _ := json.Marshal(map[string]interface{}{
+		"property_id":    booking.propertyID,
+		"unit_id":        booking.unitID,
+		"check_in_date":  booking.checkIn.Format("2006-01-02"),
+		"check_out_date": booking.checkOut.Format("2006-01-02"),
+	})
+	newValues, _ := json.Marshal(map[string]interface{}{
+		"property_id":             req.PropertyID,
+		"unit_id":                 unitID,
+		"move_date":               moveDate.Format("2006-01-02"),
+		"continuation_booking_id": continuationID,
+	})
+
+	_, err = tx.Exec(`
+		INSERT INTO booking_history (booking_id, modified_by, modification_type, old_values, new_values, modification_notes)
+		VALUES ($1, $2, 'moved', $3, $4, $5)
+	`, bookingID, userID, oldValues, newValues, req.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []string{"property_id", "unit_id", "booking_amount"}
+	if continuationID != nil {
+		changes = []string{"check_out_date", "booking_amount"}
+	}
+
+	err = s.recordBookingEvent(tx, EventBookingUpdated, bookingID, &BookingEventPayload{
+		Changes: changes,
+		Cause:   EventCauseMove,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if continuationID != nil {
+		err = s.recordBookingEvent(tx, EventBookingCreated, *continuationID, &BookingEventPayload{Cause: EventCauseMove})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	result.Booking, err = s.GetBookingByID(bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	if continuationID != nil {
+		result.Continuation, err = s.GetBookingByID(*continuationID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// copyBooking creates a booking for the same guests as an existing one on
+// other dates and/or another property, linked back to it
+func copyBooking(tx *sql.Tx, bookingID uuid.UUID, propertyID uuid.UUID, unitID *uuid.UUID, checkIn, checkOut time.Time, amount *float64) (uuid.UUID, error) {
+	newID := uuid.New()
+
+	_, err := tx.Exec(`
+		INSERT INTO bookings (
+			booking_id, property_id, unit_id, created_by, guest_name, guest_id_card,
+			guest_contact_number, guest_email, check_in_date, check_out_date,
+			number_of_guests, booking_notes, special_requests, booking_status,
+			booking_amount, payment_status, split_from_booking_id, group_id
+		)
+		SELECT $1, $2, $3, created_by, guest_name, guest_id_card,
+			guest_contact_number, guest_email, $4, $5,
+			number_of_guests, booking_notes, special_requests, booking_status,
+			$6, payment_status, booking_id, group_id
+		FROM bookings
+		WHERE booking_id = $7
+	`, newID, propertyID, unitID, checkIn, checkOut, amount, bookingID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO booking_guests (
+			booking_id, guest_name, guest_id_card, guest_contact_number,
+			guest_age, relationship_to_main_guest
+		)
+		SELECT $1, guest_name, guest_id_card, guest_contact_number,
+			guest_age, relationship_to_main_guest
+		FROM booking_guests
+		WHERE booking_id = $2
+	`, newID, bookingID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return newID, nil
+}
+
+// priceStay sums the nightly rates of a stay, failing if a night has no rate
+func (s *BookingService) priceStay(propertyID uuid.UUID, checkIn, checkOut time.Time) (*float64, error) {
+	total, ok, err := s.quoteStay(propertyID, checkIn, checkOut)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("no nightly rate set for every night from %s to %s", checkIn.Format("2006-01-02"), checkOut.Format("2006-01-02"))
+	}
+	return &total, nil
+}
+
+// moveAmount keeps a booking without an amount without one, unless the price
+// was recalculated
+func moveAmount(amount *float64, current sql.NullFloat64) *float64 {
+	if amount != nil {
+		return amount
+	}
+	if current.Valid {
+		return &current.Float64
+	}
+	return nil
+}
+
+func stayNights(checkIn, checkOut time.Time) int {
+	return int(checkOut.Sub(checkIn).Hours() / 24)
+}
+
+// HTTP Handlers
+func (s *BookingService) MoveBookingHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingIDStr := vars["bookingId"]
+
+	bookingID, err := uuid.Parse(bookingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid booking ID", http.StatusBadRequest)
+		return
+	}
+
+	var req MoveBookingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := s.requestUserID(r)
+
+	move, err := s.MoveBooking(bookingID, userID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(move)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Moving the rest of a stay shortens the booking and continues it at the
+// destination with the same guests, splitting the amount by nights
+func TestMoveBookingPartialStay(t *testing.T) {
+	s := newTestService(t)
+	fromID := createTestProperty(t, s)
+	toID := createTestProperty(t, s)
+
+	amount := 400.0
+	age := 7
+	booking, err := s.CreateBooking(s.systemUserID, &CreateBookingRequest{
+		PropertyID:         fromID,
+		GuestName:          "Moving Guest",
+		GuestIDCard:        "ID-8",
+		GuestContactNumber: "+94771234567",
+		CheckInDate:        testDate(20).Format("2006-01-02"),
+		CheckOutDate:       testDate(24).Format("2006-01-02"),
+		NumberOfGuests:     2,
+		BookingAmount:      &amount,
+		AdditionalGuests:   []CreateGuestRequest{{GuestName: "Child Guest", GuestAge: &age}},
+	})
+	if err != nil {
+		t.Fatalf("CreateBooking: %v", err)
+	}
+
+	moveDate := testDate(22).Format("2006-01-02")
+	move, err := s.MoveBooking(booking.BookingID, s.systemUserID, &MoveBookingRequest{
+		PropertyID: toID,
+		MoveDate:   &moveDate,
+		Reason:     "Water leak",
+	})
+	if err != nil {
+		t.Fatalf("MoveBooking: %v", err)
+	}
+
+	kept := move.Booking
+	if kept.PropertyID != fromID || !kept.CheckInDate.Equal(testDate(20)) || !kept.CheckOutDate.Equal(testDate(22)) {
+		t.Errorf("kept booking = %s from %s to %s, want %s from %s to %s",
+			kept.PropertyID, kept.CheckInDate, kept.CheckOutDate, fromID, testDate(20), testDate(22))
+	}
+	if kept.BookingAmount == nil || *kept.BookingAmount != 200 {
+		t.Errorf("kept booking amount = %v, want 200", kept.BookingAmount)
+	}
+
+	moved := move.Continuation
+	if moved == nil {
+		t.Fatal("no continuation booking was created")
+	}
+	if moved.PropertyID != toID || !moved.CheckInDate.Equal(testDate(22)) || !moved.CheckOutDate.Equal(testDate(24)) {
+		t.Errorf("continuation = %s from %s to %s, want %s from %s to %s",
+			moved.PropertyID, moved.CheckInDate, moved.CheckOutDate, toID, testDate(22), testDate(24))
+	}
+	if moved.BookingAmount == nil || *moved.BookingAmount != 200 {
+		t.Errorf("continuation amount = %v, want 200", moved.BookingAmount)
+	}
+	if moved.SplitFromBookingID == nil || *moved.SplitFromBookingID != booking.BookingID {
+		t.Errorf("continuation split from %v, want %s", moved.SplitFromBookingID, booking.BookingID)
+	}
+	if moved.GuestName != booking.GuestName || len(moved.AdditionalGuests) != 1 ||
+		moved.AdditionalGuests[0].GuestName != "Child Guest" {
+		t.Errorf("continuation guests = %s with %+v, want the booking's", moved.GuestName, moved.AdditionalGuests)
+	}
+
+	var notes string
+	var newValues []byte
+	err = s.db.QueryRow(`
+		SELECT modification_notes, new_values FROM booking_history
+		WHERE booking_id = $1 AND modification_type = 'moved'
+	`, booking.BookingID).Scan(&notes, &newValues)
+	if err != nil {
+		t.Fatalf("Failed to read the move from the booking history: %v", err)
+	}
+
+	var values struct {
+		PropertyID            uuid.UUID  `json:"property_id"`
+		MoveDate              string     `json:"move_date"`
+		ContinuationBookingID *uuid.UUID `json:"continuation_booking_id"`
+	}
+	if err := json.Unmarshal(newValues, &values); err != nil {
+		t.Fatalf("Failed to decode the move: %v", err)
+	}
+	if notes != "Water leak" || values.PropertyID != toID || values.MoveDate != moveDate ||
+		values.ContinuationBookingID == nil || *values.ContinuationBookingID != moved.BookingID {
+		t.Errorf("history = %q %+v, want the move to %s from %s", notes, values, toID, moveDate)
+	}
+}
+
+func TestMoveBookingErrors(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	otherID := createTestProperty(t, s)
+	bookingID := createTestBooking(t, s, propertyID, testDate(30), testDate(33))
+
+	outside := testDate(40).Format("2006-01-02")
+	badDate := "next week"
+
+	tests := []struct {
+		name      string
+		bookingID uuid.UUID
+		req       MoveBookingRequest
+		code      int
+	}{
+		{"missing property", bookingID, MoveBookingRequest{Reason: "Leak"}, http.StatusBadRequest},
+		{"missing reason", bookingID, MoveBookingRequest{PropertyID: otherID}, http.StatusBadRequest},
+		{"unknown booking", uuid.New(), MoveBookingRequest{PropertyID: otherID, Reason: "Leak"}, http.StatusNotFound},
+		{"bad move date", bookingID, MoveBookingRequest{PropertyID: otherID, Reason: "Leak", MoveDate: &badDate}, http.StatusBadRequest},
+		{"move date after the stay", bookingID, MoveBookingRequest{PropertyID: otherID, Reason: "Leak", MoveDate: &outside}, http.StatusBadRequest},
+		{"same property", bookingID, MoveBookingRequest{PropertyID: propertyID, Reason: "Leak"}, http.StatusBadRequest},
+		{"unknown property", bookingID, MoveBookingRequest{PropertyID: uuid.New(), Reason: "Leak"}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		_, err := s.MoveBooking(tt.bookingID, s.systemUserID, &tt.req)
+
+		var moveErr *ServiceError
+		if !errors.As(err, &moveErr) || moveErr.Code != tt.code {
+			t.Errorf("%s: err = %v, want a %d ServiceError", tt.name, err, tt.code)
+			continue
+		}
+
+		rec := httptest.NewRecorder()
+		writeServiceError(rec, err)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+
+	if err := s.CancelBooking(bookingID, s.systemUserID); err != nil {
+		t.Fatalf("CancelBooking: %v", err)
+	}
+
+	_, err := s.MoveBooking(bookingID, s.systemUserID, &MoveBookingRequest{PropertyID: otherID, Reason: "Leak"})
+	var moveErr *ServiceError
+	if !errors.As(err, &moveErr) || moveErr.Code != http.StatusBadRequest {
+		t.Errorf("moving a cancelled booking: err = %v, want a 400 ServiceError", err)
+	}
+}
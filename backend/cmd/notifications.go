@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Delivery attempts before a notification is given up on
+const (
+	maxNotificationAttempts = 5
+	notificationBatchSize   = 50
+
+	// How long a claimed notification is left to the server sending it
+	notificationClaimTimeout = 5 * time.Minute
+)
+
+// Notification statuses
+const (
+	NotificationStatusPending = "pending"
+	NotificationStatusSent    = "sent"
+	NotificationStatusFailed  = "failed"
+	NotificationStatusSkipped = "skipped" // the phone number opted out after it was queued
+)
+
+// OutboxNotification is a notification as queued in the outbox, with how
+// its delivery went
+type OutboxNotification struct {
+	NotificationID uuid.UUID  `json:"notification_id"`
+	Event          string     `json:"event"`
+	Channel        string     `json:"channel"`
+	BookingID      *uuid.UUID `json:"booking_id,omitempty"`
+	RecipientName  string     `json:"recipient_name"`
+	RecipientEmail *string    `json:"recipient_email,omitempty"`
+	RecipientPhone *string    `json:"recipient_phone,omitempty"`
+	Subject        *string    `json:"subject,omitempty"`
+	Body           string     `json:"body"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastError      *string    `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	SentAt         *time.Time `json:"sent_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// notificationData is what the notification templates are rendered with
+type notificationData struct {
+	GuestName       string
+	PropertyName    string
+	PropertyAddress string
+	BookingID       uuid.UUID
+	CheckIn         string
+	CheckOut        string
+	Nights          int
+	Guests          int
+	Amount          string
+	HoldExpiresAt   string
+}
+
+// Templates for each event: "<event>.subject" and "<event>.body" for email
+// and "<event>.sms" for text messages, which are kept short
+var notificationTemplates = template.Must(template.New("notifications").Parse(`
+{{- define "stay" -}}
+Check-in:  {{.CheckIn}}
+Check-out: {{.CheckOut}} ({{.Nights}} {{if eq .Nights 1}}night{{else}}nights{{end}})
+Guests:    {{.Guests}}
+{{- if .Amount}}
+Amount:    {{.Amount}}
+{{- end}}
+Booking reference: {{.BookingID}}
+{{- end -}}
+
+{{- define "booking.confirmed.subject"}}Your booking at {{.PropertyName}} is confirmed{{end -}}
+{{- define "booking.confirmed.body" -}}
+Hi {{.GuestName}},
+
+Your booking at {{.PropertyName}} is confirmed.
+
+{{template "stay" .}}
+
+We look forward to welcoming you.
+{{end -}}
+
+{{- define "booking.modified.subject"}}Your booking at {{.PropertyName}} has changed{{end -}}
+{{- define "booking.modified.body" -}}
+Hi {{.GuestName}},
+
+Your booking at {{.PropertyName}} has been changed. These are the new details:
+
+{{template "stay" .}}
+
+If you did not ask for this change, please get in touch with us.
+{{end -}}
+
+{{- define "booking.cancelled.subject"}}Your booking at {{.PropertyName}} has been cancelled{{end -}}
+{{- define "booking.cancelled.body" -}}
+Hi {{.GuestName}},
+
+Your booking at {{.PropertyName}} from {{.CheckIn}} to {{.CheckOut}} has been cancelled.
+
+Booking reference: {{.BookingID}}
+
+If you did not expect this, please get in touch with us.
+{{end -}}
+
+{{- define "booking.reminder.subject"}}See you soon at {{.PropertyName}}{{end -}}
+{{- define "booking.reminder.body" -}}
+Hi {{.GuestName}},
+
+Your stay at {{.PropertyName}} is coming up.
+
+{{template "stay" .}}
+{{- if .PropertyAddress}}
+
+Address: {{.PropertyAddress}}
+{{- end}}
+
+Have a safe journey.
+{{end -}}
+
+{{- define "waitlist.available.subject"}}Your dates at {{.PropertyName}} are available{{end -}}
+{{- define "waitlist.available.body" -}}
+Hi {{.GuestName}},
+
+{{.PropertyName}} is now available from {{.CheckIn}} to {{.CheckOut}} for {{.Guests}} {{if eq .Guests 1}}guest{{else}}guests{{end}}.
+
+{{if .HoldExpiresAt -}}
+We are holding the dates for you until {{.HoldExpiresAt}}. Get in touch with us to confirm your booking.
+{{- else -}}
+The dates go to whoever books first, so get in touch with us soon.
+{{- end}}
+{{end -}}
+
+{{- define "booking.confirmed.sms" -}}
+{{.PropertyName}}: your booking for {{.CheckIn}} to {{.CheckOut}}, {{.Guests}} {{if eq .Guests 1}}guest{{else}}guests{{end}}, is confirmed. See you soon!
+{{- end -}}
+
+{{- define "booking.modified.sms" -}}
+{{.PropertyName}}: your booking has changed. It is now {{.CheckIn}} to {{.CheckOut}}, {{.Guests}} {{if eq .Guests 1}}guest{{else}}guests{{end}}.
+{{- end -}}
+
+{{- define "booking.cancelled.sms" -}}
+{{.PropertyName}}: your booking for {{.CheckIn}} to {{.CheckOut}} has been cancelled.
+{{- end -}}
+
+{{- define "booking.reminder.sms" -}}
+{{.PropertyName}}: see you on {{.CheckIn}}!{{if .PropertyAddress}} Address: {{.PropertyAddress}}{{end}}
+{{- end -}}
+
+{{- define "waitlist.available.sms" -}}
+{{.PropertyName}} is now free {{.CheckIn}} to {{.CheckOut}}.
+{{- if .HoldExpiresAt}} We are holding it for you until {{.HoldExpiresAt}}, contact us to confirm.
+{{- else}} Contact us soon to book.
+{{- end}}
+{{- end -}}
+`))
+
+// renderNotification renders the subject and body of an email
+func renderNotification(event string, data *notificationData) (string, string, error) {
+	var subject, body bytes.Buffer
+
+	if err := notificationTemplates.ExecuteTemplate(&subject, event+".subject", data); err != nil {
+		return "", "", err
+	}
+	if err := notificationTemplates.ExecuteTemplate(&body, event+".body", data); err != nil {
+		return "", "", err
+	}
+
+	return subject.String(), body.String(), nil
+}
+
+// renderTextMessage renders the text message for an event
+func renderTextMessage(event string, data *notificationData) (string, error) {
+	var body bytes.Buffer
+
+	if err := notificationTemplates.ExecuteTemplate(&body, event+".sms", data); err != nil {
+		return "", err
+	}
+
+	return body.String(), nil
+}
+
+// queueNotification renders an event and adds it to the outbox in the
+// caller's transaction, so it is sent if and only if the change commits:
+// an email if the contact has an address, and a text message if they have
+// a valid phone number that has not opted out and an SMS provider is set.
+func (s *BookingService) queueNotification(q queryer, event string, bookingID *uuid.UUID, email, phone *string, data *notificationData) error {
+	// Reminders are sent once per booking and channel
+	insert := `
+		INSERT INTO notification_outbox (
+			event, channel, booking_id, recipient_name, recipient_email, recipient_phone, subject, body
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT DO NOTHING
+	`
+
+	if email != nil && *email != "" {
+		subject, body, err := renderNotification(event, data)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.Exec(insert, event, NotificationChannelEmail, bookingID, data.GuestName, *email, nil, subject, body)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.smsProvider == nil || phone == nil || *phone == "" {
+		return nil
+	}
+
+	// Numbers that could not be put in E.164 format are stored as given,
+	// but can't be texted
+	number, err := normalizePhoneNumber(*phone, s.defaultCountryCode)
+	if err != nil {
+		return nil
+	}
+
+	var optedOut bool
+	err = q.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM notification_opt_outs WHERE phone_number = $1)
+	`, number).Scan(&optedOut)
+	if err != nil || optedOut {
+		return err
+	}
+
+	body, err := renderTextMessage(event, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(insert, event, NotificationChannelSMS, bookingID, data.GuestName, nil, number, nil, body)
+	return err
+}
+
+// queueBookingNotification queues the notifications to the guest of a
+// booking about it
+func (s *BookingService) queueBookingNotification(q queryer, event string, bookingID uuid.UUID) error {
+	var email, phone *string
+	var checkIn, checkOut time.Time
+	var amount *float64
+	data := &notificationData{BookingID: bookingID}
+
+	err := q.QueryRow(`
+		SELECT b.guest_name, b.guest_email, b.guest_contact_number, p.property_name,
+			COALESCE(p.property_address, ''), b.check_in_date, b.check_out_date,
+			b.number_of_guests, b.booking_amount
+		FROM bookings b
+		JOIN properties p ON p.property_id = b.property_id
+		WHERE b.booking_id = $1
+	`, bookingID).Scan(&data.GuestName, &email, &phone, &data.PropertyName, &data.PropertyAddress,
+		&checkIn, &checkOut, &data.Guests, &amount)
+	if err != nil {
+		return err
+	}
+
+	data.CheckIn = checkIn.Format("2006-01-02")
+	data.CheckOut = checkOut.Format("2006-01-02")
+	data.Nights = stayNights(checkIn, checkOut)
+	if amount != nil {
+		data.Amount = fmt.Sprintf("%.2f", *amount)
+	}
+
+	return s.queueNotification(q, event, &bookingID, email, phone, data)
+}
+
+// notificationEventSink queues the guest notifications for booking events
+type notificationEventSink struct {
+	s *BookingService
+}
+
+func (notificationEventSink) Name() string {
+	return "notifications"
+}
+
+func (sink notificationEventSink) HandleEvent(q queryer, event *DomainEvent, payload *BookingEventPayload) error {
+	notification := bookingNotificationEvent(event.EventType, payload)
+	if notification == "" {
+		return nil
+	}
+
+	return sink.s.queueBookingNotification(q, notification, event.BookingID)
+}
+
+// bookingNotificationEvent picks the notification a guest gets for a
+// booking event, if any. New bookings are confirmed unless they are pending
+// or part of a group, split or move. Updates confirm a booking whose status
+// changes to confirmed and otherwise tell the guest when the stay itself
+// changes; splitting a stay or changing long stay terms is not announced,
+// and neither is the release of an expired waitlist hold.
+func bookingNotificationEvent(eventType string, payload *BookingEventPayload) string {
+	booking := payload.Booking
+
+	switch eventType {
+	case EventBookingCreated:
+		if booking.BookingStatus == "confirmed" && booking.GroupID == nil && payload.Cause == "" {
+			return NotificationBookingConfirmed
+		}
+
+	case EventBookingCancelled:
+		if payload.Cause != EventCauseHoldExpired {
+			return NotificationBookingCancelled
+		}
+
+	case EventBookingUpdated:
+		if payload.PreviousStatus != "" && payload.PreviousStatus != booking.BookingStatus && booking.BookingStatus == "confirmed" {
+			return NotificationBookingConfirmed
+		}
+
+		switch payload.Cause {
+		case EventCauseMove:
+			return NotificationBookingModified
+		case EventCauseSplit, EventCauseLongStay:
+			return ""
+		}
+
+		for _, change := range payload.Changes {
+			switch change {
+			case "check_in_date", "check_out_date", "number_of_guests":
+				return NotificationBookingModified
+			}
+		}
+	}
+
+	return ""
+}
+
+// QueueReminders queues a pre-arrival reminder for confirmed bookings
+// starting within the given number of days that have not had one
+func (s *BookingService) QueueReminders(daysBefore int) error {
+	rows, err := s.db.Query(`
+		SELECT b.booking_id
+		FROM bookings b
+		WHERE b.booking_status = 'confirmed'
+		AND (b.guest_email != '' OR ($2 AND b.guest_contact_number != ''))
+		AND b.check_in_date BETWEEN CURRENT_DATE AND CURRENT_DATE + $1::INTEGER
+		AND NOT EXISTS (
+			SELECT 1 FROM notification_outbox n
+			WHERE n.booking_id = b.booking_id AND n.event = 'booking.reminder'
+		)
+	`, daysBefore, s.smsProvider != nil)
+	if err != nil {
+		return err
+	}
+
+	var bookingIDs []uuid.UUID
+	for rows.Next() {
+		var bookingID uuid.UUID
+		if err := rows.Scan(&bookingID); err != nil {
+			rows.Close()
+			return err
+		}
+		bookingIDs = append(bookingIDs, bookingID)
+	}
+	rows.Close()
+
+	for _, bookingID := range bookingIDs {
+		if err := s.queueBookingNotification(s.db, NotificationBookingReminder, bookingID); err != nil {
+			log.Printf("Failed to queue reminder for booking %s: %v", bookingID, err)
+		}
+	}
+
+	return nil
+}
+
+// dueNotification is a notification claimed for delivery
+type dueNotification struct {
+	notification Notification
+	attempts     int
+	optedOut     bool
+}
+
+// claimNotification takes the oldest due notification, or returns nil when
+// there is none. Like webhook deliveries, it is claimed by pushing its next
+// attempt back by notificationClaimTimeout rather than locked while it is
+// sent.
+func (s *BookingService) claimNotification() (*dueNotification, error) {
+	var n dueNotification
+	err := s.db.QueryRow(`
+		UPDATE notification_outbox
+		SET next_attempt_at = $1
+		WHERE notification_id = (
+			SELECT notification_id
+			FROM notification_outbox
+			WHERE status = 'pending'
+			AND next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING notification_id, event, channel, booking_id, recipient_name,
+			COALESCE(recipient_email, ''), COALESCE(recipient_phone, ''), COALESCE(subject, ''), body, attempts,
+			EXISTS (SELECT 1 FROM notification_opt_outs o WHERE o.phone_number = recipient_phone)
+	`, time.Now().Add(notificationClaimTimeout)).Scan(
+		&n.notification.NotificationID, &n.notification.Event, &n.notification.Channel,
+		&n.notification.BookingID, &n.notification.Name, &n.notification.Email,
+		&n.notification.Phone, &n.notification.Subject, &n.notification.Body,
+		&n.attempts, &n.optedOut,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &n, nil
+}
+
+// DeliverNotifications sends the notifications that are due, oldest first,
+// up to notificationBatchSize of them. Each is claimed before it is sent, so
+// several servers can share the outbox. Failed deliveries are retried after
+// retryDelay until maxNotificationAttempts. Text messages to numbers that
+// have opted out since they were queued are skipped.
+func (s *BookingService) DeliverNotifications() error {
+	for i := 0; i < notificationBatchSize; i++ {
+		n, err := s.claimNotification()
+		if err != nil {
+			return err
+		}
+		if n == nil {
+			return nil
+		}
+
+		if n.optedOut {
+			_, err = s.db.Exec(`
+				UPDATE notification_outbox SET status = 'skipped' WHERE notification_id = $1
+			`, n.notification.NotificationID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		attempts := n.attempts + 1
+
+		if err := s.notify(n.notification); err != nil {
+			status := NotificationStatusPending
+			if attempts >= maxNotificationAttempts {
+				status = NotificationStatusFailed
+			}
+
+			_, err = s.db.Exec(`
+				UPDATE notification_outbox
+				SET status = $2, attempts = $3, last_error = $4, next_attempt_at = $5
+				WHERE notification_id = $1
+			`, n.notification.NotificationID, status, attempts, err.Error(), time.Now().Add(retryDelay(attempts)))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, err = s.db.Exec(`
+			UPDATE notification_outbox
+			SET status = 'sent', attempts = $2, last_error = NULL, sent_at = CURRENT_TIMESTAMP
+			WHERE notification_id = $1
+		`, n.notification.NotificationID, attempts)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Notifications queued for a booking, newest first
+func (s *BookingService) GetBookingNotifications(bookingID uuid.UUID) ([]OutboxNotification, error) {
+	rows, err := s.db.Query(`
+		SELECT notification_id, event, channel, booking_id, recipient_name, recipient_email,
+			recipient_phone, subject, body, status, attempts, last_error, next_attempt_at,
+			sent_at, created_at
+		FROM notification_outbox
+		WHERE booking_id = $1
+		ORDER BY created_at DESC
+	`, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []OutboxNotification{}
+
+	for rows.Next() {
+		var n OutboxNotification
+		err := rows.Scan(
+			&n.NotificationID, &n.Event, &n.Channel, &n.BookingID, &n.RecipientName,
+			&n.RecipientEmail, &n.RecipientPhone, &n.Subject, &n.Body, &n.Status,
+			&n.Attempts, &n.LastError, &n.NextAttemptAt, &n.SentAt, &n.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		notifications = append(notifications, n)
+	}
+
+	return notifications, nil
+}
+
+// runNotificationWorker queues reminders and delivers the outbox every
+// interval
+func (s *BookingService) runNotificationWorker(interval time.Duration, reminderDays int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if reminderDays > 0 {
+			if err := s.QueueReminders(reminderDays); err != nil {
+				log.Printf("Failed to queue reminders: %v", err)
+			}
+		}
+
+		if err := s.DeliverNotifications(); err != nil {
+			log.Printf("Failed to deliver notifications: %v", err)
+		}
+
+		<-ticker.C
+	}
+}
+
+// HTTP Handlers
+func (s *BookingService) GetBookingNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingIDStr := vars["bookingId"]
+
+	bookingID, err := uuid.Parse(bookingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid booking ID", http.StatusBadRequest)
+		return
+	}
+
+	notifications, err := s.GetBookingNotifications(bookingID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Notifier delivers the email notifications queued in the outbox. The SMTP
+// notifier sends them; until one is configured they are only written to
+// the log. Text messages go through an SMSProvider instead.
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+// Notification events
+const (
+	NotificationBookingConfirmed  = "booking.confirmed"
+	NotificationBookingModified   = "booking.modified"
+	NotificationBookingCancelled  = "booking.cancelled"
+	NotificationBookingReminder   = "booking.reminder"
+	NotificationWaitlistAvailable = "waitlist.available"
+)
+
+// Notification channels: email, or a text message (SMS or WhatsApp,
+// depending on the provider)
+const (
+	NotificationChannelEmail = "email"
+	NotificationChannelSMS   = "sms"
+)
+
+// Notification is a rendered message about one event, addressed to a guest
+// or waitlisted contact on one channel. Text messages have no subject.
+type Notification struct {
+	NotificationID uuid.UUID
+	Event          string
+	Channel        string
+	BookingID      *uuid.UUID
+	Name           string
+	Email          string
+	Phone          string
+	Subject        string
+	Body           string
+}
+
+// logNotifier writes notifications to the log instead of sending them
+type logNotifier struct{}
+
+func (logNotifier) Notify(notification Notification) error {
+	log.Printf("Notification %s to %s: %s", notification.Event, notification.Email, notification.Subject)
+	return nil
+}
+
+// SetNotifier replaces the notifier the outbox is delivered through
+func (s *BookingService) SetNotifier(notifier Notifier) {
+	s.notifier = notifier
+}
+
+// notify delivers a notification on its channel
+func (s *BookingService) notify(notification Notification) error {
+	if notification.Channel == NotificationChannelSMS {
+		if s.smsProvider == nil {
+			return fmt.Errorf("no SMS provider is configured")
+		}
+		return s.smsProvider.SendMessage(notification.Phone, notification.Body)
+	}
+
+	if s.notifier == nil {
+		return logNotifier{}.Notify(notification)
+	}
+	return s.notifier.Notify(notification)
+}
+
+// smtpNotifier sends notifications as plain text email through an SMTP
+// server, authenticating when a username is set. Servers other than
+// localhost must offer STARTTLS for the password to be sent.
+type smtpNotifier struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func newSMTPNotifier(host, port, username, password, from string) *smtpNotifier {
+	return &smtpNotifier{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+func (n *smtpNotifier) Notify(notification Notification) error {
+	from, err := mail.ParseAddress(n.from)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %v", err)
+	}
+
+	to, err := mail.ParseAddress(notification.Email)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %v", err)
+	}
+	to.Name = notification.Name
+
+	message, err := buildEmail(from, to, notification)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if n.username != "" {
+		auth = smtp.PlainAuth("", n.username, n.password, n.host)
+	}
+
+	return smtp.SendMail(net.JoinHostPort(n.host, n.port), auth, from.Address, []string{to.Address}, message)
+}
+
+// buildEmail renders a notification as a quoted-printable UTF-8 message
+func buildEmail(from, to *mail.Address, notification Notification) ([]byte, error) {
+	var message bytes.Buffer
+
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+	headers := []string{
+		"From: " + from.String(),
+		"To: " + to.String(),
+		"Subject: " + mime.QEncoding.Encode("utf-8", notification.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		fmt.Sprintf("Message-ID: <%s@%s>", notification.NotificationID, domain),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"Content-Transfer-Encoding: quoted-printable",
+	}
+	for _, header := range headers {
+		message.WriteString(header + "\r\n")
+	}
+	message.WriteString("\r\n")
+
+	body := quotedprintable.NewWriter(&message)
+	if _, err := body.Write([]byte(strings.ReplaceAll(notification.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+
+	return message.Bytes(), nil
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// smtpMessage is a message the fake SMTP server accepted
+type smtpMessage struct {
+	auth string
+	from string
+	to   []string
+	data []byte
+}
+
+// fakeSMTPServer speaks enough SMTP for net/smtp.SendMail, offering AUTH
+// PLAIN but not STARTTLS
+type fakeSMTPServer struct {
+	listener net.Listener
+
+	mu               sync.Mutex
+	messages         []smtpMessage
+	rejectRecipients bool
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	server := &fakeSMTPServer{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (f *fakeSMTPServer) notifier(username, password string) *smtpNotifier {
+	host, port, _ := net.SplitHostPort(f.listener.Addr().String())
+	return newSMTPNotifier(host, port, username, password, "Bookings <bookings@example.com>")
+}
+
+func (f *fakeSMTPServer) setRejectRecipients(reject bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rejectRecipients = reject
+}
+
+func (f *fakeSMTPServer) received() []smtpMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]smtpMessage(nil), f.messages...)
+}
+
+func (f *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		fmt.Fprintf(conn, "%s\r\n", line)
+	}
+
+	var message smtpMessage
+	reply("220 fake.example.com ESMTP")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch verb {
+		case "EHLO":
+			reply("250-fake.example.com")
+			reply("250 AUTH PLAIN")
+		case "HELO", "NOOP", "RSET":
+			reply("250 OK")
+		case "AUTH":
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				reply("501 Syntax error")
+				continue
+			}
+			credentials, err := base64.StdEncoding.DecodeString(fields[2])
+			if err != nil {
+				reply("501 Invalid credentials")
+				continue
+			}
+			message.auth = string(credentials)
+			reply("235 Authentication successful")
+		case "MAIL":
+			message.from = strings.TrimPrefix(line, "MAIL FROM:")
+			reply("250 OK")
+		case "RCPT":
+			f.mu.Lock()
+			reject := f.rejectRecipients
+			f.mu.Unlock()
+			if reject {
+				reply("550 Mailbox unavailable")
+				continue
+			}
+			message.to = append(message.to, strings.TrimPrefix(line, "RCPT TO:"))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+
+			var data bytes.Buffer
+			for {
+				dataLine, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(dataLine, "."))
+			}
+			message.data = data.Bytes()
+
+			f.mu.Lock()
+			f.messages = append(f.messages, message)
+			f.mu.Unlock()
+			message = smtpMessage{auth: message.auth}
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// readEmail parses a message and decodes its quoted-printable body
+func readEmail(t *testing.T, data []byte) (*mail.Message, string) {
+	t.Helper()
+
+	message, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v\n%s", err, data)
+	}
+
+	body, err := io.ReadAll(quotedprintable.NewReader(message.Body))
+	if err != nil {
+		t.Fatalf("Failed to decode body: %v", err)
+	}
+
+	return message, string(body)
+}
+
+func TestSMTPNotifierNotify(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	notifier := server.notifier("bookings", "secret")
+
+	notification := Notification{
+		NotificationID: uuid.New(),
+		Event:          NotificationBookingConfirmed,
+		Channel:        NotificationChannelEmail,
+		Name:           "Zoë Perera",
+		Email:          "zoe@example.com",
+		Subject:        "Your booking at Café Lanka is confirmed",
+		Body:           "Hi Zoë,\n\nSee you soon.\n.\nThe team",
+	}
+
+	if err := notifier.Notify(notification); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	messages := server.received()
+	if len(messages) != 1 {
+		t.Fatalf("server received %d messages, want 1", len(messages))
+	}
+
+	sent := messages[0]
+	if sent.auth != "\x00bookings\x00secret" {
+		t.Errorf("AUTH PLAIN credentials = %q", sent.auth)
+	}
+	if sent.from != "<bookings@example.com>" {
+		t.Errorf("MAIL FROM = %s", sent.from)
+	}
+	if len(sent.to) != 1 || sent.to[0] != "<zoe@example.com>" {
+		t.Errorf("RCPT TO = %v", sent.to)
+	}
+
+	message, body := readEmail(t, sent.data)
+
+	to, err := mail.ParseAddress(message.Header.Get("To"))
+	if err != nil || to.Name != notification.Name || to.Address != notification.Email {
+		t.Errorf("To = %q (%v), want %s <%s>", message.Header.Get("To"), err, notification.Name, notification.Email)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	if err != nil || subject != notification.Subject {
+		t.Errorf("Subject = %q (%v), want %q", subject, err, notification.Subject)
+	}
+
+	// A lone dot survives SMTP dot-stuffing; SendMail ends the data with a
+	// line break of its own
+	body = strings.TrimSuffix(body, "\r\n")
+	if want := strings.ReplaceAll(notification.Body, "\n", "\r\n"); body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestSMTPNotifierFailures(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	notifier := server.notifier("", "")
+
+	notification := Notification{NotificationID: uuid.New(), Email: "guest@example.com", Subject: "Hello", Body: "Hi"}
+
+	server.setRejectRecipients(true)
+	if err := notifier.Notify(notification); err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("rejected recipient: err = %v, want the 550 reply", err)
+	}
+
+	notification.Email = "not an address"
+	if err := notifier.Notify(notification); err == nil {
+		t.Error("expected an error for an invalid recipient address")
+	}
+
+	if len(server.received()) != 0 {
+		t.Error("no message should have been accepted")
+	}
+}
+
+func TestBuildEmail(t *testing.T) {
+	from := &mail.Address{Name: "Bookings", Address: "bookings@example.com"}
+	to := &mail.Address{Name: "Ann", Address: "ann@example.com"}
+
+	notification := Notification{
+		NotificationID: uuid.New(),
+		Subject:        "Réservation confirmée",
+		Body:           "Total = 100 €\n" + strings.Repeat("long line ", 20),
+	}
+
+	data, err := buildEmail(from, to, notification)
+	if err != nil {
+		t.Fatalf("buildEmail: %v", err)
+	}
+
+	message, body := readEmail(t, data)
+
+	wantHeaders := map[string]string{
+		"From":                      `"Bookings" <bookings@example.com>`,
+		"To":                        `"Ann" <ann@example.com>`,
+		"Message-Id":                fmt.Sprintf("<%s@example.com>", notification.NotificationID),
+		"Mime-Version":              "1.0",
+		"Content-Type":              "text/plain; charset=UTF-8",
+		"Content-Transfer-Encoding": "quoted-printable",
+	}
+	for name, want := range wantHeaders {
+		if got := message.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	if _, err := message.Header.Date(); err != nil {
+		t.Errorf("Date: %v", err)
+	}
+
+	rawSubject := message.Header.Get("Subject")
+	if !strings.HasPrefix(rawSubject, "=?utf-8?q?") {
+		t.Errorf("Subject = %q, want it Q-encoded", rawSubject)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(rawSubject)
+	if err != nil || subject != notification.Subject {
+		t.Errorf("decoded Subject = %q (%v), want %q", subject, err, notification.Subject)
+	}
+
+	if want := strings.ReplaceAll(notification.Body, "\n", "\r\n"); body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+
+	// The encoded body is 7-bit with lines of at most 76 characters
+	raw := data[bytes.Index(data, []byte("\r\n\r\n"))+4:]
+	if !bytes.Contains(raw, []byte("Total =3D 100 =E2=82=AC")) {
+		t.Errorf("body is not quoted-printable: %q", raw)
+	}
+	for _, line := range strings.Split(string(raw), "\r\n") {
+		if len(line) > 76 {
+			t.Errorf("encoded line longer than 76 characters: %q", line)
+		}
+	}
+
+	// Plain ASCII subjects are left as they are
+	notification.Subject = "Booking confirmed"
+	data, err = buildEmail(from, to, notification)
+	if err != nil {
+		t.Fatalf("buildEmail: %v", err)
+	}
+	if message, _ := readEmail(t, data); message.Header.Get("Subject") != "Booking confirmed" {
+		t.Errorf("Subject = %q, want it unencoded", message.Header.Get("Subject"))
+	}
+}
+
+// queueTestEmail adds an email to the outbox ahead of anything else queued
+func queueTestEmail(t *testing.T, s *BookingService, email string, attempts int) uuid.UUID {
+	t.Helper()
+
+	var notificationID uuid.UUID
+	err := s.db.QueryRow(`
+		INSERT INTO notification_outbox (
+			event, channel, recipient_name, recipient_email, subject, body, attempts, created_at
+		) VALUES ($1, 'email', 'Outbox Guest', $2, 'Outbox test', 'Hello', $3, '2000-01-01')
+		RETURNING notification_id
+	`, NotificationBookingConfirmed, email, attempts).Scan(&notificationID)
+	if err != nil {
+		t.Fatalf("Failed to queue notification: %v", err)
+	}
+
+	return notificationID
+}
+
+type outboxState struct {
+	status        string
+	attempts      int
+	lastError     *string
+	nextAttemptAt time.Time
+	sentAt        *time.Time
+}
+
+func readOutbox(t *testing.T, s *BookingService, notificationID uuid.UUID) outboxState {
+	t.Helper()
+
+	var state outboxState
+	err := s.db.QueryRow(`
+		SELECT status, attempts, last_error, next_attempt_at, sent_at
+		FROM notification_outbox WHERE notification_id = $1
+	`, notificationID).Scan(&state.status, &state.attempts, &state.lastError, &state.nextAttemptAt, &state.sentAt)
+	if err != nil {
+		t.Fatalf("Failed to read notification: %v", err)
+	}
+
+	return state
+}
+
+func TestDeliverNotificationsRetries(t *testing.T) {
+	s := newTestService(t)
+	server := newFakeSMTPServer(t)
+	s.SetNotifier(server.notifier("", ""))
+
+	recipient := "outbox-" + uuid.New().String()[:8] + "@example.com"
+	notificationID := queueTestEmail(t, s, recipient, 0)
+	lastChanceID := queueTestEmail(t, s, recipient, maxNotificationAttempts-1)
+
+	server.setRejectRecipients(true)
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		before := time.Now()
+		if err := s.DeliverNotifications(); err != nil {
+			t.Fatalf("DeliverNotifications: %v", err)
+		}
+
+		state := readOutbox(t, s, notificationID)
+		if state.status != NotificationStatusPending || state.attempts != attempt || state.lastError == nil {
+			t.Fatalf("after failed attempt %d: %+v", attempt, state)
+		}
+
+		want := retryDelay(attempt)
+		if wait := state.nextAttemptAt.Sub(before); wait < want || wait > want+time.Minute {
+			t.Errorf("after failed attempt %d the next attempt is in %v, want %v", attempt, wait, want)
+		}
+
+		// Due again
+		if _, err := s.db.Exec(`UPDATE notification_outbox SET next_attempt_at = CURRENT_TIMESTAMP WHERE notification_id = $1`, notificationID); err != nil {
+			t.Fatalf("Failed to reschedule notification: %v", err)
+		}
+	}
+
+	// The last allowed attempt failed: given up on
+	if state := readOutbox(t, s, lastChanceID); state.status != NotificationStatusFailed || state.attempts != maxNotificationAttempts {
+		t.Errorf("after the last attempt: %+v, want failed", state)
+	}
+
+	server.setRejectRecipients(false)
+
+	if err := s.DeliverNotifications(); err != nil {
+		t.Fatalf("DeliverNotifications: %v", err)
+	}
+
+	state := readOutbox(t, s, notificationID)
+	if state.status != NotificationStatusSent || state.attempts != 3 || state.lastError != nil || state.sentAt == nil {
+		t.Errorf("after the successful attempt: %+v", state)
+	}
+
+	delivered := 0
+	for _, message := range server.received() {
+		if len(message.to) == 1 && message.to[0] == "<"+recipient+">" {
+			delivered++
+		}
+	}
+	if delivered != 1 {
+		t.Errorf("%d messages were delivered to %s, want 1", delivered, recipient)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type Payment struct {
+	PaymentID     uuid.UUID `json:"payment_id"`
+	BookingID     uuid.UUID `json:"booking_id"`
+	Amount        float64   `json:"amount"`
+	PaymentMethod string    `json:"payment_method"`
+	Reference     *string   `json:"reference,omitempty"`
+	ReceivedAt    time.Time `json:"received_at"`
+	RecordedBy    uuid.UUID `json:"recorded_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type RecordPaymentRequest struct {
+	Amount        float64 `json:"amount"`
+	PaymentMethod string  `json:"payment_method"`
+	Reference     *string `json:"reference,omitempty"`
+	ReceivedAt    *string `json:"received_at,omitempty"` // "2024-01-15" format, defaults to today
+}
+
+// Record a payment received for a booking and update its payment status
+func (s *BookingService) RecordPayment(bookingID uuid.UUID, userID uuid.UUID, req *RecordPaymentRequest) (*Payment, error) {
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("payment amount must be greater than zero")
+	}
+
+	switch req.PaymentMethod {
+	case "cash", "card", "bank_transfer", "other":
+	default:
+		return nil, fmt.Errorf("payment_method must be one of 'cash', 'card', 'bank_transfer' or 'other'")
+	}
+
+	receivedAt := time.Now()
+	if req.ReceivedAt != nil {
+		var err error
+		receivedAt, err = time.Parse("2006-01-02", *req.ReceivedAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid received_at date format: %v", err)
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the booking so concurrent payments settle the status correctly
+	var paymentStatus string
+	err = tx.QueryRow(`SELECT payment_status FROM bookings WHERE booking_id = $1 FOR UPDATE`, bookingID).Scan(&paymentStatus)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("booking not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	paymentID := uuid.New()
+	_, err = tx.Exec(`
+		INSERT INTO booking_payments (
+			payment_id, booking_id, amount, payment_method, reference, received_at, recorded_by
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, paymentID, bookingID, req.Amount, req.PaymentMethod, req.Reference, receivedAt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if paymentStatus != "refunded" {
+		if err := updatePaymentStatus(tx, bookingID); err != nil {
+			return nil, err
+		}
+	}
+
+	payments, err := s.queryPayments(tx, `
+		SELECT payment_id, booking_id, amount, payment_method, reference, received_at, recorded_by, created_at
+		FROM booking_payments
+		WHERE payment_id = $1
+	`, paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.recordBookingEvent(tx, EventPaymentReceived, bookingID, &BookingEventPayload{Payment: &payments[0]})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &payments[0], nil
+}
+
+func (s *BookingService) GetPayments(bookingID uuid.UUID) ([]Payment, error) {
+	query := `
+		SELECT payment_id, booking_id, amount, payment_method, reference, received_at, recorded_by, created_at
+		FROM booking_payments
+		WHERE booking_id = $1
+		ORDER BY received_at ASC, created_at ASC
+	`
+
+	return s.queryPayments(s.db, query, bookingID)
+}
+
+func (s *BookingService) queryPayments(q queryer, query string, args ...interface{}) ([]Payment, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []Payment
+
+	for rows.Next() {
+		var payment Payment
+		err := rows.Scan(
+			&payment.PaymentID, &payment.BookingID, &payment.Amount, &payment.PaymentMethod,
+			&payment.Reference, &payment.ReceivedAt, &payment.RecordedBy, &payment.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		payments = append(payments, payment)
+	}
+
+	return payments, nil
+}
+
+// updatePaymentStatus marks the booking paid once payments cover the amount due
+func updatePaymentStatus(tx *sql.Tx, bookingID uuid.UUID) error {
+	var due, paid float64
+
+	err := tx.QueryRow(`
+		SELECT `+bookingAmountDueColumn+`,
+			COALESCE((SELECT SUM(amount) FROM booking_payments WHERE booking_id = b.booking_id), 0)
+		FROM bookings b
+		WHERE b.booking_id = $1
+	`, bookingID).Scan(&due, &paid)
+	if err != nil {
+		return err
+	}
+
+	status := "pending"
+	if paid > 0 && paid >= due {
+		status = "paid"
+	} else if paid > 0 {
+		status = "partial"
+	}
+
+	_, err = tx.Exec(`UPDATE bookings SET payment_status = $1 WHERE booking_id = $2 AND payment_status != $1`, status, bookingID)
+	return err
+}
+
+// Booking amount less discount plus taxes and fees, for a bookings row aliased b
+const bookingAmountDueColumn = `(
+	COALESCE(b.booking_amount, 0)
+	- COALESCE((SELECT discount_amount FROM booking_discounts WHERE booking_id = b.booking_id), 0)
+	+ COALESCE((SELECT SUM(amount) FROM booking_charges WHERE booking_id = b.booking_id), 0)
+)`
+
+// HTTP Handlers
+func (s *BookingService) RecordPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingIDStr := vars["bookingId"]
+
+	bookingID, err := uuid.Parse(bookingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid booking ID", http.StatusBadRequest)
+		return
+	}
+
+	var req RecordPaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := s.requestUserID(r)
+
+	payment, err := s.RecordPayment(bookingID, userID, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(payment)
+}
+
+func (s *BookingService) GetPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingIDStr := vars["bookingId"]
+
+	bookingID, err := uuid.Parse(bookingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid booking ID", http.StatusBadRequest)
+		return
+	}
+
+	payments, err := s.GetPayments(bookingID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payments)
+}
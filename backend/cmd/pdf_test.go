@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPDFEscape(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Invoice 42", "Invoice 42"},
+		{"Room (sea view)", `Room \(sea view\)`},
+		{`C:\path`, `C:\\path`},
+		{"two\nlines\r\tend", "two lines  end"},
+		{"café", `caf\351`},
+		{"£10", `\24310`},
+		{"\u00a0", `\240`},
+		{"€5", `\2005`},
+		{"“quoted” – fine", `\223quoted\224 \226 fine`},
+		{"日本", "??"},
+		{"\x7f", "?"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := pdfEscape(tt.text); got != tt.want {
+			t.Errorf("pdfEscape(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		hex     string
+		r, g, b float64
+	}{
+		{"#ffffff", 1, 1, 1},
+		{"000000", 0, 0, 0},
+		{"#ff0000", 1, 0, 0},
+		{"#1f4e79", 31.0 / 255, 78.0 / 255, 121.0 / 255},
+		{"", 0.2, 0.2, 0.2},
+		{"#fff", 0.2, 0.2, 0.2},
+		{"#zzzzzz", 0.2, 0.2, 0.2},
+	}
+
+	for _, tt := range tests {
+		r, g, b := parseHexColor(tt.hex)
+		if r != tt.r || g != tt.g || b != tt.b {
+			t.Errorf("parseHexColor(%q) = %v, %v, %v, want %v, %v, %v", tt.hex, r, g, b, tt.r, tt.g, tt.b)
+		}
+	}
+}
+
+func TestPDFTruncate(t *testing.T) {
+	tests := []struct {
+		text  string
+		width float64
+		want  string
+	}{
+		{"Short", 100, "Short"},
+		{"", 10, ""},
+		// 'i' is 222 units and '...' 834, so at size 10 each 'i' is 2.22 points
+		{"iiiiiiiiii", 22.2, "iiiiiiiiii"},
+		{"iiiiiiiiii", 20, "iiiii..."},
+		{"iiiiiiiiii", 8, "..."},
+	}
+
+	for _, tt := range tests {
+		if got := pdfTruncate(tt.text, tt.width, 10, false); got != tt.want {
+			t.Errorf("pdfTruncate(%q, %v) = %q, want %q", tt.text, tt.width, got, tt.want)
+		}
+	}
+}
+
+func testInvoice(lines int) *Invoice {
+	footer := "Thank you (really) for staying"
+	invoice := &Invoice{
+		InvoiceNumber: "INV-000042",
+		IssuedAt:      time.Date(2026, 3, 5, 10, 0, 0, 0, time.UTC),
+		Branding: PropertyBranding{
+			DisplayName: "Villa Café",
+			Currency:    "EUR",
+			AccentColor: "#1f4e79",
+			FooterText:  &footer,
+		},
+		Booking: Booking{
+			GuestName:          "Ann O'Neil",
+			GuestContactNumber: "+94771234567",
+			CheckInDate:        time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			CheckOutDate:       time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC),
+			TotalNights:        3,
+			NumberOfGuests:     2,
+		},
+		Subtotal:   300,
+		TaxTotal:   30,
+		Total:      330,
+		AmountPaid: 100,
+		BalanceDue: 230,
+		Payments: []Payment{
+			{Amount: 100, PaymentMethod: "card", ReceivedAt: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+	for i := 0; i < lines; i++ {
+		invoice.Lines = append(invoice.Lines, InvoiceLine{
+			Description: fmt.Sprintf("Night %d", i+1), Quantity: 1, UnitPrice: 100, Amount: 100,
+		})
+	}
+
+	return invoice
+}
+
+func TestRenderInvoicePDF(t *testing.T) {
+	tests := []struct {
+		lines int
+		pages int
+	}{
+		{0, 1},
+		{3, 1},
+		{60, 2},
+		{100, 3},
+	}
+
+	for _, tt := range tests {
+		out := string(renderInvoicePDF(testInvoice(tt.lines)))
+
+		if !strings.HasPrefix(out, "%PDF-1.4\n") || !strings.HasSuffix(out, "%%EOF\n") {
+			t.Errorf("%d lines: document is not framed as a PDF", tt.lines)
+			continue
+		}
+		if want := fmt.Sprintf("/Count %d >>", tt.pages); !strings.Contains(out, want) {
+			t.Errorf("%d lines: want %d pages, page tree has no %q", tt.lines, tt.pages, want)
+		}
+		for _, text := range []string{`(Villa Caf\351)`, `(Invoice INV-000042)`, `(Issued 5 Mar 2026)`,
+			`(Thank you \(really\) for staying)`, `(EUR 230.00)`, `(3 nights, 2 guests)`} {
+			if !strings.Contains(out, text) {
+				t.Errorf("%d lines: document does not draw %s", tt.lines, text)
+			}
+		}
+		if tt.lines > 0 && !strings.Contains(out, fmt.Sprintf("(Night %d)", tt.lines)) {
+			t.Errorf("%d lines: last line item is missing", tt.lines)
+		}
+
+		// Every cross-reference entry points at the object it numbers
+		xref := strings.LastIndex(out, "\nxref\n") + 1
+		entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllStringSubmatch(out[xref:], -1)
+		if len(entries) != 4+tt.pages*2 {
+			t.Errorf("%d lines: %d cross-reference entries, want %d", tt.lines, len(entries), 4+tt.pages*2)
+		}
+		for i, entry := range entries {
+			offset, _ := strconv.Atoi(entry[1])
+			if !strings.HasPrefix(out[offset:], fmt.Sprintf("%d 0 obj\n", i+1)) {
+				t.Errorf("%d lines: cross-reference entry %d does not point at its object", tt.lines, i+1)
+			}
+		}
+		if !strings.HasSuffix(out, fmt.Sprintf("startxref\n%d\n%%%%EOF\n", xref)) {
+			t.Errorf("%d lines: startxref does not point at the cross-reference table", tt.lines)
+		}
+	}
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Discount types
+const (
+	DiscountTypePercentage = "percentage"
+	DiscountTypeFixed      = "fixed"
+)
+
+// PromoCode is a discount that can be redeemed when creating a booking. The
+// validity window applies to the check-in date of the stay.
+type PromoCode struct {
+	PromoID          uuid.UUID  `json:"promo_id"`
+	Code             string     `json:"code"`
+	Description      *string    `json:"description,omitempty"`
+	DiscountType     string     `json:"discount_type"`
+	DiscountValue    float64    `json:"discount_value"`
+	ValidFrom        *time.Time `json:"valid_from,omitempty"`
+	ValidTo          *time.Time `json:"valid_to,omitempty"`
+	MaxUses          *int       `json:"max_uses,omitempty"`
+	TimesUsed        int        `json:"times_used"`
+	MinNights        *int       `json:"min_nights,omitempty"`
+	MaxNights        *int       `json:"max_nights,omitempty"`
+	PropertyID       *uuid.UUID `json:"property_id,omitempty"`
+	RepeatGuestsOnly bool       `json:"repeat_guests_only"`
+	IsActive         bool       `json:"is_active"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// BookingDiscount records the promo code redeemed on a booking
+type BookingDiscount struct {
+	PromoID        *uuid.UUID `json:"promo_id,omitempty"`
+	PromoCode      string     `json:"promo_code"`
+	DiscountType   string     `json:"discount_type"`
+	DiscountValue  float64    `json:"discount_value"`
+	DiscountAmount float64    `json:"discount_amount"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type PromoCodeRequest struct {
+	Code             string     `json:"code"`
+	Description      *string    `json:"description,omitempty"`
+	DiscountType     string     `json:"discount_type"`
+	DiscountValue    float64    `json:"discount_value"`
+	ValidFrom        *string    `json:"valid_from,omitempty"` // "2024-01-15" format
+	ValidTo          *string    `json:"valid_to,omitempty"`   // "2024-01-20" format
+	MaxUses          *int       `json:"max_uses,omitempty"`
+	MinNights        *int       `json:"min_nights,omitempty"`
+	MaxNights        *int       `json:"max_nights,omitempty"`
+	PropertyID       *uuid.UUID `json:"property_id,omitempty"`
+	RepeatGuestsOnly bool       `json:"repeat_guests_only"`
+	IsActive         *bool      `json:"is_active,omitempty"`
+}
+
+type promoCodeValues struct {
+	code      string
+	validFrom *time.Time
+	validTo   *time.Time
+	isActive  bool
+}
+
+func (req *PromoCodeRequest) validate() (*promoCodeValues, error) {
+	values := &promoCodeValues{
+		code:     normalizePromoCode(req.Code),
+		isActive: true,
+	}
+
+	if values.code == "" {
+		return nil, serviceError(http.StatusBadRequest, "code is required")
+	}
+
+	switch req.DiscountType {
+	case DiscountTypePercentage:
+		if req.DiscountValue <= 0 || req.DiscountValue > 100 {
+			return nil, serviceError(http.StatusBadRequest, "percentage discount must be between 0 and 100")
+		}
+	case DiscountTypeFixed:
+		if req.DiscountValue <= 0 {
+			return nil, serviceError(http.StatusBadRequest, "fixed discount must be greater than zero")
+		}
+	default:
+		return nil, serviceError(http.StatusBadRequest, "discount_type must be 'percentage' or 'fixed'")
+	}
+
+	if req.ValidFrom != nil {
+		validFrom, err := time.Parse("2006-01-02", *req.ValidFrom)
+		if err != nil {
+			return nil, serviceError(http.StatusBadRequest, "invalid valid_from date format: %v", err)
+		}
+		values.validFrom = &validFrom
+	}
+
+	if req.ValidTo != nil {
+		validTo, err := time.Parse("2006-01-02", *req.ValidTo)
+		if err != nil {
+			return nil, serviceError(http.StatusBadRequest, "invalid valid_to date format: %v", err)
+		}
+		values.validTo = &validTo
+	}
+
+	if values.validFrom != nil && values.validTo != nil && values.validTo.Before(*values.validFrom) {
+		return nil, serviceError(http.StatusBadRequest, "valid_to must not be before valid_from")
+	}
+
+	if req.MaxUses != nil && *req.MaxUses < 1 {
+		return nil, serviceError(http.StatusBadRequest, "max_uses must be at least 1")
+	}
+
+	if req.MinNights != nil && req.MaxNights != nil && *req.MaxNights < *req.MinNights {
+		return nil, serviceError(http.StatusBadRequest, "max_nights must not be less than min_nights")
+	}
+
+	if req.IsActive != nil {
+		values.isActive = *req.IsActive
+	}
+
+	return values, nil
+}
+
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (s *BookingService) GetPromoCodes(propertyID *uuid.UUID) ([]PromoCode, error) {
+	query := `
+		SELECT p.promo_id, p.code, p.description, p.discount_type, p.discount_value,
+			p.valid_from, p.valid_to, p.max_uses, ` + promoTimesUsedColumn + `,
+			p.min_nights, p.max_nights, p.property_id, p.repeat_guests_only,
+			p.is_active, p.created_at, p.updated_at
+		FROM promo_codes p
+		WHERE ($1::UUID IS NULL OR p.property_id IS NULL OR p.property_id = $1)
+		ORDER BY p.code
+	`
+
+	return s.queryPromoCodes(s.db, query, propertyID)
+}
+
+func (s *BookingService) GetPromoCode(promoID uuid.UUID) (*PromoCode, error) {
+	query := `
+		SELECT p.promo_id, p.code, p.description, p.discount_type, p.discount_value,
+			p.valid_from, p.valid_to, p.max_uses, ` + promoTimesUsedColumn + `,
+			p.min_nights, p.max_nights, p.property_id, p.repeat_guests_only,
+			p.is_active, p.created_at, p.updated_at
+		FROM promo_codes p
+		WHERE p.promo_id = $1
+	`
+
+	promos, err := s.queryPromoCodes(s.db, query, promoID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(promos) == 0 {
+		return nil, serviceError(http.StatusNotFound, "promo code not found")
+	}
+
+	return &promos[0], nil
+}
+
+func (s *BookingService) CreatePromoCode(req *PromoCodeRequest) (*PromoCode, error) {
+	values, err := req.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	promoID := uuid.New()
+	query := `
+		INSERT INTO promo_codes (
+			promo_id, code, description, discount_type, discount_value, valid_from,
+			valid_to, max_uses, min_nights, max_nights, property_id,
+			repeat_guests_only, is_active
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`
+
+	_, err = s.db.Exec(query, promoID, values.code, req.Description, req.DiscountType,
+		req.DiscountValue, values.validFrom, values.validTo, req.MaxUses, req.MinNights,
+		req.MaxNights, req.PropertyID, req.RepeatGuestsOnly, values.isActive)
+	if isUniqueViolation(err) {
+		return nil, serviceError(http.StatusConflict, "promo code %q already exists", values.code)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetPromoCode(promoID)
+}
+
+// Changes only affect future redemptions; redeemed bookings keep their discount
+func (s *BookingService) UpdatePromoCode(promoID uuid.UUID, req *PromoCodeRequest) (*PromoCode, error) {
+	values, err := req.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE promo_codes
+		SET code = $1, description = $2, discount_type = $3, discount_value = $4,
+			valid_from = $5, valid_to = $6, max_uses = $7, min_nights = $8,
+			max_nights = $9, property_id = $10, repeat_guests_only = $11,
+			is_active = $12, updated_at = CURRENT_TIMESTAMP
+		WHERE promo_id = $13
+	`
+
+	result, err := s.db.Exec(query, values.code, req.Description, req.DiscountType,
+		req.DiscountValue, values.validFrom, values.validTo, req.MaxUses, req.MinNights,
+		req.MaxNights, req.PropertyID, req.RepeatGuestsOnly, values.isActive, promoID)
+	if isUniqueViolation(err) {
+		return nil, serviceError(http.StatusConflict, "promo code %q already exists", values.code)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, serviceError(http.StatusNotFound, "promo code not found")
+	}
+
+	return s.GetPromoCode(promoID)
+}
+
+func (s *BookingService) DeletePromoCode(promoID uuid.UUID) error {
+	result, err := s.db.Exec(`DELETE FROM promo_codes WHERE promo_id = $1`, promoID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return serviceError(http.StatusNotFound, "promo code not found")
+	}
+
+	return nil
+}
+
+// Redemptions on bookings that have not been cancelled count towards max_uses
+const promoTimesUsedColumn = `(
+	SELECT COUNT(*) FROM booking_discounts d
+	JOIN bookings b ON b.booking_id = d.booking_id
+	WHERE d.promo_id = p.promo_id AND b.booking_status != 'cancelled'
+)`
+
+func (s *BookingService) queryPromoCodes(q queryer, query string, args ...interface{}) ([]PromoCode, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var promos []PromoCode
+
+	for rows.Next() {
+		var promo PromoCode
+		err := rows.Scan(
+			&promo.PromoID, &promo.Code, &promo.Description, &promo.DiscountType,
+			&promo.DiscountValue, &promo.ValidFrom, &promo.ValidTo, &promo.MaxUses,
+			&promo.TimesUsed, &promo.MinNights, &promo.MaxNights, &promo.PropertyID,
+			&promo.RepeatGuestsOnly, &promo.IsActive, &promo.CreatedAt, &promo.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		promos = append(promos, promo)
+	}
+
+	return promos, nil
+}
+
+// redeemPromoCode validates a promo code against the booking being created and
+// records it on the booking. The promo row is locked so usage limits hold
+// under concurrent redemptions.
+func (s *BookingService) redeemPromoCode(tx *sql.Tx, bookingID uuid.UUID, code string, req *CreateBookingRequest, checkIn, checkOut time.Time) error {
+	query := `
+		SELECT p.promo_id, p.code, p.description, p.discount_type, p.discount_value,
+			p.valid_from, p.valid_to, p.max_uses, 0,
+			p.min_nights, p.max_nights, p.property_id, p.repeat_guests_only,
+			p.is_active, p.created_at, p.updated_at
+		FROM promo_codes p
+		WHERE p.code = $1
+		FOR UPDATE
+	`
+
+	promos, err := s.queryPromoCodes(tx, query, normalizePromoCode(code))
+	if err != nil {
+		return err
+	}
+
+	if len(promos) == 0 {
+		return serviceError(http.StatusBadRequest, "promo code %q is not valid", code)
+	}
+
+	promo := promos[0]
+
+	if !promo.IsActive {
+		return serviceError(http.StatusBadRequest, "promo code %q is no longer active", promo.Code)
+	}
+
+	if promo.PropertyID != nil && *promo.PropertyID != req.PropertyID {
+		return serviceError(http.StatusBadRequest, "promo code %q cannot be used for this property", promo.Code)
+	}
+
+	if promo.ValidFrom != nil && checkIn.Before(*promo.ValidFrom) {
+		return serviceError(http.StatusBadRequest, "promo code %q is only valid for stays from %s", promo.Code, promo.ValidFrom.Format("2006-01-02"))
+	}
+
+	if promo.ValidTo != nil && checkIn.After(*promo.ValidTo) {
+		return serviceError(http.StatusBadRequest, "promo code %q expired on %s", promo.Code, promo.ValidTo.Format("2006-01-02"))
+	}
+
+	nights := int(checkOut.Sub(checkIn).Hours() / 24)
+
+	if promo.MinNights != nil && nights < *promo.MinNights {
+		return serviceError(http.StatusBadRequest, "promo code %q requires a stay of at least %d nights", promo.Code, *promo.MinNights)
+	}
+
+	if promo.MaxNights != nil && nights > *promo.MaxNights {
+		return serviceError(http.StatusBadRequest, "promo code %q is only valid for stays of up to %d nights", promo.Code, *promo.MaxNights)
+	}
+
+	if promo.MaxUses != nil {
+		var used int
+		err := tx.QueryRow(`
+			SELECT COUNT(*) FROM booking_discounts d
+			JOIN bookings b ON b.booking_id = d.booking_id
+			WHERE d.promo_id = $1 AND b.booking_status != 'cancelled'
+		`, promo.PromoID).Scan(&used)
+		if err != nil {
+			return err
+		}
+
+		if used >= *promo.MaxUses {
+			return serviceError(http.StatusBadRequest, "promo code %q has reached its usage limit", promo.Code)
+		}
+	}
+
+	if promo.RepeatGuestsOnly {
+		var isRepeat bool
+		err := tx.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1 FROM bookings
+				WHERE guest_id_card = $1
+				AND booking_id != $2
+				AND booking_status IN ('confirmed', 'completed')
+				AND check_out_date <= CURRENT_DATE
+			)
+		`, req.GuestIDCard, bookingID).Scan(&isRepeat)
+		if err != nil {
+			return err
+		}
+
+		if !isRepeat {
+			return serviceError(http.StatusBadRequest, "promo code %q is only available to returning guests", promo.Code)
+		}
+	}
+
+	if req.BookingAmount == nil {
+		return serviceError(http.StatusBadRequest, "a booking amount is required to apply promo code %q", promo.Code)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO booking_discounts (
+			booking_id, promo_id, promo_code, discount_type, discount_value, discount_amount
+		) VALUES ($1, $2, $3, $4, $5, $6)
+	`, bookingID, promo.PromoID, promo.Code, promo.DiscountType, promo.DiscountValue,
+		discountAmount(promo.DiscountType, promo.DiscountValue, *req.BookingAmount))
+
+	return err
+}
+
+// recalculateDiscount refreshes the discount of a booking against its current
+// gross amount and returns it (zero when no promo code was redeemed)
+func recalculateDiscount(tx *sql.Tx, bookingID uuid.UUID, gross float64) (float64, error) {
+	var discountType string
+	var discountValue float64
+
+	err := tx.QueryRow(`
+		SELECT discount_type, discount_value FROM booking_discounts WHERE booking_id = $1
+	`, bookingID).Scan(&discountType, &discountValue)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	amount := discountAmount(discountType, discountValue, gross)
+
+	_, err = tx.Exec(`UPDATE booking_discounts SET discount_amount = $1 WHERE booking_id = $2`, amount, bookingID)
+	if err != nil {
+		return 0, err
+	}
+
+	return amount, nil
+}
+
+// Fixed discounts never exceed the gross amount
+func discountAmount(discountType string, value, gross float64) float64 {
+	var amount float64
+	if discountType == DiscountTypePercentage {
+		amount = gross * value / 100
+	} else {
+		amount = value
+	}
+
+	if amount > gross {
+		amount = gross
+	}
+
+	return roundMoney(amount)
+}
+
+// getBookingDiscount returns nil when no promo code was redeemed
+func (s *BookingService) getBookingDiscount(q queryer, bookingID uuid.UUID) (*BookingDiscount, error) {
+	query := `
+		SELECT promo_id, promo_code, discount_type, discount_value, discount_amount, created_at
+		FROM booking_discounts
+		WHERE booking_id = $1
+	`
+
+	var d BookingDiscount
+	err := q.QueryRow(query, bookingID).Scan(
+		&d.PromoID, &d.PromoCode, &d.DiscountType, &d.DiscountValue,
+		&d.DiscountAmount, &d.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// HTTP Handlers
+func (s *BookingService) GetPromoCodesHandler(w http.ResponseWriter, r *http.Request) {
+	propertyIDStr := r.URL.Query().Get("property_id")
+
+	var propertyID *uuid.UUID
+	if propertyIDStr != "" {
+		id, err := uuid.Parse(propertyIDStr)
+		if err != nil {
+			http.Error(w, "Invalid property ID", http.StatusBadRequest)
+			return
+		}
+		propertyID = &id
+	}
+
+	promos, err := s.GetPromoCodes(propertyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(promos)
+}
+
+func (s *BookingService) GetPromoCodeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	promoIDStr := vars["promoId"]
+
+	promoID, err := uuid.Parse(promoIDStr)
+	if err != nil {
+		http.Error(w, "Invalid promo ID", http.StatusBadRequest)
+		return
+	}
+
+	promo, err := s.GetPromoCode(promoID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(promo)
+}
+
+func (s *BookingService) CreatePromoCodeHandler(w http.ResponseWriter, r *http.Request) {
+	var req PromoCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	promo, err := s.CreatePromoCode(&req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(promo)
+}
+
+func (s *BookingService) UpdatePromoCodeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	promoIDStr := vars["promoId"]
+
+	promoID, err := uuid.Parse(promoIDStr)
+	if err != nil {
+		http.Error(w, "Invalid promo ID", http.StatusBadRequest)
+		return
+	}
+
+	var req PromoCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	promo, err := s.UpdatePromoCode(promoID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(promo)
+}
+
+func (s *BookingService) DeletePromoCodeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	promoIDStr := vars["promoId"]
+
+	promoID, err := uuid.Parse(promoIDStr)
+	if err != nil {
+		http.Error(w, "Invalid promo ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.DeletePromoCode(promoID); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
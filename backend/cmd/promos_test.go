@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Two bookings redeeming the last use of a promo code at the same time: the
+// promo row lock lets exactly one through and the other is answered with
+// 400. The bookings are for different properties, so that nothing but the
+// promo row lock can serialise them.
+func TestRedeemPromoCodeUsageLimitConcurrently(t *testing.T) {
+	s := newTestService(t)
+	properties := []uuid.UUID{createTestProperty(t, s), createTestProperty(t, s)}
+
+	maxUses := 2
+	promo, err := s.CreatePromoCode(&PromoCodeRequest{
+		Code:          "LAST-" + strings.ToUpper(uuid.New().String()[:8]),
+		DiscountType:  DiscountTypeFixed,
+		DiscountValue: 20,
+		MaxUses:       &maxUses,
+	})
+	if err != nil {
+		t.Fatalf("CreatePromoCode: %v", err)
+	}
+
+	amount := 300.0
+	book := func(propertyID uuid.UUID, checkIn, checkOut int) error {
+		_, err := s.CreateBooking(s.systemUserID, &CreateBookingRequest{
+			PropertyID:         propertyID,
+			GuestName:          "Promo Guest",
+			GuestIDCard:        "ID-5",
+			GuestContactNumber: "+94771234567",
+			CheckInDate:        testDate(checkIn).Format("2006-01-02"),
+			CheckOutDate:       testDate(checkOut).Format("2006-01-02"),
+			NumberOfGuests:     1,
+			BookingAmount:      &amount,
+			PromoCode:          &promo.Code,
+		})
+		return err
+	}
+
+	// The first use leaves one
+	if err := book(properties[0], 60, 62); err != nil {
+		t.Fatalf("CreateBooking: %v", err)
+	}
+
+	attempts := len(properties)
+
+	start := make(chan struct{})
+	errs := make([]error, attempts)
+
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+
+			errs[i] = book(properties[i], 70, 72)
+		}(i)
+	}
+
+	close(start)
+	wg.Wait()
+
+	var created int
+	for i, err := range errs {
+		if err == nil {
+			created++
+			continue
+		}
+
+		var promoErr *ServiceError
+		if !errors.As(err, &promoErr) {
+			t.Errorf("attempt %d: err = %v, want a ServiceError", i, err)
+			continue
+		}
+
+		rec := httptest.NewRecorder()
+		writeServiceError(rec, err)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("attempt %d: status = %d, want %d", i, rec.Code, http.StatusBadRequest)
+		}
+	}
+
+	if created != 1 {
+		t.Errorf("%d of %d bookings redeemed the last use, want exactly 1", created, attempts)
+	}
+
+	var redeemed int
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM booking_discounts WHERE promo_id = $1`, promo.PromoID).Scan(&redeemed)
+	if err != nil {
+		t.Fatalf("Failed to count redemptions: %v", err)
+	}
+	if redeemed != maxUses {
+		t.Errorf("promo code redeemed %d times, want %d", redeemed, maxUses)
+	}
+}
+
+func TestRedeemPromoCodeErrors(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	unknown := "UNKNOWN-" + strings.ToUpper(uuid.New().String()[:8])
+	_, err := s.CreateBooking(s.systemUserID, &CreateBookingRequest{
+		PropertyID:         propertyID,
+		GuestName:          "Promo Guest",
+		GuestIDCard:        "ID-6",
+		GuestContactNumber: "+94771234567",
+		CheckInDate:        testDate(80).Format("2006-01-02"),
+		CheckOutDate:       testDate(82).Format("2006-01-02"),
+		NumberOfGuests:     1,
+		PromoCode:          &unknown,
+	})
+
+	var promoErr *ServiceError
+	if !errors.As(err, &promoErr) || promoErr.Code != http.StatusBadRequest {
+		t.Fatalf("CreateBooking with an unknown code: err = %v, want a 400 ServiceError", err)
+	}
+
+	rec := httptest.NewRecorder()
+	writeServiceError(rec, err)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestPromoCodeChangeErrors(t *testing.T) {
+	s := newTestService(t)
+
+	code := "DUP-" + strings.ToUpper(uuid.New().String()[:8])
+	taken, err := s.CreatePromoCode(&PromoCodeRequest{Code: code, DiscountType: DiscountTypePercentage, DiscountValue: 10})
+	if err != nil {
+		t.Fatalf("CreatePromoCode: %v", err)
+	}
+	other, err := s.CreatePromoCode(&PromoCodeRequest{Code: code + "-2", DiscountType: DiscountTypePercentage, DiscountValue: 10})
+	if err != nil {
+		t.Fatalf("CreatePromoCode: %v", err)
+	}
+
+	unknownID := uuid.New()
+
+	// A nil promo ID creates the code, any other updates it
+	tests := []struct {
+		name    string
+		promoID *uuid.UUID
+		req     PromoCodeRequest
+		code    int
+	}{
+		{"no code", nil, PromoCodeRequest{DiscountType: DiscountTypeFixed, DiscountValue: 5}, http.StatusBadRequest},
+		{"percentage over 100", nil, PromoCodeRequest{Code: code + "-3", DiscountType: DiscountTypePercentage, DiscountValue: 150}, http.StatusBadRequest},
+		{"unknown discount type", nil, PromoCodeRequest{Code: code + "-3", DiscountType: "free", DiscountValue: 5}, http.StatusBadRequest},
+		{"duplicate code", nil, PromoCodeRequest{Code: strings.ToLower(code), DiscountType: DiscountTypeFixed, DiscountValue: 5}, http.StatusConflict},
+		{"renamed to a taken code", &other.PromoID, PromoCodeRequest{Code: taken.Code, DiscountType: DiscountTypeFixed, DiscountValue: 5}, http.StatusConflict},
+		{"unknown promo code", &unknownID, PromoCodeRequest{Code: code + "-4", DiscountType: DiscountTypeFixed, DiscountValue: 5}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		var err error
+		if tt.promoID == nil {
+			_, err = s.CreatePromoCode(&tt.req)
+		} else {
+			_, err = s.UpdatePromoCode(*tt.promoID, &tt.req)
+		}
+
+		var promoErr *ServiceError
+		if !errors.As(err, &promoErr) || promoErr.Code != tt.code {
+			t.Errorf("%s: err = %v, want a %d ServiceError", tt.name, err, tt.code)
+			continue
+		}
+
+		rec := httptest.NewRecorder()
+		writeServiceError(rec, err)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+
+	err = s.DeletePromoCode(uuid.New())
+	var promoErr *ServiceError
+	if !errors.As(err, &promoErr) || promoErr.Code != http.StatusNotFound {
+		t.Errorf("deleting an unknown promo code: err = %v, want a 404 ServiceError", err)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// PropertyRate is the nightly rate of a property for a date range. Like a
+// booking, EndDate is exclusive.
+type PropertyRate struct {
+	RateID      uuid.UUID `json:"rate_id"`
+	PropertyID  uuid.UUID `json:"property_id"`
+	StartDate   time.Time `json:"start_date"`
+	EndDate     time.Time `json:"end_date"`
+	NightlyRate float64   `json:"nightly_rate"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type PropertyRateRequest struct {
+	StartDate   string  `json:"start_date"` // "2024-01-15" format
+	EndDate     string  `json:"end_date"`   // "2024-01-20" format
+	NightlyRate float64 `json:"nightly_rate"`
+}
+
+func (req *PropertyRateRequest) parse() (time.Time, time.Time, error) {
+	startDate, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start date format: %v", err)
+	}
+
+	endDate, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end date format: %v", err)
+	}
+
+	if !endDate.After(startDate) {
+		return time.Time{}, time.Time{}, fmt.Errorf("end date must be after start date")
+	}
+
+	if req.NightlyRate <= 0 {
+		return time.Time{}, time.Time{}, fmt.Errorf("nightly_rate must be greater than zero")
+	}
+
+	return startDate, endDate, nil
+}
+
+// Set the nightly rate for a date range. Rate periods of a property cannot overlap.
+func (s *BookingService) CreatePropertyRate(propertyID uuid.UUID, req *PropertyRateRequest) (*PropertyRate, error) {
+	startDate, endDate, err := req.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	rateID := uuid.New()
+	query := `
+		INSERT INTO property_rates (rate_id, property_id, start_date, end_date, nightly_rate)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err = s.db.Exec(query, rateID, propertyID, startDate, endDate, roundMoney(req.NightlyRate))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetPropertyRate(rateID)
+}
+
+// Rate periods of a property overlapping the given range (inclusive)
+func (s *BookingService) GetPropertyRates(propertyID uuid.UUID, from, to time.Time) ([]PropertyRate, error) {
+	query := `
+		SELECT rate_id, property_id, start_date, end_date, nightly_rate, created_at, updated_at
+		FROM property_rates
+		WHERE property_id = $1
+		AND start_date <= $2 AND end_date > $3
+		ORDER BY start_date ASC
+	`
+
+	return s.queryPropertyRates(query, propertyID, to, from)
+}
+
+func (s *BookingService) GetPropertyRate(rateID uuid.UUID) (*PropertyRate, error) {
+	query := `
+		SELECT rate_id, property_id, start_date, end_date, nightly_rate, created_at, updated_at
+		FROM property_rates
+		WHERE rate_id = $1
+	`
+
+	rates, err := s.queryPropertyRates(query, rateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("rate not found")
+	}
+
+	return &rates[0], nil
+}
+
+func (s *BookingService) UpdatePropertyRate(rateID uuid.UUID, req *PropertyRateRequest) (*PropertyRate, error) {
+	startDate, endDate, err := req.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE property_rates
+		SET start_date = $1, end_date = $2, nightly_rate = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE rate_id = $4
+	`
+
+	result, err := s.db.Exec(query, startDate, endDate, roundMoney(req.NightlyRate), rateID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, fmt.Errorf("rate not found")
+	}
+
+	return s.GetPropertyRate(rateID)
+}
+
+func (s *BookingService) DeletePropertyRate(rateID uuid.UUID) error {
+	result, err := s.db.Exec(`DELETE FROM property_rates WHERE rate_id = $1`, rateID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("rate not found")
+	}
+
+	return nil
+}
+
+func (s *BookingService) queryPropertyRates(query string, args ...interface{}) ([]PropertyRate, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []PropertyRate
+
+	for rows.Next() {
+		var rate PropertyRate
+		err := rows.Scan(
+			&rate.RateID, &rate.PropertyID, &rate.StartDate, &rate.EndDate,
+			&rate.NightlyRate, &rate.CreatedAt, &rate.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		rates = append(rates, rate)
+	}
+
+	return rates, nil
+}
+
+// nightlyRates maps each night between from and to (inclusive) that has a
+// rate to that rate, keyed by "2006-01-02". Nights without a rate are absent.
+func (s *BookingService) nightlyRates(propertyID uuid.UUID, from, to time.Time) (map[string]float64, error) {
+	rates, err := s.GetPropertyRates(propertyID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	nightly := make(map[string]float64)
+	for _, rate := range rates {
+		for d := rate.StartDate; d.Before(rate.EndDate); d = d.AddDate(0, 0, 1) {
+			if d.Before(from) || d.After(to) {
+				continue
+			}
+			nightly[d.Format("2006-01-02")] = rate.NightlyRate
+		}
+	}
+
+	return nightly, nil
+}
+
+// quoteStay sums the nightly rates from checkIn up to, not including,
+// checkOut. ok is false when a night has no rate.
+func (s *BookingService) quoteStay(propertyID uuid.UUID, checkIn, checkOut time.Time) (float64, bool, error) {
+	rates, err := s.nightlyRates(propertyID, checkIn, checkOut.AddDate(0, 0, -1))
+	if err != nil {
+		return 0, false, err
+	}
+
+	if len(rates) < stayNights(checkIn, checkOut) {
+		return 0, false, nil
+	}
+
+	total := 0.0
+	for _, rate := range rates {
+		total += rate
+	}
+
+	return roundMoney(total), true, nil
+}
+
+// HTTP Handlers
+func (s *BookingService) GetPropertyRatesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+	fromStr := r.URL.Query().Get("from")
+	toStr := r.URL.Query().Get("to")
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	// Default: from today for the next 12 months
+	from := time.Now().UTC().Truncate(24 * time.Hour)
+	to := from.AddDate(1, 0, 0)
+
+	if fromStr != "" {
+		from, err = time.Parse("2006-01-02", fromStr)
+		if err != nil {
+			http.Error(w, "Invalid from format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if toStr != "" {
+		to, err = time.Parse("2006-01-02", toStr)
+		if err != nil {
+			http.Error(w, "Invalid to format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rates, err := s.GetPropertyRates(propertyID, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rates)
+}
+
+func (s *BookingService) CreatePropertyRateHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	var req PropertyRateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rate, err := s.CreatePropertyRate(propertyID, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rate)
+}
+
+func (s *BookingService) UpdatePropertyRateHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	rateIDStr := vars["rateId"]
+
+	rateID, err := uuid.Parse(rateIDStr)
+	if err != nil {
+		http.Error(w, "Invalid rate ID", http.StatusBadRequest)
+		return
+	}
+
+	var req PropertyRateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rate, err := s.UpdatePropertyRate(rateID, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rate)
+}
+
+func (s *BookingService) DeletePropertyRateHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	rateIDStr := vars["rateId"]
+
+	rateID, err := uuid.Parse(rateIDStr)
+	if err != nil {
+		http.Error(w, "Invalid rate ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.DeletePropertyRate(rateID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type RevenueReport struct {
+	From       time.Time         `json:"from"`
+	To         time.Time         `json:"to"`
+	Properties []PropertyRevenue `json:"properties"`
+	Totals     RevenueTotals     `json:"totals"`
+}
+
+type PropertyRevenue struct {
+	PropertyID   uuid.UUID       `json:"property_id"`
+	PropertyName string          `json:"property_name"`
+	Bookings     int             `json:"bookings"`
+	Nights       int             `json:"nights"`
+	Totals       RevenueTotals   `json:"totals"`
+	Charges      []ChargeSummary `json:"charges,omitempty"`
+}
+
+type RevenueTotals struct {
+	AccommodationAmount float64 `json:"accommodation_amount"`
+	DiscountAmount      float64 `json:"discount_amount"`
+	TaxAmount           float64 `json:"tax_amount"`
+	FeeAmount           float64 `json:"fee_amount"`
+	TotalAmount         float64 `json:"total_amount"`
+}
+
+type ChargeSummary struct {
+	RuleName   string  `json:"rule_name"`
+	ChargeType string  `json:"charge_type"`
+	Amount     float64 `json:"amount"`
+}
+
+// Revenue for bookings checking in between from and to (inclusive), broken
+// down by gross accommodation, discounts, taxes and fees
+func (s *BookingService) GetRevenueReport(propertyID *uuid.UUID, from, to time.Time) (*RevenueReport, error) {
+	query := `
+		SELECT p.property_id, p.property_name, COUNT(b.booking_id),
+			COALESCE(SUM(b.total_nights), 0), COALESCE(SUM(b.booking_amount), 0),
+			COALESCE(SUM(d.discount_amount), 0)
+		FROM bookings b
+		JOIN properties p ON p.property_id = b.property_id
+		LEFT JOIN booking_discounts d ON d.booking_id = b.booking_id
+		WHERE b.booking_status IN ('confirmed', 'pending', 'completed')
+		AND b.check_in_date >= $1 AND b.check_in_date <= $2
+		AND ($3::UUID IS NULL OR b.property_id = $3)
+		GROUP BY p.property_id, p.property_name
+		ORDER BY p.property_name
+	`
+
+	rows, err := s.db.Query(query, from, to, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &RevenueReport{From: from, To: to, Properties: []PropertyRevenue{}}
+	index := make(map[uuid.UUID]int)
+
+	for rows.Next() {
+		var line PropertyRevenue
+		err := rows.Scan(&line.PropertyID, &line.PropertyName, &line.Bookings,
+			&line.Nights, &line.Totals.AccommodationAmount, &line.Totals.DiscountAmount)
+		if err != nil {
+			return nil, err
+		}
+
+		index[line.PropertyID] = len(report.Properties)
+		report.Properties = append(report.Properties, line)
+	}
+	rows.Close()
+
+	chargeQuery := `
+		SELECT b.property_id, c.rule_name, c.charge_type, SUM(c.amount)
+		FROM booking_charges c
+		JOIN bookings b ON b.booking_id = c.booking_id
+		WHERE b.booking_status IN ('confirmed', 'pending', 'completed')
+		AND b.check_in_date >= $1 AND b.check_in_date <= $2
+		AND ($3::UUID IS NULL OR b.property_id = $3)
+		GROUP BY b.property_id, c.rule_name, c.charge_type
+		ORDER BY c.charge_type DESC, c.rule_name
+	`
+
+	chargeRows, err := s.db.Query(chargeQuery, from, to, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	defer chargeRows.Close()
+
+	for chargeRows.Next() {
+		var id uuid.UUID
+		var charge ChargeSummary
+		if err := chargeRows.Scan(&id, &charge.RuleName, &charge.ChargeType, &charge.Amount); err != nil {
+			return nil, err
+		}
+
+		i, ok := index[id]
+		if !ok {
+			continue
+		}
+
+		line := &report.Properties[i]
+		line.Charges = append(line.Charges, charge)
+		if charge.ChargeType == ChargeTypeTax {
+			line.Totals.TaxAmount += charge.Amount
+		} else {
+			line.Totals.FeeAmount += charge.Amount
+		}
+	}
+
+	for i := range report.Properties {
+		line := &report.Properties[i]
+		line.Totals.TotalAmount = roundMoney(line.Totals.AccommodationAmount - line.Totals.DiscountAmount +
+			line.Totals.TaxAmount + line.Totals.FeeAmount)
+
+		report.Totals.AccommodationAmount += line.Totals.AccommodationAmount
+		report.Totals.DiscountAmount += line.Totals.DiscountAmount
+		report.Totals.TaxAmount += line.Totals.TaxAmount
+		report.Totals.FeeAmount += line.Totals.FeeAmount
+		report.Totals.TotalAmount += line.Totals.TotalAmount
+	}
+
+	report.Totals.AccommodationAmount = roundMoney(report.Totals.AccommodationAmount)
+	report.Totals.DiscountAmount = roundMoney(report.Totals.DiscountAmount)
+	report.Totals.TaxAmount = roundMoney(report.Totals.TaxAmount)
+	report.Totals.FeeAmount = roundMoney(report.Totals.FeeAmount)
+	report.Totals.TotalAmount = roundMoney(report.Totals.TotalAmount)
+
+	return report, nil
+}
+
+// HTTP Handlers
+func (s *BookingService) GetRevenueReportHandler(w http.ResponseWriter, r *http.Request) {
+	fromStr := r.URL.Query().Get("from")
+	toStr := r.URL.Query().Get("to")
+	propertyIDStr := r.URL.Query().Get("property_id")
+
+	var propertyID *uuid.UUID
+	if propertyIDStr != "" {
+		id, err := uuid.Parse(propertyIDStr)
+		if err != nil {
+			http.Error(w, "Invalid property ID", http.StatusBadRequest)
+			return
+		}
+		propertyID = &id
+	}
+
+	// Default: the current month
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, -1)
+
+	var err error
+	if fromStr != "" {
+		from, err = time.Parse("2006-01-02", fromStr)
+		if err != nil {
+			http.Error(w, "Invalid from format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if toStr != "" {
+		to, err = time.Parse("2006-01-02", toStr)
+		if err != nil {
+			http.Error(w, "Invalid to format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if to.Before(from) {
+		http.Error(w, "to must not be before from", http.StatusBadRequest)
+		return
+	}
+
+	report, err := s.GetRevenueReport(propertyID, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,24 @@
+package main
+
+import "time"
+
+// Failed webhook deliveries, guest notifications, event sinks and channel
+// syncs are tried again after a minute, with the wait doubling after every
+// further failure up to retryMaxDelay
+const (
+	retryBaseDelay = time.Minute
+	retryMaxDelay  = 6 * time.Hour
+)
+
+// retryDelay is the wait before trying again after the given number of
+// failed attempts in a row
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SMSProvider sends text messages to phone numbers in E.164 format
+type SMSProvider interface {
+	SendMessage(to string, body string) error
+}
+
+// twilioSMSProvider sends messages through a Twilio-style HTTP API:
+//
+//	POST {base}/2010-04-01/Accounts/{sid}/Messages.json   To, From, Body
+//
+// authenticated with the account SID and auth token. With whatsApp set the
+// numbers are prefixed "whatsapp:" so the messages go out over WhatsApp.
+type twilioSMSProvider struct {
+	baseURL    string
+	accountSID string
+	authToken  string
+	from       string
+	whatsApp   bool
+	client     *http.Client
+}
+
+func newTwilioSMSProvider(baseURL, accountSID, authToken, from string, whatsApp bool) *twilioSMSProvider {
+	return &twilioSMSProvider{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		whatsApp:   whatsApp,
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *twilioSMSProvider) SendMessage(to string, body string) error {
+	from := p.from
+	if p.whatsApp {
+		from = "whatsapp:" + from
+		to = "whatsapp:" + to
+	}
+
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", from)
+	form.Set("Body", body)
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", p.baseURL, url.PathEscape(p.accountSID))
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(p.accountSID, p.authToken)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("SMS provider returned %s: %s", resp.Status, apiErr.Message)
+		}
+		return fmt.Errorf("SMS provider returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// stubSMSProvider writes messages to the log instead of sending them, for
+// development
+type stubSMSProvider struct{}
+
+func (stubSMSProvider) SendMessage(to string, body string) error {
+	log.Printf("SMS to %s: %s", to, body)
+	return nil
+}
+
+// SetSMSProvider sets the provider text messages are sent through. Without
+// one, guests are not sent text messages.
+func (s *BookingService) SetSMSProvider(provider SMSProvider) {
+	s.smsProvider = provider
+}
+
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+
+// normalizePhoneNumber turns a phone number as typed into E.164 format,
+// dropping spaces, dashes, dots and brackets. "00" is read as the
+// international prefix, and numbers written nationally (with a leading 0
+// or none) get the default country code, if there is one.
+func normalizePhoneNumber(number, defaultCountryCode string) (string, error) {
+	normalized := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')', '/':
+			return -1
+		}
+		return r
+	}, number)
+
+	switch {
+	case strings.HasPrefix(normalized, "+"):
+	case strings.HasPrefix(normalized, "00"):
+		normalized = "+" + normalized[2:]
+	case defaultCountryCode != "":
+		normalized = "+" + strings.TrimPrefix(defaultCountryCode, "+") + strings.TrimPrefix(normalized, "0")
+	default:
+		return "", fmt.Errorf("phone number %q must be in international format, e.g. +94771234567", number)
+	}
+
+	if !e164Pattern.MatchString(normalized) {
+		return "", fmt.Errorf("invalid phone number %q", number)
+	}
+
+	return normalized, nil
+}
+
+// normalizeContactNumber normalizes a guest's phone number with the
+// service's default country code. Numbers that can't be normalized, such as
+// national numbers when there is no default country code, are kept as given
+// and the guest is not texted.
+func (s *BookingService) normalizeContactNumber(number string) string {
+	number = strings.TrimSpace(number)
+	if normalized, err := normalizePhoneNumber(number, s.defaultCountryCode); err == nil {
+		return normalized
+	}
+	return number
+}
+
+// NotificationOptOut is a phone number that must not be sent text messages
+type NotificationOptOut struct {
+	PhoneNumber string    `json:"phone_number"`
+	Reason      *string   `json:"reason,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type CreateNotificationOptOutRequest struct {
+	PhoneNumber string  `json:"phone_number"`
+	Reason      *string `json:"reason,omitempty"`
+}
+
+// Stop text messages to a phone number. Messages already queued for it
+// are skipped when their turn comes.
+func (s *BookingService) CreateNotificationOptOut(req *CreateNotificationOptOutRequest) (*NotificationOptOut, error) {
+	phoneNumber, err := normalizePhoneNumber(req.PhoneNumber, s.defaultCountryCode)
+	if err != nil {
+		return nil, serviceError(http.StatusBadRequest, "%v", err)
+	}
+
+	var optOut NotificationOptOut
+	err = s.db.QueryRow(`
+		INSERT INTO notification_opt_outs (phone_number, reason)
+		VALUES ($1, $2)
+		ON CONFLICT (phone_number) DO UPDATE SET reason = EXCLUDED.reason
+		RETURNING phone_number, reason, created_at
+	`, phoneNumber, req.Reason).Scan(&optOut.PhoneNumber, &optOut.Reason, &optOut.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &optOut, nil
+}
+
+func (s *BookingService) GetNotificationOptOuts() ([]NotificationOptOut, error) {
+	rows, err := s.db.Query(`
+		SELECT phone_number, reason, created_at
+		FROM notification_opt_outs
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	optOuts := []NotificationOptOut{}
+
+	for rows.Next() {
+		var optOut NotificationOptOut
+		if err := rows.Scan(&optOut.PhoneNumber, &optOut.Reason, &optOut.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		optOuts = append(optOuts, optOut)
+	}
+
+	return optOuts, nil
+}
+
+// Allow text messages to a phone number again
+func (s *BookingService) DeleteNotificationOptOut(phoneNumber string) error {
+	normalized, err := normalizePhoneNumber(phoneNumber, s.defaultCountryCode)
+	if err != nil {
+		return serviceError(http.StatusBadRequest, "%v", err)
+	}
+
+	result, err := s.db.Exec(`DELETE FROM notification_opt_outs WHERE phone_number = $1`, normalized)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return serviceError(http.StatusNotFound, "phone number has not opted out")
+	}
+
+	return nil
+}
+
+// HTTP Handlers
+func (s *BookingService) GetNotificationOptOutsHandler(w http.ResponseWriter, r *http.Request) {
+	optOuts, err := s.GetNotificationOptOuts()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(optOuts)
+}
+
+func (s *BookingService) CreateNotificationOptOutHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateNotificationOptOutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	optOut, err := s.CreateNotificationOptOut(&req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(optOut)
+}
+
+func (s *BookingService) DeleteNotificationOptOutHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	phoneNumber := vars["phoneNumber"]
+
+	if err := s.DeleteNotificationOptOut(phoneNumber); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
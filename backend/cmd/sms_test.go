@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestNormalizeContactNumber(t *testing.T) {
+	tests := []struct {
+		number             string
+		defaultCountryCode string
+		want               string
+	}{
+		{"+94 77 123 4567", "", "+94771234567"},
+		{"0094-77-123-4567", "", "+94771234567"},
+		{"077 123 4567", "94", "+94771234567"},
+		{"(077) 123-4567", "+94", "+94771234567"},
+
+		// Kept as given, and not texted, when they can't be normalized
+		{"0771234567", "", "0771234567"},
+		{" 077 123 4567 ", "", "077 123 4567"},
+		{"ext. 12", "94", "ext. 12"},
+		{"", "94", ""},
+	}
+
+	for _, tt := range tests {
+		s := &BookingService{defaultCountryCode: tt.defaultCountryCode}
+		if got := s.normalizeContactNumber(tt.number); got != tt.want {
+			t.Errorf("normalizeContactNumber(%q) with country code %q = %q, want %q", tt.number, tt.defaultCountryCode, got, tt.want)
+		}
+	}
+}
+
+func TestNotificationOptOutErrors(t *testing.T) {
+	s := newTestService(t)
+	s.defaultCountryCode = ""
+
+	call := func(_ interface{}, err error) error { return err }
+
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"opt out a national number", call(s.CreateNotificationOptOut(&CreateNotificationOptOutRequest{PhoneNumber: "0771234567"})), http.StatusBadRequest},
+		{"opt out a bad number", call(s.CreateNotificationOptOut(&CreateNotificationOptOutRequest{PhoneNumber: "+12"})), http.StatusBadRequest},
+		{"opt in a bad number", s.DeleteNotificationOptOut("ext. 12"), http.StatusBadRequest},
+		{"opt in a number never opted out", s.DeleteNotificationOptOut("+94779999999"), http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		var optOutErr *ServiceError
+		if !errors.As(tt.err, &optOutErr) || optOutErr.Code != tt.code {
+			t.Errorf("%s: err = %v, want a %d ServiceError", tt.name, tt.err, tt.code)
+		}
+	}
+}
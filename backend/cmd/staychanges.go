@@ -0,0 +1,397 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// ChangeStayRequest moves the check-in and/or check-out date of a booking.
+// With Reprice, added nights are charged at the property's nightly rates and
+// removed nights refunded at the booking's average nightly price.
+type ChangeStayRequest struct {
+	CheckInDate  *string `json:"check_in_date,omitempty"`  // "2024-01-15" format
+	CheckOutDate *string `json:"check_out_date,omitempty"` // "2024-01-20" format
+	Reprice      bool    `json:"reprice"`
+}
+
+// SplitBookingRequest splits a booking into two consecutive bookings, the
+// second starting on SplitDate
+type SplitBookingRequest struct {
+	SplitDate string `json:"split_date"` // "2024-01-17" format
+	Reason    string `json:"reason"`
+}
+
+type BookingSplit struct {
+	Booking      *Booking `json:"booking"`
+	Continuation *Booking `json:"continuation"`
+}
+
+// bookingStay is the part of a booking a date change needs
+type bookingStay struct {
+	propertyID uuid.UUID
+	unitID     *uuid.UUID
+	checkIn    time.Time
+	checkOut   time.Time
+	amount     sql.NullFloat64
+}
+
+func lockBookingStay(tx *sql.Tx, bookingID uuid.UUID) (*bookingStay, error) {
+	var stay bookingStay
+	err := tx.QueryRow(`
+		SELECT property_id, unit_id, check_in_date, check_out_date, booking_amount
+		FROM bookings
+		WHERE booking_id = $1
+		AND booking_status IN ('confirmed', 'pending')
+		FOR UPDATE
+	`, bookingID).Scan(&stay.propertyID, &stay.unitID, &stay.checkIn, &stay.checkOut, &stay.amount)
+	if err == sql.ErrNoRows {
+		var status string
+		err := tx.QueryRow(`SELECT booking_status FROM bookings WHERE booking_id = $1`, bookingID).Scan(&status)
+		if err == sql.ErrNoRows {
+			return nil, serviceError(http.StatusNotFound, "booking not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, serviceError(http.StatusBadRequest, "a %s booking cannot be changed", status)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &stay, nil
+}
+
+// checkNewBookingDates validates new dates of an existing booking, where only
+// one of them may be given, against its other date and, if checkRules, the
+// property's stay rules
+func (s *BookingService) checkNewBookingDates(tx *sql.Tx, bookingID uuid.UUID, checkIn, checkOut *time.Time, checkRules bool) error {
+	var propertyID uuid.UUID
+	var currentCheckIn, currentCheckOut time.Time
+
+	err := tx.QueryRow(`
+		SELECT property_id, check_in_date, check_out_date FROM bookings WHERE booking_id = $1
+	`, bookingID).Scan(&propertyID, &currentCheckIn, &currentCheckOut)
+	if err == sql.ErrNoRows {
+		return serviceError(http.StatusNotFound, "booking not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	newArrival := checkIn != nil && !checkIn.Equal(currentCheckIn)
+	if checkIn == nil {
+		checkIn = &currentCheckIn
+	}
+	if checkOut == nil {
+		checkOut = &currentCheckOut
+	}
+
+	if !checkOut.After(*checkIn) {
+		return serviceError(http.StatusBadRequest, "check-out date %s must be after check-in date %s",
+			checkOut.Format("2006-01-02"), checkIn.Format("2006-01-02"))
+	}
+
+	if !checkRules {
+		return nil
+	}
+
+	return s.checkStayRules(tx, propertyID, *checkIn, *checkOut, newArrival)
+}
+
+// Extend or shorten a stay. The new dates are checked against each other,
+// the stay rules and other bookings before anything changes.
+func (s *BookingService) ChangeStay(bookingID uuid.UUID, req *ChangeStayRequest) (*Booking, error) {
+	if req.CheckInDate == nil && req.CheckOutDate == nil {
+		return nil, serviceError(http.StatusBadRequest, "check_in_date or check_out_date is required")
+	}
+
+	var newCheckIn, newCheckOut *time.Time
+	if req.CheckInDate != nil {
+		checkIn, err := time.Parse("2006-01-02", *req.CheckInDate)
+		if err != nil {
+			return nil, serviceError(http.StatusBadRequest, "invalid check-in date format: %v", err)
+		}
+		newCheckIn = &checkIn
+	}
+	if req.CheckOutDate != nil {
+		checkOut, err := time.Parse("2006-01-02", *req.CheckOutDate)
+		if err != nil {
+			return nil, serviceError(http.StatusBadRequest, "invalid check-out date format: %v", err)
+		}
+		newCheckOut = &checkOut
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stay, err := lockBookingStay(tx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkNewBookingDates(tx, bookingID, newCheckIn, newCheckOut, true); err != nil {
+		return nil, err
+	}
+
+	checkIn, checkOut := stay.checkIn, stay.checkOut
+	if newCheckIn != nil {
+		checkIn = *newCheckIn
+	}
+	if newCheckOut != nil {
+		checkOut = *newCheckOut
+	}
+
+	amount := moveAmount(nil, stay.amount)
+	if req.Reprice {
+		amount, err = s.repriceStay(stay, checkIn, checkOut)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE bookings
+		SET check_in_date = $1, check_out_date = $2, booking_amount = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE booking_id = $4
+	`, checkIn, checkOut, amount, bookingID)
+	if err != nil {
+		return nil, s.asBookingUpdateConflict(err, bookingID, &checkIn, &checkOut, nil)
+	}
+
+	// Long stays are always priced by the month
+	if err = s.repriceLongStay(tx, bookingID); err != nil {
+		return nil, err
+	}
+
+	if err = s.applyCharges(tx, bookingID); err != nil {
+		return nil, err
+	}
+
+	changes := []string{"booking_amount"}
+	if req.CheckInDate != nil {
+		changes = append(changes, "check_in_date")
+	}
+	if req.CheckOutDate != nil {
+		changes = append(changes, "check_out_date")
+	}
+
+	err = s.recordBookingEvent(tx, EventBookingUpdated, bookingID, &BookingEventPayload{Changes: changes})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetBookingByID(bookingID)
+}
+
+// repriceStay works out the amount of a stay moved to new dates: nights kept
+// keep their share of the current amount and added nights are charged at
+// the nightly rates. A booking without an amount is priced from the rates.
+func (s *BookingService) repriceStay(stay *bookingStay, checkIn, checkOut time.Time) (*float64, error) {
+	if !stay.amount.Valid {
+		return s.priceStay(stay.propertyID, checkIn, checkOut)
+	}
+
+	perNight := stay.amount.Float64 / float64(stayNights(stay.checkIn, stay.checkOut))
+	total := 0.0
+
+	for d := checkIn; d.Before(checkOut); d = d.AddDate(0, 0, 1) {
+		if !d.Before(stay.checkIn) && d.Before(stay.checkOut) {
+			total += perNight
+		}
+	}
+
+	// Nights before the old check-in and after the old check-out
+	added := [][2]time.Time{}
+	if checkIn.Before(stay.checkIn) {
+		added = append(added, [2]time.Time{checkIn, minDate(stay.checkIn, checkOut)})
+	}
+	if checkOut.After(stay.checkOut) {
+		added = append(added, [2]time.Time{maxDate(stay.checkOut, checkIn), checkOut})
+	}
+
+	for _, nights := range added {
+		price, err := s.priceStay(stay.propertyID, nights[0], nights[1])
+		if err != nil {
+			return nil, err
+		}
+		total += *price
+	}
+
+	total = roundMoney(total)
+	return &total, nil
+}
+
+// Split a booking into two consecutive bookings for the same guests and
+// property, for example to bill or assign the two parts separately. The
+// amount is shared by nights.
+func (s *BookingService) SplitBooking(bookingID uuid.UUID, userID uuid.UUID, req *SplitBookingRequest) (*BookingSplit, error) {
+	splitDate, err := time.Parse("2006-01-02", req.SplitDate)
+	if err != nil {
+		return nil, serviceError(http.StatusBadRequest, "invalid split date format: %v", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stay, err := lockBookingStay(tx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !splitDate.After(stay.checkIn) || !splitDate.Before(stay.checkOut) {
+		return nil, serviceError(http.StatusBadRequest, "split date must be after the check-in date and before the check-out date")
+	}
+
+	var firstAmount, secondAmount *float64
+	if stay.amount.Valid {
+		second := roundMoney(stay.amount.Float64 * float64(stayNights(splitDate, stay.checkOut)) / float64(stayNights(stay.checkIn, stay.checkOut)))
+		first := roundMoney(stay.amount.Float64 - second)
+		firstAmount, secondAmount = &first, &second
+	}
+
+	_, err = tx.Exec(`
+		UPDATE bookings
+		SET check_out_date = $1, booking_amount = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE booking_id = $3
+	`, splitDate, firstAmount, bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	continuationID, err := copyBooking(tx, bookingID, stay.propertyID, stay.unitID, splitDate, stay.checkOut, secondAmount)
+	if err != nil {
+		return nil, s.asBookingConflict(err, stay.propertyID, stay.unitID, splitDate, stay.checkOut, &bookingID, nil)
+	}
+
+	for _, id := range []uuid.UUID{bookingID, continuationID} {
+		if err = s.applyCharges(tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	newValues, _ := json.Marshal(map[string]interface{}{
+		"split_date":              splitDate.Format("2006-01-02"),
+		"continuation_booking_id": continuationID,
+	})
+
+	_, err = tx.Exec(`
+		INSERT INTO booking_history (booking_id, modified_by, modification_type, new_values, modification_notes)
+		VALUES ($1, $2, 'split', $3, $4)
+	`, bookingID, userID, newValues, req.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.recordBookingEvent(tx, EventBookingUpdated, bookingID, &BookingEventPayload{
+		Changes: []string{"check_out_date", "booking_amount"},
+		Cause:   EventCauseSplit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.recordBookingEvent(tx, EventBookingCreated, continuationID, &BookingEventPayload{Cause: EventCauseSplit})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	split := &BookingSplit{}
+	if split.Booking, err = s.GetBookingByID(bookingID); err != nil {
+		return nil, err
+	}
+	if split.Continuation, err = s.GetBookingByID(continuationID); err != nil {
+		return nil, err
+	}
+
+	return split, nil
+}
+
+func minDate(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxDate(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// HTTP Handlers
+func (s *BookingService) ChangeStayHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingIDStr := vars["bookingId"]
+
+	bookingID, err := uuid.Parse(bookingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid booking ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ChangeStayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	booking, err := s.ChangeStay(bookingID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(booking)
+}
+
+func (s *BookingService) SplitBookingHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookingIDStr := vars["bookingId"]
+
+	bookingID, err := uuid.Parse(bookingIDStr)
+	if err != nil {
+		http.Error(w, "Invalid booking ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SplitBookingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := s.requestUserID(r)
+
+	split, err := s.SplitBooking(bookingID, userID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(split)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Stays kept within their old dates are repriced from their own amount,
+// without looking up any rate
+func TestRepriceStayKeptNights(t *testing.T) {
+	s := &BookingService{}
+
+	tests := []struct {
+		amount            float64
+		checkIn, checkOut string
+		newIn, newOut     string
+		want              float64
+	}{
+		{300, "2026-06-01", "2026-06-04", "2026-06-01", "2026-06-04", 300},
+		{300, "2026-06-01", "2026-06-04", "2026-06-01", "2026-06-03", 200},
+		{300, "2026-06-01", "2026-06-04", "2026-06-02", "2026-06-04", 200},
+		{300, "2026-06-01", "2026-06-04", "2026-06-02", "2026-06-03", 100},
+		{100, "2026-06-01", "2026-06-04", "2026-06-01", "2026-06-03", 66.67},
+		{100, "2026-06-01", "2026-06-04", "2026-06-03", "2026-06-04", 33.33},
+		{250, "2026-06-01", "2026-06-05", "2026-06-02", "2026-06-03", 62.5},
+		{0, "2026-06-01", "2026-06-04", "2026-06-02", "2026-06-03", 0},
+	}
+
+	for _, tt := range tests {
+		stay := &bookingStay{
+			propertyID: uuid.New(),
+			checkIn:    parseTestDate(t, tt.checkIn),
+			checkOut:   parseTestDate(t, tt.checkOut),
+			amount:     sql.NullFloat64{Float64: tt.amount, Valid: true},
+		}
+
+		got, err := s.repriceStay(stay, parseTestDate(t, tt.newIn), parseTestDate(t, tt.newOut))
+		if err != nil {
+			t.Errorf("repriceStay(%.2f for %s-%s to %s-%s): %v", tt.amount, tt.checkIn, tt.checkOut, tt.newIn, tt.newOut, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("repriceStay(%.2f for %s-%s to %s-%s) = %.2f, want %.2f",
+				tt.amount, tt.checkIn, tt.checkOut, tt.newIn, tt.newOut, *got, tt.want)
+		}
+	}
+}
+
+// Nights outside the old dates are charged at the nightly rates
+func TestRepriceStayAddedNights(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	_, err := s.CreatePropertyRate(propertyID, &PropertyRateRequest{
+		StartDate:   testDate(0).Format("2006-01-02"),
+		EndDate:     testDate(30).Format("2006-01-02"),
+		NightlyRate: 120,
+	})
+	if err != nil {
+		t.Fatalf("CreatePropertyRate: %v", err)
+	}
+
+	// The stay was booked for nights 10-12 at 100 a night
+	tests := []struct {
+		name          string
+		amount        *float64
+		newIn, newOut int
+		want          float64
+		valid         bool
+	}{
+		{"extended", floatPtr(300), 10, 15, 300 + 2*120, true},
+		{"arriving earlier", floatPtr(300), 8, 13, 2*120 + 300, true},
+		{"both sides", floatPtr(300), 9, 14, 120 + 300 + 120, true},
+		{"shifted later", floatPtr(300), 11, 15, 2*100 + 2*120, true},
+		{"shifted earlier", floatPtr(300), 8, 12, 2*120 + 2*100, true},
+		{"moved away", floatPtr(300), 20, 23, 3 * 120, true},
+		{"no amount", nil, 10, 15, 5 * 120, true},
+		{"night without a rate", floatPtr(300), 10, 31, 0, false},
+	}
+
+	for _, tt := range tests {
+		stay := &bookingStay{propertyID: propertyID, checkIn: testDate(10), checkOut: testDate(13)}
+		if tt.amount != nil {
+			stay.amount = sql.NullFloat64{Float64: *tt.amount, Valid: true}
+		}
+
+		got, err := s.repriceStay(stay, testDate(tt.newIn), testDate(tt.newOut))
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: repriceStay error = %v, want valid %v", tt.name, err, tt.valid)
+			continue
+		}
+		if tt.valid && *got != tt.want {
+			t.Errorf("%s: repriceStay = %.2f, want %.2f", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func TestChangeStayErrors(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	bookingID := createTestBooking(t, s, propertyID, testDate(30), testDate(33))
+	cancelledID := createTestBooking(t, s, propertyID, testDate(40), testDate(43))
+	if err := s.CancelBooking(cancelledID, s.systemUserID); err != nil {
+		t.Fatalf("CancelBooking: %v", err)
+	}
+
+	badDate := "next week"
+	early := testDate(29).Format("2006-01-02")
+	later := testDate(35).Format("2006-01-02")
+
+	call := func(_ interface{}, err error) error { return err }
+
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"change without dates", call(s.ChangeStay(bookingID, &ChangeStayRequest{})), http.StatusBadRequest},
+		{"bad check-in date", call(s.ChangeStay(bookingID, &ChangeStayRequest{CheckInDate: &badDate})), http.StatusBadRequest},
+		{"bad check-out date", call(s.ChangeStay(bookingID, &ChangeStayRequest{CheckOutDate: &badDate})), http.StatusBadRequest},
+		{"check-out before check-in", call(s.ChangeStay(bookingID, &ChangeStayRequest{CheckOutDate: &early})), http.StatusBadRequest},
+		{"change of an unknown booking", call(s.ChangeStay(uuid.New(), &ChangeStayRequest{CheckOutDate: &later})), http.StatusNotFound},
+		{"change of a cancelled booking", call(s.ChangeStay(cancelledID, &ChangeStayRequest{CheckOutDate: &later})), http.StatusBadRequest},
+		{"bad split date", call(s.SplitBooking(bookingID, s.systemUserID, &SplitBookingRequest{SplitDate: badDate})), http.StatusBadRequest},
+		{"split date outside the stay", call(s.SplitBooking(bookingID, s.systemUserID, &SplitBookingRequest{SplitDate: later})), http.StatusBadRequest},
+		{"split of an unknown booking", call(s.SplitBooking(uuid.New(), s.systemUserID, &SplitBookingRequest{SplitDate: later})), http.StatusNotFound},
+		{"split of a cancelled booking", call(s.SplitBooking(cancelledID, s.systemUserID, &SplitBookingRequest{SplitDate: later})), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		var stayErr *ServiceError
+		if !errors.As(tt.err, &stayErr) || stayErr.Code != tt.code {
+			t.Errorf("%s: err = %v, want a %d ServiceError", tt.name, tt.err, tt.code)
+			continue
+		}
+
+		rec := httptest.NewRecorder()
+		writeServiceError(rec, tt.err)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,665 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// StayRules are the booking rules of a property that apply all year.
+// Weekdays are numbered from 0 (Sunday) to 6 (Saturday); an empty list
+// allows every day.
+type StayRules struct {
+	PropertyID        uuid.UUID `json:"property_id"`
+	MinNights         int       `json:"min_nights"`
+	MaxNights         *int      `json:"max_nights,omitempty"`
+	ArrivalWeekdays   []int64   `json:"arrival_weekdays"`
+	DepartureWeekdays []int64   `json:"departure_weekdays"`
+	MinLeadDays       int       `json:"min_lead_days"`
+	MaxAdvanceDays    *int      `json:"max_advance_days,omitempty"`
+}
+
+type UpdateStayRulesRequest struct {
+	MinNights         int     `json:"min_nights"`
+	MaxNights         *int    `json:"max_nights,omitempty"`
+	ArrivalWeekdays   []int64 `json:"arrival_weekdays"`
+	DepartureWeekdays []int64 `json:"departure_weekdays"`
+	MinLeadDays       int     `json:"min_lead_days"`
+	MaxAdvanceDays    *int    `json:"max_advance_days,omitempty"`
+}
+
+// StayRestriction applies to stays arriving between StartDate and EndDate
+// (exclusive): a seasonal minimum stay and/or closing those dates to arrival
+type StayRestriction struct {
+	RestrictionID   uuid.UUID `json:"restriction_id"`
+	PropertyID      uuid.UUID `json:"property_id"`
+	StartDate       time.Time `json:"start_date"`
+	EndDate         time.Time `json:"end_date"`
+	MinNights       *int      `json:"min_nights,omitempty"`
+	ClosedToArrival bool      `json:"closed_to_arrival"`
+	Note            *string   `json:"note,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type StayRestrictionRequest struct {
+	StartDate       string  `json:"start_date"` // "2024-01-15" format
+	EndDate         string  `json:"end_date"`   // "2024-01-20" format
+	MinNights       *int    `json:"min_nights,omitempty"`
+	ClosedToArrival bool    `json:"closed_to_arrival"`
+	Note            *string `json:"note,omitempty"`
+}
+
+// StayRuleError lists the stay rules a booking breaks
+type StayRuleError struct {
+	Message    string   `json:"error"`
+	Code       int      `json:"code"`
+	Violations []string `json:"violations"`
+}
+
+func (e *StayRuleError) Error() string {
+	return e.Message + ": " + strings.Join(e.Violations, "; ")
+}
+
+// CalendarRestrictions are the stay rules for a stay arriving on a calendar day
+type CalendarRestrictions struct {
+	MinNights         int     `json:"min_nights"`
+	MaxNights         *int    `json:"max_nights,omitempty"`
+	ClosedToArrival   bool    `json:"closed_to_arrival"`
+	ClosedToDeparture bool    `json:"closed_to_departure"`
+	ArrivalNote       *string `json:"arrival_note,omitempty"`
+}
+
+var weekdayNames = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+
+// stayRuleSet is everything needed to check stays of one property
+type stayRuleSet struct {
+	rules        StayRules
+	restrictions []StayRestriction
+}
+
+func defaultStayRules(propertyID uuid.UUID) StayRules {
+	return StayRules{
+		PropertyID:        propertyID,
+		MinNights:         1,
+		ArrivalWeekdays:   []int64{},
+		DepartureWeekdays: []int64{},
+	}
+}
+
+func (req *UpdateStayRulesRequest) validate() error {
+	if req.MinNights < 1 {
+		return fmt.Errorf("min_nights must be at least 1")
+	}
+
+	if req.MaxNights != nil && *req.MaxNights < req.MinNights {
+		return fmt.Errorf("max_nights must not be less than min_nights")
+	}
+
+	for _, weekdays := range [][]int64{req.ArrivalWeekdays, req.DepartureWeekdays} {
+		for _, weekday := range weekdays {
+			if weekday < 0 || weekday > 6 {
+				return fmt.Errorf("weekdays must be between 0 (Sunday) and 6 (Saturday)")
+			}
+		}
+	}
+
+	if req.MinLeadDays < 0 {
+		return fmt.Errorf("min_lead_days must not be negative")
+	}
+
+	if req.MaxAdvanceDays != nil && *req.MaxAdvanceDays < req.MinLeadDays {
+		return fmt.Errorf("max_advance_days must not be less than min_lead_days")
+	}
+
+	return nil
+}
+
+func (req *StayRestrictionRequest) parse() (time.Time, time.Time, error) {
+	startDate, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start date format: %v", err)
+	}
+
+	endDate, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end date format: %v", err)
+	}
+
+	if !endDate.After(startDate) {
+		return time.Time{}, time.Time{}, fmt.Errorf("end date must be after start date")
+	}
+
+	if req.MinNights == nil && !req.ClosedToArrival {
+		return time.Time{}, time.Time{}, fmt.Errorf("min_nights or closed_to_arrival is required")
+	}
+
+	if req.MinNights != nil && *req.MinNights < 1 {
+		return time.Time{}, time.Time{}, fmt.Errorf("min_nights must be at least 1")
+	}
+
+	return startDate, endDate, nil
+}
+
+// Get the stay rules of a property, the defaults allowing any stay if none are set
+func (s *BookingService) GetStayRules(propertyID uuid.UUID) (*StayRules, error) {
+	sets, err := s.loadStayRuleSets(s.db, []uuid.UUID{propertyID}, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &sets[propertyID].rules, nil
+}
+
+func (s *BookingService) UpdateStayRules(propertyID uuid.UUID, req *UpdateStayRulesRequest) (*StayRules, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	if req.ArrivalWeekdays == nil {
+		req.ArrivalWeekdays = []int64{}
+	}
+	if req.DepartureWeekdays == nil {
+		req.DepartureWeekdays = []int64{}
+	}
+
+	query := `
+		INSERT INTO property_stay_rules (
+			property_id, min_nights, max_nights, arrival_weekdays, departure_weekdays,
+			min_lead_days, max_advance_days
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (property_id) DO UPDATE SET
+			min_nights = EXCLUDED.min_nights, max_nights = EXCLUDED.max_nights,
+			arrival_weekdays = EXCLUDED.arrival_weekdays, departure_weekdays = EXCLUDED.departure_weekdays,
+			min_lead_days = EXCLUDED.min_lead_days, max_advance_days = EXCLUDED.max_advance_days,
+			updated_at = CURRENT_TIMESTAMP
+	`
+
+	_, err := s.db.Exec(query, propertyID, req.MinNights, req.MaxNights, pq.Array(req.ArrivalWeekdays),
+		pq.Array(req.DepartureWeekdays), req.MinLeadDays, req.MaxAdvanceDays)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetStayRules(propertyID)
+}
+
+func (s *BookingService) CreateStayRestriction(propertyID uuid.UUID, req *StayRestrictionRequest) (*StayRestriction, error) {
+	startDate, endDate, err := req.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	restrictionID := uuid.New()
+	query := `
+		INSERT INTO stay_restrictions (
+			restriction_id, property_id, start_date, end_date, min_nights, closed_to_arrival, note
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err = s.db.Exec(query, restrictionID, propertyID, startDate, endDate, req.MinNights, req.ClosedToArrival, req.Note)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetStayRestriction(restrictionID)
+}
+
+// Restrictions of a property overlapping the given range (inclusive)
+func (s *BookingService) GetStayRestrictions(propertyID uuid.UUID, from, to time.Time) ([]StayRestriction, error) {
+	query := `
+		SELECT restriction_id, property_id, start_date, end_date, min_nights, closed_to_arrival,
+			note, created_at, updated_at
+		FROM stay_restrictions
+		WHERE property_id = $1
+		AND start_date <= $2 AND end_date > $3
+		ORDER BY start_date ASC
+	`
+
+	return s.queryStayRestrictions(s.db, query, propertyID, to, from)
+}
+
+func (s *BookingService) GetStayRestriction(restrictionID uuid.UUID) (*StayRestriction, error) {
+	query := `
+		SELECT restriction_id, property_id, start_date, end_date, min_nights, closed_to_arrival,
+			note, created_at, updated_at
+		FROM stay_restrictions
+		WHERE restriction_id = $1
+	`
+
+	restrictions, err := s.queryStayRestrictions(s.db, query, restrictionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(restrictions) == 0 {
+		return nil, fmt.Errorf("restriction not found")
+	}
+
+	return &restrictions[0], nil
+}
+
+func (s *BookingService) UpdateStayRestriction(restrictionID uuid.UUID, req *StayRestrictionRequest) (*StayRestriction, error) {
+	startDate, endDate, err := req.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE stay_restrictions
+		SET start_date = $1, end_date = $2, min_nights = $3, closed_to_arrival = $4, note = $5,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE restriction_id = $6
+	`
+
+	result, err := s.db.Exec(query, startDate, endDate, req.MinNights, req.ClosedToArrival, req.Note, restrictionID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, fmt.Errorf("restriction not found")
+	}
+
+	return s.GetStayRestriction(restrictionID)
+}
+
+func (s *BookingService) DeleteStayRestriction(restrictionID uuid.UUID) error {
+	result, err := s.db.Exec(`DELETE FROM stay_restrictions WHERE restriction_id = $1`, restrictionID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("restriction not found")
+	}
+
+	return nil
+}
+
+func (s *BookingService) queryStayRestrictions(q queryer, query string, args ...interface{}) ([]StayRestriction, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var restrictions []StayRestriction
+
+	for rows.Next() {
+		var restriction StayRestriction
+		err := rows.Scan(
+			&restriction.RestrictionID, &restriction.PropertyID, &restriction.StartDate,
+			&restriction.EndDate, &restriction.MinNights, &restriction.ClosedToArrival,
+			&restriction.Note, &restriction.CreatedAt, &restriction.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		restrictions = append(restrictions, restriction)
+	}
+
+	return restrictions, nil
+}
+
+// loadStayRuleSets loads the rules of each property and the restrictions
+// for arrivals between from and to (inclusive). A zero from skips loading
+// restrictions.
+func (s *BookingService) loadStayRuleSets(q queryer, propertyIDs []uuid.UUID, from, to time.Time) (map[uuid.UUID]*stayRuleSet, error) {
+	ids := make([]string, len(propertyIDs))
+	sets := make(map[uuid.UUID]*stayRuleSet)
+	for i, id := range propertyIDs {
+		ids[i] = id.String()
+		sets[id] = &stayRuleSet{rules: defaultStayRules(id)}
+	}
+
+	rows, err := q.Query(`
+		SELECT property_id, min_nights, max_nights, arrival_weekdays, departure_weekdays,
+			min_lead_days, max_advance_days
+		FROM property_stay_rules
+		WHERE property_id = ANY($1::UUID[])
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rules StayRules
+		err := rows.Scan(
+			&rules.PropertyID, &rules.MinNights, &rules.MaxNights, pq.Array(&rules.ArrivalWeekdays),
+			pq.Array(&rules.DepartureWeekdays), &rules.MinLeadDays, &rules.MaxAdvanceDays,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sets[rules.PropertyID].rules = rules
+	}
+
+	rows.Close()
+
+	if from.IsZero() {
+		return sets, nil
+	}
+
+	restrictions, err := s.queryStayRestrictions(q, `
+		SELECT restriction_id, property_id, start_date, end_date, min_nights, closed_to_arrival,
+			note, created_at, updated_at
+		FROM stay_restrictions
+		WHERE property_id = ANY($1::UUID[])
+		AND start_date <= $2 AND end_date > $3
+		ORDER BY start_date ASC
+	`, pq.Array(ids), to, from)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, restriction := range restrictions {
+		set := sets[restriction.PropertyID]
+		set.restrictions = append(set.restrictions, restriction)
+	}
+
+	return sets, nil
+}
+
+// minNights is the minimum stay for arrivals on the given date: the
+// property's minimum or, if longer, that of any season the date falls in
+func (set *stayRuleSet) minNights(arrival time.Time) int {
+	minNights := set.rules.MinNights
+	for _, restriction := range set.restrictions {
+		if restriction.MinNights != nil && restriction.covers(arrival) && *restriction.MinNights > minNights {
+			minNights = *restriction.MinNights
+		}
+	}
+	return minNights
+}
+
+// arrivalViolations explains why a stay may not arrive on the given date,
+// if it may not
+func (set *stayRuleSet) arrivalViolations(arrival, today time.Time) []string {
+	var violations []string
+
+	if !weekdayAllowed(set.rules.ArrivalWeekdays, arrival) {
+		violations = append(violations, fmt.Sprintf("arrivals are not allowed on %s", weekdayNames[arrival.Weekday()]))
+	}
+
+	for _, restriction := range set.restrictions {
+		if restriction.ClosedToArrival && restriction.covers(arrival) {
+			violations = append(violations, fmt.Sprintf("%s is closed to arrival", arrival.Format("2006-01-02")))
+			break
+		}
+	}
+
+	// Without a lead time, stays arriving in the past can still be recorded
+	leadDays := int(arrival.Sub(today).Hours() / 24)
+	if set.rules.MinLeadDays > 0 && leadDays < set.rules.MinLeadDays {
+		violations = append(violations, fmt.Sprintf("bookings must be made at least %d days before arrival", set.rules.MinLeadDays))
+	}
+	if set.rules.MaxAdvanceDays != nil && leadDays > *set.rules.MaxAdvanceDays {
+		violations = append(violations, fmt.Sprintf("bookings can be made at most %d days in advance", *set.rules.MaxAdvanceDays))
+	}
+
+	return violations
+}
+
+// check returns the rules a stay breaks. Arrival rules are only checked when
+// the arrival is new, so an existing stay can still be shortened or extended.
+func (set *stayRuleSet) check(checkIn, checkOut, today time.Time, newArrival bool) []string {
+	var violations []string
+
+	if newArrival {
+		violations = append(violations, set.arrivalViolations(checkIn, today)...)
+	}
+
+	if !weekdayAllowed(set.rules.DepartureWeekdays, checkOut) {
+		violations = append(violations, fmt.Sprintf("departures are not allowed on %s", weekdayNames[checkOut.Weekday()]))
+	}
+
+	nights := int(checkOut.Sub(checkIn).Hours() / 24)
+	if minNights := set.minNights(checkIn); nights < minNights {
+		violations = append(violations, fmt.Sprintf("stays arriving on %s must be at least %d nights", checkIn.Format("2006-01-02"), minNights))
+	}
+	if set.rules.MaxNights != nil && nights > *set.rules.MaxNights {
+		violations = append(violations, fmt.Sprintf("stays must be at most %d nights", *set.rules.MaxNights))
+	}
+
+	return violations
+}
+
+// restrictionsOn is what the calendar shows for a day, or nil if stays
+// arriving and departing that day are unrestricted
+func (set *stayRuleSet) restrictionsOn(d, today time.Time) *CalendarRestrictions {
+	restrictions := &CalendarRestrictions{
+		MinNights:         set.minNights(d),
+		MaxNights:         set.rules.MaxNights,
+		ClosedToDeparture: !weekdayAllowed(set.rules.DepartureWeekdays, d),
+	}
+
+	if violations := set.arrivalViolations(d, today); len(violations) > 0 {
+		note := strings.Join(violations, "; ")
+		restrictions.ClosedToArrival = true
+		restrictions.ArrivalNote = &note
+	}
+
+	if restrictions.MinNights <= 1 && restrictions.MaxNights == nil &&
+		!restrictions.ClosedToArrival && !restrictions.ClosedToDeparture {
+		return nil
+	}
+
+	return restrictions
+}
+
+func (restriction *StayRestriction) covers(d time.Time) bool {
+	return !d.Before(restriction.StartDate) && d.Before(restriction.EndDate)
+}
+
+func weekdayAllowed(weekdays []int64, d time.Time) bool {
+	if len(weekdays) == 0 {
+		return true
+	}
+	for _, weekday := range weekdays {
+		if int(weekday) == int(d.Weekday()) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkStayRules rejects a stay that breaks the property's stay rules with
+// a StayRuleError
+func (s *BookingService) checkStayRules(q queryer, propertyID uuid.UUID, checkIn, checkOut time.Time, newArrival bool) error {
+	sets, err := s.loadStayRuleSets(q, []uuid.UUID{propertyID}, checkIn, checkIn)
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	violations := sets[propertyID].check(checkIn, checkOut, today, newArrival)
+	if len(violations) > 0 {
+		return &StayRuleError{
+			Message:    "booking breaks the stay rules of this property",
+			Code:       http.StatusBadRequest,
+			Violations: violations,
+		}
+	}
+
+	return nil
+}
+
+// HTTP Handlers
+func (s *BookingService) GetStayRulesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	rules, err := s.GetStayRules(propertyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+func (s *BookingService) UpdateStayRulesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateStayRulesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rules, err := s.UpdateStayRules(propertyID, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+func (s *BookingService) GetStayRestrictionsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+	fromStr := r.URL.Query().Get("from")
+	toStr := r.URL.Query().Get("to")
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	// Default: from today for the next 12 months
+	from := time.Now().UTC().Truncate(24 * time.Hour)
+	to := from.AddDate(1, 0, 0)
+
+	if fromStr != "" {
+		from, err = time.Parse("2006-01-02", fromStr)
+		if err != nil {
+			http.Error(w, "Invalid from format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if toStr != "" {
+		to, err = time.Parse("2006-01-02", toStr)
+		if err != nil {
+			http.Error(w, "Invalid to format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	restrictions, err := s.GetStayRestrictions(propertyID, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restrictions)
+}
+
+func (s *BookingService) CreateStayRestrictionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	var req StayRestrictionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	restriction, err := s.CreateStayRestriction(propertyID, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(restriction)
+}
+
+func (s *BookingService) UpdateStayRestrictionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	restrictionIDStr := vars["restrictionId"]
+
+	restrictionID, err := uuid.Parse(restrictionIDStr)
+	if err != nil {
+		http.Error(w, "Invalid restriction ID", http.StatusBadRequest)
+		return
+	}
+
+	var req StayRestrictionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	restriction, err := s.UpdateStayRestriction(restrictionID, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restriction)
+}
+
+func (s *BookingService) DeleteStayRestrictionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	restrictionIDStr := vars["restrictionId"]
+
+	restrictionID, err := uuid.Parse(restrictionIDStr)
+	if err != nil {
+		http.Error(w, "Invalid restriction ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.DeleteStayRestriction(restrictionID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
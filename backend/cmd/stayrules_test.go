@@ -0,0 +1,133 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// testStayRuleSet allows arrivals on Fridays and Saturdays and departures on
+// Sundays, Mondays and Fridays, for 2 to 7 nights booked 2 to 90 days ahead.
+// Summer needs 5 nights and 12 June is closed to arrival.
+func testStayRuleSet() *stayRuleSet {
+	maxNights, maxAdvance, summerNights := 7, 90, 5
+
+	return &stayRuleSet{
+		rules: StayRules{
+			MinNights:         2,
+			MaxNights:         &maxNights,
+			ArrivalWeekdays:   []int64{5, 6},
+			DepartureWeekdays: []int64{0, 1, 5},
+			MinLeadDays:       2,
+			MaxAdvanceDays:    &maxAdvance,
+		},
+		restrictions: []StayRestriction{
+			{StartDate: date(2026, 7, 1), EndDate: date(2026, 9, 1), MinNights: &summerNights},
+			{StartDate: date(2026, 6, 12), EndDate: date(2026, 6, 13), ClosedToArrival: true},
+		},
+	}
+}
+
+func TestStayRuleSetCheck(t *testing.T) {
+	monday := date(2026, 6, 1)
+
+	tests := []struct {
+		name       string
+		checkIn    string
+		checkOut   string
+		today      string
+		newArrival bool
+		violations []string
+	}{
+		{"allowed", "2026-06-05", "2026-06-08", "2026-06-01", true, nil},
+		{"shortest", "2026-06-05", "2026-06-07", "2026-06-01", true, nil},
+		{"arrival weekday", "2026-06-04", "2026-06-07", "2026-06-01", true,
+			[]string{"arrivals are not allowed on Thursday"}},
+		{"existing arrival", "2026-06-04", "2026-06-07", "2026-06-01", false, nil},
+		{"closed to arrival", "2026-06-12", "2026-06-15", "2026-06-01", true,
+			[]string{"2026-06-12 is closed to arrival"}},
+		{"departure weekday", "2026-06-06", "2026-06-09", "2026-06-01", true,
+			[]string{"departures are not allowed on Tuesday"}},
+		{"too short", "2026-06-05", "2026-06-06", "2026-06-01", true,
+			[]string{"departures are not allowed on Saturday", "stays arriving on 2026-06-05 must be at least 2 nights"}},
+		{"too long", "2026-06-05", "2026-06-19", "2026-06-01", true,
+			[]string{"stays must be at most 7 nights"}},
+		{"summer minimum", "2026-07-03", "2026-07-06", "2026-06-01", true,
+			[]string{"stays arriving on 2026-07-03 must be at least 5 nights"}},
+		{"arriving before summer", "2026-06-27", "2026-07-03", "2026-06-01", true, nil},
+		{"lead time", "2026-06-05", "2026-06-08", "2026-06-04", true,
+			[]string{"bookings must be made at least 2 days before arrival"}},
+		{"lead time of an existing stay", "2026-06-05", "2026-06-08", "2026-06-04", false, nil},
+		{"too far ahead", "2026-09-04", "2026-09-07", "2026-06-01", true,
+			[]string{"bookings can be made at most 90 days in advance"}},
+	}
+
+	for _, tt := range tests {
+		checkIn, checkOut, today := parseTestDate(t, tt.checkIn), parseTestDate(t, tt.checkOut), parseTestDate(t, tt.today)
+		if got := testStayRuleSet().check(checkIn, checkOut, today, tt.newArrival); !reflect.DeepEqual(got, tt.violations) {
+			t.Errorf("%s: check(%s, %s) = %q, want %q", tt.name, tt.checkIn, tt.checkOut, got, tt.violations)
+		}
+	}
+
+	// The defaults allow any stay, even one arriving in the past
+	defaults := &stayRuleSet{rules: defaultStayRules(uuid.New())}
+	if got := defaults.check(monday.AddDate(0, 0, -3), monday.AddDate(0, 0, 30), monday, true); got != nil {
+		t.Errorf("default rules: check = %q, want no violations", got)
+	}
+}
+
+func TestStayRuleSetRestrictionsOn(t *testing.T) {
+	tests := []struct {
+		day               string
+		minNights         int
+		closedToArrival   bool
+		closedToDeparture bool
+		note              string
+	}{
+		{"2026-06-05", 2, false, false, ""},
+		{"2026-06-08", 2, true, false, "arrivals are not allowed on Monday"},
+		{"2026-06-09", 2, true, true, "arrivals are not allowed on Tuesday"},
+		{"2026-06-12", 2, true, false, "2026-06-12 is closed to arrival"},
+		{"2026-07-03", 5, false, false, ""},
+		{"2026-06-02", 2, true, true, "arrivals are not allowed on Tuesday; bookings must be made at least 2 days before arrival"},
+	}
+
+	today := date(2026, 6, 1)
+	for _, tt := range tests {
+		got := testStayRuleSet().restrictionsOn(parseTestDate(t, tt.day), today)
+		if got == nil {
+			t.Errorf("restrictionsOn(%s) = nil, want restrictions", tt.day)
+			continue
+		}
+
+		note := ""
+		if got.ArrivalNote != nil {
+			note = *got.ArrivalNote
+		}
+		if got.MinNights != tt.minNights || got.MaxNights == nil || *got.MaxNights != 7 ||
+			got.ClosedToArrival != tt.closedToArrival || got.ClosedToDeparture != tt.closedToDeparture || note != tt.note {
+			t.Errorf("restrictionsOn(%s) = %+v (note %q), want min %d, closed to arrival %v, closed to departure %v, note %q",
+				tt.day, got, note, tt.minNights, tt.closedToArrival, tt.closedToDeparture, tt.note)
+		}
+	}
+
+	// Days without any rule show no restrictions
+	defaults := &stayRuleSet{rules: defaultStayRules(uuid.New())}
+	for _, d := range []string{"2026-05-01", "2026-06-01", "2026-06-09"} {
+		if got := defaults.restrictionsOn(parseTestDate(t, d), today); got != nil {
+			t.Errorf("default rules: restrictionsOn(%s) = %+v, want nil", d, got)
+		}
+	}
+}
+
+func parseTestDate(t *testing.T, s string) time.Time {
+	t.Helper()
+
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		t.Fatalf("Invalid test date %q: %v", s, err)
+	}
+	return d
+}
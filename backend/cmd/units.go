@@ -0,0 +1,774 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// UnitType is a kind of identical unit (apartment, room) within a property.
+// Properties without units are booked as a single unit, as before.
+type UnitType struct {
+	UnitTypeID  uuid.UUID `json:"unit_type_id"`
+	PropertyID  uuid.UUID `json:"property_id"`
+	Name        string    `json:"name"`
+	MaxGuests   int       `json:"max_guests"`
+	Description *string   `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type UnitTypeRequest struct {
+	Name        string  `json:"name"`
+	MaxGuests   int     `json:"max_guests"`
+	Description *string `json:"description,omitempty"`
+}
+
+type Unit struct {
+	UnitID     uuid.UUID `json:"unit_id"`
+	PropertyID uuid.UUID `json:"property_id"`
+	UnitTypeID uuid.UUID `json:"unit_type_id"`
+	UnitName   string    `json:"unit_name"`
+	IsActive   bool      `json:"is_active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type UnitRequest struct {
+	UnitTypeID uuid.UUID `json:"unit_type_id"`
+	UnitName   string    `json:"unit_name"`
+	IsActive   *bool     `json:"is_active,omitempty"`
+}
+
+// UnitTypeAvailability is how many units of a type are still free, for a
+// calendar day or a whole stay
+type UnitTypeAvailability struct {
+	UnitTypeID uuid.UUID `json:"unit_type_id"`
+	Name       string    `json:"name"`
+	MaxGuests  int       `json:"max_guests"`
+	TotalUnits int       `json:"total_units"`
+	Remaining  int       `json:"remaining"`
+}
+
+// propertyUnit is an active unit as needed to count availability
+type propertyUnit struct {
+	unitID     uuid.UUID
+	propertyID uuid.UUID
+	unitType   UnitTypeAvailability
+}
+
+func (req *UnitTypeRequest) validate() error {
+	if req.Name == "" {
+		return serviceError(http.StatusBadRequest, "name is required")
+	}
+	if req.MaxGuests < 1 {
+		return serviceError(http.StatusBadRequest, "max_guests must be at least 1")
+	}
+	return nil
+}
+
+func (req *UnitRequest) validate() error {
+	if req.UnitTypeID == uuid.Nil {
+		return serviceError(http.StatusBadRequest, "unit_type_id is required")
+	}
+	if req.UnitName == "" {
+		return serviceError(http.StatusBadRequest, "unit_name is required")
+	}
+	return nil
+}
+
+func (s *BookingService) GetUnitTypes(propertyID uuid.UUID) ([]UnitType, error) {
+	query := `
+		SELECT unit_type_id, property_id, name, max_guests, description, created_at, updated_at
+		FROM unit_types
+		WHERE property_id = $1
+		ORDER BY name
+	`
+
+	return s.queryUnitTypes(query, propertyID)
+}
+
+func (s *BookingService) GetUnitType(unitTypeID uuid.UUID) (*UnitType, error) {
+	query := `
+		SELECT unit_type_id, property_id, name, max_guests, description, created_at, updated_at
+		FROM unit_types
+		WHERE unit_type_id = $1
+	`
+
+	unitTypes, err := s.queryUnitTypes(query, unitTypeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(unitTypes) == 0 {
+		return nil, serviceError(http.StatusNotFound, "unit type not found")
+	}
+
+	return &unitTypes[0], nil
+}
+
+func (s *BookingService) CreateUnitType(propertyID uuid.UUID, req *UnitTypeRequest) (*UnitType, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	unitTypeID := uuid.New()
+	query := `
+		INSERT INTO unit_types (unit_type_id, property_id, name, max_guests, description)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err := s.db.Exec(query, unitTypeID, propertyID, req.Name, req.MaxGuests, req.Description)
+	if isUniqueViolation(err) {
+		return nil, serviceError(http.StatusConflict, "unit type %q already exists", req.Name)
+	}
+	if isForeignKeyViolation(err) {
+		return nil, serviceError(http.StatusNotFound, "property not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetUnitType(unitTypeID)
+}
+
+func (s *BookingService) UpdateUnitType(unitTypeID uuid.UUID, req *UnitTypeRequest) (*UnitType, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE unit_types
+		SET name = $1, max_guests = $2, description = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE unit_type_id = $4
+	`
+
+	result, err := s.db.Exec(query, req.Name, req.MaxGuests, req.Description, unitTypeID)
+	if isUniqueViolation(err) {
+		return nil, serviceError(http.StatusConflict, "unit type %q already exists", req.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, serviceError(http.StatusNotFound, "unit type not found")
+	}
+
+	return s.GetUnitType(unitTypeID)
+}
+
+// Delete a unit type. Types that still have units cannot be deleted.
+func (s *BookingService) DeleteUnitType(unitTypeID uuid.UUID) error {
+	result, err := s.db.Exec(`DELETE FROM unit_types WHERE unit_type_id = $1`, unitTypeID)
+	if isForeignKeyViolation(err) {
+		return serviceError(http.StatusConflict, "unit type still has units")
+	}
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return serviceError(http.StatusNotFound, "unit type not found")
+	}
+
+	return nil
+}
+
+func (s *BookingService) queryUnitTypes(query string, args ...interface{}) ([]UnitType, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var unitTypes []UnitType
+
+	for rows.Next() {
+		var unitType UnitType
+		err := rows.Scan(
+			&unitType.UnitTypeID, &unitType.PropertyID, &unitType.Name, &unitType.MaxGuests,
+			&unitType.Description, &unitType.CreatedAt, &unitType.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		unitTypes = append(unitTypes, unitType)
+	}
+
+	return unitTypes, nil
+}
+
+func (s *BookingService) GetUnits(propertyID uuid.UUID) ([]Unit, error) {
+	query := `
+		SELECT unit_id, property_id, unit_type_id, unit_name, is_active, created_at, updated_at
+		FROM units
+		WHERE property_id = $1
+		ORDER BY unit_name
+	`
+
+	return s.queryUnits(query, propertyID)
+}
+
+func (s *BookingService) GetUnit(unitID uuid.UUID) (*Unit, error) {
+	query := `
+		SELECT unit_id, property_id, unit_type_id, unit_name, is_active, created_at, updated_at
+		FROM units
+		WHERE unit_id = $1
+	`
+
+	units, err := s.queryUnits(query, unitID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(units) == 0 {
+		return nil, serviceError(http.StatusNotFound, "unit not found")
+	}
+
+	return &units[0], nil
+}
+
+// Add a unit to a property. The unit type must belong to the same property.
+//
+// Bookings made while the property was booked whole hold every unit, so a
+// new active unit takes over those still current; the unit type must have
+// room for their guests. This runs under the property's availability lock, so no such
+// booking can be added meanwhile.
+func (s *BookingService) CreateUnit(propertyID uuid.UUID, req *UnitRequest) (*Unit, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockPropertyAvailability(tx, propertyID); err != nil {
+		return nil, err
+	}
+
+	unitID := uuid.New()
+	query := `
+		INSERT INTO units (unit_id, property_id, unit_type_id, unit_name, is_active)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err = tx.Exec(query, unitID, propertyID, req.UnitTypeID, req.UnitName, isActive)
+	if err != nil {
+		return nil, asUnitWriteError(err, req)
+	}
+
+	// An inactive unit cannot be booked, so it takes over nothing
+	if isActive {
+		if err := s.adoptWholePropertyBookings(tx, propertyID, unitID, req.UnitTypeID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetUnit(unitID)
+}
+
+// adoptWholePropertyBookings moves the current bookings of the whole
+// property onto a new active unit, refusing a unit type without room for
+// their guests
+func (s *BookingService) adoptWholePropertyBookings(tx *sql.Tx, propertyID, unitID, unitTypeID uuid.UUID) error {
+	var maxGuests, guests int
+	err := tx.QueryRow(`
+		SELECT ut.max_guests, COALESCE(MAX(b.number_of_guests), 0)
+		FROM unit_types ut
+		LEFT JOIN bookings b ON b.property_id = ut.property_id
+			AND b.unit_id IS NULL
+			AND b.booking_status IN ('confirmed', 'pending')
+			AND (b.check_out_date > CURRENT_DATE OR b.booking_id IN (SELECT booking_id FROM long_stays WHERE open_ended))
+		WHERE ut.unit_type_id = $1
+		GROUP BY ut.max_guests
+	`, unitTypeID).Scan(&maxGuests, &guests)
+	if err != nil {
+		return err
+	}
+	if guests > maxGuests {
+		return &BookingConflictError{
+			Message:   fmt.Sprintf("the property has a current booking for %d guests, the unit type has room for %d", guests, maxGuests),
+			Code:      http.StatusConflict,
+			Conflicts: []ConflictingStay{},
+		}
+	}
+
+	rows, err := tx.Query(`
+		UPDATE bookings SET unit_id = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE property_id = $2
+		AND unit_id IS NULL
+		AND booking_status IN ('confirmed', 'pending')
+		AND (check_out_date > CURRENT_DATE OR booking_id IN (SELECT booking_id FROM long_stays WHERE open_ended))
+		RETURNING booking_id
+	`, unitID, propertyID)
+	if err != nil {
+		return err
+	}
+
+	var movedBookingIDs []uuid.UUID
+	for rows.Next() {
+		var bookingID uuid.UUID
+		if err := rows.Scan(&bookingID); err != nil {
+			rows.Close()
+			return err
+		}
+		movedBookingIDs = append(movedBookingIDs, bookingID)
+	}
+	rows.Close()
+
+	for _, bookingID := range movedBookingIDs {
+		err := s.recordBookingEvent(tx, EventBookingUpdated, bookingID, &BookingEventPayload{
+			Changes: []string{"unit_id"},
+			Cause:   EventCauseMove,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *BookingService) UpdateUnit(unitID uuid.UUID, req *UnitRequest) (*Unit, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE units
+		SET unit_type_id = $1, unit_name = $2, is_active = COALESCE($3, is_active),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE unit_id = $4
+	`
+
+	result, err := s.db.Exec(query, req.UnitTypeID, req.UnitName, req.IsActive, unitID)
+	if err != nil {
+		return nil, asUnitWriteError(err, req)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, serviceError(http.StatusNotFound, "unit not found")
+	}
+
+	return s.GetUnit(unitID)
+}
+
+// asUnitWriteError reports a unit name already taken on the property, or a
+// unit type of another property, as refused requests
+func asUnitWriteError(err error, req *UnitRequest) error {
+	if isUniqueViolation(err) {
+		return serviceError(http.StatusConflict, "unit %q already exists", req.UnitName)
+	}
+	if isForeignKeyViolation(err) {
+		return serviceError(http.StatusBadRequest, "unit type not found for this property")
+	}
+	return err
+}
+
+// Delete a unit. Units with bookings cannot be deleted; deactivate them instead.
+func (s *BookingService) DeleteUnit(unitID uuid.UUID) error {
+	result, err := s.db.Exec(`DELETE FROM units WHERE unit_id = $1`, unitID)
+	if isForeignKeyViolation(err) {
+		return serviceError(http.StatusConflict, "unit has bookings; deactivate it instead")
+	}
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return serviceError(http.StatusNotFound, "unit not found")
+	}
+
+	return nil
+}
+
+func (s *BookingService) queryUnits(query string, args ...interface{}) ([]Unit, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var units []Unit
+
+	for rows.Next() {
+		var unit Unit
+		err := rows.Scan(
+			&unit.UnitID, &unit.PropertyID, &unit.UnitTypeID, &unit.UnitName,
+			&unit.IsActive, &unit.CreatedAt, &unit.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		units = append(units, unit)
+	}
+
+	return units, nil
+}
+
+// assignUnit picks the unit for a new booking on a property with units: the
+// requested unit, which must be active and have room for the guests, or else
+// the smallest free active unit with room for the guests, of the requested
+// type if one is given. Properties without units get nil; a property whose
+// units are all inactive has none free. The exclusion constraint still has
+// the final say if two bookings race for the same unit.
+func (s *BookingService) assignUnit(tx *sql.Tx, req *CreateBookingRequest, checkIn, checkOut time.Time) (*uuid.UUID, error) {
+	var hasUnits bool
+	err := tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM units WHERE property_id = $1)
+	`, req.PropertyID).Scan(&hasUnits)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasUnits {
+		if req.UnitID != nil || req.UnitTypeID != nil {
+			return nil, serviceError(http.StatusBadRequest, "property has no units")
+		}
+		return nil, nil
+	}
+
+	if req.UnitID != nil {
+		var isActive bool
+		var maxGuests int
+		err := tx.QueryRow(`
+			SELECT u.is_active, ut.max_guests
+			FROM units u
+			JOIN unit_types ut ON ut.unit_type_id = u.unit_type_id
+			WHERE u.unit_id = $1 AND u.property_id = $2
+		`, *req.UnitID, req.PropertyID).Scan(&isActive, &maxGuests)
+		if err == sql.ErrNoRows {
+			return nil, serviceError(http.StatusBadRequest, "unit not found for this property")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !isActive {
+			return nil, serviceError(http.StatusBadRequest, "unit is not active")
+		}
+		if maxGuests < req.NumberOfGuests {
+			return nil, serviceError(http.StatusBadRequest, "unit has room for %d guests, the booking has %d", maxGuests, req.NumberOfGuests)
+		}
+		return req.UnitID, nil
+	}
+
+	var unitID uuid.UUID
+	err = tx.QueryRow(`
+		SELECT u.unit_id
+		FROM units u
+		JOIN unit_types ut ON ut.unit_type_id = u.unit_type_id
+		WHERE u.property_id = $1
+		AND u.is_active
+		AND ($2::UUID IS NULL OR u.unit_type_id = $2)
+		AND ut.max_guests >= $3
+		AND NOT EXISTS (
+			SELECT 1 FROM bookings b
+			WHERE b.property_id = u.property_id AND (b.unit_id = u.unit_id OR b.unit_id IS NULL)
+			AND b.booking_status IN ('confirmed', 'pending')
+			AND b.check_in_date < $5
+			AND (b.check_out_date > $4 OR b.booking_id IN (SELECT booking_id FROM long_stays WHERE open_ended))
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM availability_blocks ab
+			WHERE ab.property_id = u.property_id AND ab.unit_id = u.unit_id
+			AND ab.start_date < $5 AND ab.end_date > $4
+		)
+		ORDER BY ut.max_guests, u.unit_name
+		LIMIT 1
+	`, req.PropertyID, req.UnitTypeID, req.NumberOfGuests, checkIn, checkOut).Scan(&unitID)
+	if err == sql.ErrNoRows {
+		return nil, &BookingConflictError{
+			Message:   "no unit with room for the guests is free on these dates",
+			Code:      http.StatusConflict,
+			Conflicts: []ConflictingStay{},
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &unitID, nil
+}
+
+// activeUnits loads the active units of the given properties with their types
+func (s *BookingService) activeUnits(propertyIDs []uuid.UUID) ([]propertyUnit, error) {
+	ids := make([]string, len(propertyIDs))
+	for i, id := range propertyIDs {
+		ids[i] = id.String()
+	}
+
+	rows, err := s.db.Query(`
+		SELECT u.unit_id, u.property_id, ut.unit_type_id, ut.name, ut.max_guests
+		FROM units u
+		JOIN unit_types ut ON ut.unit_type_id = u.unit_type_id
+		WHERE u.property_id = ANY($1::UUID[])
+		AND u.is_active
+		ORDER BY ut.name, u.unit_name
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var units []propertyUnit
+
+	for rows.Next() {
+		var unit propertyUnit
+		err := rows.Scan(&unit.unitID, &unit.propertyID, &unit.unitType.UnitTypeID,
+			&unit.unitType.Name, &unit.unitType.MaxGuests)
+		if err != nil {
+			return nil, err
+		}
+
+		units = append(units, unit)
+	}
+
+	return units, nil
+}
+
+// countUnitTypes aggregates units per type, counting those not in occupied
+// as remaining. Types keep the order of units.
+func countUnitTypes(units []propertyUnit, occupied map[uuid.UUID]bool) []UnitTypeAvailability {
+	var unitTypes []UnitTypeAvailability
+	index := make(map[uuid.UUID]int)
+
+	for _, unit := range units {
+		i, ok := index[unit.unitType.UnitTypeID]
+		if !ok {
+			i = len(unitTypes)
+			index[unit.unitType.UnitTypeID] = i
+			unitTypes = append(unitTypes, unit.unitType)
+		}
+
+		unitTypes[i].TotalUnits++
+		if !occupied[unit.unitID] {
+			unitTypes[i].Remaining++
+		}
+	}
+
+	return unitTypes
+}
+
+// HTTP Handlers
+func (s *BookingService) GetUnitTypesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	unitTypes, err := s.GetUnitTypes(propertyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(unitTypes)
+}
+
+func (s *BookingService) CreateUnitTypeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UnitTypeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	unitType, err := s.CreateUnitType(propertyID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(unitType)
+}
+
+func (s *BookingService) UpdateUnitTypeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	unitTypeIDStr := vars["unitTypeId"]
+
+	unitTypeID, err := uuid.Parse(unitTypeIDStr)
+	if err != nil {
+		http.Error(w, "Invalid unit type ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UnitTypeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	unitType, err := s.UpdateUnitType(unitTypeID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(unitType)
+}
+
+func (s *BookingService) DeleteUnitTypeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	unitTypeIDStr := vars["unitTypeId"]
+
+	unitTypeID, err := uuid.Parse(unitTypeIDStr)
+	if err != nil {
+		http.Error(w, "Invalid unit type ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.DeleteUnitType(unitTypeID); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *BookingService) GetUnitsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	units, err := s.GetUnits(propertyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(units)
+}
+
+func (s *BookingService) CreateUnitHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UnitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	unit, err := s.CreateUnit(propertyID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(unit)
+}
+
+func (s *BookingService) UpdateUnitHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	unitIDStr := vars["unitId"]
+
+	unitID, err := uuid.Parse(unitIDStr)
+	if err != nil {
+		http.Error(w, "Invalid unit ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UnitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	unit, err := s.UpdateUnit(unitID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(unit)
+}
+
+func (s *BookingService) DeleteUnitHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	unitIDStr := vars["unitId"]
+
+	unitID, err := uuid.Parse(unitIDStr)
+	if err != nil {
+		http.Error(w, "Invalid unit ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.DeleteUnit(unitID); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
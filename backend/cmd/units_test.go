@@ -0,0 +1,366 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Bookings of a whole property and of one of its units must never overlap
+func TestUnitAndWholePropertyBookings(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	// Booked whole before the property had units
+	wholeBookingID := createTestBooking(t, s, propertyID, testDate(10), testDate(13))
+	pastBookingID := createTestBooking(t, s, propertyID, testDate(-5), testDate(-2))
+
+	unitType, err := s.CreateUnitType(propertyID, &UnitTypeRequest{Name: "Double", MaxGuests: 2})
+	if err != nil {
+		t.Fatalf("CreateUnitType: %v", err)
+	}
+
+	first, err := s.CreateUnit(propertyID, &UnitRequest{UnitTypeID: unitType.UnitTypeID, UnitName: "101"})
+	if err != nil {
+		t.Fatalf("CreateUnit: %v", err)
+	}
+
+	// The current booking moves onto the new unit; past ones are left alone
+	booking, err := s.GetBookingByID(wholeBookingID)
+	if err != nil {
+		t.Fatalf("GetBookingByID: %v", err)
+	}
+	if booking.UnitID == nil || *booking.UnitID != first.UnitID {
+		t.Errorf("current booking unit = %v, want %s", booking.UnitID, first.UnitID)
+	}
+
+	past, err := s.GetBookingByID(pastBookingID)
+	if err != nil {
+		t.Fatalf("GetBookingByID: %v", err)
+	}
+	if past.UnitID != nil {
+		t.Errorf("past booking unit = %s, want none", *past.UnitID)
+	}
+
+	second, err := s.CreateUnit(propertyID, &UnitRequest{UnitTypeID: unitType.UnitTypeID, UnitName: "102"})
+	if err != nil {
+		t.Fatalf("CreateUnit: %v", err)
+	}
+
+	// A booking without a unit is refused by the database while a unit is booked
+	_, err = s.db.Exec(`
+		INSERT INTO bookings (
+			property_id, created_by, guest_name, guest_id_card, guest_contact_number,
+			check_in_date, check_out_date
+		) VALUES ($1, $2, 'Whole Property', 'ID-2', '+94771234567', $3, $4)
+	`, propertyID, s.systemUserID, testDate(12), testDate(14))
+	if !isExclusionViolation(err) {
+		t.Errorf("unitless booking over a unit booking: err = %v, want an exclusion violation", err)
+	}
+
+	// With every unit deactivated nothing can be booked, not even the whole property
+	inactive := false
+	for _, unit := range []*Unit{first, second} {
+		_, err := s.UpdateUnit(unit.UnitID, &UnitRequest{UnitTypeID: unitType.UnitTypeID, UnitName: unit.UnitName, IsActive: &inactive})
+		if err != nil {
+			t.Fatalf("UpdateUnit: %v", err)
+		}
+	}
+
+	_, err = s.CreateBooking(s.systemUserID, &CreateBookingRequest{
+		PropertyID:         propertyID,
+		GuestName:          "Late Guest",
+		GuestIDCard:        "ID-3",
+		GuestContactNumber: "+94771234567",
+		CheckInDate:        testDate(20).Format("2006-01-02"),
+		CheckOutDate:       testDate(22).Format("2006-01-02"),
+		NumberOfGuests:     1,
+	})
+	var conflict *BookingConflictError
+	if !errors.As(err, &conflict) {
+		t.Errorf("booking a property with only inactive units: err = %v, want a BookingConflictError", err)
+	}
+}
+
+func TestCreateUnitRefusesSmallerUnitType(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	_, err := s.db.Exec(`
+		INSERT INTO bookings (
+			property_id, created_by, guest_name, guest_id_card, guest_contact_number,
+			check_in_date, check_out_date, number_of_guests
+		) VALUES ($1, $2, 'Family', 'ID-4', '+94771234567', $3, $4, 4)
+	`, propertyID, s.systemUserID, testDate(5), testDate(8))
+	if err != nil {
+		t.Fatalf("Failed to create booking: %v", err)
+	}
+
+	unitType, err := s.CreateUnitType(propertyID, &UnitTypeRequest{Name: "Single", MaxGuests: 1})
+	if err != nil {
+		t.Fatalf("CreateUnitType: %v", err)
+	}
+
+	_, err = s.CreateUnit(propertyID, &UnitRequest{UnitTypeID: unitType.UnitTypeID, UnitName: "1"})
+	var conflict *BookingConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("CreateUnit: err = %v, want a BookingConflictError", err)
+	}
+
+	units, err := s.GetUnits(propertyID)
+	if err != nil {
+		t.Fatalf("GetUnits: %v", err)
+	}
+	if len(units) != 0 {
+		t.Errorf("units = %+v, want none created", units)
+	}
+}
+
+// An inactive unit cannot be booked, so it leaves the bookings of the whole
+// property alone, whatever their guests
+func TestCreateInactiveUnitAdoptsNoBookings(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	bookingID := createTestBooking(t, s, propertyID, testDate(5), testDate(8))
+
+	unitType, err := s.CreateUnitType(propertyID, &UnitTypeRequest{Name: "Single", MaxGuests: 1})
+	if err != nil {
+		t.Fatalf("CreateUnitType: %v", err)
+	}
+	_, err = s.db.Exec(`UPDATE bookings SET number_of_guests = 2 WHERE booking_id = $1`, bookingID)
+	if err != nil {
+		t.Fatalf("Failed to update booking: %v", err)
+	}
+
+	inactive := false
+	_, err = s.CreateUnit(propertyID, &UnitRequest{UnitTypeID: unitType.UnitTypeID, UnitName: "1", IsActive: &inactive})
+	if err != nil {
+		t.Fatalf("CreateUnit: %v", err)
+	}
+
+	booking, err := s.GetBookingByID(bookingID)
+	if err != nil {
+		t.Fatalf("GetBookingByID: %v", err)
+	}
+	if booking.UnitID != nil {
+		t.Errorf("booking unit = %s, want none", *booking.UnitID)
+	}
+}
+
+// A requested unit must have room for the guests, whether it is booked or
+// moved into
+func TestRequestedUnitTooSmallForGuests(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	single, err := s.CreateUnitType(propertyID, &UnitTypeRequest{Name: "Single", MaxGuests: 1})
+	if err != nil {
+		t.Fatalf("CreateUnitType: %v", err)
+	}
+	double, err := s.CreateUnitType(propertyID, &UnitTypeRequest{Name: "Double", MaxGuests: 2})
+	if err != nil {
+		t.Fatalf("CreateUnitType: %v", err)
+	}
+
+	singleUnit, err := s.CreateUnit(propertyID, &UnitRequest{UnitTypeID: single.UnitTypeID, UnitName: "1"})
+	if err != nil {
+		t.Fatalf("CreateUnit: %v", err)
+	}
+	doubleUnit, err := s.CreateUnit(propertyID, &UnitRequest{UnitTypeID: double.UnitTypeID, UnitName: "2"})
+	if err != nil {
+		t.Fatalf("CreateUnit: %v", err)
+	}
+
+	book := func(unitID uuid.UUID) (*Booking, error) {
+		return s.CreateBooking(s.systemUserID, &CreateBookingRequest{
+			PropertyID:         propertyID,
+			UnitID:             &unitID,
+			GuestName:          "Couple",
+			GuestIDCard:        "ID-7",
+			GuestContactNumber: "+94771234567",
+			CheckInDate:        testDate(10).Format("2006-01-02"),
+			CheckOutDate:       testDate(12).Format("2006-01-02"),
+			NumberOfGuests:     2,
+		})
+	}
+
+	_, err = book(singleUnit.UnitID)
+	var unitErr *ServiceError
+	if !errors.As(err, &unitErr) || unitErr.Code != http.StatusBadRequest {
+		t.Fatalf("booking 2 guests into a single unit: err = %v, want a 400 ServiceError", err)
+	}
+
+	booking, err := book(doubleUnit.UnitID)
+	if err != nil {
+		t.Fatalf("booking 2 guests into a double unit: %v", err)
+	}
+
+	_, err = s.MoveBooking(booking.BookingID, s.systemUserID, &MoveBookingRequest{
+		PropertyID: propertyID,
+		UnitID:     &singleUnit.UnitID,
+		Reason:     "Upgrade the other way",
+	})
+	if !errors.As(err, &unitErr) || unitErr.Code != http.StatusBadRequest {
+		t.Fatalf("moving 2 guests into a single unit: err = %v, want a 400 ServiceError", err)
+	}
+
+	booking, err = s.GetBookingByID(booking.BookingID)
+	if err != nil {
+		t.Fatalf("GetBookingByID: %v", err)
+	}
+	if booking.UnitID == nil || *booking.UnitID != doubleUnit.UnitID {
+		t.Errorf("booking unit = %v, want it left in %s", booking.UnitID, doubleUnit.UnitID)
+	}
+}
+
+// A block of one unit closes only that unit: the others stay bookable, and
+// it clashes with bookings and blocks of that unit or of the whole property
+func TestUnitBlocks(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	otherID := createTestProperty(t, s)
+
+	unitType, err := s.CreateUnitType(propertyID, &UnitTypeRequest{Name: "Double", MaxGuests: 2})
+	if err != nil {
+		t.Fatalf("CreateUnitType: %v", err)
+	}
+
+	var units []*Unit
+	for _, name := range []string{"101", "102"} {
+		unit, err := s.CreateUnit(propertyID, &UnitRequest{UnitTypeID: unitType.UnitTypeID, UnitName: name})
+		if err != nil {
+			t.Fatalf("CreateUnit: %v", err)
+		}
+		units = append(units, unit)
+	}
+
+	blockReq := func(unitID *uuid.UUID) *CreateBlockRequest {
+		return &CreateBlockRequest{
+			UnitID:    unitID,
+			StartDate: testDate(10).Format("2006-01-02"),
+			EndDate:   testDate(13).Format("2006-01-02"),
+			Reason:    "Repainting",
+		}
+	}
+
+	block, err := s.CreateBlock(propertyID, s.systemUserID, blockReq(&units[0].UnitID))
+	if err != nil {
+		t.Fatalf("CreateBlock: %v", err)
+	}
+	if block.UnitID == nil || *block.UnitID != units[0].UnitID {
+		t.Errorf("block unit = %v, want %s", block.UnitID, units[0].UnitID)
+	}
+
+	book := func(unitID *uuid.UUID) (*Booking, error) {
+		return s.CreateBooking(s.systemUserID, &CreateBookingRequest{
+			PropertyID:         propertyID,
+			UnitID:             unitID,
+			GuestName:          "Unit Guest",
+			GuestIDCard:        "ID-9",
+			GuestContactNumber: "+94771234567",
+			CheckInDate:        testDate(11).Format("2006-01-02"),
+			CheckOutDate:       testDate(12).Format("2006-01-02"),
+			NumberOfGuests:     2,
+		})
+	}
+
+	_, err = book(&units[0].UnitID)
+	var conflict *BookingConflictError
+	if !errors.As(err, &conflict) || conflict.ConflictingBlockID == nil || *conflict.ConflictingBlockID != block.BlockID {
+		t.Errorf("booking the blocked unit: err = %v, want a conflict with block %s", err, block.BlockID)
+	}
+
+	booking, err := book(nil)
+	if err != nil {
+		t.Fatalf("booking the property with one unit blocked: %v", err)
+	}
+	if booking.UnitID == nil || *booking.UnitID != units[1].UnitID {
+		t.Errorf("booking unit = %v, want the free unit %s", booking.UnitID, units[1].UnitID)
+	}
+
+	calendar, err := s.GetRangeCalendar(propertyID, testDate(11), testDate(11))
+	if err != nil {
+		t.Fatalf("GetRangeCalendar: %v", err)
+	}
+	day := calendar.Days[0]
+	if day.Block != nil || len(day.UnitBlocks) != 1 || day.UnitBlocks[0].BlockID != block.BlockID {
+		t.Errorf("calendar blocks = %+v and %+v, want only unit block %s", day.Block, day.UnitBlocks, block.BlockID)
+	}
+	if !day.IsBooked || len(day.UnitTypes) != 1 || day.UnitTypes[0].Remaining != 0 {
+		t.Errorf("calendar day = %+v, want booked with no unit remaining", day)
+	}
+
+	// The whole property, and the other unit while it is booked, cannot be blocked
+	for _, unitID := range []*uuid.UUID{nil, &units[1].UnitID} {
+		_, err = s.CreateBlock(propertyID, s.systemUserID, blockReq(unitID))
+		if !errors.As(err, &conflict) {
+			t.Errorf("blocking unit %v over a block and a booking: err = %v, want a BookingConflictError", unitID, err)
+		}
+	}
+
+	_, err = s.CreateBlock(otherID, s.systemUserID, blockReq(&units[1].UnitID))
+	var blockErr *ServiceError
+	if !errors.As(err, &blockErr) || blockErr.Code != http.StatusBadRequest {
+		t.Errorf("blocking a unit of another property: err = %v, want a 400 ServiceError", err)
+	}
+}
+
+func TestUnitChangeErrors(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	otherID := createTestProperty(t, s)
+
+	unitType, err := s.CreateUnitType(propertyID, &UnitTypeRequest{Name: "Double", MaxGuests: 2})
+	if err != nil {
+		t.Fatalf("CreateUnitType: %v", err)
+	}
+	otherType, err := s.CreateUnitType(otherID, &UnitTypeRequest{Name: "Double", MaxGuests: 2})
+	if err != nil {
+		t.Fatalf("CreateUnitType: %v", err)
+	}
+	unit, err := s.CreateUnit(propertyID, &UnitRequest{UnitTypeID: unitType.UnitTypeID, UnitName: "101"})
+	if err != nil {
+		t.Fatalf("CreateUnit: %v", err)
+	}
+	createTestBooking(t, s, propertyID, testDate(10), testDate(12))
+	_, err = s.db.Exec(`UPDATE bookings SET unit_id = $1 WHERE property_id = $2`, unit.UnitID, propertyID)
+	if err != nil {
+		t.Fatalf("Failed to book the unit: %v", err)
+	}
+
+	call := func(_ interface{}, err error) error { return err }
+
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"unit type without a name", call(s.CreateUnitType(propertyID, &UnitTypeRequest{MaxGuests: 2})), http.StatusBadRequest},
+		{"duplicate unit type", call(s.CreateUnitType(propertyID, &UnitTypeRequest{Name: "Double", MaxGuests: 2})), http.StatusConflict},
+		{"unit type of an unknown property", call(s.CreateUnitType(uuid.New(), &UnitTypeRequest{Name: "Double", MaxGuests: 2})), http.StatusNotFound},
+		{"unknown unit type", call(s.UpdateUnitType(uuid.New(), &UnitTypeRequest{Name: "Twin", MaxGuests: 2})), http.StatusNotFound},
+		{"unit type with units", s.DeleteUnitType(unitType.UnitTypeID), http.StatusConflict},
+		{"unit without a name", call(s.CreateUnit(propertyID, &UnitRequest{UnitTypeID: unitType.UnitTypeID})), http.StatusBadRequest},
+		{"unit type of another property", call(s.CreateUnit(propertyID, &UnitRequest{UnitTypeID: otherType.UnitTypeID, UnitName: "102"})), http.StatusBadRequest},
+		{"duplicate unit", call(s.CreateUnit(propertyID, &UnitRequest{UnitTypeID: unitType.UnitTypeID, UnitName: "101"})), http.StatusConflict},
+		{"unknown unit", call(s.UpdateUnit(uuid.New(), &UnitRequest{UnitTypeID: unitType.UnitTypeID, UnitName: "103"})), http.StatusNotFound},
+		{"unit with bookings", s.DeleteUnit(unit.UnitID), http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		var unitErr *ServiceError
+		if !errors.As(tt.err, &unitErr) || unitErr.Code != tt.code {
+			t.Errorf("%s: err = %v, want a %d ServiceError", tt.name, tt.err, tt.code)
+			continue
+		}
+
+		rec := httptest.NewRecorder()
+		writeServiceError(rec, tt.err)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+}
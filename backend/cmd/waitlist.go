@@ -0,0 +1,600 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// How long a pending booking holds freed dates for a waitlisted contact
+const waitlistHoldDuration = 24 * time.Hour
+
+// Waitlist entry statuses
+const (
+	WaitlistStatusWaiting   = "waiting"
+	WaitlistStatusNotified  = "notified"
+	WaitlistStatusHeld      = "held"
+	WaitlistStatusBooked    = "booked"
+	WaitlistStatusReleased  = "released"
+	WaitlistStatusCancelled = "cancelled"
+)
+
+// WaitlistEntry is a contact waiting for dates on a fully booked property.
+// When a cancellation frees the whole stay the contact is notified and,
+// with CreateHold, a pending booking holds the dates for them until
+// HoldExpiresAt.
+type WaitlistEntry struct {
+	EntryID        uuid.UUID  `json:"entry_id"`
+	PropertyID     uuid.UUID  `json:"property_id"`
+	UnitTypeID     *uuid.UUID `json:"unit_type_id,omitempty"`
+	ContactName    string     `json:"contact_name"`
+	ContactEmail   *string    `json:"contact_email,omitempty"`
+	ContactPhone   *string    `json:"contact_phone,omitempty"`
+	CheckInDate    time.Time  `json:"check_in_date"`
+	CheckOutDate   time.Time  `json:"check_out_date"`
+	NumberOfGuests int        `json:"number_of_guests"`
+	Priority       int        `json:"priority"`
+	CreateHold     bool       `json:"create_hold"`
+	Notes          *string    `json:"notes,omitempty"`
+	Status         string     `json:"status"`
+	NotifiedAt     *time.Time `json:"notified_at,omitempty"`
+	HoldBookingID  *uuid.UUID `json:"hold_booking_id,omitempty"`
+	HoldExpiresAt  *time.Time `json:"hold_expires_at,omitempty"`
+	CreatedBy      uuid.UUID  `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type CreateWaitlistEntryRequest struct {
+	UnitTypeID     *uuid.UUID `json:"unit_type_id,omitempty"`
+	ContactName    string     `json:"contact_name"`
+	ContactEmail   *string    `json:"contact_email,omitempty"`
+	ContactPhone   *string    `json:"contact_phone,omitempty"`
+	CheckInDate    string     `json:"check_in_date"`  // "2024-01-15" format
+	CheckOutDate   string     `json:"check_out_date"` // "2024-01-20" format
+	NumberOfGuests int        `json:"number_of_guests"`
+	Priority       int        `json:"priority"` // higher goes first
+	CreateHold     bool       `json:"create_hold"`
+	Notes          *string    `json:"notes,omitempty"`
+}
+
+// Put a contact on the waitlist of a property. The stay must meet the
+// property's stay rules, so that it can be held as soon as it is free.
+func (s *BookingService) CreateWaitlistEntry(propertyID uuid.UUID, userID uuid.UUID, req *CreateWaitlistEntryRequest) (*WaitlistEntry, error) {
+	checkInDate, err := time.Parse("2006-01-02", req.CheckInDate)
+	if err != nil {
+		return nil, serviceError(http.StatusBadRequest, "invalid check-in date format: %v", err)
+	}
+
+	checkOutDate, err := time.Parse("2006-01-02", req.CheckOutDate)
+	if err != nil {
+		return nil, serviceError(http.StatusBadRequest, "invalid check-out date format: %v", err)
+	}
+
+	if !checkOutDate.After(checkInDate) {
+		return nil, serviceError(http.StatusBadRequest, "check-out date must be after check-in date")
+	}
+
+	if req.ContactName == "" {
+		return nil, serviceError(http.StatusBadRequest, "contact_name is required")
+	}
+
+	if (req.ContactEmail == nil || *req.ContactEmail == "") && (req.ContactPhone == nil || *req.ContactPhone == "") {
+		return nil, serviceError(http.StatusBadRequest, "contact_email or contact_phone is required")
+	}
+
+	if req.NumberOfGuests <= 0 {
+		return nil, serviceError(http.StatusBadRequest, "number_of_guests must be positive")
+	}
+
+	if req.ContactPhone != nil && *req.ContactPhone != "" {
+		phone := s.normalizeContactNumber(*req.ContactPhone)
+		req.ContactPhone = &phone
+	}
+
+	if req.UnitTypeID != nil {
+		var exists bool
+		err := s.db.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM unit_types WHERE unit_type_id = $1 AND property_id = $2)
+		`, *req.UnitTypeID, propertyID).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, serviceError(http.StatusBadRequest, "unit type not found for this property")
+		}
+	}
+
+	if err := s.checkStayRules(s.db, propertyID, checkInDate, checkOutDate, true); err != nil {
+		return nil, err
+	}
+
+	entryID := uuid.New()
+	query := `
+		INSERT INTO waitlist_entries (
+			entry_id, property_id, unit_type_id, contact_name, contact_email, contact_phone,
+			check_in_date, check_out_date, number_of_guests, priority, create_hold, notes, created_by
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`
+
+	_, err = s.db.Exec(query, entryID, propertyID, req.UnitTypeID, req.ContactName,
+		req.ContactEmail, req.ContactPhone, checkInDate, checkOutDate, req.NumberOfGuests,
+		req.Priority, req.CreateHold, req.Notes, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetWaitlistEntry(entryID)
+}
+
+// Waitlist of a property in the order entries are offered freed dates,
+// optionally only entries with the given status
+func (s *BookingService) GetWaitlist(propertyID uuid.UUID, status string) ([]WaitlistEntry, error) {
+	query := `
+		SELECT entry_id, property_id, unit_type_id, contact_name, contact_email, contact_phone,
+			check_in_date, check_out_date, number_of_guests, priority, create_hold, notes,
+			status, notified_at, hold_booking_id, hold_expires_at, created_by, created_at, updated_at
+		FROM waitlist_entries
+		WHERE property_id = $1
+		AND ($2 = '' OR status = $2)
+		ORDER BY priority DESC, created_at ASC
+	`
+
+	return s.queryWaitlistEntries(query, propertyID, status)
+}
+
+func (s *BookingService) GetWaitlistEntry(entryID uuid.UUID) (*WaitlistEntry, error) {
+	query := `
+		SELECT entry_id, property_id, unit_type_id, contact_name, contact_email, contact_phone,
+			check_in_date, check_out_date, number_of_guests, priority, create_hold, notes,
+			status, notified_at, hold_booking_id, hold_expires_at, created_by, created_at, updated_at
+		FROM waitlist_entries
+		WHERE entry_id = $1
+	`
+
+	entries, err := s.queryWaitlistEntries(query, entryID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, serviceError(http.StatusNotFound, "waitlist entry not found")
+	}
+
+	return &entries[0], nil
+}
+
+// Take a contact off the waitlist. Entries holding dates are released by
+// cancelling their hold booking instead.
+func (s *BookingService) CancelWaitlistEntry(entryID uuid.UUID) error {
+	result, err := s.db.Exec(`
+		UPDATE waitlist_entries
+		SET status = 'cancelled'
+		WHERE entry_id = $1
+		AND status IN ('waiting', 'notified')
+	`, entryID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// Tell an unknown entry from one that is no longer waiting
+	if rowsAffected == 0 {
+		entry, err := s.GetWaitlistEntry(entryID)
+		if err != nil {
+			return err
+		}
+		return serviceError(http.StatusConflict, "a %s waitlist entry cannot be cancelled", entry.Status)
+	}
+
+	return nil
+}
+
+func (s *BookingService) queryWaitlistEntries(query string, args ...interface{}) ([]WaitlistEntry, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []WaitlistEntry{}
+
+	for rows.Next() {
+		var entry WaitlistEntry
+		err := rows.Scan(
+			&entry.EntryID, &entry.PropertyID, &entry.UnitTypeID, &entry.ContactName,
+			&entry.ContactEmail, &entry.ContactPhone, &entry.CheckInDate, &entry.CheckOutDate,
+			&entry.NumberOfGuests, &entry.Priority, &entry.CreateHold, &entry.Notes,
+			&entry.Status, &entry.NotifiedAt, &entry.HoldBookingID, &entry.HoldExpiresAt,
+			&entry.CreatedBy, &entry.CreatedAt, &entry.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// offerFreedDates goes through the waiting entries of a property that
+// overlap dates just freed, highest priority and then longest waiting first,
+// and offers each one whose whole stay is now free. Entries overlapping a
+// stay already offered in this round wait for the next cancellation.
+func (s *BookingService) offerFreedDates(propertyID uuid.UUID, from, to time.Time) error {
+	entries, err := s.queryWaitlistEntries(`
+		SELECT entry_id, property_id, unit_type_id, contact_name, contact_email, contact_phone,
+			check_in_date, check_out_date, number_of_guests, priority, create_hold, notes,
+			status, notified_at, hold_booking_id, hold_expires_at, created_by, created_at, updated_at
+		FROM waitlist_entries
+		WHERE property_id = $1
+		AND status = 'waiting'
+		AND check_in_date >= CURRENT_DATE
+		AND check_in_date < $3 AND check_out_date > $2
+		ORDER BY priority DESC, created_at ASC
+	`, propertyID, from, to)
+	if err != nil {
+		return err
+	}
+
+	var offered []WaitlistEntry
+	for _, entry := range entries {
+		overlaps := false
+		for _, other := range offered {
+			if entry.CheckInDate.Before(other.CheckOutDate) && entry.CheckOutDate.After(other.CheckInDate) {
+				overlaps = true
+				break
+			}
+		}
+		if overlaps {
+			continue
+		}
+
+		ok, err := s.offerStay(&entry)
+		if err != nil {
+			log.Printf("Failed to offer freed dates to waitlist entry %s: %v", entry.EntryID, err)
+			continue
+		}
+		if ok {
+			offered = append(offered, entry)
+		}
+	}
+
+	return nil
+}
+
+// offerStay offers a waitlist entry its dates if they are free: the entry
+// is marked notified, or held with a pending booking made by the user who
+// added the entry, and the contact's notification is queued. It reports
+// whether the dates were offered.
+func (s *BookingService) offerStay(entry *WaitlistEntry) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// The entry may have been offered or cancelled in the meantime
+	var status, propertyName string
+	err = tx.QueryRow(`
+		SELECT w.status, p.property_name
+		FROM waitlist_entries w
+		JOIN properties p ON p.property_id = w.property_id
+		WHERE w.entry_id = $1
+		FOR UPDATE OF w
+	`, entry.EntryID).Scan(&status, &propertyName)
+	if err != nil {
+		return false, err
+	}
+	if status != WaitlistStatusWaiting {
+		return false, nil
+	}
+
+	guestContactNumber := ""
+	if entry.ContactPhone != nil {
+		guestContactNumber = *entry.ContactPhone
+	}
+	notes := "Held for waitlisted guest"
+	req := &CreateBookingRequest{
+		PropertyID:         entry.PropertyID,
+		UnitTypeID:         entry.UnitTypeID,
+		GuestName:          entry.ContactName,
+		GuestContactNumber: guestContactNumber,
+		GuestEmail:         entry.ContactEmail,
+		NumberOfGuests:     entry.NumberOfGuests,
+		BookingNotes:       &notes,
+		pending:            true,
+	}
+
+	// Free means a unit with room is free on properties with units, and
+	// nothing else booked or blocked on the dates
+	var conflict *BookingConflictError
+	unitID, err := s.assignUnit(tx, req, entry.CheckInDate, entry.CheckOutDate)
+	if errors.As(err, &conflict) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	stays, err := s.occupiedStays(tx, entry.PropertyID, unitID, entry.CheckInDate, entry.CheckOutDate, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	if len(stays) > 0 {
+		return false, nil
+	}
+
+	status = WaitlistStatusNotified
+	var holdBookingID *uuid.UUID
+	var holdExpiresAt *time.Time
+
+	if entry.CreateHold {
+		amount, priced, err := s.quoteStay(entry.PropertyID, entry.CheckInDate, entry.CheckOutDate)
+		if err != nil {
+			return false, err
+		}
+		if priced {
+			req.BookingAmount = &amount
+		}
+		req.UnitID = unitID
+
+		bookingID, err := s.createBookingTx(tx, entry.CreatedBy, req, entry.CheckInDate, entry.CheckOutDate)
+		if errors.As(err, &conflict) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		expiresAt := time.Now().Add(waitlistHoldDuration)
+		status = WaitlistStatusHeld
+		holdBookingID = &bookingID
+		holdExpiresAt = &expiresAt
+	}
+
+	_, err = tx.Exec(`
+		UPDATE waitlist_entries
+		SET status = $2, notified_at = CURRENT_TIMESTAMP, hold_booking_id = $3, hold_expires_at = $4
+		WHERE entry_id = $1
+	`, entry.EntryID, status, holdBookingID, holdExpiresAt)
+	if err != nil {
+		return false, err
+	}
+
+	data := &notificationData{
+		GuestName:    entry.ContactName,
+		PropertyName: propertyName,
+		CheckIn:      entry.CheckInDate.Format("2006-01-02"),
+		CheckOut:     entry.CheckOutDate.Format("2006-01-02"),
+		Nights:       stayNights(entry.CheckInDate, entry.CheckOutDate),
+		Guests:       entry.NumberOfGuests,
+	}
+	if holdExpiresAt != nil {
+		data.HoldExpiresAt = holdExpiresAt.UTC().Format("2006-01-02 15:04 MST")
+	}
+
+	err = s.queueNotification(tx, NotificationWaitlistAvailable, holdBookingID, entry.ContactEmail, entry.ContactPhone, data)
+	if err != nil {
+		return false, err
+	}
+
+	if holdBookingID != nil {
+		err = s.recordBookingEvent(tx, EventBookingCreated, *holdBookingID, &BookingEventPayload{Cause: EventCauseWaitlist})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SettleWaitlistHolds cancels hold bookings left pending past their expiry,
+// offering the dates to the next entry in line, and then closes the holds
+// that have been confirmed (booked) or cancelled (released)
+func (s *BookingService) SettleWaitlistHolds() {
+	rows, err := s.db.Query(`
+		SELECT w.hold_booking_id
+		FROM waitlist_entries w
+		JOIN bookings b ON b.booking_id = w.hold_booking_id
+		WHERE w.status = 'held'
+		AND w.hold_expires_at <= CURRENT_TIMESTAMP
+		AND b.booking_status = 'pending'
+	`)
+	if err != nil {
+		log.Printf("Failed to list expired waitlist holds: %v", err)
+		return
+	}
+
+	var expired []uuid.UUID
+	for rows.Next() {
+		var bookingID uuid.UUID
+		if err := rows.Scan(&bookingID); err != nil {
+			rows.Close()
+			log.Printf("Failed to list expired waitlist holds: %v", err)
+			return
+		}
+		expired = append(expired, bookingID)
+	}
+	rows.Close()
+
+	for _, bookingID := range expired {
+		if err := s.releaseHold(bookingID); err != nil {
+			log.Printf("Failed to release waitlist hold %s: %v", bookingID, err)
+		}
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE waitlist_entries w
+		SET status = CASE
+			WHEN EXISTS (
+				SELECT 1 FROM bookings b
+				WHERE b.booking_id = w.hold_booking_id
+				AND b.booking_status IN ('confirmed', 'completed')
+			) THEN 'booked'
+			ELSE 'released'
+		END
+		WHERE w.status = 'held'
+		AND NOT EXISTS (
+			SELECT 1 FROM bookings b
+			WHERE b.booking_id = w.hold_booking_id
+			AND b.booking_status = 'pending'
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to settle waitlist holds: %v", err)
+	}
+}
+
+// releaseHold cancels an expired hold booking, whatever its dates, and
+// offers them to the waitlist again
+func (s *BookingService) releaseHold(bookingID uuid.UUID) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var propertyID uuid.UUID
+	var checkIn, checkOut time.Time
+	err = tx.QueryRow(`
+		UPDATE bookings
+		SET booking_status = 'cancelled', updated_at = CURRENT_TIMESTAMP
+		WHERE booking_id = $1
+		AND booking_status = 'pending'
+		RETURNING property_id, check_in_date, check_out_date
+	`, bookingID).Scan(&propertyID, &checkIn, &checkOut)
+	if err == sql.ErrNoRows {
+		// Confirmed or cancelled just now
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.recordBookingEvent(tx, EventBookingCancelled, bookingID, &BookingEventPayload{Cause: EventCauseHoldExpired})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	return s.offerFreedDates(propertyID, checkIn, checkOut)
+}
+
+// runWaitlistWorker settles waitlist holds every interval
+func (s *BookingService) runWaitlistWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.SettleWaitlistHolds()
+		<-ticker.C
+	}
+}
+
+// HTTP Handlers
+func (s *BookingService) CreateWaitlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	var req CreateWaitlistEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := s.requestUserID(r)
+
+	entry, err := s.CreateWaitlistEntry(propertyID, userID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
+
+func (s *BookingService) GetWaitlistHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	propertyIDStr := vars["propertyId"]
+	status := r.URL.Query().Get("status")
+
+	propertyID, err := uuid.Parse(propertyIDStr)
+	if err != nil {
+		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := s.GetWaitlist(propertyID, status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func (s *BookingService) GetWaitlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	entryIDStr := vars["entryId"]
+
+	entryID, err := uuid.Parse(entryIDStr)
+	if err != nil {
+		http.Error(w, "Invalid waitlist entry ID", http.StatusBadRequest)
+		return
+	}
+
+	entry, err := s.GetWaitlistEntry(entryID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+func (s *BookingService) CancelWaitlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	entryIDStr := vars["entryId"]
+
+	entryID, err := uuid.Parse(entryIDStr)
+	if err != nil {
+		http.Error(w, "Invalid waitlist entry ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.CancelWaitlistEntry(entryID); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
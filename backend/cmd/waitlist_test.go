@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// A cancellation offers its dates to the waitlist in priority order, and an
+// expired hold passes them on to the next entry in line
+func TestWaitlistOffersFreedDates(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	bookingID := createTestBooking(t, s, propertyID, testDate(20), testDate(23))
+
+	phone := "+94771234567"
+	join := func(name string, checkIn, checkOut, priority int, hold bool) *WaitlistEntry {
+		t.Helper()
+
+		entry, err := s.CreateWaitlistEntry(propertyID, s.systemUserID, &CreateWaitlistEntryRequest{
+			ContactName:    name,
+			ContactPhone:   &phone,
+			CheckInDate:    testDate(checkIn).Format("2006-01-02"),
+			CheckOutDate:   testDate(checkOut).Format("2006-01-02"),
+			NumberOfGuests: 2,
+			Priority:       priority,
+			CreateHold:     hold,
+		})
+		if err != nil {
+			t.Fatalf("CreateWaitlistEntry: %v", err)
+		}
+
+		return entry
+	}
+
+	// Joined first, but the held entry goes before it and takes a night it wants
+	waiting := join("Waiting Guest", 20, 22, 1, false)
+	held := join("Held Guest", 21, 23, 5, true)
+
+	if err := s.CancelBooking(bookingID, s.systemUserID); err != nil {
+		t.Fatalf("CancelBooking: %v", err)
+	}
+
+	entry, err := s.GetWaitlistEntry(held.EntryID)
+	if err != nil {
+		t.Fatalf("GetWaitlistEntry: %v", err)
+	}
+	if entry.Status != WaitlistStatusHeld || entry.HoldBookingID == nil || entry.HoldExpiresAt == nil {
+		t.Fatalf("priority entry = %s holding %v until %v, want it held", entry.Status, entry.HoldBookingID, entry.HoldExpiresAt)
+	}
+
+	hold, err := s.GetBookingByID(*entry.HoldBookingID)
+	if err != nil {
+		t.Fatalf("GetBookingByID: %v", err)
+	}
+	if hold.BookingStatus != "pending" || !hold.CheckInDate.Equal(testDate(21)) || !hold.CheckOutDate.Equal(testDate(23)) || hold.GuestName != "Held Guest" {
+		t.Errorf("hold = %s booking for %s from %s to %s, want a pending booking for Held Guest over the entry's dates",
+			hold.BookingStatus, hold.GuestName, hold.CheckInDate, hold.CheckOutDate)
+	}
+
+	if entry, err := s.GetWaitlistEntry(waiting.EntryID); err != nil || entry.Status != WaitlistStatusWaiting {
+		t.Fatalf("overlapping entry = %+v (%v), want it still waiting", entry, err)
+	}
+
+	// Once the hold expires its dates go to the entry that was passed over
+	_, err = s.db.Exec(`
+		UPDATE waitlist_entries SET hold_expires_at = CURRENT_TIMESTAMP - INTERVAL '1 minute' WHERE entry_id = $1
+	`, held.EntryID)
+	if err != nil {
+		t.Fatalf("Failed to expire hold: %v", err)
+	}
+
+	s.SettleWaitlistHolds()
+
+	if entry, err := s.GetWaitlistEntry(held.EntryID); err != nil || entry.Status != WaitlistStatusReleased {
+		t.Errorf("expired entry = %+v (%v), want it released", entry, err)
+	}
+	if hold, err := s.GetBookingByID(hold.BookingID); err != nil || hold.BookingStatus != "cancelled" {
+		t.Errorf("expired hold = %+v (%v), want it cancelled", hold, err)
+	}
+
+	entry, err = s.GetWaitlistEntry(waiting.EntryID)
+	if err != nil {
+		t.Fatalf("GetWaitlistEntry: %v", err)
+	}
+	if entry.Status != WaitlistStatusNotified || entry.NotifiedAt == nil || entry.HoldBookingID != nil {
+		t.Errorf("next entry = %s at %v holding %v, want it notified without a hold", entry.Status, entry.NotifiedAt, entry.HoldBookingID)
+	}
+
+	// A notified contact can still be taken off the list, but only once
+	if err := s.CancelWaitlistEntry(waiting.EntryID); err != nil {
+		t.Fatalf("CancelWaitlistEntry: %v", err)
+	}
+	err = s.CancelWaitlistEntry(waiting.EntryID)
+
+	var cancelErr *ServiceError
+	if !errors.As(err, &cancelErr) || cancelErr.Code != http.StatusConflict {
+		t.Errorf("cancelling a cancelled waitlist entry: err = %v, want a 409 ServiceError", err)
+	}
+}
+
+// Cancelling through a booking update frees the dates just like CancelBooking
+func TestUpdateBookingCancellationOffersFreedDates(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+	bookingID := createTestBooking(t, s, propertyID, testDate(20), testDate(23))
+
+	phone := "+94771234567"
+	entry, err := s.CreateWaitlistEntry(propertyID, s.systemUserID, &CreateWaitlistEntryRequest{
+		ContactName:    "Waiting Guest",
+		ContactPhone:   &phone,
+		CheckInDate:    testDate(20).Format("2006-01-02"),
+		CheckOutDate:   testDate(22).Format("2006-01-02"),
+		NumberOfGuests: 2,
+	})
+	if err != nil {
+		t.Fatalf("CreateWaitlistEntry: %v", err)
+	}
+
+	cancelled := "cancelled"
+	if _, err := s.UpdateBooking(bookingID, s.systemUserID, &UpdateBookingRequest{BookingStatus: &cancelled}); err != nil {
+		t.Fatalf("UpdateBooking: %v", err)
+	}
+
+	entry, err = s.GetWaitlistEntry(entry.EntryID)
+	if err != nil {
+		t.Fatalf("GetWaitlistEntry: %v", err)
+	}
+	if entry.Status != WaitlistStatusNotified || entry.NotifiedAt == nil {
+		t.Errorf("entry = %s at %v, want it notified of the freed dates", entry.Status, entry.NotifiedAt)
+	}
+}
+
+func TestCreateWaitlistEntryErrors(t *testing.T) {
+	s := newTestService(t)
+	propertyID := createTestProperty(t, s)
+
+	phone := "+94771234567"
+	empty := ""
+	otherUnitType := uuid.New()
+
+	tests := []struct {
+		name string
+		req  CreateWaitlistEntryRequest
+	}{
+		{"bad date", CreateWaitlistEntryRequest{ContactName: "Guest", ContactPhone: &phone, CheckInDate: "20-06-2026", CheckOutDate: testDate(12).Format("2006-01-02"), NumberOfGuests: 1}},
+		{"no nights", CreateWaitlistEntryRequest{ContactName: "Guest", ContactPhone: &phone, CheckInDate: testDate(12).Format("2006-01-02"), CheckOutDate: testDate(12).Format("2006-01-02"), NumberOfGuests: 1}},
+		{"no name", CreateWaitlistEntryRequest{ContactPhone: &phone, CheckInDate: testDate(10).Format("2006-01-02"), CheckOutDate: testDate(12).Format("2006-01-02"), NumberOfGuests: 1}},
+		{"no contact", CreateWaitlistEntryRequest{ContactName: "Guest", ContactPhone: &empty, CheckInDate: testDate(10).Format("2006-01-02"), CheckOutDate: testDate(12).Format("2006-01-02"), NumberOfGuests: 1}},
+		{"no guests", CreateWaitlistEntryRequest{ContactName: "Guest", ContactPhone: &phone, CheckInDate: testDate(10).Format("2006-01-02"), CheckOutDate: testDate(12).Format("2006-01-02")}},
+		{"unknown unit type", CreateWaitlistEntryRequest{ContactName: "Guest", ContactPhone: &phone, CheckInDate: testDate(10).Format("2006-01-02"), CheckOutDate: testDate(12).Format("2006-01-02"), NumberOfGuests: 1, UnitTypeID: &otherUnitType}},
+	}
+
+	for _, tt := range tests {
+		_, err := s.CreateWaitlistEntry(propertyID, s.systemUserID, &tt.req)
+
+		var entryErr *ServiceError
+		if !errors.As(err, &entryErr) || entryErr.Code != http.StatusBadRequest {
+			t.Errorf("%s: err = %v, want a 400 ServiceError", tt.name, err)
+		}
+	}
+
+	entries, err := s.GetWaitlist(propertyID, "")
+	if err != nil {
+		t.Fatalf("GetWaitlist: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("waitlist = %+v, want no entries", entries)
+	}
+}
+
+func TestUnknownWaitlistEntryHandlers(t *testing.T) {
+	s := newTestService(t)
+	entryID := uuid.New().String()
+
+	handlers := map[string]http.HandlerFunc{
+		"GET /waitlist/{entryId}":    s.GetWaitlistEntryHandler,
+		"DELETE /waitlist/{entryId}": s.CancelWaitlistEntryHandler,
+	}
+
+	for name, handler := range handlers {
+		req := httptest.NewRequest("GET", "/api/v1/waitlist/"+entryID, nil)
+		req = mux.SetURLVars(req, map[string]string{"entryId": entryID})
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d (%s), want %d", name, rec.Code, rec.Body.String(), http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,812 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Delivery attempts before a webhook delivery is given up on
+const (
+	maxWebhookAttempts    = 8
+	webhookBatchSize      = 50
+	webhookResponseLimit  = 1024
+	webhookRequestTimeout = 10 * time.Second
+
+	// How long a claimed delivery is left to the server sending it
+	webhookClaimTimeout = time.Minute
+)
+
+// Webhook delivery statuses
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// webhookClient only connects to public addresses, so a webhook whose host
+// resolves, or redirects, to the server's own network is not reached
+var webhookClient = &http.Client{
+	Timeout: webhookRequestTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: webhookRequestTimeout,
+			Control: refusePrivateWebhookAddress,
+		}).DialContext,
+		TLSHandshakeTimeout: webhookRequestTimeout,
+	},
+}
+
+// privateWebhookClient is used when webhooks may point at private
+// addresses, e.g. in development
+var privateWebhookClient = &http.Client{Timeout: webhookRequestTimeout}
+
+// Webhook is an endpoint that is POSTed the booking events it subscribes
+// to. Each request is signed with the webhook's secret, which is only
+// returned when the webhook is created or the secret is changed.
+type Webhook struct {
+	WebhookID   uuid.UUID `json:"webhook_id"`
+	URL         string    `json:"url"`
+	Events      []string  `json:"events"`
+	Secret      *string   `json:"secret,omitempty"`
+	Description *string   `json:"description,omitempty"`
+	IsActive    bool      `json:"is_active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type CreateWebhookRequest struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Secret      *string  `json:"secret,omitempty"` // generated if not given
+	Description *string  `json:"description,omitempty"`
+}
+
+type UpdateWebhookRequest struct {
+	URL         *string  `json:"url,omitempty"`
+	Events      []string `json:"events,omitempty"`
+	Secret      *string  `json:"secret,omitempty"`
+	Description *string  `json:"description,omitempty"`
+	IsActive    *bool    `json:"is_active,omitempty"`
+}
+
+// WebhookPayload is the JSON body POSTed to webhooks. EventID is the
+// domain event's, the same for every webhook the event is delivered to.
+type WebhookPayload struct {
+	EventID   uuid.UUID `json:"event_id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Booking   *Booking  `json:"booking"`
+	Payment   *Payment  `json:"payment,omitempty"`
+}
+
+// WebhookDelivery is one event queued for one webhook, with the outcome of
+// its last attempt
+type WebhookDelivery struct {
+	DeliveryID     uuid.UUID       `json:"delivery_id"`
+	WebhookID      uuid.UUID       `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus *int            `json:"response_status,omitempty"`
+	ResponseBody   *string         `json:"response_body,omitempty"`
+	LastError      *string         `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+func validWebhookEvent(event string) bool {
+	switch event {
+	case EventBookingCreated, EventBookingUpdated, EventBookingCancelled, EventPaymentReceived:
+		return true
+	}
+	return false
+}
+
+func (s *BookingService) validateWebhook(webhookURL string, events []string) error {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return serviceError(http.StatusBadRequest, "url must be an absolute http or https URL")
+	}
+
+	if !s.allowPrivateWebhooks && privateWebhookHost(parsed.Hostname()) {
+		return serviceError(http.StatusBadRequest, "url must not point at a loopback, private or link-local address")
+	}
+
+	if len(events) == 0 {
+		return serviceError(http.StatusBadRequest, "events must list at least one event")
+	}
+	for _, event := range events {
+		if !validWebhookEvent(event) {
+			return serviceError(http.StatusBadRequest, "unknown event %q; events are 'booking.created', 'booking.updated', 'booking.cancelled' and 'payment.received'", event)
+		}
+	}
+
+	return nil
+}
+
+// privateWebhookAddress reports whether an address is on the server's own
+// machine or network rather than a subscriber's
+func privateWebhookAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// privateWebhookHost reports whether a webhook host is, or resolves to, a
+// private address. Hosts that can't be resolved now are let through; the
+// address is checked again each time a delivery connects.
+func privateWebhookHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return privateWebhookAddress(ip)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookRequestTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if privateWebhookAddress(addr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// refusePrivateWebhookAddress is the dialer check behind webhookClient
+func refusePrivateWebhookAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || privateWebhookAddress(ip) {
+		return fmt.Errorf("webhook address %s is loopback, private or link-local", host)
+	}
+
+	return nil
+}
+
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
+
+// Register a webhook endpoint for the given events
+func (s *BookingService) CreateWebhook(userID uuid.UUID, req *CreateWebhookRequest) (*Webhook, error) {
+	if err := s.validateWebhook(req.URL, req.Events); err != nil {
+		return nil, err
+	}
+
+	var secret string
+	if req.Secret != nil && *req.Secret != "" {
+		secret = *req.Secret
+	} else {
+		var err error
+		if secret, err = generateWebhookSecret(); err != nil {
+			return nil, err
+		}
+	}
+
+	webhookID := uuid.New()
+	_, err := s.db.Exec(`
+		INSERT INTO webhooks (webhook_id, url, events, secret, description, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, webhookID, req.URL, pq.Array(req.Events), secret, req.Description, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, err := s.GetWebhook(webhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook.Secret = &secret
+	return webhook, nil
+}
+
+func (s *BookingService) GetWebhooks() ([]Webhook, error) {
+	return s.queryWebhooks(`
+		SELECT webhook_id, url, events, description, is_active, created_at, updated_at
+		FROM webhooks
+		ORDER BY created_at ASC
+	`)
+}
+
+func (s *BookingService) GetWebhook(webhookID uuid.UUID) (*Webhook, error) {
+	webhooks, err := s.queryWebhooks(`
+		SELECT webhook_id, url, events, description, is_active, created_at, updated_at
+		FROM webhooks
+		WHERE webhook_id = $1
+	`, webhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(webhooks) == 0 {
+		return nil, serviceError(http.StatusNotFound, "webhook not found")
+	}
+
+	return &webhooks[0], nil
+}
+
+func (s *BookingService) UpdateWebhook(webhookID uuid.UUID, req *UpdateWebhookRequest) (*Webhook, error) {
+	webhook, err := s.GetWebhook(webhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.URL != nil {
+		webhook.URL = *req.URL
+	}
+	if req.Events != nil {
+		webhook.Events = req.Events
+	}
+	if req.Description != nil {
+		webhook.Description = req.Description
+	}
+	if req.IsActive != nil {
+		webhook.IsActive = *req.IsActive
+	}
+
+	if err := s.validateWebhook(webhook.URL, webhook.Events); err != nil {
+		return nil, err
+	}
+
+	// An empty secret is replaced with a generated one, as on creation
+	if req.Secret != nil && *req.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+		req.Secret = &secret
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE webhooks
+		SET url = $2, events = $3, description = $4, is_active = $5,
+			secret = COALESCE($6, secret)
+		WHERE webhook_id = $1
+	`, webhookID, webhook.URL, pq.Array(webhook.Events), webhook.Description, webhook.IsActive, req.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, err = s.GetWebhook(webhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook.Secret = req.Secret
+	return webhook, nil
+}
+
+// Remove a webhook with its delivery log
+func (s *BookingService) DeleteWebhook(webhookID uuid.UUID) error {
+	result, err := s.db.Exec(`DELETE FROM webhooks WHERE webhook_id = $1`, webhookID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return serviceError(http.StatusNotFound, "webhook not found")
+	}
+
+	return nil
+}
+
+func (s *BookingService) queryWebhooks(query string, args ...interface{}) ([]Webhook, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+		err := rows.Scan(
+			&webhook.WebhookID, &webhook.URL, pq.Array(&webhook.Events), &webhook.Description,
+			&webhook.IsActive, &webhook.CreatedAt, &webhook.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, nil
+}
+
+// webhookEventSink queues the events for the webhooks subscribed to them
+type webhookEventSink struct {
+	s *BookingService
+}
+
+func (webhookEventSink) Name() string {
+	return "webhooks"
+}
+
+func (sink webhookEventSink) HandleEvent(q queryer, event *DomainEvent, payload *BookingEventPayload) error {
+	if !validWebhookEvent(event.EventType) {
+		return nil
+	}
+
+	data, err := json.Marshal(WebhookPayload{
+		EventID:   event.EventID,
+		Event:     event.EventType,
+		CreatedAt: event.CreatedAt,
+		Booking:   payload.Booking,
+		Payment:   payload.Payment,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT webhook_id, $1::varchar, $2::jsonb
+		FROM webhooks
+		WHERE is_active AND $1 = ANY(events)
+	`, event.EventType, data)
+	return err
+}
+
+// signWebhook is the X-Webhook-Signature header for a payload sent at the
+// given Unix time: "sha256=" and the hex HMAC-SHA256, keyed with the
+// webhook secret, of "<timestamp>.<payload>"
+func signWebhook(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookAttempt is a delivery being attempted, with where it goes
+type webhookAttempt struct {
+	deliveryID uuid.UUID
+	event      string
+	payload    []byte
+	attempts   int
+	url        string
+	secret     string
+}
+
+// sendWebhook POSTs a delivery to its webhook. Responses other than 2xx
+// are errors.
+func sendWebhook(client *http.Client, attempt *webhookAttempt) (int, string, error) {
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest("POST", attempt.url, bytes.NewReader(attempt.payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "booking-service-webhooks")
+	req.Header.Set("X-Webhook-Event", attempt.event)
+	req.Header.Set("X-Webhook-Delivery", attempt.deliveryID.String())
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", signWebhook(attempt.secret, timestamp, attempt.payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, string(body), fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return resp.StatusCode, string(body), nil
+}
+
+// sanitizeResponseText makes text from a webhook's response safe to store:
+// PostgreSQL TEXT can't hold NUL bytes or invalid UTF-8, which a response
+// body cut off at webhookResponseLimit may end with
+func sanitizeResponseText(text string) string {
+	return strings.ToValidUTF8(strings.ReplaceAll(text, "\x00", ""), "\uFFFD")
+}
+
+// claimWebhookDelivery takes the oldest due delivery, or returns nil when
+// there is none. The claim pushes its next attempt back by
+// webhookClaimTimeout rather than holding a lock while it is sent, so
+// other servers skip it, and it is picked up again if this one dies
+// before recording the outcome.
+func (s *BookingService) claimWebhookDelivery() (*webhookAttempt, error) {
+	var attempt webhookAttempt
+	err := s.db.QueryRow(`
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = $1
+		FROM webhooks w
+		WHERE w.webhook_id = d.webhook_id
+		AND d.delivery_id = (
+			SELECT due.delivery_id
+			FROM webhook_deliveries due
+			JOIN webhooks dw ON dw.webhook_id = due.webhook_id
+			WHERE due.status = 'pending'
+			AND due.next_attempt_at <= CURRENT_TIMESTAMP
+			AND dw.is_active
+			ORDER BY due.created_at
+			LIMIT 1
+			FOR UPDATE OF due SKIP LOCKED
+		)
+		RETURNING d.delivery_id, d.event, d.payload, d.attempts, w.url, w.secret
+	`, time.Now().Add(webhookClaimTimeout)).Scan(
+		&attempt.deliveryID, &attempt.event, &attempt.payload, &attempt.attempts,
+		&attempt.url, &attempt.secret,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+// attemptWebhook sends a claimed delivery once and records the outcome.
+// Failures are retried after retryDelay until maxWebhookAttempts. No
+// transaction is held open while the webhook is called.
+func (s *BookingService) attemptWebhook(attempt *webhookAttempt) error {
+	attempts := attempt.attempts + 1
+	client := webhookClient
+	if s.allowPrivateWebhooks {
+		client = privateWebhookClient
+	}
+
+	statusCode, body, sendErr := sendWebhook(client, attempt)
+	body = sanitizeResponseText(body)
+
+	var responseStatus *int
+	if statusCode != 0 {
+		responseStatus = &statusCode
+	}
+
+	if sendErr == nil {
+		_, err := s.db.Exec(`
+			UPDATE webhook_deliveries
+			SET status = 'succeeded', attempts = $2, response_status = $3, response_body = $4,
+				last_error = NULL, last_attempt_at = CURRENT_TIMESTAMP, delivered_at = CURRENT_TIMESTAMP
+			WHERE delivery_id = $1
+		`, attempt.deliveryID, attempts, responseStatus, body)
+		return err
+	}
+
+	status := WebhookDeliveryPending
+	if attempts >= maxWebhookAttempts {
+		status = WebhookDeliveryFailed
+	}
+	_, err := s.db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, response_status = $4, response_body = $5,
+			last_error = $6, last_attempt_at = CURRENT_TIMESTAMP, next_attempt_at = $7
+		WHERE delivery_id = $1
+	`, attempt.deliveryID, status, attempts, responseStatus, body,
+		sanitizeResponseText(sendErr.Error()), time.Now().Add(retryDelay(attempts)))
+	return err
+}
+
+// DeliverWebhooks sends the webhook deliveries that are due, oldest first,
+// up to webhookBatchSize of them. Each is claimed before it is sent, so
+// several servers can share the queue.
+func (s *BookingService) DeliverWebhooks() error {
+	for i := 0; i < webhookBatchSize; i++ {
+		attempt, err := s.claimWebhookDelivery()
+		if err != nil {
+			return err
+		}
+		if attempt == nil {
+			return nil
+		}
+
+		if err := s.attemptWebhook(attempt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Send a delivery again now, whatever its status. It starts over with a
+// full set of attempts, so if it fails it is retried as usual.
+func (s *BookingService) RedeliverWebhook(deliveryID uuid.UUID) (*WebhookDelivery, error) {
+	var attempt webhookAttempt
+	err := s.db.QueryRow(`
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = $2
+		FROM webhooks w
+		WHERE w.webhook_id = d.webhook_id
+		AND d.delivery_id = $1
+		RETURNING d.delivery_id, d.event, d.payload, w.url, w.secret
+	`, deliveryID, time.Now().Add(webhookClaimTimeout)).Scan(
+		&attempt.deliveryID, &attempt.event, &attempt.payload, &attempt.url, &attempt.secret,
+	)
+	if err == sql.ErrNoRows {
+		return nil, serviceError(http.StatusNotFound, "webhook delivery not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.attemptWebhook(&attempt); err != nil {
+		return nil, err
+	}
+
+	return s.GetWebhookDelivery(deliveryID)
+}
+
+// Deliveries of a webhook, newest first, optionally only those with the
+// given status
+func (s *BookingService) GetWebhookDeliveries(webhookID uuid.UUID, status string) ([]WebhookDelivery, error) {
+	return s.queryWebhookDeliveries(`
+		SELECT delivery_id, webhook_id, event, payload, status, attempts, response_status,
+			response_body, last_error, next_attempt_at, last_attempt_at, delivered_at, created_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+		LIMIT 200
+	`, webhookID, status)
+}
+
+func (s *BookingService) GetWebhookDelivery(deliveryID uuid.UUID) (*WebhookDelivery, error) {
+	deliveries, err := s.queryWebhookDeliveries(`
+		SELECT delivery_id, webhook_id, event, payload, status, attempts, response_status,
+			response_body, last_error, next_attempt_at, last_attempt_at, delivered_at, created_at
+		FROM webhook_deliveries
+		WHERE delivery_id = $1
+	`, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(deliveries) == 0 {
+		return nil, serviceError(http.StatusNotFound, "webhook delivery not found")
+	}
+
+	return &deliveries[0], nil
+}
+
+func (s *BookingService) queryWebhookDeliveries(query string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var payload []byte
+		err := rows.Scan(
+			&delivery.DeliveryID, &delivery.WebhookID, &delivery.Event, &payload, &delivery.Status,
+			&delivery.Attempts, &delivery.ResponseStatus, &delivery.ResponseBody, &delivery.LastError,
+			&delivery.NextAttemptAt, &delivery.LastAttemptAt, &delivery.DeliveredAt, &delivery.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Payload = payload
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+// runWebhookWorker delivers due webhooks every interval
+func (s *BookingService) runWebhookWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.DeliverWebhooks(); err != nil {
+			log.Printf("Failed to deliver webhooks: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// HTTP Handlers
+func (s *BookingService) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := s.requestUserID(r)
+
+	webhook, err := s.CreateWebhook(userID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(webhook)
+}
+
+func (s *BookingService) GetWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := s.GetWebhooks()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+func (s *BookingService) GetWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	webhookIDStr := vars["webhookId"]
+
+	webhookID, err := uuid.Parse(webhookIDStr)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := s.GetWebhook(webhookID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhook)
+}
+
+func (s *BookingService) UpdateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	webhookIDStr := vars["webhookId"]
+
+	webhookID, err := uuid.Parse(webhookIDStr)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := s.UpdateWebhook(webhookID, &req)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhook)
+}
+
+func (s *BookingService) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	webhookIDStr := vars["webhookId"]
+
+	webhookID, err := uuid.Parse(webhookIDStr)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.DeleteWebhook(webhookID); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *BookingService) GetWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	webhookIDStr := vars["webhookId"]
+	status := r.URL.Query().Get("status")
+
+	webhookID, err := uuid.Parse(webhookIDStr)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := s.GetWebhookDeliveries(webhookID, status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+func (s *BookingService) GetWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deliveryIDStr := vars["deliveryId"]
+
+	deliveryID, err := uuid.Parse(deliveryIDStr)
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+
+	delivery, err := s.GetWebhookDelivery(deliveryID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(delivery)
+}
+
+func (s *BookingService) RedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deliveryIDStr := vars["deliveryId"]
+
+	deliveryID, err := uuid.Parse(deliveryIDStr)
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+
+	delivery, err := s.RedeliverWebhook(deliveryID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(delivery)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+func TestSanitizeResponseText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"ok", "ok"},
+		{"null\x00byte", "nullbyte"},
+		{"cut off \xe2\x82", "cut off �"},
+		{"\xff\xfe", "�"},
+		{"Réservation", "Réservation"},
+	}
+
+	for _, tt := range tests {
+		got := sanitizeResponseText(tt.text)
+		if got != tt.want || !utf8.ValidString(got) {
+			t.Errorf("sanitizeResponseText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// Webhooks can't be pointed at the server's own machine or network, and
+// deliveries don't connect to it even if a host resolves there later
+func TestPrivateWebhookHosts(t *testing.T) {
+	s := &BookingService{}
+	events := []string{EventBookingCreated}
+
+	tests := []struct {
+		url     string
+		private bool
+	}{
+		{"http://localhost:8080/hooks", true},
+		{"http://api.localhost/hooks", true},
+		{"http://127.0.0.1/hooks", true},
+		{"http://[::1]/hooks", true},
+		{"http://0.0.0.0/hooks", true},
+		{"http://10.1.2.3/hooks", true},
+		{"http://172.16.0.1/hooks", true},
+		{"http://192.168.1.10/hooks", true},
+		{"http://169.254.169.254/latest/meta-data", true},
+		{"http://[fe80::1]/hooks", true},
+		{"http://[fd00::1]/hooks", true},
+		{"https://93.184.216.34/hooks", false},
+		{"https://[2606:4700::1111]/hooks", false},
+	}
+
+	for _, tt := range tests {
+		err := s.validateWebhook(tt.url, events)
+
+		var webhookErr *ServiceError
+		refused := errors.As(err, &webhookErr) && webhookErr.Code == http.StatusBadRequest
+		if refused != tt.private {
+			t.Errorf("validateWebhook(%q) = %v, want it refused: %v", tt.url, err, tt.private)
+		}
+	}
+
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	_, _, err := sendWebhook(webhookClient, &webhookAttempt{
+		deliveryID: uuid.New(),
+		event:      EventBookingCreated,
+		payload:    []byte("{}"),
+		url:        server.URL,
+		secret:     "whsec_test",
+	})
+	if err == nil || called {
+		t.Errorf("delivery to %s: err = %v, called = %v, want it refused before connecting", server.URL, err, called)
+	}
+}
+
+// Deliveries are sent without their rows locked, and whatever the webhook
+// answers can be stored
+func TestDeliverWebhooks(t *testing.T) {
+	s := newTestService(t)
+	s.allowPrivateWebhooks = true
+
+	var deliveryID uuid.UUID
+	var locked []error
+	status := http.StatusInternalServerError
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Another server could take the row right now, if it were due
+		_, err := s.db.Exec(`
+			SELECT 1 FROM webhook_deliveries WHERE delivery_id = $1 FOR UPDATE NOWAIT
+		`, deliveryID)
+		locked = append(locked, err)
+
+		w.WriteHeader(status)
+		w.Write([]byte("binary\x00body \xe2\x82"))
+	}))
+	defer server.Close()
+
+	webhook, err := s.CreateWebhook(s.systemUserID, &CreateWebhookRequest{
+		URL:    server.URL,
+		Events: []string{EventBookingCreated},
+	})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+
+	// Ahead of anything else queued
+	err = s.db.QueryRow(`
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, created_at)
+		VALUES ($1, $2, '{}', '2000-01-01')
+		RETURNING delivery_id
+	`, webhook.WebhookID, EventBookingCreated).Scan(&deliveryID)
+	if err != nil {
+		t.Fatalf("Failed to queue delivery: %v", err)
+	}
+
+	if err := s.DeliverWebhooks(); err != nil {
+		t.Fatalf("DeliverWebhooks: %v", err)
+	}
+
+	delivery, err := s.GetWebhookDelivery(deliveryID)
+	if err != nil {
+		t.Fatalf("GetWebhookDelivery: %v", err)
+	}
+	if delivery.Status != WebhookDeliveryPending || delivery.Attempts != 1 {
+		t.Errorf("after a failed attempt: status %s, %d attempts", delivery.Status, delivery.Attempts)
+	}
+	if delivery.ResponseBody == nil || *delivery.ResponseBody != "binarybody �" {
+		t.Errorf("response body = %v", delivery.ResponseBody)
+	}
+
+	// Not due again yet
+	if err := s.DeliverWebhooks(); err != nil {
+		t.Fatalf("DeliverWebhooks: %v", err)
+	}
+	if len(locked) != 1 {
+		t.Fatalf("webhook was called %d times, want 1", len(locked))
+	}
+
+	status = http.StatusOK
+	delivery, err = s.RedeliverWebhook(deliveryID)
+	if err != nil {
+		t.Fatalf("RedeliverWebhook: %v", err)
+	}
+	if delivery.Status != WebhookDeliverySucceeded || delivery.DeliveredAt == nil {
+		t.Errorf("after redelivery: status %s, delivered at %v", delivery.Status, delivery.DeliveredAt)
+	}
+
+	for i, err := range locked {
+		if err != nil {
+			t.Errorf("call %d: delivery row was locked while it was sent: %v", i+1, err)
+		}
+	}
+}
+
+func TestWebhookChangeErrors(t *testing.T) {
+	s := newTestService(t)
+
+	webhook, err := s.CreateWebhook(s.systemUserID, &CreateWebhookRequest{
+		URL:    "https://hooks.example.com/bookings",
+		Events: []string{EventBookingCreated},
+	})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+
+	call := func(_ interface{}, err error) error { return err }
+	relative := "/bookings"
+	unknownID := uuid.New()
+
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"create with a relative url", call(s.CreateWebhook(s.systemUserID, &CreateWebhookRequest{URL: relative, Events: []string{EventBookingCreated}})), http.StatusBadRequest},
+		{"create without events", call(s.CreateWebhook(s.systemUserID, &CreateWebhookRequest{URL: webhook.URL})), http.StatusBadRequest},
+		{"create with an unknown event", call(s.CreateWebhook(s.systemUserID, &CreateWebhookRequest{URL: webhook.URL, Events: []string{"booking.deleted"}})), http.StatusBadRequest},
+		{"update with a relative url", call(s.UpdateWebhook(webhook.WebhookID, &UpdateWebhookRequest{URL: &relative})), http.StatusBadRequest},
+		{"update an unknown webhook", call(s.UpdateWebhook(unknownID, &UpdateWebhookRequest{URL: &webhook.URL})), http.StatusNotFound},
+		{"delete an unknown webhook", s.DeleteWebhook(unknownID), http.StatusNotFound},
+		{"redeliver an unknown delivery", call(s.RedeliverWebhook(unknownID)), http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		var webhookErr *ServiceError
+		if !errors.As(tt.err, &webhookErr) || webhookErr.Code != tt.code {
+			t.Errorf("%s: err = %v, want a %d ServiceError", tt.name, tt.err, tt.code)
+		}
+	}
+
+	// An empty secret is replaced rather than stored
+	empty := ""
+	updated, err := s.UpdateWebhook(webhook.WebhookID, &UpdateWebhookRequest{Secret: &empty})
+	if err != nil {
+		t.Fatalf("UpdateWebhook: %v", err)
+	}
+	if updated.Secret == nil || *updated.Secret == "" || *updated.Secret == *webhook.Secret {
+		t.Errorf("secret = %v, want a new generated secret", updated.Secret)
+	}
+
+	var stored string
+	if err := s.db.QueryRow(`SELECT secret FROM webhooks WHERE webhook_id = $1`, webhook.WebhookID).Scan(&stored); err != nil {
+		t.Fatalf("Failed to read secret: %v", err)
+	}
+	if updated.Secret != nil && stored != *updated.Secret {
+		t.Errorf("stored secret = %q, want the returned one", stored)
+	}
+}
@@ -4,6 +4,9 @@
 -- Enable UUID extension for generating unique IDs
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
 
+-- Enable btree_gist so exclusion constraints can combine = and && (overlap)
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
 -- Table for storing users who can manage bookings
 CREATE TABLE users (
     user_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
@@ -25,6 +28,55 @@ CREATE TABLE properties (
     property_type VARCHAR(50),
     max_guests INTEGER DEFAULT 1,
     description TEXT,
+    -- IANA time zone the property's calendar days are in
+    time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table for storing unit types (identical apartments or rooms) of properties
+-- with several bookable units. Properties without units are booked whole.
+CREATE TABLE unit_types (
+    unit_type_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    property_id UUID NOT NULL REFERENCES properties(property_id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    max_guests INTEGER NOT NULL CHECK (max_guests > 0),
+    description TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (property_id, name),
+    UNIQUE (property_id, unit_type_id)
+);
+
+-- Table for storing the bookable units of a property
+CREATE TABLE units (
+    unit_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    property_id UUID NOT NULL REFERENCES properties(property_id) ON DELETE CASCADE,
+    unit_type_id UUID NOT NULL,
+    unit_name VARCHAR(50) NOT NULL,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (property_id, unit_name),
+    UNIQUE (property_id, unit_id),
+    -- The unit type must belong to the same property
+    FOREIGN KEY (property_id, unit_type_id) REFERENCES unit_types(property_id, unit_type_id) ON DELETE RESTRICT
+);
+
+CREATE INDEX idx_units_unit_type_id ON units(unit_type_id);
+
+-- Table for storing group reservations (weddings, corporate groups) that
+-- link several bookings, often across properties, under one lead contact
+CREATE TABLE group_reservations (
+    group_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    group_name VARCHAR(100) NOT NULL,
+    lead_name VARCHAR(100) NOT NULL,
+    lead_contact_number VARCHAR(20) NOT NULL,
+    lead_email VARCHAR(100),
+    notes TEXT,
+    created_by UUID NOT NULL REFERENCES users(user_id) ON DELETE RESTRICT,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
@@ -33,6 +85,9 @@ CREATE TABLE properties (
 CREATE TABLE bookings (
     booking_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     property_id UUID NOT NULL REFERENCES properties(property_id) ON DELETE CASCADE,
+    unit_id UUID, -- set on properties with units
+    split_from_booking_id UUID REFERENCES bookings(booking_id) ON DELETE SET NULL, -- stay continued from that booking
+    group_id UUID REFERENCES group_reservations(group_id) ON DELETE SET NULL, -- part of a group reservation
     created_by UUID NOT NULL REFERENCES users(user_id) ON DELETE RESTRICT,
     
     -- Guest primary contact information
@@ -64,7 +119,19 @@ CREATE TABLE bookings (
     
     -- Constraints
     CONSTRAINT check_dates CHECK (check_out_date > check_in_date),
-    CONSTRAINT check_guests CHECK (number_of_guests > 0)
+    CONSTRAINT check_guests CHECK (number_of_guests > 0),
+
+    -- The unit must belong to the booked property
+    CONSTRAINT fk_booking_unit FOREIGN KEY (property_id, unit_id) REFERENCES units(property_id, unit_id) ON DELETE RESTRICT,
+
+    -- Active bookings of a property (or of a unit, on properties with units)
+    -- cannot overlap. Unlike a trigger doing IF EXISTS, this also holds for
+    -- concurrent transactions.
+    CONSTRAINT no_overlapping_bookings EXCLUDE USING gist (
+        property_id WITH =,
+        (COALESCE(unit_id, '00000000-0000-0000-0000-000000000000'::UUID)) WITH =,
+        daterange(check_in_date, check_out_date) WITH &&
+    ) WHERE (booking_status IN ('confirmed', 'pending'))
 );
 
 -- Table for storing additional guest details (for bookings with multiple guests)
@@ -84,7 +151,7 @@ CREATE TABLE booking_history (
     history_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     booking_id UUID NOT NULL REFERENCES bookings(booking_id) ON DELETE CASCADE,
     modified_by UUID NOT NULL REFERENCES users(user_id) ON DELETE RESTRICT,
-    modification_type VARCHAR(20) NOT NULL CHECK (modification_type IN ('created', 'updated', 'cancelled', 'deleted')),
+    modification_type VARCHAR(20) NOT NULL CHECK (modification_type IN ('created', 'updated', 'cancelled', 'deleted', 'moved', 'split')),
     old_values JSONB,
     new_values JSONB,
     modification_notes TEXT,
@@ -93,6 +160,8 @@ CREATE TABLE booking_history (
 
 -- Indexes for better performance
 CREATE INDEX idx_bookings_property_id ON bookings(property_id);
+CREATE INDEX idx_bookings_unit_id ON bookings(unit_id);
+CREATE INDEX idx_bookings_group_id ON bookings(group_id);
 CREATE INDEX idx_bookings_check_in_date ON bookings(check_in_date);
 CREATE INDEX idx_bookings_check_out_date ON bookings(check_out_date);
 CREATE INDEX idx_bookings_guest_name ON bookings(guest_name);
@@ -114,30 +183,70 @@ $$ language 'plpgsql';
 CREATE TRIGGER update_users_updated_at BEFORE UPDATE ON users FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 CREATE TRIGGER update_properties_updated_at BEFORE UPDATE ON properties FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 CREATE TRIGGER update_bookings_updated_at BEFORE UPDATE ON bookings FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_unit_types_updated_at BEFORE UPDATE ON unit_types FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_units_updated_at BEFORE UPDATE ON units FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_group_reservations_updated_at BEFORE UPDATE ON group_reservations FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 
--- Function to prevent overlapping bookings for the same property
+-- Function to prevent bookings overlapping availability blocks. Overlapping
+-- bookings are rejected by the no_overlapping_bookings constraint; blocks live
+-- in another table, so they are checked here while holding a per-property
+-- lock shared with check_block_overlap. Without the lock a booking and a
+-- block could be inserted concurrently over the same dates. The constraint
+-- also cannot see that a booking without a unit holds every unit of its
+-- property, so that clash is checked here under the same lock.
 CREATE OR REPLACE FUNCTION check_booking_overlap()
 RETURNS TRIGGER AS $$
 BEGIN
-    IF EXISTS (
-        SELECT 1 FROM bookings 
-        WHERE property_id = NEW.property_id 
-        AND booking_status IN ('confirmed', 'pending')
-        AND booking_id != COALESCE(NEW.booking_id, '00000000-0000-0000-0000-000000000000'::UUID)
-        AND (
-            (NEW.check_in_date >= check_in_date AND NEW.check_in_date < check_out_date) OR
-            (NEW.check_out_date > check_in_date AND NEW.check_out_date <= check_out_date) OR
-            (NEW.check_in_date <= check_in_date AND NEW.check_out_date >= check_out_date)
-        )
-    ) THEN
-        RAISE EXCEPTION 'Booking dates overlap with existing booking for this property';
+    IF NEW.booking_status IN ('confirmed', 'pending') THEN
+        PERFORM pg_advisory_xact_lock(hashtext('property_availability'), hashtext(NEW.property_id::TEXT));
+
+        -- A block of one unit leaves the other units bookable
+        IF EXISTS (
+            SELECT 1 FROM availability_blocks
+            WHERE property_id = NEW.property_id
+            AND (unit_id IS NULL OR NEW.unit_id IS NULL OR unit_id = NEW.unit_id)
+            AND NEW.check_in_date < end_date AND NEW.check_out_date > start_date
+        ) THEN
+            RAISE EXCEPTION 'Booking dates overlap with an availability block for this property'
+                USING ERRCODE = 'exclusion_violation';
+        END IF;
+
+        -- A booking of the whole property and a booking of one of its units
+        IF EXISTS (
+            SELECT 1 FROM bookings b
+            WHERE b.property_id = NEW.property_id
+            AND (b.unit_id IS NULL) != (NEW.unit_id IS NULL)
+            AND b.booking_id != NEW.booking_id
+            AND b.booking_status IN ('confirmed', 'pending')
+            AND b.check_in_date < NEW.check_out_date
+            AND (b.check_out_date > NEW.check_in_date
+                OR b.booking_id IN (SELECT booking_id FROM long_stays WHERE open_ended))
+        ) THEN
+            RAISE EXCEPTION 'Booking dates overlap with a booking of the whole property or of one of its units'
+                USING ERRCODE = 'exclusion_violation';
+        END IF;
+
+        -- Open-ended long stays hold their property (or unit) indefinitely
+        IF EXISTS (
+            SELECT 1 FROM bookings b
+            JOIN long_stays ls ON ls.booking_id = b.booking_id
+            WHERE b.property_id = NEW.property_id
+            AND b.unit_id IS NOT DISTINCT FROM NEW.unit_id
+            AND b.booking_id != NEW.booking_id
+            AND b.booking_status IN ('confirmed', 'pending')
+            AND ls.open_ended
+            AND NEW.check_out_date > b.check_out_date
+        ) THEN
+            RAISE EXCEPTION 'Booking dates overlap with an open-ended long stay'
+                USING ERRCODE = 'exclusion_violation';
+        END IF;
     END IF;
-    
+
     RETURN NEW;
 END;
 $$ language 'plpgsql';
 
--- Trigger to prevent overlapping bookings
+-- Trigger to prevent bookings over blocks
 CREATE TRIGGER prevent_booking_overlap 
     BEFORE INSERT OR UPDATE ON bookings 
     FOR EACH ROW EXECUTE FUNCTION check_booking_overlap();
@@ -168,6 +277,651 @@ CREATE TRIGGER log_booking_changes_trigger
     AFTER INSERT OR UPDATE OR DELETE ON bookings 
     FOR EACH ROW EXECUTE FUNCTION log_booking_changes();
 
+-- Function to notify the servers of the calendar days a booking change
+-- touched, check-out day included. Notifications are sent on commit, once
+-- per distinct payload.
+CREATE OR REPLACE FUNCTION notify_calendar_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND OLD.property_id = NEW.property_id
+        AND OLD.unit_id IS NOT DISTINCT FROM NEW.unit_id
+        AND OLD.check_in_date = NEW.check_in_date
+        AND OLD.check_out_date = NEW.check_out_date
+        AND OLD.booking_status = NEW.booking_status
+        AND OLD.guest_name = NEW.guest_name THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM pg_notify('calendar_changes', json_build_object(
+            'property_id', OLD.property_id,
+            'booking_id', OLD.booking_id,
+            'from', OLD.check_in_date,
+            'to', OLD.check_out_date
+        )::text);
+    END IF;
+
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM pg_notify('calendar_changes', json_build_object(
+            'property_id', NEW.property_id,
+            'booking_id', NEW.booking_id,
+            'from', NEW.check_in_date,
+            'to', NEW.check_out_date
+        )::text);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+-- Trigger to push booking changes to live calendars
+CREATE TRIGGER notify_calendar_change_trigger
+    AFTER INSERT OR UPDATE OR DELETE ON bookings
+    FOR EACH ROW EXECUTE FUNCTION notify_calendar_change();
+
+-- Table for storing refundable security deposits held against bookings
+CREATE TABLE booking_deposits (
+    deposit_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    booking_id UUID NOT NULL UNIQUE REFERENCES bookings(booking_id) ON DELETE CASCADE,
+    amount_held DECIMAL(10, 2) NOT NULL CHECK (amount_held >= 0),
+    amount_refunded DECIMAL(10, 2),
+    deposit_status VARCHAR(20) DEFAULT 'held' CHECK (deposit_status IN ('held', 'released', 'partially_claimed')),
+    released_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table for storing damage claims deducted from a deposit
+CREATE TABLE damage_claims (
+    claim_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    deposit_id UUID NOT NULL REFERENCES booking_deposits(deposit_id) ON DELETE CASCADE,
+    description TEXT NOT NULL,
+    claim_amount DECIMAL(10, 2) NOT NULL CHECK (claim_amount > 0),
+    created_by UUID NOT NULL REFERENCES users(user_id) ON DELETE RESTRICT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_booking_deposits_status ON booking_deposits(deposit_status);
+CREATE INDEX idx_damage_claims_deposit_id ON damage_claims(deposit_id);
+
+CREATE TRIGGER update_booking_deposits_updated_at BEFORE UPDATE ON booking_deposits FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Table for storing per-property tax and fee rules
+-- Percentage rules apply to the booking amount; fixed rules are multiplied by nights and/or guests
+CREATE TABLE property_charge_rules (
+    rule_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    property_id UUID NOT NULL REFERENCES properties(property_id) ON DELETE CASCADE,
+    rule_name VARCHAR(100) NOT NULL,
+    charge_type VARCHAR(10) NOT NULL CHECK (charge_type IN ('tax', 'fee')),
+    calculation VARCHAR(20) NOT NULL CHECK (calculation IN ('percentage', 'fixed')),
+    charge_basis VARCHAR(30) NOT NULL DEFAULT 'per_stay' CHECK (charge_basis IN ('per_night', 'per_guest', 'per_guest_per_night', 'per_stay')),
+    rate DECIMAL(10, 4) NOT NULL CHECK (rate >= 0),
+    exempt_under_age INTEGER, -- additional guests younger than this are not charged
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table for storing the tax and fee breakdown calculated for each booking
+CREATE TABLE booking_charges (
+    charge_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    booking_id UUID NOT NULL REFERENCES bookings(booking_id) ON DELETE CASCADE,
+    rule_id UUID REFERENCES property_charge_rules(rule_id) ON DELETE SET NULL,
+    rule_name VARCHAR(100) NOT NULL,
+    charge_type VARCHAR(10) NOT NULL CHECK (charge_type IN ('tax', 'fee')),
+    calculation VARCHAR(20) NOT NULL,
+    charge_basis VARCHAR(30) NOT NULL,
+    rate DECIMAL(10, 4) NOT NULL,
+    quantity INTEGER NOT NULL DEFAULT 1,
+    amount DECIMAL(10, 2) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_property_charge_rules_property_id ON property_charge_rules(property_id);
+CREATE INDEX idx_booking_charges_booking_id ON booking_charges(booking_id);
+
+CREATE TRIGGER update_property_charge_rules_updated_at BEFORE UPDATE ON property_charge_rules FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Table for storing promo codes; the validity window applies to the check-in date
+CREATE TABLE promo_codes (
+    promo_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    code VARCHAR(50) UNIQUE NOT NULL,
+    description TEXT,
+    discount_type VARCHAR(20) NOT NULL CHECK (discount_type IN ('percentage', 'fixed')),
+    discount_value DECIMAL(10, 2) NOT NULL CHECK (discount_value > 0),
+    valid_from DATE,
+    valid_to DATE,
+    max_uses INTEGER CHECK (max_uses > 0),
+    min_nights INTEGER,
+    max_nights INTEGER,
+    property_id UUID REFERENCES properties(property_id) ON DELETE CASCADE, -- NULL applies to all properties
+    repeat_guests_only BOOLEAN DEFAULT FALSE,
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT check_promo_validity CHECK (valid_to IS NULL OR valid_from IS NULL OR valid_to >= valid_from)
+);
+
+-- Table for storing the promo code redeemed on a booking
+CREATE TABLE booking_discounts (
+    booking_id UUID PRIMARY KEY REFERENCES bookings(booking_id) ON DELETE CASCADE,
+    promo_id UUID REFERENCES promo_codes(promo_id) ON DELETE SET NULL,
+    promo_code VARCHAR(50) NOT NULL,
+    discount_type VARCHAR(20) NOT NULL,
+    discount_value DECIMAL(10, 2) NOT NULL,
+    discount_amount DECIMAL(10, 2) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_booking_discounts_promo_id ON booking_discounts(promo_id);
+
+CREATE TRIGGER update_promo_codes_updated_at BEFORE UPDATE ON promo_codes FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Table for storing payments received against bookings
+CREATE TABLE booking_payments (
+    payment_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    booking_id UUID NOT NULL REFERENCES bookings(booking_id) ON DELETE CASCADE,
+    amount DECIMAL(10, 2) NOT NULL CHECK (amount > 0),
+    payment_method VARCHAR(20) NOT NULL CHECK (payment_method IN ('cash', 'card', 'bank_transfer', 'other')),
+    reference VARCHAR(100),
+    received_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    recorded_by UUID NOT NULL REFERENCES users(user_id) ON DELETE RESTRICT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table for storing per-property invoice branding
+CREATE TABLE property_branding (
+    property_id UUID PRIMARY KEY REFERENCES properties(property_id) ON DELETE CASCADE,
+    display_name VARCHAR(100) NOT NULL,
+    address TEXT,
+    email VARCHAR(100),
+    phone VARCHAR(20),
+    tax_number VARCHAR(50),
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    accent_color CHAR(7) NOT NULL DEFAULT '#1f4e79',
+    footer_text TEXT,
+    html_template TEXT, -- optional Go html/template overriding the default invoice layout
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table for storing the terms of long stays billed by the month. Open-ended
+-- stays are kept booked a couple of months ahead by moving their check-out
+-- date forward, and no other booking or block may start after them.
+CREATE TABLE long_stays (
+    booking_id UUID PRIMARY KEY REFERENCES bookings(booking_id) ON DELETE CASCADE,
+    monthly_rate DECIMAL(10, 2) CHECK (monthly_rate > 0), -- NULL: priced from the nightly rates
+    open_ended BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table for storing the billed months of long stays
+CREATE TABLE billing_periods (
+    period_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    booking_id UUID NOT NULL REFERENCES bookings(booking_id) ON DELETE CASCADE,
+    period_start DATE NOT NULL,
+    period_end DATE NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (booking_id, period_start),
+    CONSTRAINT check_period_dates CHECK (period_end > period_start)
+);
+
+CREATE TRIGGER update_long_stays_updated_at BEFORE UPDATE ON long_stays FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Gap-free counters used for sequential document numbers
+CREATE TABLE invoice_counters (
+    counter_name VARCHAR(20) PRIMARY KEY,
+    last_number INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO invoice_counters (counter_name, last_number) VALUES ('invoice', 0);
+
+-- Table for storing issued invoice numbers, each for a booking, a group or
+-- a billed month of a long stay
+CREATE TABLE invoices (
+    invoice_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    invoice_number VARCHAR(20) UNIQUE NOT NULL,
+    booking_id UUID UNIQUE REFERENCES bookings(booking_id) ON DELETE RESTRICT,
+    group_id UUID UNIQUE REFERENCES group_reservations(group_id) ON DELETE RESTRICT,
+    period_id UUID UNIQUE REFERENCES billing_periods(period_id) ON DELETE RESTRICT,
+    issued_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT check_invoice_owner CHECK (num_nonnulls(booking_id, group_id, period_id) = 1)
+);
+
+CREATE INDEX idx_booking_payments_booking_id ON booking_payments(booking_id);
+
+CREATE TRIGGER update_property_branding_updated_at BEFORE UPDATE ON property_branding FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Table for storing external iCalendar feeds (Airbnb, Booking.com, ...)
+-- imported as availability blocks
+CREATE TABLE external_calendars (
+    calendar_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    property_id UUID NOT NULL REFERENCES properties(property_id) ON DELETE CASCADE,
+    calendar_name VARCHAR(100) NOT NULL,
+    feed_url TEXT NOT NULL,
+    is_active BOOLEAN DEFAULT TRUE,
+    last_synced_at TIMESTAMP WITH TIME ZONE,
+    last_sync_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE(property_id, feed_url)
+);
+
+CREATE TRIGGER update_external_calendars_updated_at BEFORE UPDATE ON external_calendars FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Table for storing dates a property is closed without a guest booking
+-- (maintenance, owner use, reservations imported from other channels).
+-- end_date is exclusive, like check_out_date. On properties with units a
+-- block may close a single unit instead of the whole property.
+CREATE TABLE availability_blocks (
+    block_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    property_id UUID NOT NULL REFERENCES properties(property_id) ON DELETE CASCADE,
+    unit_id UUID,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    block_type VARCHAR(20) DEFAULT 'maintenance' CHECK (block_type IN ('maintenance', 'owner_use', 'external', 'other')),
+    reason TEXT NOT NULL,
+    source VARCHAR(20) DEFAULT 'manual' CHECK (source IN ('manual', 'ical')),
+    external_calendar_id UUID REFERENCES external_calendars(calendar_id) ON DELETE CASCADE,
+    external_uid VARCHAR(255),
+    created_by UUID REFERENCES users(user_id) ON DELETE RESTRICT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT fk_block_unit FOREIGN KEY (property_id, unit_id) REFERENCES units(property_id, unit_id) ON DELETE CASCADE,
+    CONSTRAINT check_block_dates CHECK (end_date > start_date),
+    CONSTRAINT no_overlapping_blocks EXCLUDE USING gist (
+        property_id WITH =,
+        (COALESCE(unit_id, '00000000-0000-0000-0000-000000000000'::UUID)) WITH =,
+        daterange(start_date, end_date) WITH &&
+    ),
+    -- Imported blocks stand for a reservation on another channel, which
+    -- does not name a unit
+    CONSTRAINT check_block_unit CHECK (source = 'manual' OR unit_id IS NULL),
+    CONSTRAINT check_block_source CHECK (
+        (source = 'manual' AND created_by IS NOT NULL) OR
+        (source = 'ical' AND external_calendar_id IS NOT NULL AND external_uid IS NOT NULL)
+    ),
+    UNIQUE(external_calendar_id, external_uid)
+);
+
+CREATE INDEX idx_availability_blocks_property_dates ON availability_blocks(property_id, start_date, end_date);
+
+CREATE TRIGGER update_availability_blocks_updated_at BEFORE UPDATE ON availability_blocks FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Function to prevent blocks overlapping active bookings, under the same
+-- per-property lock as check_booking_overlap. Overlapping blocks of the same
+-- unit (or both of the whole property) are rejected by the
+-- no_overlapping_blocks constraint. A block of one unit only clashes with
+-- bookings of that unit or of the whole property.
+CREATE OR REPLACE FUNCTION check_block_overlap()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('property_availability'), hashtext(NEW.property_id::TEXT));
+
+    -- Blocks imported from other calendars may overlap bookings of some of
+    -- a property's units; the importer checks a unit is left every night
+    -- under this lock
+    IF NEW.source = 'ical' AND EXISTS (
+        SELECT 1 FROM units WHERE property_id = NEW.property_id AND is_active
+    ) THEN
+        RETURN NEW;
+    END IF;
+
+    -- A block of the whole property and a block of one of its units
+    IF EXISTS (
+        SELECT 1 FROM availability_blocks
+        WHERE property_id = NEW.property_id
+        AND (unit_id IS NULL) != (NEW.unit_id IS NULL)
+        AND block_id != NEW.block_id
+        AND NEW.start_date < end_date AND NEW.end_date > start_date
+    ) THEN
+        RAISE EXCEPTION 'Block dates overlap with a block of the whole property or of one of its units'
+            USING ERRCODE = 'exclusion_violation';
+    END IF;
+
+    IF EXISTS (
+        SELECT 1 FROM bookings
+        WHERE property_id = NEW.property_id
+        AND (NEW.unit_id IS NULL OR unit_id IS NULL OR unit_id = NEW.unit_id)
+        AND booking_status IN ('confirmed', 'pending')
+        AND NEW.start_date < check_out_date AND NEW.end_date > check_in_date
+    ) THEN
+        RAISE EXCEPTION 'Block dates overlap with existing booking for this property'
+            USING ERRCODE = 'exclusion_violation';
+    END IF;
+
+    IF EXISTS (
+        SELECT 1 FROM bookings b
+        JOIN long_stays ls ON ls.booking_id = b.booking_id
+        WHERE b.property_id = NEW.property_id
+        AND (NEW.unit_id IS NULL OR b.unit_id IS NULL OR b.unit_id = NEW.unit_id)
+        AND b.booking_status IN ('confirmed', 'pending')
+        AND ls.open_ended
+        AND NEW.end_date > b.check_out_date
+    ) THEN
+        RAISE EXCEPTION 'Block dates overlap with an open-ended long stay'
+            USING ERRCODE = 'exclusion_violation';
+    END IF;
+
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+-- Trigger to prevent blocks over bookings
+CREATE TRIGGER prevent_block_overlap
+    BEFORE INSERT OR UPDATE ON availability_blocks
+    FOR EACH ROW EXECUTE FUNCTION check_block_overlap();
+
+-- Table for storing the secret tokens of public iCalendar feeds
+CREATE TABLE property_calendar_feeds (
+    property_id UUID PRIMARY KEY REFERENCES properties(property_id) ON DELETE CASCADE,
+    token VARCHAR(64) UNIQUE NOT NULL,
+    hide_guest_names BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table for storing imported events that overlap a local booking or block.
+-- Conflicting events are not imported; resolved_at is set once the event
+-- no longer conflicts or disappears from the feed.
+CREATE TABLE ical_import_conflicts (
+    conflict_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    calendar_id UUID NOT NULL REFERENCES external_calendars(calendar_id) ON DELETE CASCADE,
+    external_uid VARCHAR(255) NOT NULL,
+    summary TEXT,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    booking_id UUID REFERENCES bookings(booking_id) ON DELETE CASCADE,
+    block_id UUID REFERENCES availability_blocks(block_id) ON DELETE CASCADE,
+    detected_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX idx_ical_import_conflicts_open ON ical_import_conflicts(calendar_id, external_uid) WHERE resolved_at IS NULL;
+
+-- Table for storing nightly rates per property and date range.
+-- end_date is exclusive, like check_out_date.
+CREATE TABLE property_rates (
+    rate_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    property_id UUID NOT NULL REFERENCES properties(property_id) ON DELETE CASCADE,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    nightly_rate DECIMAL(10, 2) NOT NULL CHECK (nightly_rate > 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT check_rate_dates CHECK (end_date > start_date),
+    CONSTRAINT no_overlapping_rates EXCLUDE USING gist (
+        property_id WITH =,
+        daterange(start_date, end_date) WITH &&
+    )
+);
+
+CREATE TRIGGER update_property_rates_updated_at BEFORE UPDATE ON property_rates FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Table for storing the year-round stay rules of a property. Weekdays are
+-- 0 (Sunday) to 6 (Saturday); an empty array allows every day.
+CREATE TABLE property_stay_rules (
+    property_id UUID PRIMARY KEY REFERENCES properties(property_id) ON DELETE CASCADE,
+    min_nights INTEGER NOT NULL DEFAULT 1 CHECK (min_nights >= 1),
+    max_nights INTEGER,
+    arrival_weekdays INTEGER[] NOT NULL DEFAULT '{}',
+    departure_weekdays INTEGER[] NOT NULL DEFAULT '{}',
+    min_lead_days INTEGER NOT NULL DEFAULT 0 CHECK (min_lead_days >= 0),
+    max_advance_days INTEGER,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT check_max_nights CHECK (max_nights IS NULL OR max_nights >= min_nights),
+    CONSTRAINT check_weekdays CHECK (
+        arrival_weekdays <@ ARRAY[0, 1, 2, 3, 4, 5, 6] AND departure_weekdays <@ ARRAY[0, 1, 2, 3, 4, 5, 6]
+    ),
+    CONSTRAINT check_max_advance_days CHECK (max_advance_days IS NULL OR max_advance_days >= min_lead_days)
+);
+
+CREATE TRIGGER update_property_stay_rules_updated_at BEFORE UPDATE ON property_stay_rules FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Table for storing seasonal minimum stays and closed-to-arrival dates.
+-- They apply to stays arriving from start_date up to, not including, end_date.
+CREATE TABLE stay_restrictions (
+    restriction_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    property_id UUID NOT NULL REFERENCES properties(property_id) ON DELETE CASCADE,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    min_nights INTEGER CHECK (min_nights >= 1),
+    closed_to_arrival BOOLEAN NOT NULL DEFAULT FALSE,
+    note VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT check_restriction_dates CHECK (end_date > start_date),
+    CONSTRAINT check_restriction_effect CHECK (min_nights IS NOT NULL OR closed_to_arrival)
+);
+
+CREATE INDEX idx_stay_restrictions_property_dates ON stay_restrictions(property_id, start_date, end_date);
+
+CREATE TRIGGER update_stay_restrictions_updated_at BEFORE UPDATE ON stay_restrictions FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Table for storing property listings on booking channels
+CREATE TABLE channel_listings (
+    listing_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    property_id UUID NOT NULL REFERENCES properties(property_id) ON DELETE CASCADE,
+    channel VARCHAR(50) NOT NULL,
+    external_listing_id VARCHAR(255) NOT NULL,
+    is_active BOOLEAN DEFAULT TRUE,
+    last_synced_at TIMESTAMP WITH TIME ZONE,
+    last_sync_error TEXT,
+    failure_count INTEGER NOT NULL DEFAULT 0, -- consecutive failed syncs, drives the retry backoff
+    next_sync_at TIMESTAMP WITH TIME ZONE, -- no sync before this time while backing off
+    sync_claimed_until TIMESTAMP WITH TIME ZONE, -- being synced, unless this time has passed
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE(property_id, channel),
+    UNIQUE(channel, external_listing_id)
+);
+
+CREATE TRIGGER update_channel_listings_updated_at BEFORE UPDATE ON channel_listings FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Table for storing reservations pulled from channels and their bookings
+CREATE TABLE channel_reservations (
+    channel_reservation_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    listing_id UUID NOT NULL REFERENCES channel_listings(listing_id) ON DELETE CASCADE,
+    external_reservation_id VARCHAR(255) NOT NULL,
+    booking_id UUID REFERENCES bookings(booking_id) ON DELETE SET NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('imported', 'cancelled', 'failed')),
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE(listing_id, external_reservation_id)
+);
+
+CREATE INDEX idx_channel_reservations_booking_id ON channel_reservations(booking_id);
+
+-- Table for storing the waitlists of fully booked dates. When a
+-- cancellation frees a waiting entry's dates, the contact is notified in
+-- priority order and, if asked for, a pending booking holds the dates.
+CREATE TABLE waitlist_entries (
+    entry_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    property_id UUID NOT NULL REFERENCES properties(property_id) ON DELETE CASCADE,
+    unit_type_id UUID REFERENCES unit_types(unit_type_id) ON DELETE SET NULL,
+    contact_name VARCHAR(255) NOT NULL,
+    contact_email VARCHAR(255),
+    contact_phone VARCHAR(20),
+    check_in_date DATE NOT NULL,
+    check_out_date DATE NOT NULL,
+    number_of_guests INTEGER NOT NULL CHECK (number_of_guests > 0),
+    priority INTEGER NOT NULL DEFAULT 0, -- higher goes first
+    create_hold BOOLEAN NOT NULL DEFAULT FALSE,
+    notes TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'waiting' CHECK (status IN ('waiting', 'notified', 'held', 'booked', 'released', 'cancelled')),
+    notified_at TIMESTAMP WITH TIME ZONE,
+    hold_booking_id UUID REFERENCES bookings(booking_id) ON DELETE SET NULL,
+    hold_expires_at TIMESTAMP WITH TIME ZONE,
+    created_by UUID NOT NULL REFERENCES users(user_id) ON DELETE RESTRICT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT check_waitlist_dates CHECK (check_out_date > check_in_date),
+    CONSTRAINT check_waitlist_contact CHECK (contact_email IS NOT NULL OR contact_phone IS NOT NULL)
+);
+
+CREATE INDEX idx_waitlist_entries_waiting ON waitlist_entries(property_id, check_in_date) WHERE status = 'waiting';
+CREATE INDEX idx_waitlist_entries_hold_booking_id ON waitlist_entries(hold_booking_id);
+
+CREATE TRIGGER update_waitlist_entries_updated_at BEFORE UPDATE ON waitlist_entries FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Table for storing guest notifications waiting to be sent or already
+-- sent, by email or text message. They are written when the event they are
+-- about is dispatched and delivered by the notification worker, retrying
+-- on failure.
+CREATE TABLE notification_outbox (
+    notification_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    event VARCHAR(50) NOT NULL,
+    channel VARCHAR(10) NOT NULL DEFAULT 'email' CHECK (channel IN ('email', 'sms')),
+    booking_id UUID REFERENCES bookings(booking_id) ON DELETE SET NULL,
+    recipient_name VARCHAR(255) NOT NULL,
+    recipient_email VARCHAR(255),
+    recipient_phone VARCHAR(20), -- E.164
+    subject TEXT, -- email only
+    body TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed', 'skipped')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT check_notification_recipient CHECK (
+        (channel = 'email' AND recipient_email IS NOT NULL AND subject IS NOT NULL) OR
+        (channel = 'sms' AND recipient_phone IS NOT NULL)
+    )
+);
+
+CREATE INDEX idx_notification_outbox_due ON notification_outbox(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_notification_outbox_booking_id ON notification_outbox(booking_id);
+-- Each booking gets one pre-arrival reminder per channel
+CREATE UNIQUE INDEX idx_notification_outbox_reminder ON notification_outbox(booking_id, channel) WHERE event = 'booking.reminder';
+
+-- Table for storing phone numbers (E.164) that must not be sent text messages
+CREATE TABLE notification_opt_outs (
+    phone_number VARCHAR(20) PRIMARY KEY,
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table for storing the domain events: every change to a booking, written
+-- in the same transaction as the change, with the booking as it was then.
+-- The dispatcher publishes them to the event sinks (webhooks, guest
+-- notifications, the log).
+CREATE TABLE domain_events (
+    event_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    sequence BIGINT UNIQUE, -- set as the recording transaction commits
+    event_type VARCHAR(50) NOT NULL, -- booking.created, booking.updated, booking.cancelled, payment.received
+    booking_id UUID NOT NULL REFERENCES bookings(booking_id) ON DELETE CASCADE,
+    property_id UUID NOT NULL REFERENCES properties(property_id) ON DELETE CASCADE,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_domain_events_booking_id ON domain_events(booking_id);
+
+CREATE SEQUENCE domain_events_sequence;
+
+-- Number the events as their transactions commit, one transaction at a
+-- time. A sequence value taken on insert could commit after a higher one,
+-- and a reader that had already moved past it would never see the event;
+-- numbered this way, once an event is visible so is every event before it.
+-- The lock is only held while committing.
+CREATE OR REPLACE FUNCTION number_domain_event()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('domain_events'));
+
+    UPDATE domain_events
+    SET sequence = nextval('domain_events_sequence')
+    WHERE event_id = NEW.event_id;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER number_domain_event
+    AFTER INSERT ON domain_events
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION number_domain_event();
+
+-- Table for storing which events each sink has processed, so each sink
+-- gets each event once. Events a sink failed on are retried with a
+-- doubling delay until they are given up on.
+CREATE TABLE event_consumptions (
+    consumer VARCHAR(50) NOT NULL,
+    event_id UUID NOT NULL REFERENCES domain_events(event_id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('processed', 'retrying', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP WITH TIME ZONE,
+    processed_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (consumer, event_id)
+);
+
+CREATE INDEX idx_event_consumptions_retrying ON event_consumptions(consumer, next_attempt_at) WHERE status = 'retrying';
+
+-- Table for storing how far each sink has read the events. Events are
+-- numbered as they commit, so a sink has been handed every event up to
+-- last_sequence; the ones it failed on are retried from event_consumptions.
+CREATE TABLE event_consumers (
+    consumer VARCHAR(50) PRIMARY KEY,
+    last_sequence BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Table for storing endpoints that are POSTed booking and payment events.
+-- Requests are signed with the secret (HMAC-SHA256).
+CREATE TABLE webhooks (
+    webhook_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    url TEXT NOT NULL,
+    events TEXT[] NOT NULL, -- booking.created, booking.updated, booking.cancelled, payment.received
+    secret VARCHAR(255) NOT NULL,
+    description TEXT,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_by UUID NOT NULL REFERENCES users(user_id) ON DELETE RESTRICT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER update_webhooks_updated_at BEFORE UPDATE ON webhooks FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Table for storing each event queued for each webhook, with the outcome
+-- of its last attempt. Failed attempts are retried with a doubling delay.
+CREATE TABLE webhook_deliveries (
+    delivery_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    webhook_id UUID NOT NULL REFERENCES webhooks(webhook_id) ON DELETE CASCADE,
+    event VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_status INTEGER,
+    response_body TEXT,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_attempt_at TIMESTAMP WITH TIME ZONE,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, created_at);
+
 -- Sample data insertion (optional)
 -- Insert a default property
 INSERT INTO properties (property_name, property_address, property_type, max_guests, description)